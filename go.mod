@@ -3,3 +3,10 @@ module github.com/user/go-mcp-commander
 go 1.21
 
 require github.com/google/shlex v0.0.0-20191202100458-e7afc7fbc510
+
+require (
+	golang.org/x/net v0.17.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require golang.org/x/text v0.13.0 // indirect
@@ -3,22 +3,37 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/user/go-mcp-commander/pkg/auth"
 	"github.com/user/go-mcp-commander/pkg/commander"
 	"github.com/user/go-mcp-commander/pkg/logging"
 	"github.com/user/go-mcp-commander/pkg/mcp"
+	"github.com/user/go-mcp-commander/pkg/web"
 )
 
 const (
 	Version = "1.0.0"
+
+	// defaultCacheSize and defaultCacheDirSize bound the response cache
+	defaultCacheSize    = "32M"
+	defaultCacheDirSize = "256M"
+	// defaultSearchCacheTTL is how long identical searches are answered
+	// from the cache
+	defaultSearchCacheTTL = 5 * time.Minute
 )
 
 var (
@@ -28,23 +43,95 @@ var (
 	allowedCmds         = flag.String("allowed-commands", "", "Comma-separated list of allowed command prefixes (empty = allow all)")
 	blockedCmds         = flag.String("blocked-commands", "", "Comma-separated list of blocked command patterns")
 	defaultTimeout      = flag.Duration("timeout", 30*time.Second, "Default command timeout")
+	killGracePeriod     = flag.Duration("kill-grace-period", commander.DefaultKillGracePeriod, "Time a timed out or cancelled command has to exit after SIGTERM before it is killed (negative = kill immediately)")
 	shell               = flag.String("shell", "", "Shell to use for command execution (default: /bin/sh on Unix, cmd on Windows)")
 	shellArg            = flag.String("shell-arg", "", "Shell argument for command execution (default: -c on Unix, /c on Windows)")
 	useDefaultBlocklist = flag.Bool("use-default-blocklist", true, "Use default blocklist of dangerous commands")
 	httpMode            = flag.Bool("http", false, "Run in HTTP mode instead of stdio")
 	httpPort            = flag.Int("port", 3000, "HTTP port (only used with --http)")
 	httpHost            = flag.String("host", "127.0.0.1", "HTTP host (only used with --http)")
+	maxConcurrent       = flag.Int("max-concurrent", mcp.DefaultMaxInFlight, "Maximum number of requests handled concurrently over stdio")
+	maxJobs             = flag.Int("max-jobs", commander.DefaultMaxJobs, "Maximum number of background jobs tracked at once")
+	jobBufferSize       = flag.Int("job-buffer-size", commander.DefaultJobBufferSize, "Bytes of output retained per background job")
+	maxSessions         = flag.Int("max-sessions", commander.DefaultMaxSessions, "Maximum number of concurrent shell sessions")
+	sessionIdleTimeout  = flag.Duration("session-idle-timeout", commander.DefaultSessionIdleTimeout, "Close shell sessions unused for this long")
+	policyFile          = flag.String("policy-file", "", "Path to a YAML or JSON command policy file")
+	argvOnly            = flag.Bool("argv-only", false, "Only run programs given as argv arrays, never through a shell")
+	sandbox             = flag.Bool("sandbox", false, "Run commands in a Linux sandbox (namespaces, Landlock, seccomp)")
+	sandboxReadOnly     = flag.String("sandbox-read-only", "", "Comma-separated paths sandboxed commands may read (default: system directories)")
+	sandboxReadWrite    = flag.String("sandbox-read-write", "", "Comma-separated paths sandboxed commands may modify (default: /tmp,/dev)")
+	sandboxNetwork      = flag.Bool("sandbox-network", false, "Allow sandboxed commands to use the network")
+	limitMemory         = flag.String("limit-memory", "", "Memory limit per command, e.g. 512M (default: unlimited)")
+	limitCPU            = flag.Int("limit-cpu", 0, "CPU time limit per command process in seconds (0 = unlimited)")
+	limitProcesses      = flag.Int("limit-processes", 0, "Maximum number of processes per command (0 = unlimited)")
+	limitFileSize       = flag.String("limit-file-size", "", "Maximum size of files a command writes, e.g. 100M (default: unlimited)")
+	maxOutputBytes      = flag.String("max-output-bytes", "", "Cap on captured stdout and stderr per command, each (default: 10M, -1 = unlimited)")
+	fetchAllowHosts     = flag.String("fetch-allow-hosts", "", "Comma-separated hosts web_fetch may request, e.g. example.com,*.example.org (empty = any public host)")
+	fetchDenyHosts      = flag.String("fetch-deny-hosts", "", "Comma-separated hosts web_fetch may not request")
+	fetchAllowPrivate   = flag.Bool("fetch-allow-private", false, "Allow web_fetch to reach loopback, private, link-local and cloud metadata addresses")
+	fetchAllowCIDRs     = flag.String("fetch-allow-cidrs", "", "Comma-separated internal networks web_fetch may reach despite the default block")
+	fetchDenyCIDRs      = flag.String("fetch-deny-cidrs", "", "Comma-separated further networks web_fetch may not reach")
+	searchProvider      = flag.String("search-provider", "", "Search backend for google_search: google|google-cse|searxng|brave|bing (default: google)")
+	searchURL           = flag.String("search-url", "", "Search API URL, or the base URL of a SearXNG instance")
+	searchAPIKey        = flag.String("search-api-key", "", "API key for the google-cse, brave and bing search providers")
+	searchEngineID      = flag.String("search-engine-id", "", "Programmable Search Engine ID (cx) for the google-cse search provider")
+	cacheSize           = flag.String("cache-size", "", "Memory for cached web_fetch and search responses, e.g. 64M (default: 32M, 0 = no caching)")
+	cacheDir            = flag.String("cache-dir", "", "Directory in which to also cache web_fetch and search responses across restarts")
+	cacheDirSize        = flag.String("cache-dir-size", "", "Maximum size of the cache directory (default: 256M)")
+	searchCacheTTL      = flag.Duration("search-cache-ttl", defaultSearchCacheTTL, "Time search results are cached, even if the provider marks them as stale (0 = honour the provider)")
+	authJWKSFile        = flag.String("auth-jwks-file", "", "JSON Web Key Set file for verifying RS256 and ES256 JWTs on the HTTP transport")
+	authJWKSURL         = flag.String("auth-jwks-url", "", "JSON Web Key Set URL for verifying RS256 and ES256 JWTs on the HTTP transport")
+	authIssuer          = flag.String("auth-issuer", "", "Required JWT issuer (iss claim)")
+	authAudience        = flag.String("auth-audience", "", "Comma-separated accepted JWT audiences (aud claim)")
+	authClockSkew       = flag.Duration("auth-clock-skew", auth.DefaultClockSkew, "Tolerance for JWT exp and nbf claims")
+	authMaxFailures     = flag.Int("auth-max-failures", auth.DefaultMaxFailures, "Failed authentication attempts after which a client is locked out (0 = never)")
+	authLockout         = flag.Duration("auth-lockout", auth.DefaultLockout, "Duration of a client's first lockout, doubling with each further failure")
+	authMaxLockout      = flag.Duration("auth-max-lockout", auth.DefaultMaxLockout, "Longest lockout, and how long failures are remembered")
+	authIdentities      = flag.String("auth-identities", "", "YAML or JSON file mapping tokens to identities with scopes and command policies")
+	httpAllowedOrigins  = flag.String("http-allowed-origins", "", "Comma-separated browser origins allowed to use the HTTP transport besides the local host (* = any)")
+	tlsCert             = flag.String("tls-cert", "", "PEM certificate file; serves the HTTP transport over HTTPS")
+	tlsKey              = flag.String("tls-key", "", "PEM private key file for --tls-cert")
+	tlsClientCA         = flag.String("tls-client-ca", "", "PEM file of CAs whose client certificates are accepted and mapped to identities")
+	tlsClientAuth       = flag.String("tls-client-auth", mcp.ClientAuthRequire, "Client certificate mode with --tls-client-ca: require or optional")
+	tlsMinVersion       = flag.String("tls-min-version", "1.2", "Minimum TLS version: 1.2 or 1.3")
+	cgroupParent        = flag.String("cgroup-parent", "", "cgroup v2 directory in which to create a cgroup per command for memory and process limits")
 
 	// Global variables
-	logger *logging.Logger
-	cmd    *commander.Commander
+	logger      *logging.Logger
+	cmd         *commander.Commander
+	jobs        *commander.JobRegistry
+	sessions    *commander.SessionManager
+	fetchPolicy *web.DestinationPolicy
+	searcher    web.SearchProvider
+	// responseCache caches web_fetch and search responses; nil if disabled
+	responseCache *web.Cache
+	searchTTL     time.Duration
+	// identityCommanders hold the command policies of HTTP identities that
+	// have one, keyed by identity name
+	identityCommanders map[string]*commander.Commander
 )
 
 func main() {
+	os.Exit(run())
+}
+
+// run starts the server and returns the exit code once it stops. Returning
+// rather than exiting lets the deferred cleanup kill background jobs and
+// shell sessions, which run in their own process groups and would otherwise
+// outlive the server.
+func run() int {
+	// Sandboxed and resource limited commands re-execute this binary as a
+	// helper that sets up the sandbox and limits before running the command
+	commander.RunSandboxHelper()
+
 	// Load environment variables from ~/.mcp_env if it exists
 	// This must happen before flag parsing so env vars are available for defaults
 	logging.LoadEnvFile()
 
+	if len(os.Args) > 1 && os.Args[1] == "validate-policy" {
+		return runValidatePolicy(os.Args[2:])
+	}
+
 	flag.Parse()
 
 	// Resolve configuration with priority: flags > env vars > defaults
@@ -58,8 +145,35 @@ func main() {
 			resolvedTimeout = parsed
 		}
 	}
+	resolvedKillGracePeriod := *killGracePeriod
+	if envGrace := os.Getenv("MCP_KILL_GRACE_PERIOD"); envGrace != "" && !isFlagSet("kill-grace-period") {
+		if parsed, err := time.ParseDuration(envGrace); err == nil {
+			resolvedKillGracePeriod = parsed
+		}
+	}
+	resolvedArgvOnly := *argvOnly
+	if envArgvOnly := os.Getenv("MCP_ARGV_ONLY"); envArgvOnly != "" && !isFlagSet("argv-only") {
+		if parsed, err := strconv.ParseBool(envArgvOnly); err == nil {
+			resolvedArgvOnly = parsed
+		}
+	}
+	resolvedSandbox := *sandbox
+	if envSandbox := os.Getenv("MCP_SANDBOX"); envSandbox != "" && !isFlagSet("sandbox") {
+		if parsed, err := strconv.ParseBool(envSandbox); err == nil {
+			resolvedSandbox = parsed
+		}
+	}
+	resolvedSandboxReadOnly := resolvePriority(*sandboxReadOnly, os.Getenv("MCP_SANDBOX_READ_ONLY"), "")
+	resolvedSandboxReadWrite := resolvePriority(*sandboxReadWrite, os.Getenv("MCP_SANDBOX_READ_WRITE"), "")
+	resolvedPolicyFile := resolvePriority(*policyFile, os.Getenv("MCP_POLICY_FILE"), "")
 	resolvedShell := resolvePriority(*shell, os.Getenv("MCP_SHELL"), "")
 	resolvedShellArg := resolvePriority(*shellArg, os.Getenv("MCP_SHELL_ARG"), "")
+	resolvedMaxConcurrent := *maxConcurrent
+	if envMax := os.Getenv("MCP_MAX_CONCURRENT"); envMax != "" && !isFlagSet("max-concurrent") {
+		if parsed, err := strconv.Atoi(envMax); err == nil && parsed > 0 {
+			resolvedMaxConcurrent = parsed
+		}
+	}
 
 	// Determine if we should add app subfolder (when log dir was specified by user)
 	addAppSubfolder := *logDir != "" || os.Getenv("MCP_LOG_DIR") != ""
@@ -74,7 +188,7 @@ func main() {
 	})
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to initialize logger: %v\n", err)
-		os.Exit(1)
+		return 1
 	}
 	defer logger.Close()
 
@@ -90,15 +204,103 @@ func main() {
 		blockedList = append(blockedList, commander.DefaultBlockedCommands()...)
 	}
 
+	// Load the policy file, if any
+	var policy *commander.Policy
+	var fetchSection *web.DestinationPolicy
+	if resolvedPolicyFile != "" {
+		file, err := loadPolicyFile(resolvedPolicyFile)
+		if err != nil {
+			logger.Error("%v", err)
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		policy, fetchSection = &file.Policy, file.Fetch
+		logger.Info("Loaded policy file %s (%d rules)", resolvedPolicyFile, len(policy.Rules))
+	}
+
+	// Set up the sandbox, refusing to start if it is requested but unusable
+	var sandboxConfig *commander.SandboxConfig
+	if resolvedSandbox {
+		var readOnly, readWrite []string
+		if resolvedSandboxReadOnly != "" {
+			readOnly = parseCommandList(resolvedSandboxReadOnly)
+		}
+		if resolvedSandboxReadWrite != "" {
+			readWrite = parseCommandList(resolvedSandboxReadWrite)
+		}
+		sandboxConfig = commander.NewSandboxConfig(readOnly, readWrite, *sandboxNetwork)
+		if err := commander.CheckSandbox(sandboxConfig); err != nil {
+			logger.Error("%v", err)
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		logger.Info("Sandbox enabled: read-only %v, read-write %v, network %v",
+			sandboxConfig.ReadOnlyPaths, sandboxConfig.ReadWritePaths, sandboxConfig.AllowNetwork)
+	}
+
+	// Set up the web tools' destination policy, starting from the policy
+	// file's fetch section
+	fetchPolicy, err = resolveFetchPolicy(fetchSection)
+	if err != nil {
+		logger.Error("%v", err)
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	// Set up the search backend
+	searcher, err = web.NewSearchProvider(web.SearchConfig{
+		Provider: resolvePriority(*searchProvider, os.Getenv("MCP_SEARCH_PROVIDER"), ""),
+		Endpoint: resolvePriority(*searchURL, os.Getenv("MCP_SEARCH_URL"), ""),
+		APIKey:   resolvePriority(*searchAPIKey, os.Getenv("MCP_SEARCH_API_KEY"), ""),
+		EngineID: resolvePriority(*searchEngineID, os.Getenv("MCP_SEARCH_ENGINE_ID"), ""),
+	})
+	if err != nil {
+		logger.Error("%v", err)
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	logger.Info("Search provider: %s", searcher.Name())
+
+	// Set up the response cache shared by web_fetch and search
+	responseCache, err = resolveCache()
+	if err != nil {
+		logger.Error("%v", err)
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	searchTTL = resolveSearchCacheTTL()
+
+	// Set up resource limits, refusing to start if they cannot be enforced
+	limits, err := resolveLimits()
+	if err == nil {
+		err = commander.CheckLimits(limits)
+	}
+	if err != nil {
+		logger.Error("%v", err)
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	logger.Info("Resource limits: memory %d, cpu %ds, processes %d, file size %d, output %d, cgroup %q",
+		limits.MemoryBytes, limits.CPUSeconds, limits.MaxProcesses, limits.FileSizeBytes, limits.OutputBytes, limits.CgroupParent)
+
 	// Initialize commander
 	cmdConfig := commander.Config{
 		AllowedCommands: allowedList,
 		BlockedCommands: blockedList,
 		DefaultTimeout:  resolvedTimeout,
+		KillGracePeriod: resolvedKillGracePeriod,
 		Shell:           resolvedShell,
 		ShellArg:        resolvedShellArg,
+		Policy:          policy,
+		ArgvOnly:        resolvedArgvOnly,
+		Sandbox:         sandboxConfig,
+		Limits:          limits,
 	}
 	cmd = commander.NewCommander(cmdConfig)
+	jobs = commander.NewJobRegistry(cmd, *maxJobs, *jobBufferSize)
+	defer jobs.KillAll()
+	sessions = commander.NewSessionManager(cmd, *maxSessions, *sessionIdleTimeout, commander.DefaultSessionBufferSize)
+	defer sessions.CloseAll()
 
 	// Get shell info for logging
 	shellInfo, shellArgInfo := cmd.GetShellInfo()
@@ -115,8 +317,13 @@ func main() {
 	)
 	logger.LogStartup(startupInfo)
 
+	// Stop serving on SIGINT or SIGTERM, so that the deferred cleanup runs
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// Create MCP server
 	server := mcp.NewServer("go-mcp-commander", Version)
+	server.SetMaxInFlight(resolvedMaxConcurrent)
 
 	// Register tools
 	registerTools(server)
@@ -124,22 +331,53 @@ func main() {
 	// Run server
 	logger.Info("MCP server starting...")
 	if *httpMode {
+		validator, err := resolveAuth(cmdConfig)
+		if err != nil {
+			logger.Error("%v", err)
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		server.SetAuthenticator(validator)
+		server.SetLockout(resolveLockout())
+		tlsConfig, err := resolveTLS()
+		if err != nil {
+			logger.Error("%v", err)
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		server.SetTLS(tlsConfig)
+		if origins := resolvePriority(*httpAllowedOrigins, os.Getenv("MCP_HTTP_ALLOWED_ORIGINS"), ""); origins != "" {
+			server.SetAllowedOrigins(parseCommandList(origins))
+		}
+		server.SetAuthFailureHandler(func(failure auth.Failure) {
+			if failure.Locked {
+				logger.AuthLocked(failure.Client, failure.LockedFor)
+			} else {
+				logger.AuthFailure(failure.Client, failure.Reason, failure.Failures, failure.LockedFor)
+			}
+		})
+
 		addr := fmt.Sprintf("%s:%d", *httpHost, *httpPort)
 		logger.Info("Starting HTTP server on %s", addr)
-		if err := server.RunHTTP(addr); err != nil {
+		if err := server.RunHTTPContext(ctx, addr); err != nil {
 			logger.Error("HTTP server error: %v", err)
 			logger.LogShutdown(fmt.Sprintf("error: %v", err))
-			os.Exit(1)
+			return 1
 		}
 	} else {
-		if err := server.Run(); err != nil {
+		if err := server.RunContext(ctx); err != nil {
 			logger.Error("Server error: %v", err)
 			logger.LogShutdown(fmt.Sprintf("error: %v", err))
-			os.Exit(1)
+			return 1
 		}
 	}
 
-	logger.LogShutdown("normal")
+	if ctx.Err() != nil {
+		logger.LogShutdown("signal")
+	} else {
+		logger.LogShutdown("normal")
+	}
+	return 0
 }
 
 func registerTools(server *mcp.Server) {
@@ -151,13 +389,18 @@ func registerTools(server *mcp.Server) {
 	// Register execute_command tool
 	server.RegisterTool(mcp.Tool{
 		Name:        "execute_command",
-		Description: "Execute a system command and return its output. Commands are validated against allow/block lists before execution - use list_allowed_commands and list_blocked_commands to check what's permitted. Supports timeout, working directory, and environment variables.",
+		Description: "Execute a system command and return its output. Commands are validated against allow/block lists before execution - use list_allowed_commands and list_blocked_commands to check what's permitted. Supports timeout, working directory, and environment variables. If the request includes a progress token, output is streamed as progress notifications while the command runs.",
 		InputSchema: mcp.JSONSchema{
 			Type: "object",
 			Properties: map[string]mcp.Property{
 				"command": {
 					Type:        "string",
-					Description: "The command to execute. Will be validated against configured allow/block lists before execution.",
+					Description: "The command to execute through the shell. Will be validated against configured allow/block lists before execution. Provide either command or argv.",
+				},
+				"argv": {
+					Type:        "array",
+					Description: "The program and its arguments, run directly without a shell (e.g., [\"git\", \"commit\", \"-m\", \"it's done\"]). Arguments are passed exactly as given, so no quoting is needed and shell syntax has no effect. Provide either command or argv.",
+					Items:       &mcp.Property{Type: "string"},
 				},
 				"working_directory": {
 					Type:        "string",
@@ -168,12 +411,12 @@ func registerTools(server *mcp.Server) {
 					Description: "Timeout duration in Go duration format. Valid examples: '30s' (30 seconds), '1m' (1 minute), '5m' (5 minutes), '1h' (1 hour), '1m30s' (1 minute 30 seconds). Default is 30s. Maximum recommended: 1h.",
 				},
 				"env": {
-					Type:        "object",
-					Description: "Environment variables as key-value pairs (e.g., {\"NODE_ENV\": \"production\", \"DEBUG\": \"true\"}). These are added to the command's environment, supplementing (not replacing) existing environment variables.",
-					Properties:  map[string]mcp.Property{},
+					Type:                 "object",
+					Description:          "Environment variables as key-value pairs (e.g., {\"NODE_ENV\": \"production\", \"DEBUG\": \"true\"}). These are added to the command's environment, supplementing (not replacing) existing environment variables.",
+					AdditionalProperties: &mcp.Property{Type: "string"},
 				},
 			},
-			Required: []string{"command"},
+			Required: commandRequired(),
 		},
 		Annotations: &mcp.ToolAnnotations{
 			Title:           "Execute Command",
@@ -222,6 +465,20 @@ func registerTools(server *mcp.Server) {
 			Type:       "object",
 			Properties: map[string]mcp.Property{},
 		},
+		OutputSchema: &mcp.JSONSchema{
+			Type: "object",
+			Properties: map[string]mcp.Property{
+				"shell":             {Type: "string", Description: "Shell executable path"},
+				"shell_arg":         {Type: "string", Description: "Argument used to pass commands to the shell"},
+				"default_timeout":   {Type: "string", Description: "Default command timeout"},
+				"kill_grace_period": {Type: "string", Description: "Time stopped commands have to exit after SIGTERM"},
+				"argv_only":         {Type: "boolean", Description: "Whether command strings are refused in favour of argv"},
+				"sandbox":           {Type: "object", Description: "Sandbox applied to each command, if enabled"},
+				"limits":            {Type: "object", Description: "Resource limits applied to each command, if any"},
+				"limit_enforcement": {Type: "string", Enum: []string{"rlimit", "cgroup"}, Description: "How the limits are enforced"},
+			},
+			Required: []string{"shell", "shell_arg", "default_timeout", "kill_grace_period", "argv_only"},
+		},
 		Annotations: &mcp.ToolAnnotations{
 			Title:          "Get Shell Info",
 			ReadOnlyHint:   boolPtr(true),
@@ -229,10 +486,17 @@ func registerTools(server *mcp.Server) {
 		},
 	}, handleGetShellInfo)
 
+	registerJobTools(server)
+	// Shell sessions are inherently shell-based, so argv-only servers do not
+	// offer them
+	if !cmd.ArgvOnly() {
+		registerSessionTools(server)
+	}
+
 	// Register web_fetch tool
 	server.RegisterTool(mcp.Tool{
 		Name:        "web_fetch",
-		Description: "Fetch content from a URL and return the response body. Supports HTTP/HTTPS. Returns raw HTML/text content. Use for retrieving web pages, APIs, or any HTTP resource. Timeout defaults to 30s.",
+		Description: "Fetch content from a URL and return the response body. Supports HTTP/HTTPS. Returns raw content, or HTML converted to text or Markdown with the format parameter. Use for retrieving web pages, APIs, or any HTTP resource. Timeout defaults to 30s. Loopback, private and cloud metadata addresses are blocked unless the server allows them.",
 		InputSchema: mcp.JSONSchema{
 			Type: "object",
 			Properties: map[string]mcp.Property{
@@ -247,9 +511,9 @@ func registerTools(server *mcp.Server) {
 					Enum:        []string{"GET", "POST", "PUT", "DELETE", "HEAD", "OPTIONS"},
 				},
 				"headers": {
-					Type:        "object",
-					Description: "HTTP headers as key-value pairs (e.g., {\"Authorization\": \"Bearer token\", \"Accept\": \"application/json\"}).",
-					Properties:  map[string]mcp.Property{},
+					Type:                 "object",
+					Description:          "HTTP headers as key-value pairs (e.g., {\"Authorization\": \"Bearer token\", \"Accept\": \"application/json\"}).",
+					AdditionalProperties: &mcp.Property{Type: "string"},
 				},
 				"body": {
 					Type:        "string",
@@ -267,6 +531,18 @@ func registerTools(server *mcp.Server) {
 					Minimum:     intPtr(1024),
 					Maximum:     intPtr(10485760),
 				},
+				"format": {
+					Type:        "string",
+					Description: "How to return HTML pages: 'raw' (default) returns the body as is, 'text' the visible text, 'markdown' the page as Markdown with links and tables, 'readability' the main article as Markdown without navigation or sidebars. Other content types are returned as text in every format.",
+					Default:     "raw",
+					Enum:        web.Formats,
+				},
+				"cache": {
+					Type:        "string",
+					Description: "Response cache use: 'default' serves fresh cached responses and revalidates stale ones, 'refresh' always checks with the server, 'bypass' neither reads nor stores the cache. Default: 'default'.",
+					Default:     "default",
+					Enum:        web.CacheModes,
+				},
 			},
 			Required: []string{"url"},
 		},
@@ -281,7 +557,7 @@ func registerTools(server *mcp.Server) {
 	// Register google_search tool
 	server.RegisterTool(mcp.Tool{
 		Name:        "google_search",
-		Description: "Perform a Google search and return the search results page HTML. Results can be parsed to extract links, snippets, and titles. For structured results, consider using the Google Custom Search API instead.",
+		Description: "Search the web with the server's configured search provider (Google by default) and return the results as a JSON array of {title, url, snippet, position}, with tracking redirects removed. Use start to page through results. The provider's raw response is only returned if include_html is true.",
 		InputSchema: mcp.JSONSchema{
 			Type: "object",
 			Properties: map[string]mcp.Property{
@@ -307,6 +583,23 @@ func registerTools(server *mcp.Server) {
 					Default:     "moderate",
 					Enum:        []string{"off", "moderate", "strict"},
 				},
+				"start": {
+					Type:        "integer",
+					Description: "Offset of the first result, for pagination (e.g., 10 for the second page of 10 results). Default: 0.",
+					Default:     0,
+					Minimum:     intPtr(0),
+				},
+				"cache": {
+					Type:        "string",
+					Description: "Response cache use: 'default' serves fresh cached responses and revalidates stale ones, 'refresh' always checks with the server, 'bypass' neither reads nor stores the cache. Default: 'default'.",
+					Default:     "default",
+					Enum:        web.CacheModes,
+				},
+				"include_html": {
+					Type:        "boolean",
+					Description: "Also return the provider's raw response (results page HTML, or JSON for API providers) in the body field. Default: false.",
+					Default:     false,
+				},
 				"timeout": {
 					Type:        "string",
 					Description: "Request timeout in Go duration format (e.g., '30s', '1m'). Default: 30s.",
@@ -324,19 +617,266 @@ func registerTools(server *mcp.Server) {
 	}, handleGoogleSearch)
 }
 
-func handleExecuteCommand(args map[string]interface{}) (*mcp.CallToolResult, error) {
-	logger.ToolCall("execute_command", args)
+func registerJobTools(server *mcp.Server) {
+	boolPtr := func(b bool) *bool { return &b }
+	intPtr := func(i int) *int { return &i }
 
-	// Extract command
-	command, ok := args["command"].(string)
-	if !ok || command == "" {
-		return errorResult("command is required")
+	jobIDProperty := mcp.Property{
+		Type:        "string",
+		Description: "Job identifier returned by start_job (e.g., 'job-1').",
 	}
 
-	// Validate command
-	if err := cmd.ValidateCommand(command); err != nil {
-		logger.CommandBlocked(command, err.Error())
-		return errorResult(fmt.Sprintf("Command validation failed: %s", err.Error()))
+	// Register start_job tool
+	server.RegisterTool(mcp.Tool{
+		Name:        "start_job",
+		Description: "Start a command in the background and return immediately with a job ID. Use for dev servers, watchers, and long test suites that would exceed execute_command's timeout. Commands are validated against the same allow/block lists as execute_command. Poll with job_status and job_output, stop with kill_job.",
+		InputSchema: mcp.JSONSchema{
+			Type: "object",
+			Properties: map[string]mcp.Property{
+				"command": {
+					Type:        "string",
+					Description: "The command to run in the background through the shell. Will be validated against configured allow/block lists before execution. Provide either command or argv.",
+				},
+				"argv": {
+					Type:        "array",
+					Description: "The program and its arguments, run directly without a shell. Provide either command or argv.",
+					Items:       &mcp.Property{Type: "string"},
+				},
+				"working_directory": {
+					Type:        "string",
+					Description: "Working directory for the job. If not specified, uses the server's current working directory.",
+				},
+				"timeout": {
+					Type:        "string",
+					Description: "Optional maximum run time in Go duration format (e.g., '10m', '2h'). If not specified, the job runs until it exits or is killed.",
+				},
+				"env": {
+					Type:                 "object",
+					Description:          "Environment variables as key-value pairs, added to the server's environment.",
+					AdditionalProperties: &mcp.Property{Type: "string"},
+				},
+			},
+			Required: commandRequired(),
+		},
+		Annotations: &mcp.ToolAnnotations{
+			Title:           "Start Background Job",
+			ReadOnlyHint:    boolPtr(false),
+			DestructiveHint: boolPtr(true),
+			IdempotentHint:  boolPtr(false),
+			OpenWorldHint:   boolPtr(true),
+		},
+	}, handleStartJob)
+
+	// Register job_status tool
+	server.RegisterTool(mcp.Tool{
+		Name:        "job_status",
+		Description: "Get the state of a background job: running, exited, killed, timed_out, or failed, along with exit code, start/finish timestamps, duration, and total bytes of output produced.",
+		InputSchema: mcp.JSONSchema{
+			Type: "object",
+			Properties: map[string]mcp.Property{
+				"job_id": jobIDProperty,
+			},
+			Required: []string{"job_id"},
+		},
+		Annotations: &mcp.ToolAnnotations{
+			Title:          "Job Status",
+			ReadOnlyHint:   boolPtr(true),
+			IdempotentHint: boolPtr(true),
+		},
+	}, handleJobStatus)
+
+	// Register job_output tool
+	server.RegisterTool(mcp.Tool{
+		Name:        "job_output",
+		Description: "Read combined stdout/stderr of a background job. Offsets are absolute byte positions in the job's output; pass the returned next_offset to continue reading new output. A negative offset tails the output (e.g., -4096 for the last 4KB). Only the most recent output is retained, so old offsets may be skipped forward; 'truncated' reports when that happens.",
+		InputSchema: mcp.JSONSchema{
+			Type: "object",
+			Properties: map[string]mcp.Property{
+				"job_id": jobIDProperty,
+				"offset": {
+					Type:        "integer",
+					Description: "Byte offset to start reading from. Default: 0. Negative values count back from the end of the output.",
+					Default:     0,
+				},
+				"limit": {
+					Type:        "integer",
+					Description: "Maximum number of bytes to return. Default: 65536.",
+					Default:     65536,
+					Minimum:     intPtr(1),
+					Maximum:     intPtr(1048576),
+				},
+			},
+			Required: []string{"job_id"},
+		},
+		Annotations: &mcp.ToolAnnotations{
+			Title:          "Job Output",
+			ReadOnlyHint:   boolPtr(true),
+			IdempotentHint: boolPtr(true),
+		},
+	}, handleJobOutput)
+
+	// Register list_jobs tool
+	server.RegisterTool(mcp.Tool{
+		Name:        "list_jobs",
+		Description: "List the background jobs started by the caller, oldest first, with their current state. Finished jobs are retained until the job limit is reached.",
+		InputSchema: mcp.JSONSchema{
+			Type:       "object",
+			Properties: map[string]mcp.Property{},
+		},
+		Annotations: &mcp.ToolAnnotations{
+			Title:          "List Jobs",
+			ReadOnlyHint:   boolPtr(true),
+			IdempotentHint: boolPtr(true),
+		},
+	}, handleListJobs)
+
+	// Register kill_job tool
+	server.RegisterTool(mcp.Tool{
+		Name:        "kill_job",
+		Description: "Stop a running background job, killing its whole process tree, and return its final status. Killing a job that already finished just returns its status.",
+		InputSchema: mcp.JSONSchema{
+			Type: "object",
+			Properties: map[string]mcp.Property{
+				"job_id": jobIDProperty,
+			},
+			Required: []string{"job_id"},
+		},
+		Annotations: &mcp.ToolAnnotations{
+			Title:           "Kill Job",
+			ReadOnlyHint:    boolPtr(false),
+			DestructiveHint: boolPtr(true),
+			IdempotentHint:  boolPtr(true),
+		},
+	}, handleKillJob)
+}
+
+func registerSessionTools(server *mcp.Server) {
+	boolPtr := func(b bool) *bool { return &b }
+	intPtr := func(i int) *int { return &i }
+
+	sessionIDProperty := mcp.Property{
+		Type:        "string",
+		Description: "Session identifier returned by open_shell_session (e.g., 'session-1').",
+	}
+
+	// Register open_shell_session tool
+	server.RegisterTool(mcp.Tool{
+		Name:        "open_shell_session",
+		Description: "Open a persistent interactive shell. Unlike execute_command, state such as the current directory, exported variables and activated virtualenvs persists between inputs. On Linux the shell runs on a pseudo-terminal, so interactive programs behave as in a terminal and input is echoed. Sessions are closed automatically after a period of inactivity.",
+		InputSchema: mcp.JSONSchema{
+			Type: "object",
+			Properties: map[string]mcp.Property{
+				"working_directory": {
+					Type:        "string",
+					Description: "Initial working directory for the shell. If not specified, uses the server's current working directory.",
+				},
+				"env": {
+					Type:                 "object",
+					Description:          "Environment variables as key-value pairs, added to the server's environment.",
+					AdditionalProperties: &mcp.Property{Type: "string"},
+				},
+			},
+		},
+		Annotations: &mcp.ToolAnnotations{
+			Title:           "Open Shell Session",
+			ReadOnlyHint:    boolPtr(false),
+			DestructiveHint: boolPtr(false),
+			IdempotentHint:  boolPtr(false),
+		},
+	}, handleOpenShellSession)
+
+	// Register send_input tool
+	server.RegisterTool(mcp.Tool{
+		Name:        "send_input",
+		Description: "Send input to a shell session. Each line is validated against the configured allow/block lists before anything is sent. While commands are restricted, control characters other than Ctrl-C and Ctrl-D are refused, including tab, which the shell would use to complete words after the line was checked; separate words with spaces instead. Returns the output offset at which the response to this input begins; pass it to read_output.",
+		InputSchema: mcp.JSONSchema{
+			Type: "object",
+			Properties: map[string]mcp.Property{
+				"session_id": sessionIDProperty,
+				"input": {
+					Type:        "string",
+					Description: "Text to send to the shell, e.g. a command line.",
+				},
+				"newline": {
+					Type:        "boolean",
+					Description: "Append a newline so the shell executes the input. Default: true. Set to false to send partial input or control characters. While commands are restricted, partial input is held back until a later call completes the line (or the command, when a line ends in a backslash or leaves a quote or here-document open), and only Ctrl-C (\\u0003) and Ctrl-D (\\u0004) at the start of a line are accepted as control characters.",
+					Default:     true,
+				},
+			},
+			Required: []string{"session_id", "input"},
+		},
+		Annotations: &mcp.ToolAnnotations{
+			Title:           "Send Shell Input",
+			ReadOnlyHint:    boolPtr(false),
+			DestructiveHint: boolPtr(true),
+			IdempotentHint:  boolPtr(false),
+			OpenWorldHint:   boolPtr(true),
+		},
+	}, handleSendInput)
+
+	// Register read_output tool
+	server.RegisterTool(mcp.Tool{
+		Name:        "read_output",
+		Description: "Read terminal output from a shell session starting at a byte offset. Pass the returned next_offset to continue reading. Use 'wait' to block until new output arrives.",
+		InputSchema: mcp.JSONSchema{
+			Type: "object",
+			Properties: map[string]mcp.Property{
+				"session_id": sessionIDProperty,
+				"offset": {
+					Type:        "integer",
+					Description: "Byte offset to start reading from. Default: 0. Negative values count back from the end of the output.",
+					Default:     0,
+				},
+				"limit": {
+					Type:        "integer",
+					Description: "Maximum number of bytes to return. Default: 65536.",
+					Default:     65536,
+					Minimum:     intPtr(1),
+					Maximum:     intPtr(1048576),
+				},
+				"wait": {
+					Type:        "string",
+					Description: "How long to wait for output beyond 'offset' in Go duration format (e.g., '2s'). Default: '0s', max: 30s.",
+					Default:     "0s",
+				},
+			},
+			Required: []string{"session_id"},
+		},
+		Annotations: &mcp.ToolAnnotations{
+			Title:          "Read Shell Output",
+			ReadOnlyHint:   boolPtr(true),
+			IdempotentHint: boolPtr(true),
+		},
+	}, handleReadOutput)
+
+	// Register close_session tool
+	server.RegisterTool(mcp.Tool{
+		Name:        "close_session",
+		Description: "Close a shell session, terminating the shell and any processes it started.",
+		InputSchema: mcp.JSONSchema{
+			Type: "object",
+			Properties: map[string]mcp.Property{
+				"session_id": sessionIDProperty,
+			},
+			Required: []string{"session_id"},
+		},
+		Annotations: &mcp.ToolAnnotations{
+			Title:           "Close Shell Session",
+			ReadOnlyHint:    boolPtr(false),
+			DestructiveHint: boolPtr(true),
+			IdempotentHint:  boolPtr(true),
+		},
+	}, handleCloseSession)
+}
+
+func handleExecuteCommand(ctx context.Context, args map[string]interface{}) (*mcp.CallToolResult, error) {
+	logger := requestLogger(ctx)
+	logger.ToolCall("execute_command", args)
+
+	// Extract command or argv
+	command, argv, errMsg := getCommand(args)
+	if errMsg != "" {
+		return errorResult(errMsg)
 	}
 
 	// Extract optional parameters
@@ -344,18 +884,43 @@ func handleExecuteCommand(args map[string]interface{}) (*mcp.CallToolResult, err
 	timeoutStr := getString(args, "timeout", "")
 	envMap := getStringMap(args, "env")
 
+	// Validate command
+	decision, err := validateCommand(ctx, command, argv, workDir, envMap)
+	if err != nil {
+		logger.CommandBlocked(command, err.Error())
+		return validationErrorResult("Command validation failed", err)
+	}
+
 	// Parse timeout
 	var timeout time.Duration
 	if timeoutStr != "" {
-		var err error
 		timeout, err = time.ParseDuration(timeoutStr)
 		if err != nil {
 			return errorResult(fmt.Sprintf("Invalid timeout format: %s", err.Error()))
 		}
 	}
-
-	// Execute command
-	result := cmd.Execute(context.Background(), command, workDir, timeout, envMap)
+	if timeout <= 0 {
+		timeout = cmd.GetDefaultTimeout()
+	}
+	timeout = limitTimeout(timeout, decision)
+
+	// Execute command, streaming output as progress notifications when the
+	// client supplied a progress token
+	var onOutput commander.OutputFunc
+	var progress *outputProgress
+	if mcp.HasProgress(ctx) {
+		progress = newOutputProgress(ctx)
+		onOutput = progress.write
+	}
+	var result *commander.Result
+	if argv != nil {
+		result = cmd.ExecuteArgv(ctx, argv, workDir, timeout, envMap, onOutput)
+	} else {
+		result = cmd.ExecuteStream(ctx, command, workDir, timeout, envMap, onOutput)
+	}
+	if progress != nil {
+		progress.flush()
+	}
 
 	// Log execution
 	logger.CommandExec(command, workDir, result.ExitCode, result.Duration, result.Error)
@@ -370,6 +935,30 @@ func handleExecuteCommand(args map[string]interface{}) (*mcp.CallToolResult, err
 	if result.Error != nil {
 		response["error"] = result.Error.Error()
 	}
+	if result.Cancelled {
+		response["cancelled"] = true
+	}
+	if result.ForcedKill {
+		response["forced_kill"] = true
+	}
+	if result.Sandboxed {
+		response["sandboxed"] = true
+	}
+	if len(result.SandboxViolations) > 0 {
+		response["sandbox_violations"] = result.SandboxViolations
+	}
+	if len(result.LimitsExceeded) > 0 {
+		response["limits_exceeded"] = result.LimitsExceeded
+	}
+	if len(result.Hints) > 0 {
+		response["hints"] = result.Hints
+	}
+	if result.StdoutTruncated {
+		response["stdout_truncated"] = true
+	}
+	if result.StderrTruncated {
+		response["stderr_truncated"] = true
+	}
 
 	data, _ := json.MarshalIndent(response, "", "  ")
 
@@ -384,7 +973,8 @@ func handleExecuteCommand(args map[string]interface{}) (*mcp.CallToolResult, err
 	return textResult(string(data))
 }
 
-func handleListAllowedCommands(args map[string]interface{}) (*mcp.CallToolResult, error) {
+func handleListAllowedCommands(ctx context.Context, args map[string]interface{}) (*mcp.CallToolResult, error) {
+	logger := requestLogger(ctx)
 	logger.ToolCall("list_allowed_commands", args)
 
 	allowedStr := *allowedCmds
@@ -401,12 +991,23 @@ func handleListAllowedCommands(args map[string]interface{}) (*mcp.CallToolResult
 		"allowed_commands": allowed,
 		"allow_all":        len(allowed) == 0,
 	}
+	if policy := cmd.GetPolicy(); policy != nil {
+		response["policy"] = policy
+		if len(policy.Rules) > 0 || len(policy.WorkingDirectories) > 0 || len(policy.AllowedEnv) > 0 || policy.Default == commander.ActionDeny {
+			response["allow_all"] = false
+		}
+	}
+	if c := identityCommander(ctx); c != nil {
+		response["identity_policy"] = c.GetPolicy()
+		response["allow_all"] = false
+	}
 
 	data, _ := json.MarshalIndent(response, "", "  ")
 	return textResult(string(data))
 }
 
-func handleListBlockedCommands(args map[string]interface{}) (*mcp.CallToolResult, error) {
+func handleListBlockedCommands(ctx context.Context, args map[string]interface{}) (*mcp.CallToolResult, error) {
+	logger := requestLogger(ctx)
 	logger.ToolCall("list_blocked_commands", args)
 
 	blockedStr := *blockedCmds
@@ -431,45 +1032,353 @@ func handleListBlockedCommands(args map[string]interface{}) (*mcp.CallToolResult
 	return textResult(string(data))
 }
 
-func handleGetShellInfo(args map[string]interface{}) (*mcp.CallToolResult, error) {
+func handleGetShellInfo(ctx context.Context, args map[string]interface{}) (*mcp.CallToolResult, error) {
+	logger := requestLogger(ctx)
 	logger.ToolCall("get_shell_info", args)
 
 	shell, shellArg := cmd.GetShellInfo()
 
 	response := map[string]interface{}{
-		"shell":           shell,
-		"shell_arg":       shellArg,
-		"default_timeout": cmd.GetDefaultTimeout().String(),
+		"shell":             shell,
+		"shell_arg":         shellArg,
+		"default_timeout":   cmd.GetDefaultTimeout().String(),
+		"kill_grace_period": cmd.GetKillGracePeriod().String(),
+		"argv_only":         cmd.ArgvOnly(),
+	}
+	if sandbox := cmd.GetSandbox(); sandbox != nil {
+		response["sandbox"] = sandbox
+	}
+	if limits := cmd.GetLimits(); limits != nil {
+		response["limits"] = limits
+		enforcement := "rlimit"
+		if limits.CgroupParent != "" {
+			enforcement = "cgroup"
+		}
+		response["limit_enforcement"] = enforcement
 	}
 
-	data, _ := json.MarshalIndent(response, "", "  ")
-	return textResult(string(data))
+	return structuredResult(response)
 }
 
-func handleWebFetch(args map[string]interface{}) (*mcp.CallToolResult, error) {
-	logger.ToolCall("web_fetch", args)
+func handleStartJob(ctx context.Context, args map[string]interface{}) (*mcp.CallToolResult, error) {
+	logger := requestLogger(ctx)
+	logger.ToolCall("start_job", args)
 
-	// Extract URL (required)
-	urlStr, ok := args["url"].(string)
-	if !ok || urlStr == "" {
-		return errorResult("url is required")
+	command, argv, errMsg := getCommand(args)
+	if errMsg != "" {
+		return errorResult(errMsg)
 	}
 
-	// Validate URL
-	parsedURL, err := url.Parse(urlStr)
+	workDir := getString(args, "working_directory", "")
+	timeoutStr := getString(args, "timeout", "")
+	envMap := getStringMap(args, "env")
+
+	decision, err := validateCommand(ctx, command, argv, workDir, envMap)
 	if err != nil {
-		return errorResult(fmt.Sprintf("Invalid URL: %s", err.Error()))
-	}
-	if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
-		return errorResult("URL must use http:// or https:// protocol")
+		logger.CommandBlocked(command, err.Error())
+		return validationErrorResult("Command validation failed", err)
 	}
 
-	// Extract optional parameters
-	method := getString(args, "method", "GET")
-	body := getString(args, "body", "")
-	timeoutStr := getString(args, "timeout", "30s")
+	var timeout time.Duration
+	if timeoutStr != "" {
+		timeout, err = time.ParseDuration(timeoutStr)
+		if err != nil {
+			return errorResult(fmt.Sprintf("Invalid timeout format: %s", err.Error()))
+		}
+	}
+	timeout = limitTimeout(timeout, decision)
+
+	var job *commander.Job
+	if argv != nil {
+		job, err = jobs.StartArgv(argv, workDir, timeout, envMap, callerIdentity(ctx))
+	} else {
+		job, err = jobs.Start(command, workDir, timeout, envMap, callerIdentity(ctx))
+	}
+	if err != nil {
+		return errorResult(fmt.Sprintf("Failed to start job: %s", err.Error()))
+	}
+
+	logger.Access("JOB_START: id=%s command=%q dir=%q", job.ID, job.Command, workDir)
+
+	data, _ := json.MarshalIndent(job.Info(), "", "  ")
+	return textResult(string(data))
+}
+
+func handleJobStatus(ctx context.Context, args map[string]interface{}) (*mcp.CallToolResult, error) {
+	logger := requestLogger(ctx)
+	logger.ToolCall("job_status", args)
+
+	job, errResult := lookupJob(ctx, args)
+	if job == nil {
+		return errResult, nil
+	}
+
+	data, _ := json.MarshalIndent(job.Info(), "", "  ")
+	return textResult(string(data))
+}
+
+func handleJobOutput(ctx context.Context, args map[string]interface{}) (*mcp.CallToolResult, error) {
+	logger := requestLogger(ctx)
+	logger.ToolCall("job_output", args)
+
+	job, errResult := lookupJob(ctx, args)
+	if job == nil {
+		return errResult, nil
+	}
+
+	offset := int64(getInt(args, "offset", 0))
+	limit := getInt(args, "limit", 65536)
+	if limit < 1 || limit > 1048576 {
+		limit = 65536
+	}
+
+	output, start, next := job.Output(offset, limit)
+	info := job.Info()
+
+	response := map[string]interface{}{
+		"job_id":       job.ID,
+		"state":        info.State,
+		"output":       string(output),
+		"offset":       start,
+		"next_offset":  next,
+		"output_bytes": info.OutputBytes,
+		"truncated":    offset >= 0 && start > offset,
+		"more":         next < info.OutputBytes,
+	}
+
+	data, _ := json.MarshalIndent(response, "", "  ")
+	return textResult(string(data))
+}
+
+func handleListJobs(ctx context.Context, args map[string]interface{}) (*mcp.CallToolResult, error) {
+	logger := requestLogger(ctx)
+	logger.ToolCall("list_jobs", args)
+
+	response := map[string]interface{}{
+		"jobs": jobs.List(callerIdentity(ctx)),
+	}
+
+	data, _ := json.MarshalIndent(response, "", "  ")
+	return textResult(string(data))
+}
+
+func handleKillJob(ctx context.Context, args map[string]interface{}) (*mcp.CallToolResult, error) {
+	logger := requestLogger(ctx)
+	logger.ToolCall("kill_job", args)
+
+	jobID := getString(args, "job_id", "")
+	if jobID == "" {
+		return errorResult("job_id is required")
+	}
+
+	job, err := jobs.Kill(jobID, callerIdentity(ctx))
+	if err != nil {
+		return errorResult(err.Error())
+	}
+
+	logger.Access("JOB_KILL: id=%s", job.ID)
+
+	data, _ := json.MarshalIndent(job.Info(), "", "  ")
+	return textResult(string(data))
+}
+
+// lookupJob finds the caller's job named by the job_id argument, returning
+// an error result if it is missing or unknown
+func lookupJob(ctx context.Context, args map[string]interface{}) (*commander.Job, *mcp.CallToolResult) {
+	jobID := getString(args, "job_id", "")
+	if jobID == "" {
+		result, _ := errorResult("job_id is required")
+		return nil, result
+	}
+
+	job, ok := jobs.Get(jobID, callerIdentity(ctx))
+	if !ok {
+		result, _ := errorResult(fmt.Sprintf("job not found: %s", jobID))
+		return nil, result
+	}
+	return job, nil
+}
+
+func handleOpenShellSession(ctx context.Context, args map[string]interface{}) (*mcp.CallToolResult, error) {
+	logger := requestLogger(ctx)
+	logger.ToolCall("open_shell_session", args)
+
+	workDir := getString(args, "working_directory", "")
+	envMap := getStringMap(args, "env")
+
+	if c := identityCommander(ctx); c != nil {
+		if err := c.ValidateEnvironment(workDir, envMap); err != nil {
+			return errorResult(fmt.Sprintf("Failed to open session: %s", err.Error()))
+		}
+	}
+
+	session, err := sessions.Open(workDir, envMap, callerIdentity(ctx))
+	if err != nil {
+		return errorResult(fmt.Sprintf("Failed to open session: %s", err.Error()))
+	}
+
+	logger.Access("SESSION_OPEN: id=%s shell=%q dir=%q pty=%v", session.ID, session.Shell, workDir, session.PTY)
+
+	data, _ := json.MarshalIndent(session.Info(), "", "  ")
+	return textResult(string(data))
+}
+
+func handleSendInput(ctx context.Context, args map[string]interface{}) (*mcp.CallToolResult, error) {
+	logger := requestLogger(ctx)
+	logger.ToolCall("send_input", args)
+
+	session, errResult := lookupSession(ctx, args)
+	if session == nil {
+		return errResult, nil
+	}
+
+	input, ok := args["input"].(string)
+	if !ok {
+		return errorResult("input is required")
+	}
+	newline := true
+	if val, ok := args["newline"].(bool); ok {
+		newline = val
+	}
+
+	offset := session.Info().OutputBytes
+	if err := sessions.SendInput(session.ID, session.Owner, input, newline, identityCommander(ctx)); err != nil {
+		logger.CommandBlocked(input, err.Error())
+		return validationErrorResult("Input rejected", err)
+	}
+
+	logger.Access("SESSION_INPUT: id=%s input=%q", session.ID, input)
+
+	response := map[string]interface{}{
+		"session_id": session.ID,
+		"offset":     offset,
+	}
+
+	data, _ := json.MarshalIndent(response, "", "  ")
+	return textResult(string(data))
+}
+
+func handleReadOutput(ctx context.Context, args map[string]interface{}) (*mcp.CallToolResult, error) {
+	logger := requestLogger(ctx)
+	logger.ToolCall("read_output", args)
+
+	session, errResult := lookupSession(ctx, args)
+	if session == nil {
+		return errResult, nil
+	}
+
+	offset := int64(getInt(args, "offset", 0))
+	limit := getInt(args, "limit", 65536)
+	if limit < 1 || limit > 1048576 {
+		limit = 65536
+	}
+
+	wait, err := time.ParseDuration(getString(args, "wait", "0s"))
+	if err != nil {
+		return errorResult(fmt.Sprintf("Invalid wait format: %s", err.Error()))
+	}
+	if wait > 30*time.Second {
+		wait = 30 * time.Second
+	}
+	if wait > 0 && offset >= 0 {
+		session.WaitOutput(ctx, offset, wait)
+	}
+
+	output, start, next := session.Output(offset, limit)
+	info := session.Info()
+
+	response := map[string]interface{}{
+		"session_id":   session.ID,
+		"running":      info.Running,
+		"output":       string(output),
+		"offset":       start,
+		"next_offset":  next,
+		"output_bytes": info.OutputBytes,
+		"truncated":    offset >= 0 && start > offset,
+		"more":         next < info.OutputBytes,
+	}
+	if info.ExitCode != nil {
+		response["exit_code"] = *info.ExitCode
+	}
+
+	data, _ := json.MarshalIndent(response, "", "  ")
+	return textResult(string(data))
+}
+
+func handleCloseSession(ctx context.Context, args map[string]interface{}) (*mcp.CallToolResult, error) {
+	logger := requestLogger(ctx)
+	logger.ToolCall("close_session", args)
+
+	sessionID := getString(args, "session_id", "")
+	if sessionID == "" {
+		return errorResult("session_id is required")
+	}
+
+	session, err := sessions.Close(sessionID, callerIdentity(ctx))
+	if err != nil {
+		return errorResult(err.Error())
+	}
+
+	logger.Access("SESSION_CLOSE: id=%s", session.ID)
+
+	data, _ := json.MarshalIndent(session.Info(), "", "  ")
+	return textResult(string(data))
+}
+
+// lookupSession finds the caller's session named by the session_id
+// argument, returning an error result if it is missing or unknown
+func lookupSession(ctx context.Context, args map[string]interface{}) (*commander.Session, *mcp.CallToolResult) {
+	sessionID := getString(args, "session_id", "")
+	if sessionID == "" {
+		result, _ := errorResult("session_id is required")
+		return nil, result
+	}
+
+	session, ok := sessions.Get(sessionID, callerIdentity(ctx))
+	if !ok {
+		result, _ := errorResult(fmt.Sprintf("session not found: %s", sessionID))
+		return nil, result
+	}
+	return session, nil
+}
+
+func handleWebFetch(ctx context.Context, args map[string]interface{}) (*mcp.CallToolResult, error) {
+	logger := requestLogger(ctx)
+	logger.ToolCall("web_fetch", args)
+
+	// Extract URL (required)
+	urlStr, ok := args["url"].(string)
+	if !ok || urlStr == "" {
+		return errorResult("url is required")
+	}
+
+	// Validate URL
+	parsedURL, err := url.Parse(urlStr)
+	if err != nil {
+		return errorResult(fmt.Sprintf("Invalid URL: %s", err.Error()))
+	}
+	if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
+		return errorResult("URL must use http:// or https:// protocol")
+	}
+	if err := fetchPolicy.CheckURL(parsedURL); err != nil {
+		logger.Warn("web_fetch: %v", err)
+		return errorResult(err.Error())
+	}
+
+	// Extract optional parameters
+	method := getString(args, "method", "GET")
+	body := getString(args, "body", "")
+	timeoutStr := getString(args, "timeout", "30s")
 	maxSize := getInt(args, "max_size", 1048576) // 1MB default
 	headers := getStringMap(args, "headers")
+	format, err := web.ParseFormat(getString(args, "format", ""))
+	if err != nil {
+		return errorResult(err.Error())
+	}
+	cacheMode, err := web.ParseCacheMode(getString(args, "cache", ""))
+	if err != nil {
+		return errorResult(err.Error())
+	}
 
 	// Parse timeout
 	timeout, err := time.ParseDuration(timeoutStr)
@@ -480,10 +1389,9 @@ func handleWebFetch(args map[string]interface{}) (*mcp.CallToolResult, error) {
 		timeout = 5 * time.Minute
 	}
 
-	// Create HTTP client with timeout
-	client := &http.Client{
-		Timeout: timeout,
-	}
+	// Create HTTP client with timeout that enforces the destination policy
+	// on redirects and on the addresses it connects to, behind the cache
+	client := cachedClient(fetchPolicy.NewClient(timeout))
 
 	// Create request
 	var reqBody io.Reader
@@ -491,7 +1399,7 @@ func handleWebFetch(args map[string]interface{}) (*mcp.CallToolResult, error) {
 		reqBody = strings.NewReader(body)
 	}
 
-	req, err := http.NewRequest(method, urlStr, reqBody)
+	req, err := http.NewRequestWithContext(web.WithCacheMode(ctx, cacheMode), method, urlStr, reqBody)
 	if err != nil {
 		return errorResult(fmt.Sprintf("Failed to create request: %s", err.Error()))
 	}
@@ -508,6 +1416,14 @@ func handleWebFetch(args map[string]interface{}) (*mcp.CallToolResult, error) {
 	startTime := time.Now()
 	resp, err := client.Do(req)
 	if err != nil {
+		if ctx.Err() == context.Canceled {
+			return errorResult("Request cancelled")
+		}
+		var destErr *web.DestinationError
+		if errors.As(err, &destErr) {
+			logger.Warn("web_fetch: %v", destErr)
+			return errorResult(destErr.Error())
+		}
 		return errorResult(fmt.Sprintf("Request failed: %s", err.Error()))
 	}
 	defer resp.Body.Close()
@@ -520,15 +1436,38 @@ func handleWebFetch(args map[string]interface{}) (*mcp.CallToolResult, error) {
 	}
 
 	duration := time.Since(startTime)
+	cacheStatus := resp.Header.Get(web.CacheStatusHeader)
+	resp.Header.Del(web.CacheStatusHeader)
+
+	// Decode the body and convert HTML to the requested format, resolving
+	// links against the URL after any redirects
+	contentType := resp.Header.Get("Content-Type")
+	doc, err := web.Convert(respBody, contentType, resp.Request.URL, format)
+	if err != nil {
+		return errorResult(fmt.Sprintf("Failed to convert response: %s", err.Error()))
+	}
 
 	// Build response
 	response := map[string]interface{}{
 		"status_code":    resp.StatusCode,
 		"status":         resp.Status,
 		"content_length": len(respBody),
-		"content_type":   resp.Header.Get("Content-Type"),
+		"content_type":   contentType,
 		"duration":       duration.String(),
-		"body":           string(respBody),
+		"format":         string(format),
+		"body":           doc.Content,
+	}
+	if cacheStatus != "" {
+		response["cache"] = cacheStatus
+	}
+	if doc.Charset != "" {
+		response["charset"] = doc.Charset
+	}
+	if doc.Title != "" {
+		response["title"] = doc.Title
+	}
+	if doc.CanonicalURL != "" {
+		response["canonical_url"] = doc.CanonicalURL
 	}
 
 	// Add response headers
@@ -538,7 +1477,7 @@ func handleWebFetch(args map[string]interface{}) (*mcp.CallToolResult, error) {
 	}
 	response["headers"] = respHeaders
 
-	logger.Info("web_fetch: %s %s -> %d (%d bytes, %s)", method, urlStr, resp.StatusCode, len(respBody), duration)
+	logger.Info("web_fetch: %s %s -> %d (%d bytes, %s, cache %s)", method, urlStr, resp.StatusCode, len(respBody), duration, cacheStatus)
 
 	data, _ := json.MarshalIndent(response, "", "  ")
 
@@ -553,7 +1492,8 @@ func handleWebFetch(args map[string]interface{}) (*mcp.CallToolResult, error) {
 	return textResult(string(data))
 }
 
-func handleGoogleSearch(args map[string]interface{}) (*mcp.CallToolResult, error) {
+func handleGoogleSearch(ctx context.Context, args map[string]interface{}) (*mcp.CallToolResult, error) {
+	logger := requestLogger(ctx)
 	logger.ToolCall("google_search", args)
 
 	// Extract query (required)
@@ -567,6 +1507,12 @@ func handleGoogleSearch(args map[string]interface{}) (*mcp.CallToolResult, error
 	language := getString(args, "language", "en")
 	safeSearch := getString(args, "safe_search", "moderate")
 	timeoutStr := getString(args, "timeout", "30s")
+	start := getInt(args, "start", 0)
+	includeHTML := getBool(args, "include_html", false)
+	cacheMode, err := web.ParseCacheMode(getString(args, "cache", ""))
+	if err != nil {
+		return errorResult(err.Error())
+	}
 
 	// Clamp num_results
 	if numResults < 10 {
@@ -575,6 +1521,9 @@ func handleGoogleSearch(args map[string]interface{}) (*mcp.CallToolResult, error
 	if numResults > 100 {
 		numResults = 100
 	}
+	if start < 0 {
+		return errorResult("start must not be negative")
+	}
 
 	// Parse timeout
 	timeout, err := time.ParseDuration(timeoutStr)
@@ -582,63 +1531,53 @@ func handleGoogleSearch(args map[string]interface{}) (*mcp.CallToolResult, error
 		timeout = 30 * time.Second
 	}
 
-	// Build Google search URL
-	searchURL := fmt.Sprintf("https://www.google.com/search?q=%s&num=%d&hl=%s&safe=%s",
-		url.QueryEscape(query),
-		numResults,
-		url.QueryEscape(language),
-		url.QueryEscape(safeSearch),
-	)
-
-	// Create HTTP client with timeout
-	client := &http.Client{
-		Timeout: timeout,
-	}
-
-	// Create request
-	req, err := http.NewRequest("GET", searchURL, nil)
-	if err != nil {
-		return errorResult(fmt.Sprintf("Failed to create request: %s", err.Error()))
-	}
-
-	// Set headers to appear as regular browser (Google blocks obvious bots)
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36")
-	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
-	req.Header.Set("Accept-Language", language+",en;q=0.5")
-
-	// Execute request
+	// Run the search with the configured provider. Its endpoint is set by
+	// the operator, not the caller, so the fetch destination policy does
+	// not apply. Identical searches are answered from the cache.
+	client := cachedClient(&http.Client{Timeout: timeout})
+	searchCtx := web.WithCacheTTL(web.WithCacheMode(ctx, cacheMode), searchTTL)
 	startTime := time.Now()
-	resp, err := client.Do(req)
+	result, err := searcher.Search(searchCtx, client, web.SearchRequest{
+		Query:      query,
+		Count:      numResults,
+		Start:      start,
+		Language:   language,
+		SafeSearch: safeSearch,
+	})
 	if err != nil {
+		if ctx.Err() == context.Canceled {
+			return errorResult("Search cancelled")
+		}
 		return errorResult(fmt.Sprintf("Search request failed: %s", err.Error()))
 	}
-	defer resp.Body.Close()
-
-	// Read response body (limit to 2MB for search results)
-	limitedReader := io.LimitReader(resp.Body, 2*1024*1024)
-	respBody, err := io.ReadAll(limitedReader)
-	if err != nil {
-		return errorResult(fmt.Sprintf("Failed to read response: %s", err.Error()))
-	}
 
 	duration := time.Since(startTime)
 
 	// Build response
 	response := map[string]interface{}{
 		"query":          query,
-		"status_code":    resp.StatusCode,
-		"content_length": len(respBody),
+		"provider":       searcher.Name(),
+		"start":          start,
+		"status_code":    result.StatusCode,
+		"content_length": len(result.Body),
 		"duration":       duration.String(),
-		"search_url":     searchURL,
-		"body":           string(respBody),
+		"search_url":     result.URL,
+		"result_count":   len(result.Results),
+		"results":        result.Results,
+	}
+	if result.CacheStatus != "" {
+		response["cache"] = result.CacheStatus
+	}
+	if includeHTML {
+		response["body"] = string(result.Body)
 	}
 
-	logger.Info("google_search: query=%q -> %d (%d bytes, %s)", query, resp.StatusCode, len(respBody), duration)
+	logger.Info("google_search: %s query=%q start=%d -> %d (%d results, %d bytes, %s, cache %s)", searcher.Name(), query, start, result.StatusCode, len(result.Results), len(result.Body), duration, result.CacheStatus)
 
 	data, _ := json.MarshalIndent(response, "", "  ")
 
 	// Return error result for non-2xx status codes
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+	if result.StatusCode < 200 || result.StatusCode >= 300 {
 		return &mcp.CallToolResult{
 			Content: []mcp.ContentItem{{Type: "text", Text: string(data)}},
 			IsError: true,
@@ -648,6 +1587,90 @@ func handleGoogleSearch(args map[string]interface{}) (*mcp.CallToolResult, error
 	return textResult(string(data))
 }
 
+// outputProgress coalesces command output into progress notifications so that
+// chatty commands do not flood the client with one message per write
+type outputProgress struct {
+	ctx     context.Context
+	mu      sync.Mutex
+	pending map[string]*strings.Builder
+	timer   *time.Timer
+	// reported is the number of output bytes sent so far, which is the
+	// progress of the last notification
+	reported int
+	// stderrMidLine is set when the last stderr chunk sent did not end a line
+	stderrMidLine bool
+}
+
+// progressInterval is the minimum delay between output notifications
+const progressInterval = 250 * time.Millisecond
+
+func newOutputProgress(ctx context.Context) *outputProgress {
+	return &outputProgress{
+		ctx:     ctx,
+		pending: make(map[string]*strings.Builder),
+	}
+}
+
+// write buffers a chunk of output and schedules a flush
+func (p *outputProgress) write(stream string, data []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	buf, ok := p.pending[stream]
+	if !ok {
+		buf = &strings.Builder{}
+		p.pending[stream] = buf
+	}
+	buf.Write(data)
+
+	if p.timer == nil {
+		p.timer = time.AfterFunc(progressInterval, p.flush)
+	}
+}
+
+// flush sends buffered output as one notification per stream. Progress is the
+// number of output bytes sent so far, so it increases with every
+// notification.
+func (p *outputProgress) flush() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+	for _, stream := range []string{"stdout", "stderr"} {
+		buf, ok := p.pending[stream]
+		if !ok || buf.Len() == 0 {
+			continue
+		}
+		message := buf.String()
+		p.reported += len(message)
+		if stream == "stderr" {
+			message = prefixLines(message, "[stderr] ", !p.stderrMidLine)
+			p.stderrMidLine = !strings.HasSuffix(message, "\n")
+		}
+		mcp.ReportProgress(p.ctx, float64(p.reported), 0, message)
+		buf.Reset()
+	}
+}
+
+// prefixLines prefixes every line of text that starts in it, including the
+// first if atLineStart is set
+func prefixLines(text, prefix string, atLineStart bool) string {
+	var b strings.Builder
+	for i, line := range strings.SplitAfter(text, "\n") {
+		if line == "" {
+			continue
+		}
+		if i > 0 || atLineStart {
+			b.WriteString(prefix)
+		}
+		b.WriteString(line)
+	}
+	return b.String()
+}
+
 // Helper functions
 
 func resolvePriority(flagVal, envVal, defaultVal string) string {
@@ -660,6 +1683,371 @@ func resolvePriority(flagVal, envVal, defaultVal string) string {
 	return defaultVal
 }
 
+// runValidatePolicy implements the validate-policy subcommand. It loads and
+// validates a policy file and, for each further argument, prints the
+// decision for that command. It returns 1 if the policy is invalid or any
+// command is denied.
+func runValidatePolicy(args []string) int {
+	fs := flag.NewFlagSet("validate-policy", flag.ContinueOnError)
+	allowed := fs.String("allowed-commands", "", "Comma-separated list of allowed command prefixes")
+	blocked := fs.String("blocked-commands", "", "Comma-separated list of blocked command patterns")
+	useDefaults := fs.Bool("use-default-blocklist", true, "Use default blocklist of dangerous commands")
+	shellName := fs.String("shell", "", "Shell whose syntax commands are parsed with")
+	workDir := fs.String("working-directory", "", "Working directory to check commands in")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s validate-policy [options] POLICY_FILE [COMMAND...]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return 2
+	}
+
+	path := fs.Arg(0)
+	file, err := loadPolicyFile(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	policy := &file.Policy
+
+	allowRules := 0
+	for _, rule := range policy.Rules {
+		if rule.Action == commander.ActionAllow {
+			allowRules++
+		}
+	}
+	fmt.Printf("%s: OK (%d rules: %d allow, %d deny)\n", path, len(policy.Rules), allowRules, len(policy.Rules)-allowRules)
+
+	var blockedList []string
+	if *blocked != "" {
+		blockedList = parseCommandList(*blocked)
+	}
+	if *useDefaults {
+		blockedList = append(blockedList, commander.DefaultBlockedCommands()...)
+	}
+	var allowedList []string
+	if *allowed != "" {
+		allowedList = parseCommandList(*allowed)
+	}
+	checker := commander.NewCommander(commander.Config{
+		AllowedCommands: allowedList,
+		BlockedCommands: blockedList,
+		Shell:           *shellName,
+		Policy:          policy,
+	})
+
+	status := 0
+	for _, command := range fs.Args()[1:] {
+		decision := checker.CheckCommandIn(command, *workDir)
+		data, _ := json.MarshalIndent(decision, "", "  ")
+		fmt.Println(string(data))
+		if !decision.Allowed {
+			status = 1
+		}
+	}
+	return status
+}
+
+// isFlagSet reports whether a flag was given explicitly on the command line
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// policyDocument is the content of a policy file: the command policy and the
+// destination policy of the web tools
+type policyDocument struct {
+	commander.Policy `yaml:",inline"`
+	// Fetch restricts the destinations of the web tools
+	Fetch *web.DestinationPolicy `yaml:"fetch" json:"fetch,omitempty"`
+}
+
+// loadPolicyFile reads and validates a policy file
+func loadPolicyFile(path string) (*policyDocument, error) {
+	file := &policyDocument{}
+	if err := commander.DecodePolicyFile(path, file); err != nil {
+		return nil, err
+	}
+
+	var errs []string
+	if err := file.Policy.Compile(); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if file.Fetch != nil {
+		if err := file.Fetch.Compile(); err != nil {
+			errs = append(errs, fmt.Sprintf("fetch: %v", err))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid policy file %s: %s", path, strings.Join(errs, "; "))
+	}
+	return file, nil
+}
+
+// resolveFetchPolicy builds the destination policy of the web tools from the
+// policy file's fetch section, if any, extended by flags and environment
+// variables
+func resolveFetchPolicy(section *web.DestinationPolicy) (*web.DestinationPolicy, error) {
+	fetch := &web.DestinationPolicy{}
+	if section != nil {
+		copied := *section
+		fetch = &copied
+	}
+
+	lists := []struct {
+		list *[]string
+		flag string
+		env  string
+	}{
+		{&fetch.AllowHosts, *fetchAllowHosts, "MCP_FETCH_ALLOW_HOSTS"},
+		{&fetch.DenyHosts, *fetchDenyHosts, "MCP_FETCH_DENY_HOSTS"},
+		{&fetch.AllowCIDRs, *fetchAllowCIDRs, "MCP_FETCH_ALLOW_CIDRS"},
+		{&fetch.DenyCIDRs, *fetchDenyCIDRs, "MCP_FETCH_DENY_CIDRS"},
+	}
+	for _, l := range lists {
+		if value := resolvePriority(l.flag, os.Getenv(l.env), ""); value != "" {
+			*l.list = append(append([]string(nil), *l.list...), parseCommandList(value)...)
+		}
+	}
+
+	if *fetchAllowPrivate {
+		fetch.AllowPrivate = true
+	} else if env := os.Getenv("MCP_FETCH_ALLOW_PRIVATE"); env != "" && !isFlagSet("fetch-allow-private") {
+		if parsed, err := strconv.ParseBool(env); err == nil {
+			fetch.AllowPrivate = fetch.AllowPrivate || parsed
+		}
+	}
+
+	if err := fetch.Compile(); err != nil {
+		return nil, fmt.Errorf("invalid fetch policy: %w", err)
+	}
+	return fetch, nil
+}
+
+// resolveCache builds the response cache from flags and environment
+// variables. It returns nil if caching is disabled.
+func resolveCache() (*web.Cache, error) {
+	size, err := commander.ParseByteSize(resolvePriority(*cacheSize, os.Getenv("MCP_CACHE_SIZE"), defaultCacheSize))
+	if err != nil {
+		return nil, fmt.Errorf("invalid cache size: %w", err)
+	}
+	dirSize, err := commander.ParseByteSize(resolvePriority(*cacheDirSize, os.Getenv("MCP_CACHE_DIR_SIZE"), defaultCacheDirSize))
+	if err != nil {
+		return nil, fmt.Errorf("invalid cache directory size: %w", err)
+	}
+	if size == 0 {
+		logger.Info("Response cache disabled")
+		return nil, nil
+	}
+
+	dir := resolvePriority(*cacheDir, os.Getenv("MCP_CACHE_DIR"), "")
+	cache, err := web.NewCache(size, dir, dirSize)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("Response cache: memory %d, directory %q (%d)", size, dir, dirSize)
+	return cache, nil
+}
+
+// resolveSearchCacheTTL returns the minimum time search results are cached
+func resolveSearchCacheTTL() time.Duration {
+	if env := os.Getenv("MCP_SEARCH_CACHE_TTL"); env != "" && !isFlagSet("search-cache-ttl") {
+		if parsed, err := time.ParseDuration(env); err == nil {
+			return parsed
+		}
+	}
+	return *searchCacheTTL
+}
+
+// resolveAuth builds the HTTP transport's token validator. Static tokens
+// and HMAC secrets come only from the environment so that they do not
+// appear in process listings; the JWT settings may also be given as flags.
+// It also loads the identities' command policies, which are checked with
+// the server's other command settings.
+func resolveAuth(cmdConfig commander.Config) (*auth.Validator, error) {
+	config := auth.ConfigFromEnv()
+	config.IdentitiesFile = resolvePriority(*authIdentities, config.IdentitiesFile, "")
+	config.JWKSFile = resolvePriority(*authJWKSFile, config.JWKSFile, "")
+	config.JWKSURL = resolvePriority(*authJWKSURL, config.JWKSURL, "")
+	config.Issuer = resolvePriority(*authIssuer, config.Issuer, "")
+	if *authAudience != "" {
+		config.Audience = parseCommandList(*authAudience)
+	}
+	if isFlagSet("auth-clock-skew") || config.ClockSkew == 0 {
+		config.ClockSkew = *authClockSkew
+	}
+
+	validator, err := auth.NewValidator(config)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("Authentication: %d static tokens, %d HMAC secrets, JWKS %q, issuer %q, audience %v",
+		len(config.Tokens), len(config.HMACSecrets), config.JWKSFile+config.JWKSURL, config.Issuer, config.Audience)
+
+	identityCommanders = make(map[string]*commander.Commander)
+	for _, identity := range validator.Identities() {
+		logger.Info("Identity %s: scopes %v, policy file %q", identity.Name, identity.Scopes, identity.PolicyFile)
+		if identity.PolicyFile == "" {
+			continue
+		}
+		file, err := loadPolicyFile(identity.PolicyFile)
+		if err != nil {
+			return nil, fmt.Errorf("identity %s: %w", identity.Name, err)
+		}
+		identityConfig := cmdConfig
+		identityConfig.Policy = &file.Policy
+		identityCommanders[identity.Name] = commander.NewCommander(identityConfig)
+	}
+	return validator, nil
+}
+
+// resolveTLS returns the HTTPS configuration, or nil to serve plain HTTP
+func resolveTLS() (*mcp.TLSConfig, error) {
+	config := &mcp.TLSConfig{
+		CertFile:     resolvePriority(*tlsCert, os.Getenv("MCP_TLS_CERT"), ""),
+		KeyFile:      resolvePriority(*tlsKey, os.Getenv("MCP_TLS_KEY"), ""),
+		ClientCAFile: resolvePriority(*tlsClientCA, os.Getenv("MCP_TLS_CLIENT_CA"), ""),
+		ClientAuth:   resolveStringSetting("tls-client-auth", *tlsClientAuth, "MCP_TLS_CLIENT_AUTH"),
+		MinVersion:   resolveStringSetting("tls-min-version", *tlsMinVersion, "MCP_TLS_MIN_VERSION"),
+	}
+	if config.CertFile == "" && config.KeyFile == "" {
+		if config.ClientCAFile != "" {
+			return nil, fmt.Errorf("--tls-client-ca requires --tls-cert and --tls-key")
+		}
+		return nil, nil
+	}
+	if config.CertFile == "" || config.KeyFile == "" {
+		return nil, fmt.Errorf("--tls-cert and --tls-key must be given together")
+	}
+	logger.Info("TLS: certificate %s, client CA %q (%s), minimum version %s",
+		config.CertFile, config.ClientCAFile, config.ClientAuth, config.MinVersion)
+	return config, nil
+}
+
+// resolveLockout configures how clients failing HTTP authentication are
+// locked out
+func resolveLockout() *auth.Lockout {
+	config := auth.LockoutConfig{
+		MaxFailures: resolveIntSetting("auth-max-failures", *authMaxFailures, "MCP_AUTH_MAX_FAILURES"),
+		Lockout:     resolveDurationSetting("auth-lockout", *authLockout, "MCP_AUTH_LOCKOUT"),
+		MaxLockout:  resolveDurationSetting("auth-max-lockout", *authMaxLockout, "MCP_AUTH_MAX_LOCKOUT"),
+	}
+	logger.Info("Authentication lockout: after %d failures, %s doubling up to %s", config.MaxFailures, config.Lockout, config.MaxLockout)
+	return auth.NewLockout(config)
+}
+
+// requestLogger returns a logger that records the caller's identity, if
+// the request was authenticated
+func requestLogger(ctx context.Context) *logging.Logger {
+	if identity := auth.IdentityFromContext(ctx); identity != nil {
+		return logger.With(fmt.Sprintf("identity=%q", identity.Name))
+	}
+	return logger
+}
+
+// callerIdentity names the caller's identity, which owns the jobs and
+// sessions it starts, or returns "" if the request was not authenticated
+func callerIdentity(ctx context.Context) string {
+	if identity := auth.IdentityFromContext(ctx); identity != nil {
+		return identity.Name
+	}
+	return ""
+}
+
+// identityCommander returns the commander enforcing the command policy of
+// the caller's identity, or nil if it has none
+func identityCommander(ctx context.Context) *commander.Commander {
+	if identity := auth.IdentityFromContext(ctx); identity != nil {
+		return identityCommanders[identity.Name]
+	}
+	return nil
+}
+
+// cachedClient makes client use the response cache, if enabled
+func cachedClient(client *http.Client) *http.Client {
+	if responseCache != nil {
+		transport := client.Transport
+		if transport == nil {
+			transport = http.DefaultTransport
+		}
+		client.Transport = responseCache.Wrap(transport)
+	}
+	return client
+}
+
+// resolveLimits builds the resource limits from flags and environment
+// variables. Sizes accept suffixes such as K, M and G.
+func resolveLimits() (*commander.ResourceLimits, error) {
+	limits := &commander.ResourceLimits{
+		CPUSeconds:   resolveIntSetting("limit-cpu", *limitCPU, "MCP_LIMIT_CPU"),
+		MaxProcesses: resolveIntSetting("limit-processes", *limitProcesses, "MCP_LIMIT_PROCESSES"),
+		CgroupParent: resolvePriority(*cgroupParent, os.Getenv("MCP_CGROUP_PARENT"), ""),
+	}
+
+	var err error
+	if value := resolvePriority(*limitMemory, os.Getenv("MCP_LIMIT_MEMORY"), ""); value != "" {
+		if limits.MemoryBytes, err = commander.ParseByteSize(value); err != nil {
+			return nil, fmt.Errorf("invalid memory limit: %w", err)
+		}
+	}
+	if value := resolvePriority(*limitFileSize, os.Getenv("MCP_LIMIT_FILE_SIZE"), ""); value != "" {
+		if limits.FileSizeBytes, err = commander.ParseByteSize(value); err != nil {
+			return nil, fmt.Errorf("invalid file size limit: %w", err)
+		}
+	}
+	limits.OutputBytes = commander.DefaultMaxOutputBytes
+	if value := resolvePriority(*maxOutputBytes, os.Getenv("MCP_MAX_OUTPUT_BYTES"), ""); value == "-1" {
+		limits.OutputBytes = -1
+	} else if value != "" {
+		size, err := commander.ParseByteSize(value)
+		if err != nil || size == 0 || size > math.MaxInt32 {
+			return nil, fmt.Errorf("invalid output limit: %q", value)
+		}
+		limits.OutputBytes = int(size)
+	}
+	return limits, nil
+}
+
+// resolveIntSetting returns an integer flag, or the environment variable if
+// the flag was not given
+func resolveIntSetting(name string, flagVal int, envName string) int {
+	if envVal := os.Getenv(envName); envVal != "" && !isFlagSet(name) {
+		if parsed, err := strconv.Atoi(envVal); err == nil {
+			return parsed
+		}
+	}
+	return flagVal
+}
+
+// resolveStringSetting returns a string flag with a default, or the
+// environment variable if the flag was not set
+func resolveStringSetting(name string, flagVal string, envName string) string {
+	if envVal := os.Getenv(envName); envVal != "" && !isFlagSet(name) {
+		return envVal
+	}
+	return flagVal
+}
+
+// resolveDurationSetting returns a duration flag, or the environment
+// variable if the flag was not set
+func resolveDurationSetting(name string, flagVal time.Duration, envName string) time.Duration {
+	if envVal := os.Getenv(envName); envVal != "" && !isFlagSet(name) {
+		if parsed, err := time.ParseDuration(envVal); err == nil {
+			return parsed
+		}
+	}
+	return flagVal
+}
+
 func getConfigValue(resolved, flagVal, envVal string) logging.ConfigValue {
 	if flagVal != "" && flagVal == resolved {
 		return logging.ConfigValue{Value: resolved, Source: logging.SourceFlag}
@@ -699,6 +2087,13 @@ func getInt(args map[string]interface{}, key string, defaultVal int) int {
 	return defaultVal
 }
 
+func getBool(args map[string]interface{}, key string, defaultVal bool) bool {
+	if val, ok := args[key].(bool); ok {
+		return val
+	}
+	return defaultVal
+}
+
 func getStringMap(args map[string]interface{}, key string) map[string]string {
 	result := make(map[string]string)
 	if val, ok := args[key].(map[string]interface{}); ok {
@@ -717,9 +2112,110 @@ func textResult(text string) (*mcp.CallToolResult, error) {
 	}, nil
 }
 
+// structuredResult returns value both as structured content, for tools with
+// an output schema, and as indented JSON text for older clients
+func structuredResult(value map[string]interface{}) (*mcp.CallToolResult, error) {
+	data, _ := json.MarshalIndent(value, "", "  ")
+	return &mcp.CallToolResult{
+		Content:           []mcp.ContentItem{{Type: "text", Text: string(data)}},
+		StructuredContent: value,
+	}, nil
+}
+
 func errorResult(message string) (*mcp.CallToolResult, error) {
 	return &mcp.CallToolResult{
 		Content: []mcp.ContentItem{{Type: "text", Text: message}},
 		IsError: true,
 	}, nil
 }
+
+// getCommand reads the command to run from either the "command" string or
+// the "argv" array. When argv is used, command is set to its display form.
+func getCommand(args map[string]interface{}) (command string, argv []string, errMsg string) {
+	command = getString(args, "command", "")
+	rawArgv, hasArgv := args["argv"]
+
+	if hasArgv {
+		if command != "" {
+			return "", nil, "Provide either command or argv, not both"
+		}
+		items, ok := rawArgv.([]interface{})
+		if !ok || len(items) == 0 {
+			return "", nil, "argv must be a non-empty array of strings"
+		}
+		for _, item := range items {
+			arg, ok := item.(string)
+			if !ok {
+				return "", nil, "argv must be a non-empty array of strings"
+			}
+			argv = append(argv, arg)
+		}
+		return commander.FormatArgv(argv), argv, ""
+	}
+
+	if command == "" {
+		if cmd.ArgvOnly() {
+			return "", nil, "argv is required"
+		}
+		return "", nil, "command is required"
+	}
+	if cmd.ArgvOnly() {
+		return "", nil, "Shell commands are disabled on this server; pass the program and its arguments as argv"
+	}
+	return command, nil, ""
+}
+
+// validateCommand checks a command string or argv against the policy, and
+// against the command policy of the caller's identity if it has one
+func validateCommand(ctx context.Context, command string, argv []string, workDir string, env map[string]string) (*commander.PolicyDecision, error) {
+	decision, err := validateWith(cmd, command, argv, workDir, env)
+	if err != nil {
+		return decision, err
+	}
+	if c := identityCommander(ctx); c != nil {
+		identityDecision, err := validateWith(c, command, argv, workDir, env)
+		if err != nil {
+			return identityDecision, err
+		}
+		// Keep both decisions' segments so the tightest timeout applies
+		decision.Segments = append(decision.Segments, identityDecision.Segments...)
+	}
+	return decision, nil
+}
+
+// validateWith checks a command string or argv with one commander's policy
+func validateWith(c *commander.Commander, command string, argv []string, workDir string, env map[string]string) (*commander.PolicyDecision, error) {
+	if argv != nil {
+		return c.ValidateArgv(argv, workDir, env)
+	}
+	return c.ValidateRequest(command, workDir, env)
+}
+
+// commandRequired lists the required arguments of tools that take a command
+func commandRequired() []string {
+	if cmd.ArgvOnly() {
+		return []string{"argv"}
+	}
+	return nil
+}
+
+// limitTimeout applies the run time limit of the policy rules that allowed a
+// command. A zero timeout means no limit of its own.
+func limitTimeout(timeout time.Duration, decision *commander.PolicyDecision) time.Duration {
+	if limit := decision.Timeout(); limit > 0 && (timeout <= 0 || timeout > limit) {
+		return limit
+	}
+	return timeout
+}
+
+// validationErrorResult reports a rejected command. Policy denials include
+// the per-segment decision so clients can see which part was refused.
+func validationErrorResult(prefix string, err error) (*mcp.CallToolResult, error) {
+	message := fmt.Sprintf("%s: %s", prefix, err.Error())
+	var policyErr *commander.PolicyError
+	if errors.As(err, &policyErr) {
+		data, _ := json.MarshalIndent(policyErr.Decision, "", "  ")
+		message += "\n\n" + string(data)
+	}
+	return errorResult(message)
+}
@@ -1,25 +1,300 @@
 package auth
 
 import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
 	"os"
+	"strings"
+	"time"
 )
 
 // AuthHeaderName is the HTTP header used for authentication
 const AuthHeaderName = "X-MCP-Auth-Token"
 
-// ValidateToken validates the provided authentication token.
-// Currently returns true for all non-empty tokens.
-// TODO: Implement actual token validation (e.g., JWT validation, API call, etc.)
-func ValidateToken(token string) bool {
+// DefaultClockSkew is the tolerance applied to JWT time claims
+const DefaultClockSkew = time.Minute
+
+var (
+	// ErrMissingToken is returned when a request carries no token
+	ErrMissingToken = errors.New("missing authentication token")
+	// ErrInvalidToken is returned, wrapped with the reason, when a token is
+	// not accepted
+	ErrInvalidToken = errors.New("invalid authentication token")
+)
+
+// Config configures a Validator. Any combination of static tokens, HMAC
+// secrets and a JWKS may be given; a token is accepted if any of them
+// accepts it. Several tokens or secrets may be active at once so that they
+// can be rotated without downtime.
+type Config struct {
+	// Tokens are static bearer tokens
+	Tokens []string
+	// HMACSecrets verify HS256-signed JWTs
+	HMACSecrets []string
+	// JWKSFile is a local JSON Web Key Set verifying RS256 and ES256 JWTs
+	JWKSFile string
+	// JWKSURL is a JSON Web Key Set fetched over HTTP(S), e.g. an identity
+	// provider's jwks_uri
+	JWKSURL string
+	// JWKSRefresh is how often the key set is reloaded (default: 1h)
+	JWKSRefresh time.Duration
+	// Issuer, if set, must match a JWT's "iss" claim
+	Issuer string
+	// Audience, if not empty, must include one of a JWT's "aud" values
+	Audience []string
+	// ClockSkew is the tolerance for "exp" and "nbf" (default: 1m)
+	ClockSkew time.Duration
+	// IdentitiesFile maps tokens to identities with scopes (see
+	// LoadIdentities). Its tokens are accepted in addition to Tokens.
+	IdentitiesFile string
+}
+
+// Enabled reports whether the configuration requires authentication
+func (c Config) Enabled() bool {
+	return len(c.Tokens) > 0 || len(c.HMACSecrets) > 0 || c.JWKSFile != "" || c.JWKSURL != "" || c.IdentitiesFile != ""
+}
+
+// ConfigFromEnv reads the configuration from environment variables:
+// MCP_AUTH_TOKEN and MCP_AUTH_TOKENS (comma-separated) for static tokens,
+// MCP_AUTH_HMAC_SECRET and MCP_AUTH_HMAC_SECRETS for HS256 secrets, and
+// MCP_AUTH_JWKS_FILE, MCP_AUTH_JWKS_URL, MCP_AUTH_ISSUER, MCP_AUTH_AUDIENCE
+// and MCP_AUTH_CLOCK_SKEW for JWT validation, and MCP_AUTH_IDENTITIES_FILE.
+func ConfigFromEnv() Config {
+	config := Config{
+		Tokens:      envList("MCP_AUTH_TOKEN", "MCP_AUTH_TOKENS"),
+		HMACSecrets: envList("MCP_AUTH_HMAC_SECRET", "MCP_AUTH_HMAC_SECRETS"),
+		JWKSFile:    os.Getenv("MCP_AUTH_JWKS_FILE"),
+		JWKSURL:     os.Getenv("MCP_AUTH_JWKS_URL"),
+		Issuer:      os.Getenv("MCP_AUTH_ISSUER"),
+		Audience:    envList("MCP_AUTH_AUDIENCE"),
+
+		IdentitiesFile: os.Getenv("MCP_AUTH_IDENTITIES_FILE"),
+	}
+	if skew, err := time.ParseDuration(os.Getenv("MCP_AUTH_CLOCK_SKEW")); err == nil {
+		config.ClockSkew = skew
+	}
+	return config
+}
+
+// envList collects the comma-separated values of the named variables
+func envList(names ...string) []string {
+	var values []string
+	for _, name := range names {
+		for _, value := range strings.Split(os.Getenv(name), ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
+
+// Claims describes an accepted token
+type Claims struct {
+	// Method is "token" for static tokens and "jwt" for JWTs
+	Method string
+	// Subject is the JWT "sub" claim
+	Subject string
+	// Issuer is the JWT "iss" claim
+	Issuer string
+	// Audience is the JWT "aud" claim
+	Audience []string
+	// ExpiresAt is the JWT "exp" claim
+	ExpiresAt time.Time
+	// Raw holds every JWT claim as decoded
+	Raw map[string]interface{}
+
+	// identity is the identity a static token is listed under, if any
+	identity string
+}
+
+// staticToken is an accepted static token and the identity it belongs to.
+// Tokens are kept as SHA-256 digests so that comparisons take the same
+// time whatever the length of the token presented.
+type staticToken struct {
+	digest   [sha256.Size]byte
+	identity string
+}
+
+// Validator checks authentication tokens and maps them to identities
+type Validator struct {
+	tokens    []staticToken
+	hmacKeys  [][]byte
+	keys      *keySet
+	issuer    string
+	audience  []string
+	clockSkew time.Duration
+	now       func() time.Time
+
+	identityConfigs []IdentityConfig
+	identities      map[string]*Identity
+	defaultScopes   []string
+}
+
+// NewValidator creates a validator, loading the JWKS if one is configured
+func NewValidator(config Config) (*Validator, error) {
+	v := &Validator{
+		issuer:        config.Issuer,
+		audience:      config.Audience,
+		clockSkew:     config.ClockSkew,
+		now:           time.Now,
+		identities:    make(map[string]*Identity),
+		defaultScopes: []string{ScopeAll},
+	}
+	if v.clockSkew == 0 {
+		v.clockSkew = DefaultClockSkew
+	}
+	for _, token := range config.Tokens {
+		v.tokens = append(v.tokens, staticToken{digest: sha256.Sum256([]byte(token))})
+	}
+	for _, secret := range config.HMACSecrets {
+		v.hmacKeys = append(v.hmacKeys, []byte(secret))
+	}
+
+	if config.IdentitiesFile != "" {
+		file, err := LoadIdentities(config.IdentitiesFile)
+		if err != nil {
+			return nil, err
+		}
+		v.identityConfigs = file.Identities
+		v.defaultScopes = file.DefaultScopes
+		for _, identity := range file.Identities {
+			v.identities[identity.Name] = &Identity{Name: identity.Name, Scopes: identity.Scopes, PolicyFile: identity.PolicyFile}
+			for _, token := range identity.Tokens {
+				v.tokens = append(v.tokens, staticToken{digest: sha256.Sum256([]byte(token)), identity: identity.Name})
+			}
+		}
+	}
+
+	if config.JWKSFile != "" && config.JWKSURL != "" {
+		return nil, errors.New("configure either a JWKS file or a JWKS URL, not both")
+	}
+	if config.JWKSFile != "" || config.JWKSURL != "" {
+		keys, err := newKeySet(config.JWKSFile, config.JWKSURL, config.JWKSRefresh)
+		if err != nil {
+			return nil, err
+		}
+		v.keys = keys
+	}
+	return v, nil
+}
+
+// Enabled reports whether the validator requires a token
+func (v *Validator) Enabled() bool {
+	return len(v.tokens) > 0 || len(v.hmacKeys) > 0 || v.keys != nil || len(v.identityConfigs) > 0
+}
+
+// Validate checks a token, returning its claims if it is accepted. Tokens
+// with three dot-separated parts are treated as JWTs; others are compared
+// with the static tokens.
+func (v *Validator) Validate(token string) (*Claims, error) {
 	if token == "" {
-		return false
+		return nil, ErrMissingToken
+	}
+	// Compare with every token, without stopping at a match, so that the
+	// time taken does not reveal which token matched
+	digest := sha256.Sum256([]byte(token))
+	match := -1
+	for i, expected := range v.tokens {
+		equal := subtle.ConstantTimeCompare(digest[:], expected.digest[:])
+		match = subtle.ConstantTimeSelect(equal&subtle.ConstantTimeEq(int32(match), -1), i, match)
+	}
+	if match >= 0 {
+		return &Claims{Method: "token", identity: v.tokens[match].identity}, nil
+	}
+	if strings.Count(token, ".") == 2 && (len(v.hmacKeys) > 0 || v.keys != nil) {
+		return v.validateJWT(token)
+	}
+	return nil, fmt.Errorf("%w: token not recognised", ErrInvalidToken)
+}
+
+// Failure describes a rejected authentication attempt, for audit logging
+type Failure struct {
+	// Client is the client's lockout key (see ClientKey)
+	Client string
+	// Reason is why the attempt was rejected
+	Reason string
+	// Locked is true if the attempt was refused because the client is
+	// locked out, without checking its token
+	Locked bool
+	// Failures is the client's number of consecutive failures
+	Failures int
+	// LockedFor is how long the client is now locked out, if at all
+	LockedFor time.Duration
+}
+
+// Authenticate validates a token and returns the identity it authenticates
+// as. Static tokens listed in the identities file, and JWTs matching an
+// identity's subject and claims, get that identity. Other JWTs carrying a
+// "scope" or "scp" claim get an identity named after their subject with
+// those scopes; remaining callers get the default scopes.
+func (v *Validator) Authenticate(token string) (*Identity, error) {
+	claims, err := v.Validate(token)
+	if err != nil {
+		return nil, err
+	}
+	if claims.identity != "" {
+		return v.identities[claims.identity], nil
+	}
+	if claims.Method == "jwt" {
+		for _, config := range v.identityConfigs {
+			if config.matches(claims) {
+				return v.identities[config.Name], nil
+			}
+		}
+	}
+
+	name := claims.Subject
+	if name == "" {
+		name = claims.Method
+	}
+	if scopes := tokenScopes(claims.Raw); len(scopes) > 0 {
+		return &Identity{Name: name, Scopes: scopes}, nil
 	}
-	// Placeholder implementation - always returns true for non-empty tokens
-	// In a production environment, this should validate against:
-	// - A JWT secret/public key
-	// - An authentication API
-	// - A token database
-	return true
+	return &Identity{Name: name, Scopes: v.defaultScopes}, nil
+}
+
+// AuthenticateCertificate returns the identity of a client certificate
+// that the TLS layer has verified: the identity whose certificate subject
+// matches, or else one named after the certificate's subject with the
+// default scopes
+func (v *Validator) AuthenticateCertificate(cert *x509.Certificate) *Identity {
+	for _, config := range v.identityConfigs {
+		if config.matchesCertificate(cert) {
+			return v.identities[config.Name]
+		}
+	}
+	name := cert.Subject.CommonName
+	if name == "" {
+		name = cert.Subject.String()
+	}
+	return &Identity{Name: name, Scopes: v.defaultScopes}
+}
+
+// Identities returns the identities configured in the identities file
+func (v *Validator) Identities() []*Identity {
+	identities := make([]*Identity, 0, len(v.identityConfigs))
+	for _, config := range v.identityConfigs {
+		identities = append(identities, v.identities[config.Name])
+	}
+	return identities
+}
+
+// TokenFromRequest returns the token from the X-MCP-Auth-Token header or an
+// "Authorization: Bearer" header
+func TokenFromRequest(r *http.Request) string {
+	if token := r.Header.Get(AuthHeaderName); token != "" {
+		return token
+	}
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	return ""
 }
 
 // GetExpectedToken returns the expected token from environment variable.
@@ -28,18 +303,30 @@ func GetExpectedToken() string {
 	return os.Getenv("MCP_AUTH_TOKEN")
 }
 
-// IsAuthEnabled returns true if authentication is enabled (token is configured)
+// IsAuthEnabled returns true if authentication is configured in the
+// environment (see ConfigFromEnv)
 func IsAuthEnabled() bool {
-	return GetExpectedToken() != ""
+	return ConfigFromEnv().Enabled()
+}
+
+// ValidateToken reports whether token is accepted by the configuration in
+// the environment. It builds a new Validator on every call; servers should
+// create one with NewValidator and reuse it.
+func ValidateToken(token string) bool {
+	v, err := NewValidator(ConfigFromEnv())
+	if err != nil {
+		return false
+	}
+	_, err = v.Validate(token)
+	return err == nil
 }
 
-// ValidateAgainstExpected validates the provided token against the expected token.
-// Returns true if auth is disabled (no expected token) or if tokens match.
+// ValidateAgainstExpected validates the provided token against the
+// configuration in the environment. Returns true if auth is disabled.
 func ValidateAgainstExpected(providedToken string) bool {
-	expectedToken := GetExpectedToken()
-	if expectedToken == "" {
+	if !IsAuthEnabled() {
 		// Auth is disabled - allow all requests
 		return true
 	}
-	return providedToken == expectedToken
+	return ValidateToken(providedToken)
 }
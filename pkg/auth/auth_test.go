@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestValidator_StaticTokens(t *testing.T) {
+	v, err := NewValidator(Config{Tokens: []string{"old-token", "new-token"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v.Enabled() {
+		t.Error("Expected a validator with tokens to be enabled")
+	}
+
+	for _, token := range []string{"old-token", "new-token"} {
+		claims, err := v.Validate(token)
+		if err != nil || claims.Method != "token" {
+			t.Errorf("Expected %q to be accepted during rotation, got %v", token, err)
+		}
+	}
+	if _, err := v.Validate("wrong"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected ErrInvalidToken, got %v", err)
+	}
+	if _, err := v.Validate(""); !errors.Is(err, ErrMissingToken) {
+		t.Errorf("Expected ErrMissingToken, got %v", err)
+	}
+}
+
+func TestValidator_Disabled(t *testing.T) {
+	v, err := NewValidator(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Enabled() {
+		t.Error("Expected an empty configuration to disable authentication")
+	}
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("MCP_AUTH_TOKEN", "primary")
+	t.Setenv("MCP_AUTH_TOKENS", "second, third,")
+	t.Setenv("MCP_AUTH_HMAC_SECRETS", "s1,s2")
+	t.Setenv("MCP_AUTH_AUDIENCE", "mcp")
+	t.Setenv("MCP_AUTH_CLOCK_SKEW", "30s")
+
+	config := ConfigFromEnv()
+	if len(config.Tokens) != 3 || config.Tokens[2] != "third" {
+		t.Errorf("Unexpected tokens %q", config.Tokens)
+	}
+	if len(config.HMACSecrets) != 2 || len(config.Audience) != 1 || config.ClockSkew.Seconds() != 30 {
+		t.Errorf("Unexpected configuration %+v", config)
+	}
+	if !IsAuthEnabled() || !ValidateAgainstExpected("second") || ValidateAgainstExpected("fourth") {
+		t.Error("Expected the package functions to use the environment configuration")
+	}
+}
+
+func TestTokenFromRequest(t *testing.T) {
+	tests := []struct {
+		name, value string
+		token       string
+	}{
+		{AuthHeaderName, "abc", "abc"},
+		{"Authorization", "Bearer xyz", "xyz"},
+		{"Authorization", "bearer xyz", "xyz"},
+		{"Authorization", "Basic dXNlcg==", ""},
+		{"Accept", "*/*", ""},
+	}
+
+	for _, tt := range tests {
+		r := &http.Request{Header: http.Header{}}
+		r.Header.Set(tt.name, tt.value)
+		if got := TokenFromRequest(r); got != tt.token {
+			t.Errorf("TokenFromRequest(%s: %s) = %q, expected %q", tt.name, tt.value, got, tt.token)
+		}
+	}
+}
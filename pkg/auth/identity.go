@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ScopeAll grants every scope
+const ScopeAll = "*"
+
+// ToolScope returns the scope required to list and call a tool
+func ToolScope(tool string) string {
+	return "tools:" + tool
+}
+
+// Identity is an authenticated caller and what it may do
+type Identity struct {
+	// Name identifies the caller in logs
+	Name string
+	// Scopes are granted permissions such as "tools:execute_command". Entries
+	// may be globs, e.g. "tools:job_*", and "*" grants everything.
+	Scopes []string
+	// PolicyFile names a command policy applied to the caller's commands in
+	// addition to the server's own
+	PolicyFile string
+}
+
+// HasScope reports whether the identity was granted scope
+func (id *Identity) HasScope(scope string) bool {
+	for _, granted := range id.Scopes {
+		if granted == ScopeAll || granted == scope {
+			return true
+		}
+		if matched, _ := path.Match(granted, scope); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// IdentityConfig maps tokens to an identity. A static token listed in
+// Tokens, a JWT whose "sub" is Subject and whose claims include Claims, or
+// a verified client certificate whose subject common name or full
+// distinguished name is CertificateSubject authenticates as the identity.
+type IdentityConfig struct {
+	Name               string            `yaml:"name" json:"name"`
+	Tokens             []string          `yaml:"tokens" json:"tokens,omitempty"`
+	Subject            string            `yaml:"subject" json:"subject,omitempty"`
+	Claims             map[string]string `yaml:"claims" json:"claims,omitempty"`
+	CertificateSubject string            `yaml:"certificate_subject" json:"certificate_subject,omitempty"`
+	Scopes             []string          `yaml:"scopes" json:"scopes"`
+	PolicyFile         string            `yaml:"policy_file" json:"policy_file,omitempty"`
+}
+
+// IdentityFile is the format of the file named by Config.IdentitiesFile
+type IdentityFile struct {
+	// DefaultScopes are granted to callers that match no identity and whose
+	// JWT carries no scope claim. Without an identities file they get every
+	// scope.
+	DefaultScopes []string         `yaml:"default_scopes" json:"default_scopes,omitempty"`
+	Identities    []IdentityConfig `yaml:"identities" json:"identities"`
+}
+
+// LoadIdentities reads an identities file. Files ending in .json are parsed
+// as JSON; anything else is parsed as YAML. Relative policy files are
+// resolved against the file's directory.
+func LoadIdentities(file string) (*IdentityFile, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read identities file: %w", err)
+	}
+
+	identities := &IdentityFile{}
+	if strings.EqualFold(filepath.Ext(file), ".json") {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(identities)
+	} else {
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(identities)
+		if errors.Is(err, io.EOF) {
+			err = nil
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse identities file %s: %w", file, err)
+	}
+
+	names := make(map[string]bool)
+	for i := range identities.Identities {
+		identity := &identities.Identities[i]
+		switch {
+		case identity.Name == "":
+			return nil, fmt.Errorf("invalid identities file %s: identity %d has no name", file, i+1)
+		case names[identity.Name]:
+			return nil, fmt.Errorf("invalid identities file %s: duplicate identity %q", file, identity.Name)
+		case len(identity.Tokens) == 0 && identity.Subject == "" && len(identity.Claims) == 0 && identity.CertificateSubject == "":
+			return nil, fmt.Errorf("invalid identities file %s: identity %q needs tokens, a subject, claims or a certificate subject", file, identity.Name)
+		}
+		for _, scope := range identity.Scopes {
+			if _, err := path.Match(scope, ""); err != nil {
+				return nil, fmt.Errorf("invalid identities file %s: identity %q: bad scope %q", file, identity.Name, scope)
+			}
+		}
+		names[identity.Name] = true
+		if identity.PolicyFile != "" && !filepath.IsAbs(identity.PolicyFile) {
+			identity.PolicyFile = filepath.Join(filepath.Dir(file), identity.PolicyFile)
+		}
+	}
+	return identities, nil
+}
+
+// matches reports whether JWT claims authenticate as the identity
+func (c *IdentityConfig) matches(claims *Claims) bool {
+	if c.Subject == "" && len(c.Claims) == 0 {
+		return false
+	}
+	if c.Subject != "" && claims.Subject != c.Subject {
+		return false
+	}
+	for name, expected := range c.Claims {
+		if !claimContains(claims.Raw[name], expected) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesCertificate reports whether a client certificate authenticates as
+// the identity
+func (c *IdentityConfig) matchesCertificate(cert *x509.Certificate) bool {
+	return c.CertificateSubject != "" &&
+		(c.CertificateSubject == cert.Subject.CommonName || c.CertificateSubject == cert.Subject.String())
+}
+
+// claimContains reports whether a claim is, or is a list containing, value
+func claimContains(claim interface{}, value string) bool {
+	switch claim := claim.(type) {
+	case string:
+		return claim == value
+	case []interface{}:
+		for _, item := range claim {
+			if s, ok := item.(string); ok && s == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// tokenScopes returns the scopes carried by a JWT's "scope" (space
+// separated) or "scp" (list or space separated) claim
+func tokenScopes(raw map[string]interface{}) []string {
+	var scopes []string
+	for _, name := range []string{"scope", "scp"} {
+		switch claim := raw[name].(type) {
+		case string:
+			scopes = append(scopes, strings.Fields(claim)...)
+		case []interface{}:
+			for _, item := range claim {
+				if s, ok := item.(string); ok {
+					scopes = append(scopes, s)
+				}
+			}
+		}
+	}
+	return scopes
+}
+
+type identityKey struct{}
+
+// WithIdentity returns a context carrying the caller's identity
+func WithIdentity(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// IdentityFromContext returns the caller's identity, or nil if the request
+// was not authenticated (e.g. over stdio)
+func IdentityFromContext(ctx context.Context) *Identity {
+	identity, _ := ctx.Value(identityKey{}).(*Identity)
+	return identity
+}
@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const identitiesYAML = `
+default_scopes: ["tools:list_*"]
+identities:
+  - name: ci
+    tokens: [ci-token]
+    scopes: ["tools:execute_command", "tools:job_*"]
+    policy_file: ci-policy.yaml
+  - name: researcher
+    subject: alice
+    scopes: ["tools:web_fetch", "tools:google_search"]
+  - name: ops
+    claims:
+      groups: ops
+    scopes: ["*"]
+  - name: runner
+    certificate_subject: "CN=runner-1,O=Example"
+    scopes: ["tools:start_job"]
+`
+
+func identitiesValidator(t *testing.T) *Validator {
+	t.Helper()
+	dir := t.TempDir()
+	file := filepath.Join(dir, "identities.yaml")
+	if err := os.WriteFile(file, []byte(identitiesYAML), 0600); err != nil {
+		t.Fatal(err)
+	}
+	v, err := NewValidator(Config{Tokens: []string{"legacy"}, HMACSecrets: []string{"secret"}, IdentitiesFile: file})
+	if err != nil {
+		t.Fatalf("NewValidator failed: %v", err)
+	}
+	return v
+}
+
+func TestValidator_Authenticate(t *testing.T) {
+	v := identitiesValidator(t)
+	jwt := func(change func(map[string]interface{})) string {
+		c := validClaims()
+		change(c)
+		return signToken(t, "HS256", "", []byte("secret"), c)
+	}
+
+	tests := []struct {
+		name     string
+		token    string
+		identity string
+		allowed  string
+		denied   string
+	}{
+		{"static token", "ci-token", "ci", "tools:job_status", "tools:web_fetch"},
+		{"unlisted static token", "legacy", "token", "tools:list_jobs", "tools:execute_command"},
+		{"jwt subject", jwt(func(c map[string]interface{}) {}), "researcher", "tools:web_fetch", "tools:execute_command"},
+		{"jwt claim", jwt(func(c map[string]interface{}) {
+			c["sub"] = "bob"
+			c["groups"] = []string{"dev", "ops"}
+		}), "ops", "tools:execute_command", ""},
+		{"jwt scope claim", jwt(func(c map[string]interface{}) {
+			c["sub"] = "carol"
+			c["scope"] = "openid tools:get_shell_info"
+		}), "carol", "tools:get_shell_info", "tools:list_jobs"},
+		{"unmatched jwt", jwt(func(c map[string]interface{}) { c["sub"] = "dave" }), "dave", "tools:list_allowed_commands", "tools:web_fetch"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity, err := v.Authenticate(tt.token)
+			if err != nil {
+				t.Fatalf("Authenticate failed: %v", err)
+			}
+			if identity.Name != tt.identity {
+				t.Errorf("Expected identity %q, got %q", tt.identity, identity.Name)
+			}
+			if !identity.HasScope(tt.allowed) {
+				t.Errorf("Expected %s to have %s, scopes %v", identity.Name, tt.allowed, identity.Scopes)
+			}
+			if tt.denied != "" && identity.HasScope(tt.denied) {
+				t.Errorf("Expected %s not to have %s", identity.Name, tt.denied)
+			}
+		})
+	}
+
+	if _, err := v.Authenticate("unknown"); err == nil {
+		t.Error("Expected an unknown token to be rejected")
+	}
+}
+
+func TestValidator_AuthenticateCertificate(t *testing.T) {
+	v := identitiesValidator(t)
+
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "runner-1", Organization: []string{"Example"}}}
+	if identity := v.AuthenticateCertificate(cert); identity.Name != "runner" || !identity.HasScope("tools:start_job") {
+		t.Errorf("Expected the runner identity, got %+v", identity)
+	}
+
+	cert = &x509.Certificate{Subject: pkix.Name{CommonName: "laptop"}}
+	if identity := v.AuthenticateCertificate(cert); identity.Name != "laptop" || identity.HasScope("tools:start_job") {
+		t.Errorf("Expected an identity with the default scopes, got %+v", identity)
+	}
+}
+
+func TestValidator_AuthenticateWithoutIdentities(t *testing.T) {
+	v, err := NewValidator(Config{Tokens: []string{"token"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity, err := v.Authenticate("token")
+	if err != nil || !identity.HasScope(ToolScope("execute_command")) {
+		t.Errorf("Expected every scope without an identities file, got %+v, %v", identity, err)
+	}
+}
+
+func TestLoadIdentities(t *testing.T) {
+	v := identitiesValidator(t)
+	identities := v.Identities()
+	if len(identities) != 4 || identities[0].Name != "ci" {
+		t.Fatalf("Unexpected identities %+v", identities)
+	}
+	if !filepath.IsAbs(identities[0].PolicyFile) || filepath.Base(identities[0].PolicyFile) != "ci-policy.yaml" {
+		t.Errorf("Expected the policy file to be resolved against the identities file, got %s", identities[0].PolicyFile)
+	}
+}
+
+func TestLoadIdentities_Errors(t *testing.T) {
+	tests := []struct {
+		content string
+		message string
+	}{
+		{`identities: [{scopes: ["*"], tokens: [a]}]`, "has no name"},
+		{`identities: [{name: a, tokens: [a]}, {name: a, tokens: [b]}]`, "duplicate identity"},
+		{`identities: [{name: a, scopes: ["*"]}]`, "needs tokens, a subject, claims or a certificate subject"},
+		{`identities: [{name: a, tokens: [a], scopes: ["tools:["]}]`, "bad scope"},
+		{`identities: [{name: a, token: a}]`, "failed to parse"},
+	}
+
+	for _, tt := range tests {
+		file := filepath.Join(t.TempDir(), "identities.yaml")
+		os.WriteFile(file, []byte(tt.content), 0600)
+		_, err := LoadIdentities(file)
+		if err == nil || !strings.Contains(err.Error(), tt.message) {
+			t.Errorf("LoadIdentities(%s) = %v, expected error containing %q", tt.content, err, tt.message)
+		}
+	}
+}
+
+func TestIdentityContext(t *testing.T) {
+	if IdentityFromContext(context.Background()) != nil {
+		t.Error("Expected no identity in a plain context")
+	}
+	identity := &Identity{Name: "ci"}
+	if IdentityFromContext(WithIdentity(context.Background(), identity)) != identity {
+		t.Error("Expected the identity to be carried by the context")
+	}
+}
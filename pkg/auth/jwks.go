@@ -0,0 +1,240 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultJWKSRefresh is how often a key set is reloaded
+	defaultJWKSRefresh = time.Hour
+	// minJWKSFetch limits how often an unknown key ID triggers a fetch of a
+	// remote key set
+	minJWKSFetch = time.Minute
+	// maxJWKSSize caps the size of a key set document
+	maxJWKSSize = 1 << 20
+)
+
+// jwk is a JSON Web Key as found in a key set
+type jwk struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n"`
+	E         string `json:"e"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	Y         string `json:"y"`
+}
+
+// publicKey is a verification key from a key set
+type publicKey struct {
+	id  string
+	key crypto.PublicKey
+}
+
+// keySet holds the keys of a JWKS file or URL, reloading them periodically
+// and when a token names a key that is not known yet, so that keys can be
+// rotated at the source without restarting the server
+type keySet struct {
+	file    string
+	url     string
+	refresh time.Duration
+	client  *http.Client
+
+	mu       sync.Mutex
+	keys     []publicKey
+	loadedAt time.Time
+	now      func() time.Time
+}
+
+// newKeySet loads a key set from a file or URL
+func newKeySet(file, url string, refresh time.Duration) (*keySet, error) {
+	if refresh <= 0 {
+		refresh = defaultJWKSRefresh
+	}
+	ks := &keySet{
+		file:    file,
+		url:     url,
+		refresh: refresh,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		now:     time.Now,
+	}
+	if err := ks.load(); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// verify checks a signature with the key named by the header, or with every
+// key if the token names none
+func (ks *keySet) verify(header jwtHeader, signed, signature []byte) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	now := ks.now()
+	stale := now.Sub(ks.loadedAt) >= ks.refresh
+	if !stale && header.KeyID != "" && !ks.has(header.KeyID) {
+		stale = ks.url == "" || now.Sub(ks.loadedAt) >= minJWKSFetch
+	}
+	if stale {
+		// Keep the previous keys if the source is temporarily unavailable
+		ks.load()
+	}
+
+	for _, key := range ks.keys {
+		if header.KeyID != "" && key.id != header.KeyID {
+			continue
+		}
+		if verifySignature(header.Algorithm, key.key, signed, signature) {
+			return nil
+		}
+	}
+	if header.KeyID != "" && !ks.has(header.KeyID) {
+		return fmt.Errorf("unknown key ID %q", header.KeyID)
+	}
+	return fmt.Errorf("signature mismatch")
+}
+
+// has reports whether the set contains a key with the given ID
+func (ks *keySet) has(id string) bool {
+	for _, key := range ks.keys {
+		if key.id == id {
+			return true
+		}
+	}
+	return false
+}
+
+// load reads the key set from its source, replacing the current keys
+func (ks *keySet) load() error {
+	var data []byte
+	var err error
+	if ks.file != "" {
+		data, err = os.ReadFile(ks.file)
+	} else {
+		data, err = ks.fetch()
+	}
+	ks.loadedAt = ks.now()
+	if err != nil {
+		return fmt.Errorf("failed to load JWKS: %w", err)
+	}
+
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return fmt.Errorf("failed to load JWKS: %w", err)
+	}
+	ks.keys = keys
+	return nil
+}
+
+// fetch downloads the key set from its URL
+func (ks *keySet) fetch() ([]byte, error) {
+	resp, err := ks.client.Get(ks.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", ks.url, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize))
+}
+
+// parseJWKS decodes a JSON Web Key Set, keeping the RSA and P-256 signing
+// keys and skipping any it cannot use
+func parseJWKS(data []byte) ([]publicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid key set: %w", err)
+	}
+
+	var keys []publicKey
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", k.KeyID, err)
+		}
+		if key != nil {
+			keys = append(keys, publicKey{id: k.KeyID, key: key})
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("key set contains no usable signing keys")
+	}
+	return keys, nil
+}
+
+// publicKey converts the JWK to a public key, or nil for unsupported types
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %w", err)
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil || !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("invalid exponent")
+		}
+		if n.BitLen() < 2048 {
+			return nil, fmt.Errorf("RSA keys must be at least 2048 bits")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if k.Curve != "P-256" {
+			return nil, nil
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x coordinate: %w", err)
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y coordinate: %w", err)
+		}
+		// ecdh rejects points that are not on the curve
+		point := make([]byte, 65)
+		point[0] = 4
+		if len(x.Bytes()) > 32 || len(y.Bytes()) > 32 {
+			return nil, fmt.Errorf("coordinates are too large for P-256")
+		}
+		x.FillBytes(point[1:33])
+		y.FillBytes(point[33:])
+		if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+			return nil, fmt.Errorf("point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	}
+	return nil, nil
+}
+
+// decodeBigInt decodes a base64url unsigned big-endian integer
+func decodeBigInt(s string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty value")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
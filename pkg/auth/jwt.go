@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// jwtHeader is the JOSE header of a JWT
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+// validateJWT verifies a compact JWT's signature and registered claims
+func (v *Validator) validateJWT(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: malformed header: %v", ErrInvalidToken, err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed signature", ErrInvalidToken)
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+
+	// The algorithm decides which keys may verify the token, so that a
+	// public key can never be used as an HMAC secret
+	switch header.Algorithm {
+	case "HS256":
+		if !v.verifyHMAC(signed, signature) {
+			return nil, fmt.Errorf("%w: signature mismatch", ErrInvalidToken)
+		}
+	case "RS256", "ES256":
+		if v.keys == nil {
+			return nil, fmt.Errorf("%w: no key set configured for %s", ErrInvalidToken, header.Algorithm)
+		}
+		if err := v.keys.verify(header, signed, signature); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, header.Algorithm)
+	}
+
+	var raw map[string]interface{}
+	if err := decodeSegment(parts[1], &raw); err != nil {
+		return nil, fmt.Errorf("%w: malformed claims: %v", ErrInvalidToken, err)
+	}
+	claims, err := parseClaims(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if err := v.checkClaims(claims, raw); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	return claims, nil
+}
+
+// verifyHMAC checks an HS256 signature against every configured secret
+func (v *Validator) verifyHMAC(signed, signature []byte) bool {
+	for _, key := range v.hmacKeys {
+		mac := hmac.New(sha256.New, key)
+		mac.Write(signed)
+		if hmac.Equal(mac.Sum(nil), signature) {
+			return true
+		}
+	}
+	return false
+}
+
+// verifySignature checks an RS256 or ES256 signature with a public key
+func verifySignature(algorithm string, key crypto.PublicKey, signed, signature []byte) bool {
+	digest := sha256.Sum256(signed)
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return algorithm == "RS256" && rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+	case *ecdsa.PublicKey:
+		// ES256 signatures are the fixed-width concatenation of r and s
+		if algorithm != "ES256" || len(signature) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(key, digest[:], r, s)
+	}
+	return false
+}
+
+// checkClaims enforces expiry, not-before, issuer and audience
+func (v *Validator) checkClaims(claims *Claims, raw map[string]interface{}) error {
+	now := v.now()
+	if claims.ExpiresAt.IsZero() {
+		return fmt.Errorf("token has no expiry")
+	}
+	if now.After(claims.ExpiresAt.Add(v.clockSkew)) {
+		return fmt.Errorf("token expired at %s", claims.ExpiresAt.UTC().Format(time.RFC3339))
+	}
+	if _, ok := raw["nbf"]; ok {
+		notBefore, err := numericDate(raw["nbf"])
+		if err != nil {
+			return fmt.Errorf("invalid nbf claim")
+		}
+		if now.Add(v.clockSkew).Before(notBefore) {
+			return fmt.Errorf("token not valid before %s", notBefore.UTC().Format(time.RFC3339))
+		}
+	}
+	if v.issuer != "" && claims.Issuer != v.issuer {
+		return fmt.Errorf("unexpected issuer %q", claims.Issuer)
+	}
+	if len(v.audience) > 0 && !intersects(v.audience, claims.Audience) {
+		return fmt.Errorf("token is not intended for this audience")
+	}
+	return nil
+}
+
+// parseClaims extracts the registered claims from a decoded payload
+func parseClaims(raw map[string]interface{}) (*Claims, error) {
+	claims := &Claims{Method: "jwt", Raw: raw}
+	claims.Subject, _ = raw["sub"].(string)
+	claims.Issuer, _ = raw["iss"].(string)
+
+	switch aud := raw["aud"].(type) {
+	case nil:
+	case string:
+		claims.Audience = []string{aud}
+	case []interface{}:
+		for _, value := range aud {
+			s, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid aud claim")
+			}
+			claims.Audience = append(claims.Audience, s)
+		}
+	default:
+		return nil, fmt.Errorf("invalid aud claim")
+	}
+
+	if _, ok := raw["exp"]; ok {
+		expires, err := numericDate(raw["exp"])
+		if err != nil {
+			return nil, fmt.Errorf("invalid exp claim")
+		}
+		claims.ExpiresAt = expires
+	}
+	return claims, nil
+}
+
+// numericDate converts a JWT NumericDate (seconds since the epoch)
+func numericDate(value interface{}) (time.Time, error) {
+	seconds, ok := value.(float64)
+	if !ok {
+		return time.Time{}, fmt.Errorf("not a number")
+	}
+	whole := int64(seconds)
+	return time.Unix(whole, int64((seconds-float64(whole))*1e9)), nil
+}
+
+// decodeSegment decodes a base64url JSON segment of a JWT
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// intersects reports whether a and b share a value
+func intersects(a, b []string) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
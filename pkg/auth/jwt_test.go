@@ -0,0 +1,247 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var (
+	rsaKey, _ = rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _  = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+)
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// signToken builds a JWT with the given algorithm and claims, signed with
+// key (an HMAC secret, *rsa.PrivateKey or *ecdsa.PrivateKey)
+func signToken(t *testing.T, alg, kid string, key interface{}, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch key := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		signature, _ = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+	return signed + "." + b64(signature)
+}
+
+// jwks encodes the public halves of the test keys as a key set
+func jwks(rsaID, ecID string) []byte {
+	set := map[string]interface{}{"keys": []map[string]string{
+		{"kty": "RSA", "kid": rsaID, "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{"kty": "EC", "kid": ecID, "crv": "P-256", "x": b64(ecKey.X.Bytes()), "y": b64(ecKey.Y.Bytes())},
+		{"kty": "RSA", "kid": "enc", "use": "enc", "n": "AQAB", "e": "AQAB"},
+	}}
+	data, _ := json.Marshal(set)
+	return data
+}
+
+func validClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"sub": "alice",
+		"iss": "https://issuer.example",
+		"aud": []string{"other", "mcp"},
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func jwksValidator(t *testing.T) *Validator {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(file, jwks("rsa-1", "ec-1"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	v, err := NewValidator(Config{JWKSFile: file, Issuer: "https://issuer.example", Audience: []string{"mcp"}})
+	if err != nil {
+		t.Fatalf("NewValidator failed: %v", err)
+	}
+	return v
+}
+
+func TestValidator_JWKS(t *testing.T) {
+	v := jwksValidator(t)
+
+	for _, token := range []string{
+		signToken(t, "RS256", "rsa-1", rsaKey, validClaims()),
+		signToken(t, "ES256", "ec-1", ecKey, validClaims()),
+		signToken(t, "ES256", "", ecKey, validClaims()),
+	} {
+		claims, err := v.Validate(token)
+		if err != nil {
+			t.Fatalf("Expected token to be accepted, got %v", err)
+		}
+		if claims.Method != "jwt" || claims.Subject != "alice" || len(claims.Audience) != 2 {
+			t.Errorf("Unexpected claims %+v", claims)
+		}
+	}
+}
+
+func TestValidator_RejectedJWTs(t *testing.T) {
+	v := jwksValidator(t)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	claims := func(change func(map[string]interface{})) map[string]interface{} {
+		c := validClaims()
+		change(c)
+		return c
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		message string
+	}{
+		{"wrong key", signToken(t, "ES256", "ec-1", otherKey, validClaims()), "signature mismatch"},
+		{"unknown kid", signToken(t, "RS256", "rsa-2", rsaKey, validClaims()), "unknown key ID"},
+		{"algorithm mismatch", signToken(t, "ES256", "rsa-1", ecKey, validClaims()), "signature mismatch"},
+		{"none", signToken(t, "none", "", []byte{}, validClaims()), "unsupported algorithm"},
+		{"hmac with public key", signToken(t, "HS256", "", []byte("secret"), validClaims()), "signature mismatch"},
+		{"expired", signToken(t, "RS256", "rsa-1", rsaKey, claims(func(c map[string]interface{}) {
+			c["exp"] = time.Now().Add(-2 * time.Minute).Unix()
+		})), "expired"},
+		{"no expiry", signToken(t, "RS256", "rsa-1", rsaKey, claims(func(c map[string]interface{}) {
+			delete(c, "exp")
+		})), "no expiry"},
+		{"not yet valid", signToken(t, "RS256", "rsa-1", rsaKey, claims(func(c map[string]interface{}) {
+			c["nbf"] = time.Now().Add(5 * time.Minute).Unix()
+		})), "not valid before"},
+		{"wrong issuer", signToken(t, "RS256", "rsa-1", rsaKey, claims(func(c map[string]interface{}) {
+			c["iss"] = "https://evil.example"
+		})), "unexpected issuer"},
+		{"wrong audience", signToken(t, "RS256", "rsa-1", rsaKey, claims(func(c map[string]interface{}) {
+			c["aud"] = "other"
+		})), "audience"},
+		{"tampered", signToken(t, "RS256", "rsa-1", rsaKey, validClaims()) + "x", "signature"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := v.Validate(tt.token)
+			if err == nil || !strings.Contains(err.Error(), tt.message) {
+				t.Errorf("Expected error containing %q, got %v", tt.message, err)
+			}
+		})
+	}
+}
+
+func TestValidator_ClockSkew(t *testing.T) {
+	v := jwksValidator(t)
+	c := validClaims()
+	c["exp"] = time.Now().Add(-30 * time.Second).Unix()
+	c["nbf"] = time.Now().Add(30 * time.Second).Unix()
+
+	if _, err := v.Validate(signToken(t, "RS256", "rsa-1", rsaKey, c)); err != nil {
+		t.Errorf("Expected times within the clock skew to be accepted, got %v", err)
+	}
+}
+
+func TestValidator_HMACRotation(t *testing.T) {
+	v, err := NewValidator(Config{HMACSecrets: []string{"current", "previous"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, secret := range []string{"current", "previous"} {
+		if _, err := v.Validate(signToken(t, "HS256", "", []byte(secret), validClaims())); err != nil {
+			t.Errorf("Expected a token signed with %q to be accepted, got %v", secret, err)
+		}
+	}
+	if _, err := v.Validate(signToken(t, "HS256", "", []byte("retired"), validClaims())); err == nil {
+		t.Error("Expected a token signed with an unknown secret to be rejected")
+	}
+	if _, err := v.Validate(signToken(t, "RS256", "", rsaKey, validClaims())); err == nil {
+		t.Error("Expected RS256 to be rejected without a key set")
+	}
+}
+
+func TestValidator_JWKSURLRotation(t *testing.T) {
+	var current atomic.Value
+	current.Store(jwks("rsa-1", "ec-1"))
+	var fetches int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		w.Write(current.Load().([]byte))
+	}))
+	defer server.Close()
+
+	v, err := NewValidator(Config{JWKSURL: server.URL})
+	if err != nil {
+		t.Fatalf("NewValidator failed: %v", err)
+	}
+	if _, err := v.Validate(signToken(t, "RS256", "rsa-1", rsaKey, validClaims())); err != nil {
+		t.Fatalf("Expected token to be accepted, got %v", err)
+	}
+
+	// The provider rotates to a new key ID; the set is refetched once the
+	// minimum interval has passed
+	current.Store(jwks("rsa-2", "ec-2"))
+	token := signToken(t, "RS256", "rsa-2", rsaKey, validClaims())
+	if _, err := v.Validate(token); err == nil {
+		t.Error("Expected an unknown key ID not to trigger an immediate refetch")
+	}
+	v.keys.now = func() time.Time { return time.Now().Add(2 * minJWKSFetch) }
+	if _, err := v.Validate(token); err != nil {
+		t.Errorf("Expected the rotated key to be fetched, got %v", err)
+	}
+	if fetches != 2 {
+		t.Errorf("Expected 2 fetches of the key set, got %d", fetches)
+	}
+}
+
+func TestNewValidator_Errors(t *testing.T) {
+	dir := t.TempDir()
+	empty := filepath.Join(dir, "empty.json")
+	os.WriteFile(empty, []byte(`{"keys": []}`), 0600)
+	weak := filepath.Join(dir, "weak.json")
+	os.WriteFile(weak, []byte(`{"keys": [{"kty": "RSA", "n": "AQAB", "e": "AQAB"}]}`), 0600)
+
+	tests := []struct {
+		config  Config
+		message string
+	}{
+		{Config{JWKSFile: filepath.Join(dir, "missing.json")}, "failed to load JWKS"},
+		{Config{JWKSFile: empty}, "no usable signing keys"},
+		{Config{JWKSFile: weak}, "at least 2048 bits"},
+		{Config{JWKSFile: empty, JWKSURL: "https://issuer.example/jwks"}, "not both"},
+	}
+
+	for _, tt := range tests {
+		_, err := NewValidator(tt.config)
+		if err == nil || !strings.Contains(err.Error(), tt.message) {
+			t.Errorf("NewValidator(%+v) = %v, expected error containing %q", tt.config, err, tt.message)
+		}
+	}
+}
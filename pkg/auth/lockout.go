@@ -0,0 +1,162 @@
+package auth
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxFailures is the number of failed attempts before a client
+	// is locked out
+	DefaultMaxFailures = 5
+	// DefaultLockout is how long a client is first locked out
+	DefaultLockout = time.Minute
+	// DefaultMaxLockout caps the lockout as it doubles with further failures
+	DefaultMaxLockout = time.Hour
+
+	// maxTrackedClients bounds the number of clients with recorded failures
+	maxTrackedClients = 10000
+)
+
+// LockoutConfig configures a Lockout
+type LockoutConfig struct {
+	// MaxFailures is the number of consecutive failures after which a
+	// client is locked out; zero disables lockout
+	MaxFailures int
+	// Lockout is the duration of the first lockout. Every failure after a
+	// lockout ends doubles it, up to MaxLockout.
+	Lockout    time.Duration
+	MaxLockout time.Duration
+}
+
+// Lockout counts failed authentication attempts per client and locks out
+// clients that fail repeatedly, with exponentially growing lockouts. A
+// client's failures are forgotten after a success, or once it has not
+// failed for MaxLockout.
+type Lockout struct {
+	config LockoutConfig
+
+	mu      sync.Mutex
+	clients map[string]*clientFailures
+	now     func() time.Time
+}
+
+// clientFailures is the failure record of one client
+type clientFailures struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// NewLockout creates a lockout tracker
+func NewLockout(config LockoutConfig) *Lockout {
+	if config.Lockout <= 0 {
+		config.Lockout = DefaultLockout
+	}
+	if config.MaxLockout < config.Lockout {
+		config.MaxLockout = config.Lockout
+	}
+	return &Lockout{
+		config:  config,
+		clients: make(map[string]*clientFailures),
+		now:     time.Now,
+	}
+}
+
+// Locked reports whether the client is locked out and for how much longer
+func (l *Lockout) Locked(client string) (bool, time.Duration) {
+	if l == nil || l.config.MaxFailures <= 0 {
+		return false, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	record, ok := l.clients[client]
+	if !ok {
+		return false, 0
+	}
+	if remaining := record.lockedUntil.Sub(l.now()); remaining > 0 {
+		return true, remaining
+	}
+	return false, 0
+}
+
+// Failure records a failed attempt by the client, returning its number of
+// consecutive failures and the lockout it now has, if any
+func (l *Lockout) Failure(client string) (int, time.Duration) {
+	if l == nil || l.config.MaxFailures <= 0 {
+		return 0, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	record, ok := l.clients[client]
+	if !ok || now.Sub(record.lastFailure) > l.config.MaxLockout {
+		if !ok && len(l.clients) >= maxTrackedClients {
+			l.prune(now)
+		}
+		record = &clientFailures{}
+		l.clients[client] = record
+	}
+	record.failures++
+	record.lastFailure = now
+
+	if record.failures < l.config.MaxFailures {
+		return record.failures, 0
+	}
+	lockout := l.config.Lockout
+	for i := l.config.MaxFailures; i < record.failures && lockout < l.config.MaxLockout; i++ {
+		lockout *= 2
+	}
+	if lockout > l.config.MaxLockout {
+		lockout = l.config.MaxLockout
+	}
+	record.lockedUntil = now.Add(lockout)
+	return record.failures, lockout
+}
+
+// Success forgets the client's failures
+func (l *Lockout) Success(client string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.clients, client)
+}
+
+// prune drops the records of clients that are no longer tracked, and if
+// that is not enough, the record with the oldest failure
+func (l *Lockout) prune(now time.Time) {
+	var oldest string
+	for client, record := range l.clients {
+		if now.Sub(record.lastFailure) > l.config.MaxLockout {
+			delete(l.clients, client)
+		} else if oldest == "" || record.lastFailure.Before(l.clients[oldest].lastFailure) {
+			oldest = client
+		}
+	}
+	if len(l.clients) >= maxTrackedClients {
+		delete(l.clients, oldest)
+	}
+}
+
+// ClientKey returns the lockout key for a request's remote address: the
+// IP address, or for IPv6 its /64 network, which usually belongs to a
+// single host
+func ClientKey(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return host
+	}
+	if ip.To4() == nil {
+		return ip.Mask(net.CIDRMask(64, 128)).String() + "/64"
+	}
+	return ip.String()
+}
@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockout_Backoff(t *testing.T) {
+	l := NewLockout(LockoutConfig{MaxFailures: 3, Lockout: time.Minute, MaxLockout: 5 * time.Minute})
+	now := time.Now()
+	l.now = func() time.Time { return now }
+
+	for i := 1; i < 3; i++ {
+		if failures, lockedFor := l.Failure("10.0.0.1"); failures != i || lockedFor != 0 {
+			t.Fatalf("Failure %d: got %d failures, locked for %s", i, failures, lockedFor)
+		}
+	}
+	if locked, _ := l.Locked("10.0.0.1"); locked {
+		t.Fatal("Expected no lockout before the maximum number of failures")
+	}
+
+	// Each failure after a lockout ends doubles it, up to the maximum
+	for _, expected := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute} {
+		_, lockedFor := l.Failure("10.0.0.1")
+		if lockedFor != expected {
+			t.Errorf("Expected a lockout of %s, got %s", expected, lockedFor)
+		}
+		if locked, retryAfter := l.Locked("10.0.0.1"); !locked || retryAfter != expected {
+			t.Errorf("Expected the client to be locked for %s, got %v, %s", expected, locked, retryAfter)
+		}
+		now = now.Add(lockedFor)
+	}
+
+	if locked, _ := l.Locked("10.0.0.2"); locked {
+		t.Error("Expected other clients not to be locked out")
+	}
+}
+
+func TestLockout_Reset(t *testing.T) {
+	l := NewLockout(LockoutConfig{MaxFailures: 2, Lockout: time.Minute, MaxLockout: time.Hour})
+	now := time.Now()
+	l.now = func() time.Time { return now }
+
+	l.Failure("client")
+	l.Success("client")
+	if failures, _ := l.Failure("client"); failures != 1 {
+		t.Errorf("Expected a success to reset the failures, got %d", failures)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if failures, _ := l.Failure("client"); failures != 1 {
+		t.Errorf("Expected old failures to be forgotten, got %d", failures)
+	}
+}
+
+func TestLockout_Disabled(t *testing.T) {
+	l := NewLockout(LockoutConfig{})
+	for i := 0; i < 100; i++ {
+		l.Failure("client")
+	}
+	if locked, _ := l.Locked("client"); locked {
+		t.Error("Expected no lockout when disabled")
+	}
+
+	var nilLockout *Lockout
+	if locked, _ := nilLockout.Locked("client"); locked {
+		t.Error("Expected a nil lockout never to lock")
+	}
+}
+
+func TestClientKey(t *testing.T) {
+	tests := map[string]string{
+		"192.0.2.7:5123":             "192.0.2.7",
+		"[2001:db8:1:2:3:4:5:6]:443": "2001:db8:1:2::/64",
+		"[2001:db8:1:2:ffff::1]:443": "2001:db8:1:2::/64",
+		"[::ffff:192.0.2.7]:80":      "192.0.2.7",
+		"unix-socket":                "unix-socket",
+	}
+	for addr, expected := range tests {
+		if got := ClientKey(addr); got != expected {
+			t.Errorf("ClientKey(%q) = %q, expected %q", addr, got, expected)
+		}
+	}
+}
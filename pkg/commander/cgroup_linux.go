@@ -0,0 +1,193 @@
+//go:build linux
+
+package commander
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// cgroup2SuperMagic is the filesystem type of a cgroup v2 hierarchy
+const cgroup2SuperMagic = 0x63677270
+
+// cgroupCounter numbers the cgroups created for commands
+var cgroupCounter atomic.Uint64
+
+// commandCgroup is the cgroup v2 directory a single command runs in. Its
+// methods may be called on a nil *commandCgroup, which does nothing.
+type commandCgroup struct {
+	path string
+	dir  *os.File
+}
+
+// cgroupControllers returns the controllers needed to enforce limits
+func cgroupControllers(limits *ResourceLimits) []string {
+	var controllers []string
+	if limits.MemoryBytes > 0 {
+		controllers = append(controllers, "memory")
+	}
+	if limits.MaxProcesses > 0 {
+		controllers = append(controllers, "pids")
+	}
+	return controllers
+}
+
+// checkCgroupParent verifies that command cgroups can be created under the
+// configured parent, enabling the controllers they need
+func checkCgroupParent(limits *ResourceLimits) error {
+	parent := limits.CgroupParent
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(parent, &fs); err != nil {
+		return fmt.Errorf("cgroup %s: %w", parent, err)
+	}
+	if fs.Type != cgroup2SuperMagic {
+		return fmt.Errorf("cgroup %s: not a cgroup v2 directory", parent)
+	}
+
+	available, err := os.ReadFile(filepath.Join(parent, "cgroup.controllers"))
+	if err != nil {
+		return fmt.Errorf("cgroup %s: %w", parent, err)
+	}
+	enabled, err := os.ReadFile(filepath.Join(parent, "cgroup.subtree_control"))
+	if err != nil {
+		return fmt.Errorf("cgroup %s: %w", parent, err)
+	}
+	for _, controller := range cgroupControllers(limits) {
+		if !containsField(string(available), controller) {
+			return fmt.Errorf("cgroup %s: %s controller is not available", parent, controller)
+		}
+		if containsField(string(enabled), controller) {
+			continue
+		}
+		// Fails if the cgroup itself contains processes
+		if err := os.WriteFile(filepath.Join(parent, "cgroup.subtree_control"), []byte("+"+controller), 0); err != nil {
+			return fmt.Errorf("cgroup %s: cannot enable %s controller (the cgroup must not contain processes): %w", parent, controller, err)
+		}
+	}
+
+	cgroup, err := newCommandCgroup(limits)
+	if err != nil {
+		return err
+	}
+	cgroup.remove()
+	return nil
+}
+
+// newCommandCgroup creates a cgroup for one command with the memory and
+// process limits
+func newCommandCgroup(limits *ResourceLimits) (*commandCgroup, error) {
+	name := fmt.Sprintf("cmd-%d-%d", os.Getpid(), cgroupCounter.Add(1))
+	path := filepath.Join(limits.CgroupParent, name)
+	if err := os.Mkdir(path, 0o755); err != nil {
+		return nil, fmt.Errorf("create cgroup: %w", err)
+	}
+	cgroup := &commandCgroup{path: path}
+
+	var settings [][2]string
+	if limits.MemoryBytes > 0 {
+		settings = append(settings, [2]string{"memory.max", strconv.FormatInt(limits.MemoryBytes, 10)})
+	}
+	if limits.MaxProcesses > 0 {
+		settings = append(settings, [2]string{"pids.max", strconv.Itoa(limits.MaxProcesses)})
+	}
+	for _, setting := range settings {
+		if err := os.WriteFile(filepath.Join(path, setting[0]), []byte(setting[1]), 0); err != nil {
+			cgroup.remove()
+			return nil, fmt.Errorf("set cgroup %s: %w", setting[0], err)
+		}
+	}
+	if limits.MemoryBytes > 0 {
+		// Keep the memory limit from being sidestepped through swap; not
+		// every kernel has swap accounting
+		os.WriteFile(filepath.Join(path, "memory.swap.max"), []byte("0"), 0)
+	}
+
+	dir, err := os.Open(path)
+	if err != nil {
+		cgroup.remove()
+		return nil, fmt.Errorf("open cgroup: %w", err)
+	}
+	cgroup.dir = dir
+	return cgroup, nil
+}
+
+// attach makes cmd start in the cgroup
+func (cg *commandCgroup) attach(cmd *exec.Cmd) {
+	if cg == nil {
+		return
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(cg.dir.Fd())
+}
+
+// inspect records the limits the command's processes ran into
+func (cg *commandCgroup) inspect(result *Result) {
+	if cg == nil {
+		return
+	}
+	if cgroupEvent(filepath.Join(cg.path, "memory.events"), "oom_kill") > 0 {
+		addLimitExceeded(result, LimitMemory)
+	}
+	if cgroupEvent(filepath.Join(cg.path, "pids.events"), "max") > 0 {
+		addLimitExceeded(result, LimitProcesses)
+	}
+}
+
+// remove kills anything left in the cgroup and deletes it
+func (cg *commandCgroup) remove() {
+	if cg == nil {
+		return
+	}
+	if cg.dir != nil {
+		cg.dir.Close()
+	}
+	// cgroup.kill needs Linux 5.14; the process group is killed anyway
+	os.WriteFile(filepath.Join(cg.path, "cgroup.kill"), []byte("1"), 0)
+	for i := 0; i < 50; i++ {
+		if err := os.Remove(cg.path); err == nil || os.IsNotExist(err) {
+			return
+		}
+		// Removal fails while dying processes are still being reaped
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// cgroupEvent returns a counter from a cgroup events file
+func cgroupEvent(path, name string) int64 {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == name {
+			n, _ := strconv.ParseInt(fields[1], 10, 64)
+			return n
+		}
+	}
+	return 0
+}
+
+// containsField reports whether a space-separated list contains word
+func containsField(list, word string) bool {
+	for _, field := range strings.Fields(list) {
+		if field == word {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,26 @@
+//go:build !linux
+
+package commander
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+)
+
+// commandCgroup is never created on platforms without cgroups
+type commandCgroup struct{}
+
+func checkCgroupParent(limits *ResourceLimits) error {
+	return fmt.Errorf("cgroups are not supported on %s", runtime.GOOS)
+}
+
+func newCommandCgroup(limits *ResourceLimits) (*commandCgroup, error) {
+	return nil, fmt.Errorf("cgroups are not supported on %s", runtime.GOOS)
+}
+
+func (cg *commandCgroup) attach(cmd *exec.Cmd) {}
+
+func (cg *commandCgroup) inspect(result *Result) {}
+
+func (cg *commandCgroup) remove() {}
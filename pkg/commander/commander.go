@@ -3,11 +3,14 @@ package commander
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/shlex"
@@ -25,8 +28,36 @@ type Config struct {
 	Shell string
 	// ShellArg is the argument to pass to the shell for command execution
 	ShellArg string
+	// KillGracePeriod is how long a command that timed out or was cancelled
+	// has to exit after SIGTERM before its process group is killed with
+	// SIGKILL. Zero means DefaultKillGracePeriod; a negative value kills
+	// immediately.
+	KillGracePeriod time.Duration
+	// Policy holds optional declarative rules applied alongside the allowed
+	// and blocked lists. It must have been compiled; see LoadPolicy.
+	Policy *Policy
+	// ArgvOnly rejects shell command strings, so that only ExecuteArgv can
+	// run programs
+	ArgvOnly bool
+	// Sandbox, if set, runs every command and shell session in a sandbox.
+	// Programs using it must call RunSandboxHelper at the start of main.
+	Sandbox *SandboxConfig
+	// Limits restricts the resources of every command, and of shell
+	// sessions except for CPU time. Memory, CPU, process and file size
+	// limits also require RunSandboxHelper. Nil only caps captured output
+	// at DefaultMaxOutputBytes.
+	Limits *ResourceLimits
 }
 
+// DefaultKillGracePeriod is the default time a stopped command has to exit
+// after SIGTERM
+const DefaultKillGracePeriod = 5 * time.Second
+
+// pipeCloseDelay is how long to wait, after the command was killed or its
+// shell exited, for processes that left the process group to close its
+// output pipes before they are closed anyway
+const pipeCloseDelay = 2 * time.Second
+
 // Commander handles command execution with security controls
 type Commander struct {
 	config Config
@@ -39,8 +70,37 @@ type Result struct {
 	ExitCode int
 	Duration time.Duration
 	Error    error
+	// Cancelled is true if the caller's context was cancelled before the
+	// command finished
+	Cancelled bool
+	// TimedOut is true if the command was killed for exceeding its timeout
+	TimedOut bool
+	// ForcedKill is true if the command's processes had to be killed with
+	// SIGKILL because they did not exit within the grace period after
+	// SIGTERM
+	ForcedKill bool
+	// Sandboxed is true if the command ran in the sandbox
+	Sandboxed bool
+	// SandboxViolations describes sandbox restrictions that stopped the
+	// command, such as a blocked system call
+	SandboxViolations []string
+	// LimitsExceeded names the resource limits the command ran into; see
+	// the Limit constants
+	LimitsExceeded []string
+	// Hints are best-effort guesses, from the command's error output, at
+	// limits or sandbox restrictions that made it fail. Unlike
+	// LimitsExceeded and SandboxViolations they may be wrong.
+	Hints []string
+	// StdoutTruncated and StderrTruncated are true if output beyond the
+	// output limit was dropped from Stdout or Stderr
+	StdoutTruncated bool
+	StderrTruncated bool
 }
 
+// OutputFunc receives command output as it is produced. Stream is "stdout" or
+// "stderr". It may be called concurrently from the stdout and stderr readers.
+type OutputFunc func(stream string, data []byte)
+
 // NewCommander creates a new Commander with the given configuration
 func NewCommander(cfg Config) *Commander {
 	// Set default shell based on OS
@@ -59,6 +119,10 @@ func NewCommander(cfg Config) *Commander {
 		cfg.DefaultTimeout = 30 * time.Second
 	}
 
+	if cfg.KillGracePeriod == 0 {
+		cfg.KillGracePeriod = DefaultKillGracePeriod
+	}
+
 	return &Commander{config: cfg}
 }
 
@@ -95,37 +159,115 @@ func DefaultBlockedCommands() []string {
 	}
 }
 
-// ValidateCommand checks if a command is allowed to run
+// ValidateCommand checks if a command is allowed to run. See CheckCommand
+// for how the allow and block lists are applied. A denied command yields a
+// *PolicyError carrying the full decision.
 func (c *Commander) ValidateCommand(command string) error {
-	command = strings.TrimSpace(command)
-	commandLower := strings.ToLower(command)
-
-	// Check blocked commands first
-	for _, blocked := range c.config.BlockedCommands {
-		blockedLower := strings.ToLower(strings.TrimSpace(blocked))
-		if strings.HasPrefix(commandLower, blockedLower) || strings.Contains(commandLower, blockedLower) {
-			return fmt.Errorf("command blocked: matches blocked pattern '%s'", blocked)
-		}
+	decision := c.CheckCommand(command)
+	if !decision.Allowed {
+		return &PolicyError{Decision: decision}
 	}
+	return nil
+}
 
-	// If allowed commands list is empty, allow all (except blocked)
-	if len(c.config.AllowedCommands) == 0 {
-		return nil
+// ValidateRequest checks a command together with the working directory and
+// environment it will run with. It returns the policy decision so callers
+// can apply any timeout limit set by the rule that allowed the command.
+func (c *Commander) ValidateRequest(command, workDir string, env map[string]string) (*PolicyDecision, error) {
+	if err := c.ValidateEnvironment(workDir, env); err != nil {
+		return nil, err
+	}
+	decision := c.CheckCommandIn(command, workDir)
+	if !decision.Allowed {
+		return decision, &PolicyError{Decision: decision}
 	}
+	return decision, nil
+}
 
-	// Check if command starts with an allowed prefix
-	for _, allowed := range c.config.AllowedCommands {
-		allowedLower := strings.ToLower(strings.TrimSpace(allowed))
-		if strings.HasPrefix(commandLower, allowedLower) {
-			return nil
-		}
+// ValidateArgv is like ValidateRequest for a program run directly with
+// ExecuteArgv
+func (c *Commander) ValidateArgv(argv []string, workDir string, env map[string]string) (*PolicyDecision, error) {
+	if err := c.ValidateEnvironment(workDir, env); err != nil {
+		return nil, err
 	}
+	decision := c.CheckArgv(argv, workDir)
+	if !decision.Allowed {
+		return decision, &PolicyError{Decision: decision}
+	}
+	return decision, nil
+}
 
-	return fmt.Errorf("command not allowed: does not match any allowed command patterns")
+// ValidateEnvironment checks a working directory and environment variable
+// names against the policy, if one is configured
+func (c *Commander) ValidateEnvironment(workDir string, env map[string]string) error {
+	if c.config.Policy == nil {
+		return nil
+	}
+	return c.config.Policy.CheckEnvironment(workDir, env)
 }
 
 // Execute runs a command with the given options
 func (c *Commander) Execute(ctx context.Context, command string, workDir string, timeout time.Duration, env map[string]string) *Result {
+	return c.ExecuteStream(ctx, command, workDir, timeout, env, nil)
+}
+
+// ExecuteStream runs a command like Execute, additionally passing output to
+// onOutput while the command runs. The returned Result still contains the
+// complete output. A negative timeout runs the command without a time limit.
+func (c *Commander) ExecuteStream(ctx context.Context, command string, workDir string, timeout time.Duration, env map[string]string, onOutput OutputFunc) *Result {
+	return c.execute(ctx, c.shellArgv(command), workDir, timeout, env, onOutput, true)
+}
+
+// ExecuteArgv runs a program directly, without a shell, so that its
+// arguments are passed exactly as given. argv[0] is resolved through PATH,
+// or against workDir if it is a relative path. Output is streamed to
+// onOutput as in ExecuteStream, which may be nil.
+func (c *Commander) ExecuteArgv(ctx context.Context, argv []string, workDir string, timeout time.Duration, env map[string]string, onOutput OutputFunc) *Result {
+	resolved, err := c.resolveArgv(argv, workDir)
+	if err != nil {
+		return &Result{ExitCode: -1, Error: err}
+	}
+	return c.execute(ctx, resolved, workDir, timeout, env, onOutput, true)
+}
+
+// shellArgv returns the argv that runs command with the configured shell
+func (c *Commander) shellArgv(command string) []string {
+	return []string{c.config.Shell, c.config.ShellArg, command}
+}
+
+// resolveArgv returns argv with the program replaced by its absolute path
+func (c *Commander) resolveArgv(argv []string, workDir string) ([]string, error) {
+	if len(argv) == 0 || argv[0] == "" {
+		return nil, fmt.Errorf("argv must name a program")
+	}
+	program, err := lookupProgram(argv[0], workDir)
+	if err != nil {
+		return nil, err
+	}
+	resolved := append([]string{program}, argv[1:]...)
+	return resolved, nil
+}
+
+// lookupProgram resolves a program name through PATH. Names containing a
+// path separator are used as given, relative to workDir if not absolute.
+func lookupProgram(name, workDir string) (string, error) {
+	if strings.ContainsAny(name, `/\`) && !filepath.IsAbs(name) && workDir != "" {
+		name = filepath.Join(workDir, name)
+	}
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return "", fmt.Errorf("program not found: %s", name)
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return path, nil
+}
+
+// execute runs a command. When capture is false, output is only passed to
+// onOutput and Result.Stdout/Stderr are left empty, so long-running commands
+// do not accumulate their output in memory.
+func (c *Commander) execute(ctx context.Context, argv []string, workDir string, timeout time.Duration, env map[string]string, onOutput OutputFunc, capture bool) *Result {
 	start := time.Now()
 	result := &Result{}
 
@@ -135,11 +277,31 @@ func (c *Commander) Execute(ctx context.Context, command string, workDir string,
 	}
 
 	// Create context with timeout
-	ctx, cancel := context.WithTimeout(ctx, timeout)
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
 	defer cancel()
 
-	// Create command
-	cmd := exec.CommandContext(ctx, c.config.Shell, c.config.ShellArg, command)
+	// Create command in its own process group so that cancellation and
+	// timeouts terminate everything it spawned, not just the shell
+	cmd := exec.Command(argv[0], argv[1:]...)
+	setProcessGroup(cmd)
+	cmd.WaitDelay = pipeCloseDelay
+
+	limits := c.config.Limits
+	cgroup, report, err := c.prepareCommand(cmd, limits)
+	if err != nil {
+		result.Error = err
+		result.Duration = time.Since(start)
+		result.ExitCode = -1
+		return result
+	}
+	defer cgroup.remove()
+	defer report.close()
+	result.Sandboxed = c.config.Sandbox != nil
 
 	// Set working directory if specified
 	if workDir != "" {
@@ -162,24 +324,46 @@ func (c *Commander) Execute(ctx context.Context, command string, workDir string,
 	}
 
 	// Capture output
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+	outputLimit := limits.outputLimit()
+	stdout := &outputWriter{stream: "stdout", onOutput: onOutput, capture: capture, limit: outputLimit}
+	stderr := &outputWriter{stream: "stderr", onOutput: onOutput, capture: capture, limit: outputLimit}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
 
-	// Execute command
-	err := cmd.Run()
+	// Execute command, stopping it when the context is done
+	err = cmd.Start()
+	report.started()
+	if err == nil {
+		stopper := &processStopper{cmd: cmd, grace: c.config.KillGracePeriod}
+		stopOnDone := context.AfterFunc(ctx, stopper.stop)
+		err = cmd.Wait()
+		stopOnDone()
+		stopper.finish()
+		result.ForcedKill = stopper.forced()
+	}
+	if errors.Is(err, exec.ErrWaitDelay) {
+		// The command succeeded, but left processes holding its output open
+		err = nil
+	}
 
 	result.Stdout = stdout.String()
 	result.Stderr = stderr.String()
+	result.StdoutTruncated = stdout.dropped > 0
+	result.StderrTruncated = stderr.dropped > 0
 	result.Duration = time.Since(start)
 
 	if err != nil {
 		result.Error = err
-		if exitError, ok := err.(*exec.ExitError); ok {
-			result.ExitCode = exitError.ExitCode()
-		} else if ctx.Err() == context.DeadlineExceeded {
+		if ctx.Err() == context.DeadlineExceeded {
 			result.ExitCode = -1
+			result.TimedOut = true
 			result.Error = fmt.Errorf("command timed out after %s", timeout)
+		} else if ctx.Err() == context.Canceled {
+			result.ExitCode = -1
+			result.Cancelled = true
+			result.Error = fmt.Errorf("command cancelled")
+		} else if exitError, ok := err.(*exec.ExitError); ok {
+			result.ExitCode = exitError.ExitCode()
 		} else {
 			result.ExitCode = -1
 		}
@@ -187,9 +371,165 @@ func (c *Commander) Execute(ctx context.Context, command string, workDir string,
 		result.ExitCode = 0
 	}
 
+	if msg := report.message(); msg != "" {
+		result.ExitCode = -1
+		result.Error = errors.New(msg)
+		return result
+	}
+	if result.Sandboxed {
+		inspectSandboxResult(result, err)
+	}
+	if limits.processLimited() {
+		inspectLimitResult(result, err, limits, cgroup != nil)
+	}
+	cgroup.inspect(result)
+	if result.StdoutTruncated || result.StderrTruncated {
+		addLimitExceeded(result, LimitOutput)
+	}
+
 	return result
 }
 
+// processStopper stops a command whose context is done: it sends SIGTERM
+// to the command's process group and SIGKILL if the group has not exited
+// after the grace period
+type processStopper struct {
+	cmd      *exec.Cmd
+	grace    time.Duration
+	mu       sync.Mutex
+	stopping bool
+	timer    *time.Timer
+	fired    bool
+	killed   bool
+	done     bool
+}
+
+func (s *processStopper) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done {
+		return
+	}
+	s.stopping = true
+	if s.grace > 0 && terminateProcessGroup(s.cmd) {
+		s.timer = time.AfterFunc(s.grace, s.kill)
+		return
+	}
+	s.killed = killProcessGroup(s.cmd) == nil
+}
+
+func (s *processStopper) kill() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fired = true
+	if !s.done && processGroupAlive(s.cmd) {
+		s.killed = killProcessGroup(s.cmd) == nil
+	}
+}
+
+// finish is called once the command has exited. If it was being stopped,
+// finish waits for processes left in its group to exit or be killed at the
+// end of the grace period.
+func (s *processStopper) finish() {
+	for s.waiting() && processGroupAlive(s.cmd) {
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.done = true
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+}
+
+// waiting reports whether a SIGKILL is pending
+func (s *processStopper) waiting() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stopping && s.timer != nil && !s.fired
+}
+
+// forced reports whether the command had to be killed with SIGKILL
+func (s *processStopper) forced() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.killed
+}
+
+// prepareCommand applies the sandbox and the process resource limits to
+// cmd. If it runs through the helper, the helper's report is returned. The
+// returned cgroup, which may be nil, must be removed once the command has
+// exited.
+func (c *Commander) prepareCommand(cmd *exec.Cmd, limits *ResourceLimits) (*commandCgroup, *helperReport, error) {
+	helper := &helperConfig{Sandbox: c.config.Sandbox}
+	if helper.Sandbox != nil {
+		if err := applySandbox(cmd, helper.Sandbox); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var cgroup *commandCgroup
+	if limits.usesCgroup() {
+		var err error
+		cgroup, err = newCommandCgroup(limits)
+		if err != nil {
+			return nil, nil, fmt.Errorf("resource limits: %w", err)
+		}
+		cgroup.attach(cmd)
+		helper.Cgroup = true
+	}
+	if limits.processLimited() {
+		helper.Limits = limits
+	}
+
+	if !helper.needed() {
+		return cgroup, nil, nil
+	}
+	report, err := wrapWithHelper(cmd, helper)
+	if err != nil {
+		cgroup.remove()
+		return nil, nil, fmt.Errorf("%s%w", helper.errorPrefix(), err)
+	}
+	return cgroup, report, nil
+}
+
+// outputWriter captures one output stream and forwards it to an OutputFunc.
+// Captured output stops at limit bytes, if limit is positive; the rest is
+// only counted.
+type outputWriter struct {
+	buf      bytes.Buffer
+	stream   string
+	onOutput OutputFunc
+	capture  bool
+	limit    int
+	dropped  int64
+}
+
+func (w *outputWriter) Write(p []byte) (int, error) {
+	if w.capture {
+		keep := p
+		if w.limit > 0 && w.buf.Len()+len(p) > w.limit {
+			keep = p[:w.limit-w.buf.Len()]
+			w.dropped += int64(len(p) - len(keep))
+		}
+		w.buf.Write(keep)
+	}
+	if w.onOutput != nil {
+		w.onOutput(w.stream, p)
+	}
+	return len(p), nil
+}
+
+// String returns the captured output, followed by a marker if some of it
+// was dropped
+func (w *outputWriter) String() string {
+	if w.dropped == 0 {
+		return w.buf.String()
+	}
+	return fmt.Sprintf("%s\n[output truncated: %d bytes omitted]", w.buf.String(), w.dropped)
+}
+
 // GetCommandName extracts the command name from a command string
 func GetCommandName(command string) string {
 	parts, err := shlex.Split(command)
@@ -208,6 +548,47 @@ func (c *Commander) GetShellInfo() (shell, shellArg string) {
 	return c.config.Shell, c.config.ShellArg
 }
 
+// restricted reports whether any command may be refused, by the allowed or
+// blocked lists or by a policy
+func (c *Commander) restricted() bool {
+	return len(c.config.AllowedCommands) > 0 || len(c.config.BlockedCommands) > 0 ||
+		c.config.Policy != nil || c.config.ArgvOnly
+}
+
+// restrictsDirectories reports whether the policy depends on the directory
+// commands run in
+func (c *Commander) restrictsDirectories() bool {
+	return c.config.Policy != nil && c.config.Policy.restrictsDirectories()
+}
+
+// ArgvOnly reports whether shell command strings are disabled
+func (c *Commander) ArgvOnly() bool {
+	return c.config.ArgvOnly
+}
+
+// GetSandbox returns the sandbox configuration, or nil if commands are not
+// sandboxed
+func (c *Commander) GetSandbox() *SandboxConfig {
+	return c.config.Sandbox
+}
+
+// GetLimits returns the configured resource limits, or nil if only the
+// default output limit applies
+func (c *Commander) GetLimits() *ResourceLimits {
+	return c.config.Limits
+}
+
+// GetPolicy returns the configured policy, or nil if there is none
+func (c *Commander) GetPolicy() *Policy {
+	return c.config.Policy
+}
+
+// GetKillGracePeriod returns how long stopped commands have to exit after
+// SIGTERM, or a negative duration if they are killed immediately
+func (c *Commander) GetKillGracePeriod() time.Duration {
+	return c.config.KillGracePeriod
+}
+
 // GetDefaultTimeout returns the default timeout
 func (c *Commander) GetDefaultTimeout() time.Duration {
 	return c.config.DefaultTimeout
@@ -5,10 +5,18 @@ import (
 	"os"
 	"runtime"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
 
+func TestMain(m *testing.M) {
+	// Sandboxed and resource limited commands re-execute the test binary as
+	// the command helper
+	RunSandboxHelper()
+	os.Exit(m.Run())
+}
+
 func TestNewCommander(t *testing.T) {
 	cfg := Config{}
 	cmd := NewCommander(cfg)
@@ -131,6 +139,32 @@ func TestExecute_SimpleCommand(t *testing.T) {
 	}
 }
 
+func TestExecuteStream(t *testing.T) {
+	cmd := NewCommander(Config{})
+
+	var mu sync.Mutex
+	var streamed strings.Builder
+	onOutput := func(stream string, data []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		if stream == "stdout" {
+			streamed.Write(data)
+		}
+	}
+
+	result := cmd.ExecuteStream(context.Background(), "echo first && echo second", "", 0, nil, onOutput)
+
+	if result.ExitCode != 0 {
+		t.Fatalf("Expected exit code 0, got %d. Error: %v", result.ExitCode, result.Error)
+	}
+	if !strings.Contains(result.Stdout, "first") || !strings.Contains(result.Stdout, "second") {
+		t.Errorf("Expected complete output in result, got %s", result.Stdout)
+	}
+	if streamed.String() != result.Stdout {
+		t.Errorf("Expected streamed output %q to match result %q", streamed.String(), result.Stdout)
+	}
+}
+
 func TestExecute_Timeout(t *testing.T) {
 	// Skip this test on Windows because context cancellation doesn't reliably
 	// kill child processes through cmd.exe shell
@@ -168,6 +202,130 @@ func TestExecute_Timeout(t *testing.T) {
 	}
 }
 
+func TestExecute_TimeoutKillsProcessTree(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Process groups are not used on Windows")
+	}
+
+	cmd := NewCommander(Config{KillGracePeriod: time.Second})
+
+	// The grandchild holds stdout open, so the command only finishes once
+	// the whole process group has exited
+	result := cmd.Execute(context.Background(), "sh -c 'sleep 30' & sleep 30", "", 300*time.Millisecond, nil)
+
+	if !result.TimedOut {
+		t.Errorf("Expected result to be marked timed out, got %+v", result)
+	}
+	if result.ForcedKill {
+		t.Error("Expected processes to exit on SIGTERM without a forced kill")
+	}
+	if result.Duration > 3*time.Second {
+		t.Errorf("Command ran too long after timeout: %s", result.Duration)
+	}
+}
+
+func TestExecute_TimeoutForcedKill(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("SIGTERM is not used on Windows")
+	}
+
+	grace := 300 * time.Millisecond
+	cmd := NewCommander(Config{KillGracePeriod: grace})
+
+	// Ignoring SIGTERM is inherited by the sleep, so only SIGKILL stops it
+	result := cmd.Execute(context.Background(), "trap '' TERM; sleep 30; echo survived", "", 200*time.Millisecond, nil)
+
+	if !result.TimedOut {
+		t.Errorf("Expected result to be marked timed out, got %+v", result)
+	}
+	if !result.ForcedKill {
+		t.Error("Expected a forced kill after the grace period")
+	}
+	if strings.Contains(result.Stdout, "survived") {
+		t.Error("Expected the command to be killed before finishing")
+	}
+	if result.Duration < grace {
+		t.Errorf("Expected the grace period to be honoured, finished after %s", result.Duration)
+	}
+	if result.Duration > 3*time.Second {
+		t.Errorf("Command ran too long after timeout: %s", result.Duration)
+	}
+}
+
+func TestExecute_TimeoutForcedKillGrandchild(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("SIGTERM is not used on Windows")
+	}
+
+	grace := 500 * time.Millisecond
+	cmd := NewCommander(Config{KillGracePeriod: grace})
+
+	// The shell exits on SIGTERM, but the subshell's sleep ignores it and
+	// keeps stdout open
+	result := cmd.Execute(context.Background(), "(trap '' TERM; sleep 30) & wait", "", 200*time.Millisecond, nil)
+
+	if !result.ForcedKill {
+		t.Error("Expected the remaining process to be killed after the grace period")
+	}
+	if result.Duration < grace || result.Duration > 3*time.Second {
+		t.Errorf("Expected the command to end after the grace period, took %s", result.Duration)
+	}
+}
+
+func TestExecute_NoGracePeriod(t *testing.T) {
+	cmd := NewCommander(Config{KillGracePeriod: -1})
+
+	result := cmd.Execute(context.Background(), "sleep 30", "", 200*time.Millisecond, nil)
+	if !result.TimedOut || !result.ForcedKill {
+		t.Errorf("Expected an immediate forced kill, got %+v", result)
+	}
+}
+
+func TestExecute_BackgroundProcessDoesNotBlock(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Requires a POSIX shell")
+	}
+
+	cmd := NewCommander(Config{})
+
+	// The background sleep keeps stdout open after the shell exits
+	result := cmd.Execute(context.Background(), "sleep 30 & echo started", "", 20*time.Second, nil)
+	if result.ExitCode != 0 || result.Error != nil {
+		t.Fatalf("Expected success, got %d: %v", result.ExitCode, result.Error)
+	}
+	if strings.TrimSpace(result.Stdout) != "started" {
+		t.Errorf("Expected 'started', got %q", result.Stdout)
+	}
+	if result.Duration > 10*time.Second {
+		t.Errorf("Expected the command to finish without waiting for the background process, took %s", result.Duration)
+	}
+}
+
+func TestExecute_Cancelled(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping cancellation test on Windows - child process termination behaves differently")
+	}
+
+	cmd := NewCommander(Config{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+
+	// The background sleep holds stdout open; it only exits if the whole
+	// process group is killed
+	result := cmd.Execute(ctx, "sleep 10 & sleep 10; wait", "", 30*time.Second, nil)
+
+	if !result.Cancelled {
+		t.Errorf("Expected result to be marked cancelled, got %+v", result)
+	}
+	if result.ExitCode == 0 {
+		t.Errorf("Expected non-zero exit code for cancelled command, got %d", result.ExitCode)
+	}
+	if result.Duration > 3*time.Second {
+		t.Errorf("Cancelled command ran too long: %s", result.Duration)
+	}
+}
+
 func TestExecute_WorkingDirectory(t *testing.T) {
 	cmd := NewCommander(Config{})
 
@@ -284,3 +442,26 @@ func TestDefaultBlockedCommands(t *testing.T) {
 		}
 	}
 }
+
+func TestExecuteArgv(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping on Windows")
+	}
+
+	cmd := NewCommander(Config{})
+	ctx := context.Background()
+
+	// Shell syntax in arguments is passed through literally
+	result := cmd.ExecuteArgv(ctx, []string{"echo", "a; rm -rf ~", "$HOME"}, "", 0, nil, nil)
+	if result.ExitCode != 0 {
+		t.Fatalf("Expected exit code 0, got %d: %v", result.ExitCode, result.Error)
+	}
+	if got := strings.TrimSpace(result.Stdout); got != "a; rm -rf ~ $HOME" {
+		t.Errorf("Expected arguments to be passed literally, got %q", got)
+	}
+
+	result = cmd.ExecuteArgv(ctx, []string{"no-such-program-xyz"}, "", 0, nil, nil)
+	if result.Error == nil || result.ExitCode != -1 {
+		t.Errorf("Expected error for missing program, got exit code %d", result.ExitCode)
+	}
+}
@@ -0,0 +1,81 @@
+package commander
+
+import (
+	"io"
+	"os"
+	"strings"
+)
+
+// helperConfig tells the command helper what to set up before it executes
+// the real command. The helper is the server binary re-executed with
+// helperArg; see RunSandboxHelper.
+type helperConfig struct {
+	Sandbox *SandboxConfig  `json:"sandbox,omitempty"`
+	Limits  *ResourceLimits `json:"limits,omitempty"`
+	// Cgroup is true if the command was started in a cgroup that enforces
+	// the memory and process limits, so the helper need not set rlimits
+	// for them
+	Cgroup bool `json:"cgroup,omitempty"`
+	// ReportFD is the descriptor on which the helper reports a setup error
+	ReportFD int `json:"report_fd,omitempty"`
+}
+
+// needed reports whether the command has to run through the helper
+func (h *helperConfig) needed() bool {
+	return h.Sandbox != nil || h.Limits.rlimited(h.Cgroup)
+}
+
+// errorPrefix returns the prefix of the helper's error messages
+func (h *helperConfig) errorPrefix() string {
+	if h.Sandbox != nil {
+		return "sandbox: "
+	}
+	return "resource limits: "
+}
+
+// helperSetupExitCode is the exit status of the command helper when it
+// cannot set up the sandbox or resource limits. Its error message, prefixed
+// with "sandbox: " or "resource limits: ", goes to the report pipe.
+const helperSetupExitCode = 125
+
+// helperReport is a pipe on which the helper reports a setup error, kept
+// apart from the command's own output. The helper marks its end
+// close-on-exec, so the pipe reaches end of file once the command starts.
+type helperReport struct {
+	r *os.File
+	w *os.File
+}
+
+func newHelperReport() (*helperReport, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	return &helperReport{r: r, w: w}, nil
+}
+
+// started closes the server's copy of the write end, once the helper has
+// been started or failed to start
+func (h *helperReport) started() {
+	if h != nil {
+		h.w.Close()
+	}
+}
+
+// message waits for the helper to execute the command or exit, and returns
+// its setup error, if it reported one. started must have been called.
+func (h *helperReport) message() string {
+	if h == nil {
+		return ""
+	}
+	data, _ := io.ReadAll(h.r)
+	return strings.TrimSpace(string(data))
+}
+
+// close releases both ends of the pipe
+func (h *helperReport) close() {
+	if h != nil {
+		h.r.Close()
+		h.w.Close()
+	}
+}
@@ -0,0 +1,210 @@
+//go:build !windows
+
+package commander
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+)
+
+// helperArg marks a re-execution of the server binary as the command
+// helper. Go cannot run code between fork and exec, so the helper applies
+// the sandbox and resource limits from inside the new process and then
+// executes the real command.
+const helperArg = "__go_mcp_commander_sandbox__"
+
+// Shell exit statuses of a child killed by exceeding an rlimit
+const (
+	cpuLimitShellStatus      = 128 + int(syscall.SIGXCPU)
+	fileSizeLimitShellStatus = 128 + int(syscall.SIGXFSZ)
+)
+
+// memoryErrorMessages are common ways programs report failed allocations
+var memoryErrorMessages = []string{
+	"Cannot allocate memory", "out of memory", "MemoryError", "std::bad_alloc",
+}
+
+// wrapWithHelper rewrites cmd to run through the command helper. The
+// returned report must be started once cmd has been started, and its
+// message read once cmd has exited.
+func wrapWithHelper(cmd *exec.Cmd, cfg *helperConfig) (*helperReport, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("cannot locate server executable: %w", err)
+	}
+	withReport := *cfg
+	withReport.ReportFD = 3 + len(cmd.ExtraFiles)
+	encoded, err := json.Marshal(&withReport)
+	if err != nil {
+		return nil, err
+	}
+	report, err := newHelperReport()
+	if err != nil {
+		return nil, fmt.Errorf("cannot create report pipe: %w", err)
+	}
+
+	args := append([]string{self, helperArg, string(encoded), cmd.Path}, cmd.Args...)
+	cmd.Path = self
+	cmd.Args = args
+	cmd.ExtraFiles = append(cmd.ExtraFiles, report.w)
+	return report, nil
+}
+
+// RunSandboxHelper turns the process into the command helper if it was
+// started as one, and never returns in that case. Programs that use a
+// Commander with a sandbox or resource limits must call it first thing in
+// main.
+func RunSandboxHelper() {
+	if len(os.Args) < 5 || os.Args[1] != helperArg {
+		return
+	}
+	var cfg helperConfig
+	err := json.Unmarshal([]byte(os.Args[2]), &cfg)
+	if err != nil {
+		err = fmt.Errorf("resource limits: invalid helper configuration: %w", err)
+	} else {
+		err = runHelper(&cfg, os.Args[3], os.Args[4:])
+	}
+
+	report := os.Stderr
+	if cfg.ReportFD > 0 {
+		report = os.NewFile(uintptr(cfg.ReportFD), "helper report")
+	}
+	fmt.Fprintln(report, err)
+	os.Exit(helperSetupExitCode)
+}
+
+func runHelper(cfg *helperConfig, path string, argv []string) error {
+	// Landlock and seccomp apply to the calling thread, which then execs
+	runtime.LockOSThread()
+
+	// The command must not inherit the report pipe, so that the server
+	// sees it close once the command starts
+	if cfg.ReportFD > 0 {
+		syscall.CloseOnExec(cfg.ReportFD)
+	}
+
+	if cfg.Sandbox != nil {
+		if err := setupSandbox(cfg.Sandbox); err != nil {
+			return fmt.Errorf("sandbox: %w", err)
+		}
+	}
+	// Set limits last: the address space limit must not get in the way of
+	// the helper itself
+	if cfg.Limits != nil {
+		if err := setRlimits(cfg.Limits, cfg.Cgroup); err != nil {
+			return fmt.Errorf("resource limits: %w", err)
+		}
+	}
+
+	err := syscall.Exec(path, argv, os.Environ())
+	return fmt.Errorf("%sexec %s: %w", cfg.errorPrefix(), path, err)
+}
+
+// setRlimits applies the resource limits to the current process, leaving
+// memory and process limits to the cgroup if there is one
+func setRlimits(limits *ResourceLimits, cgroup bool) error {
+	if limits.CPUSeconds > 0 {
+		// The soft limit sends SIGXCPU; the hard limit a second later SIGKILL
+		seconds := uint64(limits.CPUSeconds)
+		if err := setrlimit(syscall.RLIMIT_CPU, seconds, seconds+1); err != nil {
+			return fmt.Errorf("set CPU limit: %w", err)
+		}
+	}
+	if limits.FileSizeBytes > 0 {
+		size := uint64(limits.FileSizeBytes)
+		if err := setrlimit(syscall.RLIMIT_FSIZE, size, size); err != nil {
+			return fmt.Errorf("set file size limit: %w", err)
+		}
+	}
+	if cgroup {
+		return nil
+	}
+	if limits.MaxProcesses > 0 {
+		if rlimitNproc == 0 {
+			return fmt.Errorf("process limits are not supported on %s", runtime.GOOS)
+		}
+		count := uint64(limits.MaxProcesses)
+		if err := setrlimit(rlimitNproc, count, count); err != nil {
+			return fmt.Errorf("set process limit: %w", err)
+		}
+	}
+	if limits.MemoryBytes > 0 {
+		size := uint64(limits.MemoryBytes)
+		if err := setrlimit(syscall.RLIMIT_AS, size, size); err != nil {
+			return fmt.Errorf("set memory limit: %w", err)
+		}
+	}
+	return nil
+}
+
+// setrlimit lowers a limit, never raising the hard limit above its current
+// value
+func setrlimit(resource int, soft, hard uint64) error {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(resource, &limit); err != nil {
+		return err
+	}
+	lowerRlimit(&limit.Cur, &limit.Max, soft, hard)
+	return syscall.Setrlimit(resource, &limit)
+}
+
+// lowerRlimit sets the fields of a syscall.Rlimit, whose type differs
+// between platforms
+func lowerRlimit[T int64 | uint64](cur, max *T, soft, hard uint64) {
+	if uint64(*max) > hard {
+		*max = T(hard)
+	}
+	*cur = T(soft)
+	if *cur > *max {
+		*cur = *max
+	}
+}
+
+// inspectLimitResult records in result the rlimits a command exceeded, from
+// the signal that ended it. Memory and process rlimits make system calls
+// fail rather than send a signal, so those are only suggested in
+// result.Hints from the command's error output. err is the error returned
+// by running the command.
+func inspectLimitResult(result *Result, err error, limits *ResourceLimits, cgroup bool) {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			switch status.Signal() {
+			case syscall.SIGXCPU:
+				addLimitExceeded(result, LimitCPU)
+			case syscall.SIGXFSZ:
+				addLimitExceeded(result, LimitFileSize)
+			}
+		}
+	}
+
+	// A shell reports a child killed by a signal as 128 plus its number
+	if limits.CPUSeconds > 0 && result.ExitCode == cpuLimitShellStatus {
+		addLimitExceeded(result, LimitCPU)
+	}
+	if limits.FileSizeBytes > 0 && result.ExitCode == fileSizeLimitShellStatus {
+		addLimitExceeded(result, LimitFileSize)
+	}
+	if result.ExitCode == 0 || cgroup {
+		return
+	}
+	if limits.MemoryBytes > 0 {
+		for _, message := range memoryErrorMessages {
+			if line := lineContaining(result.Stderr, message); line != "" {
+				result.Hints = append(result.Hints, "possibly exceeded the memory limit: "+line)
+				break
+			}
+		}
+	}
+	if limits.MaxProcesses > 0 {
+		if line := lineContaining(result.Stderr, "Resource temporarily unavailable"); line != "" {
+			result.Hints = append(result.Hints, "possibly exceeded the process limit: "+line)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+//go:build windows
+
+package commander
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// wrapWithHelper fails on Windows, which supports neither the sandbox nor
+// process resource limits
+func wrapWithHelper(cmd *exec.Cmd, cfg *helperConfig) (*helperReport, error) {
+	return nil, fmt.Errorf("not supported on windows")
+}
+
+// RunSandboxHelper does nothing on Windows, where commands never run
+// through the helper
+func RunSandboxHelper() {}
+
+func inspectLimitResult(result *Result, err error, limits *ResourceLimits, cgroup bool) {}
+
+// rlimitNproc is zero as Windows has no process limits
+const rlimitNproc = 0
@@ -0,0 +1,319 @@
+package commander
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// JobState describes the lifecycle state of a background job
+type JobState string
+
+const (
+	// JobRunning indicates the job's command is still running
+	JobRunning JobState = "running"
+	// JobExited indicates the command exited on its own
+	JobExited JobState = "exited"
+	// JobKilled indicates the job was stopped with Kill
+	JobKilled JobState = "killed"
+	// JobTimedOut indicates the job exceeded its timeout
+	JobTimedOut JobState = "timed_out"
+	// JobFailed indicates the command could not be started or was terminated
+	// by a signal
+	JobFailed JobState = "failed"
+)
+
+const (
+	// DefaultMaxJobs is the default number of jobs a JobRegistry tracks
+	DefaultMaxJobs = 16
+	// DefaultJobBufferSize is the default number of output bytes kept per job
+	DefaultJobBufferSize = 1024 * 1024
+)
+
+// Job is a command running in the background
+type Job struct {
+	ID string
+	// Owner is the identity that started the job; only it can see the job
+	Owner      string
+	Command    string
+	WorkDir    string
+	StartedAt  time.Time
+	output     *RingBuffer
+	cancel     context.CancelFunc
+	done       chan struct{}
+	mu         sync.Mutex
+	state      JobState
+	exitCode   int
+	err        error
+	violations []string
+	limits     []string
+	hints      []string
+	forced     bool
+	finishedAt time.Time
+}
+
+// JobInfo is a point-in-time snapshot of a job
+type JobInfo struct {
+	ID          string     `json:"id"`
+	Owner       string     `json:"owner,omitempty"`
+	Command     string     `json:"command"`
+	WorkDir     string     `json:"working_directory,omitempty"`
+	State       JobState   `json:"state"`
+	ExitCode    *int       `json:"exit_code,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	StartedAt   time.Time  `json:"started_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	Duration    string     `json:"duration"`
+	OutputBytes int64      `json:"output_bytes"`
+	// SandboxViolations lists sandbox restrictions the job ran into
+	SandboxViolations []string `json:"sandbox_violations,omitempty"`
+	// LimitsExceeded names the resource limits the job ran into
+	LimitsExceeded []string `json:"limits_exceeded,omitempty"`
+	// Hints are best-effort guesses at why the job failed
+	Hints []string `json:"hints,omitempty"`
+	// ForcedKill is true if the job ignored SIGTERM and had to be killed
+	ForcedKill bool `json:"forced_kill,omitempty"`
+}
+
+// Info returns a snapshot of the job's current state
+func (j *Job) Info() JobInfo {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	info := JobInfo{
+		ID:                j.ID,
+		Owner:             j.Owner,
+		Command:           j.Command,
+		WorkDir:           j.WorkDir,
+		State:             j.state,
+		StartedAt:         j.StartedAt,
+		OutputBytes:       j.output.Total(),
+		SandboxViolations: j.violations,
+		LimitsExceeded:    j.limits,
+		Hints:             j.hints,
+		ForcedKill:        j.forced,
+	}
+	if j.err != nil {
+		info.Error = j.err.Error()
+	}
+	if j.state == JobRunning {
+		info.Duration = time.Since(j.StartedAt).String()
+	} else {
+		exitCode := j.exitCode
+		finishedAt := j.finishedAt
+		info.ExitCode = &exitCode
+		info.FinishedAt = &finishedAt
+		info.Duration = j.finishedAt.Sub(j.StartedAt).String()
+	}
+	return info
+}
+
+// Output returns up to limit bytes of combined stdout/stderr starting at the
+// absolute offset (negative offsets count back from the end). See
+// RingBuffer.ReadAt for the meaning of the returned offsets.
+func (j *Job) Output(offset int64, limit int) (data []byte, start int64, next int64) {
+	return j.output.ReadAt(offset, limit)
+}
+
+// Done returns a channel that is closed when the job finishes
+func (j *Job) Done() <-chan struct{} {
+	return j.done
+}
+
+// Running reports whether the job's command is still running
+func (j *Job) Running() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.state == JobRunning
+}
+
+// JobRegistry starts and tracks background jobs
+type JobRegistry struct {
+	commander  *Commander
+	maxJobs    int
+	bufferSize int
+	mu         sync.Mutex
+	jobs       map[string]*Job
+	nextID     int
+}
+
+// NewJobRegistry creates a registry that runs jobs with the given Commander.
+// It tracks at most maxJobs jobs, discarding the oldest finished jobs to make
+// room, and keeps the last bufferSize bytes of output for each job.
+func NewJobRegistry(c *Commander, maxJobs, bufferSize int) *JobRegistry {
+	if maxJobs <= 0 {
+		maxJobs = DefaultMaxJobs
+	}
+	if bufferSize <= 0 {
+		bufferSize = DefaultJobBufferSize
+	}
+	return &JobRegistry{
+		commander:  c,
+		maxJobs:    maxJobs,
+		bufferSize: bufferSize,
+		jobs:       make(map[string]*Job),
+	}
+}
+
+// Start launches command in the background on behalf of owner, the
+// caller's identity or "" for none. A zero timeout lets the job run until it
+// exits or is killed. The command is not validated; callers should run it
+// through ValidateCommand first.
+func (r *JobRegistry) Start(command, workDir string, timeout time.Duration, env map[string]string, owner string) (*Job, error) {
+	return r.start(command, r.commander.shellArgv(command), workDir, timeout, env, owner)
+}
+
+// StartArgv launches a program directly, without a shell, like
+// Commander.ExecuteArgv. The argv is not validated; callers should run it
+// through ValidateArgv first.
+func (r *JobRegistry) StartArgv(argv []string, workDir string, timeout time.Duration, env map[string]string, owner string) (*Job, error) {
+	resolved, err := r.commander.resolveArgv(argv, workDir)
+	if err != nil {
+		return nil, err
+	}
+	return r.start(FormatArgv(argv), resolved, workDir, timeout, env, owner)
+}
+
+func (r *JobRegistry) start(command string, argv []string, workDir string, timeout time.Duration, env map[string]string, owner string) (*Job, error) {
+	r.mu.Lock()
+	if len(r.jobs) >= r.maxJobs {
+		r.pruneLocked()
+	}
+	if len(r.jobs) >= r.maxJobs {
+		r.mu.Unlock()
+		return nil, fmt.Errorf("too many jobs: limit of %d reached", r.maxJobs)
+	}
+	r.nextID++
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &Job{
+		ID:        fmt.Sprintf("job-%d", r.nextID),
+		Owner:     owner,
+		Command:   command,
+		WorkDir:   workDir,
+		StartedAt: time.Now(),
+		output:    NewRingBuffer(r.bufferSize),
+		cancel:    cancel,
+		done:      make(chan struct{}),
+		state:     JobRunning,
+	}
+	r.jobs[job.ID] = job
+	r.mu.Unlock()
+
+	if timeout <= 0 {
+		timeout = -1
+	}
+
+	go func() {
+		defer close(job.done)
+		defer cancel()
+
+		result := r.commander.execute(ctx, argv, workDir, timeout, env, func(stream string, data []byte) {
+			job.output.Write(data)
+		}, false)
+
+		job.mu.Lock()
+		defer job.mu.Unlock()
+		job.exitCode = result.ExitCode
+		job.err = result.Error
+		job.violations = result.SandboxViolations
+		job.limits = result.LimitsExceeded
+		job.hints = result.Hints
+		job.forced = result.ForcedKill
+		job.finishedAt = job.StartedAt.Add(result.Duration)
+		switch {
+		case result.Cancelled:
+			job.state = JobKilled
+		case result.TimedOut:
+			job.state = JobTimedOut
+		case result.ExitCode == -1:
+			job.state = JobFailed
+		default:
+			job.state = JobExited
+		}
+	}()
+
+	return job, nil
+}
+
+// Get returns the job with the given id if owner started it. Jobs of other
+// owners are reported as not found.
+func (r *JobRegistry) Get(id, owner string) (*Job, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	job, ok := r.jobs[id]
+	if !ok || job.Owner != owner {
+		return nil, false
+	}
+	return job, true
+}
+
+// List returns snapshots of the tracked jobs owner started, oldest first
+func (r *JobRegistry) List(owner string) []JobInfo {
+	r.mu.Lock()
+	jobs := make([]*Job, 0, len(r.jobs))
+	for _, job := range r.jobs {
+		if job.Owner == owner {
+			jobs = append(jobs, job)
+		}
+	}
+	r.mu.Unlock()
+
+	sort.Slice(jobs, func(i, k int) bool {
+		return jobs[i].StartedAt.Before(jobs[k].StartedAt)
+	})
+
+	infos := make([]JobInfo, 0, len(jobs))
+	for _, job := range jobs {
+		infos = append(infos, job.Info())
+	}
+	return infos
+}
+
+// Kill stops a running job, sending SIGTERM to its whole process group and
+// SIGKILL if it has not exited after the grace period, and waits for it to
+// finish. Killing a job that already finished is not an error. Only the
+// job's owner can kill it.
+func (r *JobRegistry) Kill(id, owner string) (*Job, error) {
+	job, ok := r.Get(id, owner)
+	if !ok {
+		return nil, fmt.Errorf("job not found: %s", id)
+	}
+	job.cancel()
+	<-job.done
+	return job, nil
+}
+
+// KillAll stops every running job
+func (r *JobRegistry) KillAll() {
+	r.mu.Lock()
+	jobs := make([]*Job, 0, len(r.jobs))
+	for _, job := range r.jobs {
+		jobs = append(jobs, job)
+	}
+	r.mu.Unlock()
+
+	for _, job := range jobs {
+		job.cancel()
+	}
+	for _, job := range jobs {
+		<-job.done
+	}
+}
+
+// pruneLocked discards the oldest finished job. r.mu must be held.
+func (r *JobRegistry) pruneLocked() {
+	var oldest *Job
+	for _, job := range r.jobs {
+		if job.Running() {
+			continue
+		}
+		if oldest == nil || job.StartedAt.Before(oldest.StartedAt) {
+			oldest = job
+		}
+	}
+	if oldest != nil {
+		delete(r.jobs, oldest.ID)
+	}
+}
@@ -0,0 +1,144 @@
+package commander
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJobRegistry_StartAndWait(t *testing.T) {
+	registry := NewJobRegistry(NewCommander(Config{}), 0, 0)
+
+	job, err := registry.Start("echo job output", "", 0, nil, "")
+	if err != nil {
+		t.Fatalf("Start returned error: %v", err)
+	}
+
+	select {
+	case <-job.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Job did not finish")
+	}
+
+	info := job.Info()
+	if info.State != JobExited {
+		t.Errorf("Expected state %s, got %s", JobExited, info.State)
+	}
+	if info.ExitCode == nil || *info.ExitCode != 0 {
+		t.Errorf("Expected exit code 0, got %v", info.ExitCode)
+	}
+	if info.FinishedAt == nil {
+		t.Error("Expected finished_at to be set")
+	}
+
+	data, _, _ := job.Output(0, 0)
+	if !strings.Contains(string(data), "job output") {
+		t.Errorf("Expected job output, got %q", data)
+	}
+
+	if got, ok := registry.Get(job.ID, ""); !ok || got != job {
+		t.Error("Expected job to be retrievable by id")
+	}
+	if jobs := registry.List(""); len(jobs) != 1 || jobs[0].ID != job.ID {
+		t.Errorf("Expected list with one job, got %+v", jobs)
+	}
+}
+
+func TestJobRegistry_Kill(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping kill test on Windows - child process termination behaves differently")
+	}
+
+	registry := NewJobRegistry(NewCommander(Config{}), 0, 0)
+
+	job, err := registry.Start("sleep 10", "", 0, nil, "")
+	if err != nil {
+		t.Fatalf("Start returned error: %v", err)
+	}
+	if !job.Running() {
+		t.Error("Expected job to be running")
+	}
+
+	if _, err := registry.Kill(job.ID, ""); err != nil {
+		t.Fatalf("Kill returned error: %v", err)
+	}
+	if info := job.Info(); info.State != JobKilled {
+		t.Errorf("Expected state %s, got %s", JobKilled, info.State)
+	}
+
+	if _, err := registry.Kill("job-unknown", ""); err == nil {
+		t.Error("Expected error killing unknown job")
+	}
+}
+
+func TestJobRegistry_Timeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping timeout test on Windows - child process termination behaves differently")
+	}
+
+	registry := NewJobRegistry(NewCommander(Config{}), 0, 0)
+
+	job, err := registry.Start("sleep 10", "", 200*time.Millisecond, nil, "")
+	if err != nil {
+		t.Fatalf("Start returned error: %v", err)
+	}
+
+	select {
+	case <-job.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Job did not time out")
+	}
+	if info := job.Info(); info.State != JobTimedOut {
+		t.Errorf("Expected state %s, got %s", JobTimedOut, info.State)
+	}
+}
+
+func TestJobRegistry_Limit(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping limit test on Windows - child process termination behaves differently")
+	}
+
+	registry := NewJobRegistry(NewCommander(Config{}), 1, 0)
+	defer registry.KillAll()
+
+	if _, err := registry.Start("sleep 10", "", 0, nil, ""); err != nil {
+		t.Fatalf("Start returned error: %v", err)
+	}
+	if _, err := registry.Start("sleep 10", "", 0, nil, ""); err == nil {
+		t.Error("Expected error when job limit is reached")
+	}
+}
+
+func TestJobRegistry_Owner(t *testing.T) {
+	registry := NewJobRegistry(NewCommander(Config{}), 0, 0)
+	defer registry.KillAll()
+
+	job, err := registry.Start("echo owned", "", 0, nil, "alice")
+	if err != nil {
+		t.Fatalf("Start returned error: %v", err)
+	}
+	if info := job.Info(); info.Owner != "alice" {
+		t.Errorf("Expected owner alice, got %q", info.Owner)
+	}
+
+	// Other identities cannot see the job
+	for _, owner := range []string{"bob", ""} {
+		if _, ok := registry.Get(job.ID, owner); ok {
+			t.Errorf("Expected the job to be hidden from %q", owner)
+		}
+		if jobs := registry.List(owner); len(jobs) != 0 {
+			t.Errorf("Expected no jobs listed for %q, got %+v", owner, jobs)
+		}
+		if _, err := registry.Kill(job.ID, owner); err == nil || !strings.Contains(err.Error(), "not found") {
+			t.Errorf("Expected killing another identity's job to fail as not found, got %v", err)
+		}
+	}
+
+	if _, ok := registry.Get(job.ID, "alice"); !ok {
+		t.Error("Expected the owner to find the job")
+	}
+	if jobs := registry.List("alice"); len(jobs) != 1 {
+		t.Errorf("Expected the owner's job to be listed, got %+v", jobs)
+	}
+}
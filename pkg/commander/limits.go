@@ -0,0 +1,175 @@
+package commander
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// ResourceLimits restricts the resources each command may use. Zero fields
+// are unlimited.
+//
+// Memory, CPU time, process and file size limits are enforced on Unix by the
+// command helper with setrlimit before it executes the command. When
+// CgroupParent names a cgroup v2 directory on Linux, every command instead
+// runs in its own child cgroup that enforces the memory and process limits
+// for the whole process tree, and reports when they are hit.
+type ResourceLimits struct {
+	// MemoryBytes limits memory use: the cgroup's memory.max, or the
+	// address space size (RLIMIT_AS) of each process without a cgroup
+	MemoryBytes int64 `json:"memory_bytes,omitempty"`
+	// CPUSeconds limits the CPU time of each process (RLIMIT_CPU)
+	CPUSeconds int `json:"cpu_seconds,omitempty"`
+	// MaxProcesses limits the number of processes: the cgroup's pids.max,
+	// or RLIMIT_NPROC without a cgroup, which counts every process of the
+	// user and does not apply to root
+	MaxProcesses int `json:"max_processes,omitempty"`
+	// FileSizeBytes limits the size of files a command writes (RLIMIT_FSIZE)
+	FileSizeBytes int64 `json:"file_size_bytes,omitempty"`
+	// OutputBytes caps the captured stdout and stderr of a command, each.
+	// Zero means DefaultMaxOutputBytes and a negative value disables the cap.
+	OutputBytes int `json:"output_bytes,omitempty"`
+	// CgroupParent is a cgroup v2 directory in which a child cgroup is
+	// created for each command. The server must be able to write to it.
+	CgroupParent string `json:"cgroup_parent,omitempty"`
+}
+
+// DefaultMaxOutputBytes is the default cap on the captured output of each
+// stream of a command
+const DefaultMaxOutputBytes = 10 << 20
+
+// Names of limits reported in Result.LimitsExceeded
+const (
+	LimitMemory    = "memory"
+	LimitCPU       = "cpu"
+	LimitProcesses = "processes"
+	LimitFileSize  = "file_size"
+	LimitOutput    = "output"
+)
+
+// CheckLimits verifies that the limits are valid and can be enforced on
+// this system. With a cgroup parent it enables the controllers needed.
+func CheckLimits(limits *ResourceLimits) error {
+	if limits == nil {
+		return nil
+	}
+	if limits.MemoryBytes < 0 || limits.CPUSeconds < 0 || limits.MaxProcesses < 0 || limits.FileSizeBytes < 0 {
+		return fmt.Errorf("resource limits must not be negative")
+	}
+	if limits.processLimited() && runtime.GOOS == "windows" {
+		return fmt.Errorf("resource limits: only the output limit is supported on windows")
+	}
+	if limits.usesCgroup() {
+		if err := checkCgroupParent(limits); err != nil {
+			return fmt.Errorf("resource limits: %w", err)
+		}
+		return nil
+	}
+	if limits.MaxProcesses > 0 && rlimitNproc == 0 {
+		return fmt.Errorf("resource limits: process limits are not supported on %s", runtime.GOOS)
+	}
+	return nil
+}
+
+// outputLimit returns the capture cap per stream, or 0 for none
+func (l *ResourceLimits) outputLimit() int {
+	if l == nil || l.OutputBytes == 0 {
+		return DefaultMaxOutputBytes
+	}
+	if l.OutputBytes < 0 {
+		return 0
+	}
+	return l.OutputBytes
+}
+
+// processLimited reports whether any limit applies to the command's
+// processes rather than to its captured output
+func (l *ResourceLimits) processLimited() bool {
+	return l != nil && (l.MemoryBytes > 0 || l.CPUSeconds > 0 || l.MaxProcesses > 0 || l.FileSizeBytes > 0)
+}
+
+// usesCgroup reports whether commands run in a cgroup
+func (l *ResourceLimits) usesCgroup() bool {
+	return l != nil && l.CgroupParent != "" && (l.MemoryBytes > 0 || l.MaxProcesses > 0)
+}
+
+// rlimited reports whether the helper has to set rlimits. cgroup is true if
+// the cgroup enforces the memory and process limits.
+func (l *ResourceLimits) rlimited(cgroup bool) bool {
+	if l == nil {
+		return false
+	}
+	if l.CPUSeconds > 0 || l.FileSizeBytes > 0 {
+		return true
+	}
+	return !cgroup && (l.MemoryBytes > 0 || l.MaxProcesses > 0)
+}
+
+// withoutCPU returns a copy of the limits without the CPU time limit, for
+// long-lived shell sessions
+func (l *ResourceLimits) withoutCPU() *ResourceLimits {
+	if l == nil {
+		return nil
+	}
+	copied := *l
+	copied.CPUSeconds = 0
+	return &copied
+}
+
+// byteSuffixes are the multipliers accepted by ParseByteSize
+var byteSuffixes = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"k", 1 << 10},
+	{"m", 1 << 20},
+	{"g", 1 << 30},
+	{"t", 1 << 40},
+}
+
+// ParseByteSize parses a size such as "4096", "512K", "256MiB" or "1G".
+// Suffixes are binary: K is 1024 bytes.
+func ParseByteSize(s string) (int64, error) {
+	value := strings.ToLower(strings.TrimSpace(s))
+	value = strings.TrimSuffix(value, "b")
+	value = strings.TrimSuffix(value, "i")
+
+	multiplier := int64(1)
+	for _, suffix := range byteSuffixes {
+		if strings.HasSuffix(value, suffix.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, suffix.suffix))
+			multiplier = suffix.multiplier
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size: %q", s)
+	}
+	if n > 0 && multiplier > 1<<62/n {
+		return 0, fmt.Errorf("size too large: %q", s)
+	}
+	return n * multiplier, nil
+}
+
+// lineContaining returns the first line of text containing s, trimmed, or ""
+func lineContaining(text, s string) string {
+	for _, line := range strings.Split(text, "\n") {
+		if strings.Contains(line, s) {
+			return strings.TrimSpace(line)
+		}
+	}
+	return ""
+}
+
+// addLimitExceeded records a limit in result once
+func addLimitExceeded(result *Result, limit string) {
+	for _, existing := range result.LimitsExceeded {
+		if existing == limit {
+			return
+		}
+	}
+	result.LimitsExceeded = append(result.LimitsExceeded, limit)
+}
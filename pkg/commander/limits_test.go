@@ -0,0 +1,106 @@
+package commander
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"4096", 4096},
+		{"512K", 512 << 10},
+		{"512kb", 512 << 10},
+		{"256MiB", 256 << 20},
+		{"1G", 1 << 30},
+		{"2t", 2 << 40},
+		{"0", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseByteSize(tt.input)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got != tt.expected {
+				t.Errorf("ParseByteSize(%q) = %d, expected %d", tt.input, got, tt.expected)
+			}
+		})
+	}
+
+	for _, input := range []string{"", "abc", "-1", "1.5G", "1X", "99999999999T"} {
+		if _, err := ParseByteSize(input); err == nil {
+			t.Errorf("Expected error for %q", input)
+		}
+	}
+}
+
+func TestCheckLimits_Negative(t *testing.T) {
+	if err := CheckLimits(&ResourceLimits{MemoryBytes: -1}); err == nil {
+		t.Error("Expected negative limit to be rejected")
+	}
+	if err := CheckLimits(nil); err != nil {
+		t.Errorf("Expected no limits to be valid, got %v", err)
+	}
+}
+
+func TestOutputWriter_Limit(t *testing.T) {
+	var streamed int
+	w := &outputWriter{
+		stream:   "stdout",
+		capture:  true,
+		limit:    10,
+		onOutput: func(stream string, data []byte) { streamed += len(data) },
+	}
+	w.Write([]byte("0123456"))
+	w.Write([]byte("789abcdef"))
+	w.Write([]byte("ghij"))
+
+	if streamed != 20 {
+		t.Errorf("Expected all 20 bytes to be streamed, got %d", streamed)
+	}
+	if w.dropped != 10 {
+		t.Errorf("Expected 10 dropped bytes, got %d", w.dropped)
+	}
+	expected := "0123456789\n[output truncated: 10 bytes omitted]"
+	if w.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, w.String())
+	}
+}
+
+func TestExecute_OutputLimit(t *testing.T) {
+	cmd := NewCommander(Config{Limits: &ResourceLimits{OutputBytes: 100}})
+
+	result := cmd.Execute(context.Background(), "echo "+strings.Repeat("x", 500), "", 0, nil)
+	if result.ExitCode != 0 {
+		t.Fatalf("Expected exit code 0, got %d: %v", result.ExitCode, result.Error)
+	}
+	if !result.StdoutTruncated {
+		t.Error("Expected stdout to be marked as truncated")
+	}
+	if result.StderrTruncated {
+		t.Error("Expected stderr not to be truncated")
+	}
+	if !strings.HasPrefix(result.Stdout, strings.Repeat("x", 100)+"\n[output truncated: ") {
+		t.Errorf("Unexpected stdout: %q", result.Stdout)
+	}
+	if len(result.LimitsExceeded) != 1 || result.LimitsExceeded[0] != LimitOutput {
+		t.Errorf("Expected output limit to be reported, got %v", result.LimitsExceeded)
+	}
+}
+
+func TestExecute_DefaultOutputLimit(t *testing.T) {
+	cmd := NewCommander(Config{})
+
+	result := cmd.Execute(context.Background(), "echo hello", "", 0, nil)
+	if result.StdoutTruncated || len(result.LimitsExceeded) != 0 {
+		t.Errorf("Expected short output not to be truncated: %+v", result)
+	}
+	if NewCommander(Config{}).GetLimits().outputLimit() != DefaultMaxOutputBytes {
+		t.Error("Expected the default output limit without configured limits")
+	}
+}
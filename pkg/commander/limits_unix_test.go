@@ -0,0 +1,144 @@
+//go:build !windows
+
+package commander
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func hasLimit(result *Result, limit string) bool {
+	for _, exceeded := range result.LimitsExceeded {
+		if exceeded == limit {
+			return true
+		}
+	}
+	return false
+}
+
+func TestExecute_CPULimit(t *testing.T) {
+	cmd := NewCommander(Config{Limits: &ResourceLimits{CPUSeconds: 1}})
+
+	start := time.Now()
+	result := cmd.Execute(context.Background(), "while :; do :; done", "", 20*time.Second, nil)
+	if result.TimedOut {
+		t.Fatalf("Expected CPU limit to stop the command before the timeout (after %s)", time.Since(start))
+	}
+	if !hasLimit(result, LimitCPU) {
+		t.Errorf("Expected CPU limit to be reported, got %v (exit code %d, %v)", result.LimitsExceeded, result.ExitCode, result.Error)
+	}
+}
+
+func TestExecute_FileSizeLimit(t *testing.T) {
+	dir := t.TempDir()
+	cmd := NewCommander(Config{Limits: &ResourceLimits{FileSizeBytes: 4096}})
+
+	target := filepath.Join(dir, "big")
+	result := cmd.Execute(context.Background(), "head -c 100000 /dev/zero > "+target, "", 0, nil)
+	if result.ExitCode == 0 {
+		t.Fatal("Expected writing past the file size limit to fail")
+	}
+	if !hasLimit(result, LimitFileSize) {
+		t.Errorf("Expected file size limit to be reported, got %v (exit code %d, stderr %q)", result.LimitsExceeded, result.ExitCode, result.Stderr)
+	}
+	if info, err := os.Stat(target); err == nil && info.Size() > 4096 {
+		t.Errorf("Expected file to stay within the limit, got %d bytes", info.Size())
+	}
+
+	result = cmd.Execute(context.Background(), "head -c 1000 /dev/zero > "+target, "", 0, nil)
+	if result.ExitCode != 0 || len(result.LimitsExceeded) != 0 {
+		t.Errorf("Expected small write to succeed, got %d %v: %s", result.ExitCode, result.LimitsExceeded, result.Stderr)
+	}
+}
+
+func TestExecute_HelperReport(t *testing.T) {
+	cmd := NewCommander(Config{Limits: &ResourceLimits{FileSizeBytes: 1 << 20}})
+
+	// Output that looks like a helper error is the command's own
+	result := cmd.Execute(context.Background(), "echo 'resource limits: not really' >&2; exit 125", "", 0, nil)
+	if result.ExitCode != 125 {
+		t.Errorf("Expected the command's exit code 125, got %d (%v)", result.ExitCode, result.Error)
+	}
+	if !strings.Contains(result.Stderr, "resource limits: not really") {
+		t.Errorf("Expected the command's stderr to be kept, got %q", result.Stderr)
+	}
+
+	// The command does not inherit the report pipe
+	result = cmd.Execute(context.Background(), "if [ -e /dev/fd/3 ]; then echo inherited; fi", "", 0, nil)
+	if result.ExitCode != 0 || strings.Contains(result.Stdout, "inherited") {
+		t.Errorf("Expected the report pipe to be closed for the command, got %d %q", result.ExitCode, result.Stdout)
+	}
+
+	// A real setup failure is reported as the command's error
+	missing := NewCommander(Config{Shell: "/nonexistent/sh", ShellArg: "-c", Limits: &ResourceLimits{FileSizeBytes: 1 << 20}})
+	result = missing.Execute(context.Background(), "true", "", 0, nil)
+	if result.ExitCode != -1 || result.Error == nil || !strings.HasPrefix(result.Error.Error(), "resource limits: exec /nonexistent/sh") {
+		t.Errorf("Expected the helper's setup error, got %d %v", result.ExitCode, result.Error)
+	}
+}
+
+func TestExecute_LimitsWithArgv(t *testing.T) {
+	cmd := NewCommander(Config{Limits: &ResourceLimits{FileSizeBytes: 1 << 20}})
+
+	result := cmd.ExecuteArgv(context.Background(), []string{"echo", "limited"}, "", 0, nil, nil)
+	if result.ExitCode != 0 {
+		t.Fatalf("Expected exit code 0, got %d: %v %s", result.ExitCode, result.Error, result.Stderr)
+	}
+	if result.Stdout != "limited\n" {
+		t.Errorf("Expected 'limited', got %q", result.Stdout)
+	}
+}
+
+func TestExecute_CgroupLimits(t *testing.T) {
+	parent := os.Getenv("COMMANDER_TEST_CGROUP")
+	if parent == "" {
+		t.Skip("COMMANDER_TEST_CGROUP not set to a writable cgroup v2 directory")
+	}
+	limits := &ResourceLimits{MaxProcesses: 8, MemoryBytes: 64 << 20, CgroupParent: parent}
+	if err := CheckLimits(limits); err != nil {
+		t.Skipf("cgroup not usable: %v", err)
+	}
+	cmd := NewCommander(Config{Limits: limits})
+
+	result := cmd.Execute(context.Background(), "for i in $(seq 20); do sleep 1 & done; wait", "", 10*time.Second, nil)
+	if !hasLimit(result, LimitProcesses) {
+		t.Errorf("Expected process limit to be reported, got %v: %s", result.LimitsExceeded, result.Stderr)
+	}
+
+	entries, _ := os.ReadDir(parent)
+	for _, entry := range entries {
+		if entry.IsDir() && strings.HasPrefix(entry.Name(), "cmd-") {
+			t.Errorf("Expected command cgroup %s to be removed", entry.Name())
+		}
+	}
+}
+
+func TestInspectLimitResult_Hints(t *testing.T) {
+	limits := &ResourceLimits{MemoryBytes: 64 << 20, MaxProcesses: 8, CPUSeconds: 1}
+
+	// Error output alone is not proof that a limit was hit
+	result := &Result{ExitCode: 1, Stderr: "loading\npython: Cannot allocate memory\n"}
+	inspectLimitResult(result, nil, limits, false)
+	if len(result.LimitsExceeded) != 0 {
+		t.Errorf("Expected no limits from error output, got %v", result.LimitsExceeded)
+	}
+	if len(result.Hints) != 1 || !strings.Contains(result.Hints[0], "memory limit: python: Cannot allocate memory") {
+		t.Errorf("Expected a memory hint, got %q", result.Hints)
+	}
+
+	result = &Result{ExitCode: 2, Stderr: "CPU time limit exceeded"}
+	inspectLimitResult(result, nil, limits, false)
+	if len(result.LimitsExceeded) != 0 || len(result.Hints) != 0 {
+		t.Errorf("Expected a message without a signal to be ignored, got %v %q", result.LimitsExceeded, result.Hints)
+	}
+
+	result = &Result{ExitCode: cpuLimitShellStatus}
+	inspectLimitResult(result, nil, limits, false)
+	if !hasLimit(result, LimitCPU) {
+		t.Errorf("Expected the shell status for SIGXCPU to report the CPU limit, got %v", result.LimitsExceeded)
+	}
+}
@@ -0,0 +1,698 @@
+package commander
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// PolicyDecision explains whether a command is permitted and, segment by
+// segment, why
+type PolicyDecision struct {
+	Command  string            `json:"command"`
+	Allowed  bool              `json:"allowed"`
+	Segments []SegmentDecision `json:"segments"`
+}
+
+// SegmentDecision is the verdict for one simple command within a command line
+type SegmentDecision struct {
+	// Segment is the source text of the simple command
+	Segment string `json:"segment"`
+	// Program is the command name, if any
+	Program string `json:"program,omitempty"`
+	// Context describes where the segment appeared, e.g. "subshell"
+	Context string `json:"context,omitempty"`
+	Allowed bool   `json:"allowed"`
+	// Rule is the pattern or policy rule that decided the verdict, if any
+	Rule   string `json:"rule,omitempty"`
+	Reason string `json:"reason"`
+	// Timeout is the run time limit set by the policy rule that allowed the
+	// segment, if any
+	Timeout string `json:"timeout,omitempty"`
+
+	timeout time.Duration
+}
+
+// Denied returns the segments that were not allowed
+func (d *PolicyDecision) Denied() []SegmentDecision {
+	var denied []SegmentDecision
+	for _, seg := range d.Segments {
+		if !seg.Allowed {
+			denied = append(denied, seg)
+		}
+	}
+	return denied
+}
+
+// Timeout returns the tightest run time limit set by the policy rules that
+// allowed the command, or zero if there is none
+func (d *PolicyDecision) Timeout() time.Duration {
+	var limit time.Duration
+	for _, seg := range d.Segments {
+		if seg.timeout > 0 && (limit == 0 || seg.timeout < limit) {
+			limit = seg.timeout
+		}
+	}
+	return limit
+}
+
+// PolicyError is returned by ValidateCommand when a command is denied
+type PolicyError struct {
+	Decision *PolicyDecision
+}
+
+func (e *PolicyError) Error() string {
+	denied := e.Decision.Denied()
+	if len(denied) == 0 {
+		return "command not allowed"
+	}
+	return denied[0].Reason
+}
+
+// Reasons used in segment decisions
+const (
+	reasonAllowed = "allowed"
+)
+
+// CheckCommand evaluates command against the configured policy. Rather than
+// matching the raw string, the command line is parsed with the shell's syntax
+// and every simple command it contains (across pipelines, lists, subshells,
+// substitutions and nested "sh -c" scripts) is checked on its own:
+//
+//  1. A segment is denied if it matches a deny rule of the policy file.
+//  2. Otherwise it is denied if it matches any blocked pattern.
+//  3. Otherwise it is allowed if it matches an allow rule of the policy file
+//     or an allowed pattern.
+//  4. Otherwise it is denied if allowed patterns are configured or the
+//     policy denies by default, and allowed if not.
+//
+// When the allow side applies, the program name must be known statically,
+// and a shell may only be run with a -c script, which is checked too.
+// Commands that cannot be parsed are denied.
+func (c *Commander) CheckCommand(command string) *PolicyDecision {
+	return c.CheckCommandIn(command, "")
+}
+
+// CheckCommandIn is like CheckCommand for a command that will run in workDir,
+// against which relative path arguments are resolved for policy path rules
+func (c *Commander) CheckCommandIn(command, workDir string) *PolicyDecision {
+	decision := &PolicyDecision{Command: command, Allowed: true}
+	trimmed := strings.TrimSpace(command)
+
+	// Patterns made of shell syntax (e.g. fork bombs) cannot be expressed as
+	// simple commands and are matched against the whole command line
+	compact := compactShell(strings.ToLower(trimmed))
+	for _, blocked := range c.config.BlockedCommands {
+		pattern := strings.TrimSpace(blocked)
+		if isSyntaxPattern(pattern) && strings.Contains(compact, compactShell(strings.ToLower(pattern))) {
+			decision.deny(SegmentDecision{
+				Segment: trimmed,
+				Rule:    blocked,
+				Reason:  fmt.Sprintf("command blocked: '%s' matches blocked pattern '%s'", trimmed, blocked),
+			})
+			return decision
+		}
+	}
+
+	if c.config.ArgvOnly {
+		decision.deny(SegmentDecision{
+			Segment: trimmed,
+			Reason:  "command not allowed: shell commands are disabled; pass the program and its arguments as argv",
+		})
+		return decision
+	}
+
+	commands, err := c.parseCommand(trimmed)
+	if err != nil {
+		decision.deny(SegmentDecision{
+			Segment: trimmed,
+			Reason:  fmt.Sprintf("command not allowed: unable to parse command: %s", err.Error()),
+		})
+		return decision
+	}
+
+	for _, sc := range commands {
+		seg := c.checkSimpleCommand(sc, workDir)
+		decision.Segments = append(decision.Segments, seg)
+		if !seg.Allowed {
+			decision.Allowed = false
+		}
+	}
+	return decision
+}
+
+// CheckArgv evaluates a program and its arguments, as run by ExecuteArgv,
+// against the configured policy. argv[0] is resolved through PATH first, so
+// allowed and blocked patterns containing a path are compared with the
+// program that will actually run. Arguments are matched as literal words;
+// scripts passed to a shell with -c are still parsed and checked.
+func (c *Commander) CheckArgv(argv []string, workDir string) *PolicyDecision {
+	text := FormatArgv(argv)
+	decision := &PolicyDecision{Command: text, Allowed: true}
+
+	resolved, err := c.resolveArgv(argv, workDir)
+	if err != nil {
+		decision.deny(SegmentDecision{
+			Segment: text,
+			Reason:  fmt.Sprintf("command not allowed: %s", err.Error()),
+		})
+		return decision
+	}
+
+	sc := SimpleCommand{Text: text}
+	for _, arg := range resolved {
+		sc.Args = append(sc.Args, Word{Value: arg, Quoted: true})
+	}
+	commands, err := expandNestedScripts([]SimpleCommand{sc}, 0)
+	if err != nil {
+		decision.deny(SegmentDecision{
+			Segment: text,
+			Reason:  fmt.Sprintf("command not allowed: unable to parse command: %s", err.Error()),
+		})
+		return decision
+	}
+
+	for _, sc := range commands {
+		seg := c.checkSimpleCommand(sc, workDir)
+		decision.Segments = append(decision.Segments, seg)
+		if !seg.Allowed {
+			decision.Allowed = false
+		}
+	}
+	return decision
+}
+
+// FormatArgv renders argv as a shell-quoted command line for display
+func FormatArgv(argv []string) string {
+	quoted := make([]string, len(argv))
+	for i, arg := range argv {
+		if arg != "" && !strings.ContainsAny(arg, " \t\n'\"\\$`|&;<>()*?[]{}~#!") {
+			quoted[i] = arg
+			continue
+		}
+		quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
+
+func (d *PolicyDecision) deny(seg SegmentDecision) {
+	seg.Allowed = false
+	d.Allowed = false
+	d.Segments = append(d.Segments, seg)
+}
+
+// directoryCommands change the current directory of the shell running them
+var directoryCommands = map[string]bool{"cd": true, "pushd": true, "popd": true, "chdir": true}
+
+// changesDirectory reports whether a command line changes the current
+// directory of the shell, including through wrappers such as builtin or
+// nested eval scripts. Lines that cannot be parsed are reported as not
+// changing it; CheckCommand denies them anyway.
+func (c *Commander) changesDirectory(command string) bool {
+	commands, err := c.parseCommand(command)
+	if err != nil {
+		return false
+	}
+	for _, sc := range commands {
+		args := unwrapCommand(lowerAll(wordValues(sc.Args)))
+		if len(args) > 0 && directoryCommands[programName(args[0])] {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCommand parses a command line with the dialect of the configured
+// shell, expanding nested "sh -c" and eval scripts
+func (c *Commander) parseCommand(command string) ([]SimpleCommand, error) {
+	var commands []SimpleCommand
+	var err error
+	if isCmdShell(c.config.Shell) {
+		commands, err = ParseCmd(command)
+	} else {
+		commands, err = ParseShell(command)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return expandNestedScripts(commands, 0)
+}
+
+// maxNestedScripts bounds recursion through "sh -c" and eval
+const maxNestedScripts = 8
+
+// expandNestedScripts appends the commands of scripts passed to shells
+// ("bash -c '...'") or eval, so that they are checked too
+func expandNestedScripts(commands []SimpleCommand, depth int) ([]SimpleCommand, error) {
+	if depth > maxNestedScripts {
+		return nil, fmt.Errorf("scripts nested too deeply")
+	}
+
+	var result []SimpleCommand
+	for _, sc := range commands {
+		result = append(result, sc)
+
+		args := unwrapCommand(wordValues(sc.Args))
+		if len(args) == 0 {
+			continue
+		}
+
+		var script string
+		name := programName(args[0])
+		switch {
+		case name == "eval":
+			script = strings.Join(args[1:], " ")
+		case isPosixShell(name):
+			script, _ = shellScript(args[1:])
+		}
+		if script == "" {
+			continue
+		}
+
+		nested, err := ParseShell(script)
+		if err != nil {
+			return nil, fmt.Errorf("in script passed to %s: %w", name, err)
+		}
+		for i := range nested {
+			nested[i].Context = name + " script"
+		}
+		nested, err = expandNestedScripts(nested, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, nested...)
+	}
+	return result, nil
+}
+
+// shellValueOptions are shell options that consume the following word
+var shellValueOptions = map[string]bool{
+	"-o": true, "+o": true, "-O": true, "+O": true, "--rcfile": true, "--init-file": true,
+}
+
+// shellScript finds the script a shell runs with -c, given the shell's
+// arguments. Like the shell, it takes the first operand after the options
+// once -c is among them, skipping the values of options such as -o.
+func shellScript(args []string) (string, bool) {
+	command := false
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "-" || arg == "--" {
+			if command && i+1 < len(args) {
+				return args[i+1], true
+			}
+			return "", false
+		}
+		if len(arg) < 2 || (arg[0] != '-' && arg[0] != '+') {
+			if command {
+				return arg, true
+			}
+			return "", false
+		}
+		if strings.HasPrefix(arg, "--") {
+			if shellValueOptions[arg] {
+				i++
+			}
+			continue
+		}
+		for _, r := range arg[1:] {
+			switch r {
+			case 'c':
+				command = command || arg[0] == '-'
+			case 'o', 'O':
+				i++
+			}
+		}
+	}
+	return "", false
+}
+
+// checkSimpleCommand applies the policy rules and the allow and block lists
+// to one simple command
+func (c *Commander) checkSimpleCommand(sc SimpleCommand, workDir string) SegmentDecision {
+	seg := SegmentDecision{
+		Segment: sc.Text,
+		Program: sc.Name(),
+		Context: sc.Context,
+		Allowed: true,
+		Reason:  reasonAllowed,
+	}
+
+	original := wordValues(sc.Args)
+	args := lowerAll(original)
+	inner := unwrapCommand(args)
+	innerWords := sc.Args[len(sc.Args)-len(inner):]
+	redirects := redirectWords(sc.Redirects)
+	policy := c.config.Policy
+
+	if policy != nil {
+		for i := range policy.Rules {
+			rule := &policy.Rules[i]
+			if rule.Action != ActionDeny {
+				continue
+			}
+			if rule.matches(sc.Text, sc.Args, args, sc.Redirects, workDir) || rule.matches(sc.Text, innerWords, inner, sc.Redirects, workDir) {
+				seg.Allowed = false
+				seg.Rule = rule.id()
+				seg.Reason = fmt.Sprintf("command blocked: '%s' matches policy %s", sc.Text, rule.label())
+				if rule.Reason != "" {
+					seg.Reason += ": " + rule.Reason
+				}
+				return seg
+			}
+		}
+	}
+
+	for _, blocked := range c.config.BlockedCommands {
+		pattern := strings.TrimSpace(blocked)
+		if pattern == "" || isSyntaxPattern(pattern) {
+			continue
+		}
+		words := splitPattern(strings.ToLower(pattern))
+		if matchesBlocked(words, args) || matchesBlocked(words, inner) || matchesRedirect(words, redirects) {
+			seg.Allowed = false
+			seg.Rule = blocked
+			seg.Reason = fmt.Sprintf("command blocked: '%s' matches blocked pattern '%s'", sc.Text, blocked)
+			return seg
+		}
+	}
+
+	if len(args) == 0 {
+		return seg
+	}
+
+	restricted := len(c.config.AllowedCommands) > 0 || (policy != nil && policy.defaultDeny())
+	if restricted && sc.Args[0].Dynamic {
+		seg.Allowed = false
+		seg.Reason = fmt.Sprintf("command not allowed: '%s' - program name is computed at runtime and cannot be checked against the allowed commands list", sc.Text)
+		return seg
+	}
+
+	rule, timeout, ok := c.matchAllowed(sc.Text, sc.Args, args, sc.Redirects, workDir)
+	if ok && len(inner) > 0 && len(inner) != len(args) {
+		// A wrapper such as sudo, env or xargs is allowed; the program it
+		// runs must be allowed too
+		var innerTimeout time.Duration
+		_, innerTimeout, ok = c.matchAllowed(sc.Text, innerWords, inner, sc.Redirects, workDir)
+		if innerTimeout > 0 && (timeout == 0 || innerTimeout < timeout) {
+			timeout = innerTimeout
+		}
+	}
+	if !ok {
+		if restricted {
+			seg.Allowed = false
+			seg.Reason = fmt.Sprintf("command not allowed: '%s' - not in allowed commands list", sc.Text)
+		}
+		return seg
+	}
+	if restricted && len(inner) > 0 && isPosixShell(programName(inner[0])) {
+		// The shell is allowed, but only a -c script can be checked
+		if _, found := shellScript(wordValues(innerWords[1:])); !found {
+			seg.Allowed = false
+			seg.Reason = fmt.Sprintf("command not allowed: '%s' - the script this shell runs cannot be checked; pass it with -c", sc.Text)
+			return seg
+		}
+	}
+	seg.Rule = rule
+	if timeout > 0 {
+		seg.timeout = timeout
+		seg.Timeout = timeout.String()
+	}
+	return seg
+}
+
+// matchAllowed finds the first policy allow rule or allowed pattern matching
+// a command, returning its label and any timeout limit
+func (c *Commander) matchAllowed(text string, words []Word, args []string, redirects []Redirect, workDir string) (string, time.Duration, bool) {
+	if len(args) == 0 {
+		return "", 0, false
+	}
+	if policy := c.config.Policy; policy != nil {
+		for i := range policy.Rules {
+			rule := &policy.Rules[i]
+			if rule.Action == ActionAllow && rule.matches(text, words, args, redirects, workDir) {
+				return rule.id(), rule.timeout, true
+			}
+		}
+	}
+	for _, allowed := range c.config.AllowedCommands {
+		pattern := strings.TrimSpace(allowed)
+		if pattern == "" {
+			continue
+		}
+		if matchesAllowed(splitPattern(strings.ToLower(pattern)), args) {
+			return allowed, 0, true
+		}
+	}
+	return "", 0, false
+}
+
+// matchesAllowed reports whether args starts with the pattern's words. The
+// program name is compared by base name, so "git" allows "/usr/bin/git".
+func matchesAllowed(pattern, args []string) bool {
+	if len(pattern) == 0 || len(pattern) > len(args) {
+		return false
+	}
+	if !programMatches(pattern[0], args[0], false) {
+		return false
+	}
+	for i := 1; i < len(pattern); i++ {
+		if pattern[i] != args[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesBlocked reports whether args matches a blocked pattern. The program
+// name must match, every flag in the pattern must be present (short flags
+// may be combined or reordered, so "rm -rf" matches "rm -f -r"), and the
+// pattern's remaining words must appear in order, each as a prefix of an
+// argument ("dd if=" matches "dd if=/dev/zero").
+func matchesBlocked(pattern, args []string) bool {
+	if len(pattern) == 0 || len(args) == 0 {
+		return false
+	}
+	if !programMatches(pattern[0], args[0], true) {
+		return false
+	}
+
+	argFlags, argWords := splitFlags(args[1:])
+	patternFlags, patternWords := splitFlags(pattern[1:])
+	for flag := range patternFlags {
+		if !argFlags[flag] {
+			return false
+		}
+	}
+
+	i := 0
+	for _, word := range argWords {
+		if i < len(patternWords) && strings.HasPrefix(word, patternWords[i]) {
+			i++
+		}
+	}
+	return i == len(patternWords)
+}
+
+// matchesRedirect handles blocked patterns that start with a redirection,
+// such as "> /dev/sda"
+func matchesRedirect(pattern []string, redirects [][2]string) bool {
+	if len(pattern) != 2 || !isRedirectOp(pattern[0]) {
+		return false
+	}
+	for _, r := range redirects {
+		if strings.TrimLeft(r[0], "0123456789") == strings.TrimLeft(pattern[0], "0123456789") && strings.HasPrefix(r[1], pattern[1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// programMatches compares a pattern's program name against a command's.
+// Unless the pattern is a path, only base names are compared. With prefix
+// set, the pattern may be a prefix of the name ("mkfs" matches "mkfs.ext4").
+func programMatches(pattern, program string, prefix bool) bool {
+	if !strings.ContainsAny(pattern, `/\`) {
+		program = programName(program)
+	}
+	if prefix {
+		return strings.HasPrefix(program, pattern)
+	}
+	return program == pattern
+}
+
+// programName returns the base name of a program, without any Windows
+// executable extension
+func programName(program string) string {
+	program = path.Base(strings.ReplaceAll(program, `\`, "/"))
+	lower := strings.ToLower(program)
+	for _, ext := range []string{".exe", ".cmd", ".bat", ".com"} {
+		if strings.HasSuffix(lower, ext) {
+			return program[:len(program)-len(ext)]
+		}
+	}
+	return program
+}
+
+// splitFlags separates short and long options from other words. Short option
+// clusters are split into single letters.
+func splitFlags(words []string) (map[string]bool, []string) {
+	flags := make(map[string]bool)
+	var rest []string
+	for _, w := range words {
+		switch {
+		case strings.HasPrefix(w, "--") && len(w) > 2:
+			flags[w] = true
+		case strings.HasPrefix(w, "-") && len(w) > 1:
+			for _, r := range w[1:] {
+				flags["-"+string(r)] = true
+			}
+		default:
+			rest = append(rest, w)
+		}
+	}
+	return flags, rest
+}
+
+// wrapperCommands run another program given as their arguments. The values
+// list options that consume the following word.
+var wrapperCommands = map[string][]string{
+	"sudo":    {"-u", "-g", "-C", "-D", "-h", "-p", "-r", "-t", "-U"},
+	"doas":    {"-u", "-c"},
+	"env":     {"-u", "-c", "-s", "--unset", "--chdir", "--split-string"},
+	"nice":    {"-n", "--adjustment"},
+	"ionice":  {"-c", "-n", "-p"},
+	"nohup":   nil,
+	"time":    {"-f", "-o"},
+	"command": nil,
+	"builtin": nil,
+	"exec":    {"-a"},
+	"setsid":  nil,
+	"stdbuf":  {"-i", "-o", "-e"},
+	"timeout": {"-s", "-k", "--signal", "--kill-after"},
+	"xargs":   {"-a", "-d", "-e", "-i", "-l", "-n", "-p", "-s", "-E", "-I", "-L", "-P"},
+	"watch":   {"-n", "-d", "--interval"},
+	"chroot":  nil,
+	"busybox": nil,
+}
+
+// unwrapCommand strips wrapper programs such as sudo, env and xargs (with
+// their options) to find the program that actually runs. It returns args
+// unchanged if there is no wrapper.
+func unwrapCommand(args []string) []string {
+	for len(args) > 0 {
+		name := strings.ToLower(programName(args[0]))
+		valueOpts, ok := wrapperCommands[name]
+		if !ok {
+			return args
+		}
+
+		i := 1
+		for i < len(args) {
+			arg := args[i]
+			if arg == "--" {
+				i++
+				break
+			}
+			if name == "env" && isAssignment(arg) {
+				i++
+				continue
+			}
+			if !strings.HasPrefix(arg, "-") || arg == "-" {
+				break
+			}
+			i++
+			if containsString(valueOpts, arg) && i < len(args) {
+				i++
+			}
+		}
+		// timeout DURATION COMMAND and chroot DIR COMMAND take a positional
+		// argument before the command
+		if (name == "timeout" || name == "chroot") && i < len(args) {
+			i++
+		}
+		args = args[i:]
+	}
+	return args
+}
+
+func wordValues(words []Word) []string {
+	values := make([]string, len(words))
+	for i, w := range words {
+		values[i] = w.Value
+	}
+	return values
+}
+
+func redirectWords(redirects []Redirect) [][2]string {
+	result := make([][2]string, len(redirects))
+	for i, r := range redirects {
+		result[i] = [2]string{r.Op, strings.ToLower(r.Target.Value)}
+	}
+	return result
+}
+
+func lowerAll(values []string) []string {
+	result := make([]string, len(values))
+	for i, v := range values {
+		result[i] = strings.ToLower(v)
+	}
+	return result
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// splitPattern splits a policy pattern into words, honouring quotes
+func splitPattern(pattern string) []string {
+	commands, err := ParseShell(pattern)
+	if err != nil || len(commands) != 1 {
+		return strings.Fields(pattern)
+	}
+	words := wordValues(commands[0].Args)
+	for _, r := range commands[0].Redirects {
+		words = append(words, r.Op, r.Target.Value)
+	}
+	return words
+}
+
+func isRedirectOp(s string) bool {
+	return containsString(redirectOperators, strings.TrimLeft(s, "0123456789"))
+}
+
+// isSyntaxPattern reports whether a blocked pattern relies on shell syntax
+// (e.g. the fork bomb ":(){:|:&};:") rather than naming a command
+func isSyntaxPattern(pattern string) bool {
+	return strings.ContainsAny(pattern, "(){};|`") || strings.Contains(pattern, "&&")
+}
+
+// compactShell removes whitespace so syntax patterns match regardless of
+// spacing
+func compactShell(s string) string {
+	return strings.Join(strings.Fields(s), "")
+}
+
+func isPosixShell(name string) bool {
+	switch strings.ToLower(name) {
+	case "sh", "bash", "dash", "zsh", "ksh", "ash", "mksh":
+		return true
+	}
+	return false
+}
+
+// isCmdShell reports whether shell is a Windows shell whose syntax is parsed
+// with ParseCmd rather than POSIX rules
+func isCmdShell(shell string) bool {
+	switch strings.ToLower(programName(shell)) {
+	case "cmd", "powershell", "pwsh":
+		return true
+	}
+	return false
+}
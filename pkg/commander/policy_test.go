@@ -0,0 +1,225 @@
+package commander
+
+import (
+	"errors"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestCheckCommand_Allowlist(t *testing.T) {
+	cmd := NewCommander(Config{
+		AllowedCommands: []string{"ls", "echo", "git status", "bash"},
+	})
+
+	tests := []struct {
+		command     string
+		shouldAllow bool
+	}{
+		{"ls -la", true},
+		{"/bin/ls -la", true},
+		{"ls | echo", true},
+		{"git status --short", true},
+		{"git push", false},
+		{"ls; rm -rf ~", false},
+		{"ls && curl http://evil", false},
+		{"ls $(rm -rf ~)", false},
+		{"echo `wget x`", false},
+		{"(ls; rm x)", false},
+		{"bash -c 'ls; rm x'", false},
+		{"bash -c 'ls'", true},
+		{"bash -o errexit -c 'ls'", true},
+		{"bash -e -o pipefail -c 'rm x'", false},
+		{"bash script.sh", false},
+		{"bash", false},
+		{"function f { ls; }", true},
+		{"function f { rm x; }", false},
+		{"coproc rm x", false},
+		{"$CMD arg", false},
+		{"lsblk", false},
+		{"echo 'a; rm -rf /'", true},
+		{"ls > out.txt", true},
+	}
+
+	for _, tt := range tests {
+		err := cmd.ValidateCommand(tt.command)
+		if tt.shouldAllow && err != nil {
+			t.Errorf("Expected command '%s' to be allowed, got error: %v", tt.command, err)
+		}
+		if !tt.shouldAllow && err == nil {
+			t.Errorf("Expected command '%s' to be blocked", tt.command)
+		}
+	}
+}
+
+func TestCheckCommand_Blocklist(t *testing.T) {
+	cmd := NewCommander(Config{
+		BlockedCommands: DefaultBlockedCommands(),
+	})
+
+	tests := []struct {
+		command     string
+		shouldAllow bool
+	}{
+		{"echo shutdown", true},
+		{"grep reboot /var/log/syslog", true},
+		{"rm -rf build", true},
+		{"rm -fr /", false},
+		{"rm -r -f /", false},
+		{"/bin/rm -rf /", false},
+		{"sudo rm -rf /", false},
+		{"ls; shutdown now", false},
+		{"echo $(shutdown -h now)", false},
+		{"sh -c 'reboot'", false},
+		{"bash -o errexit -c 'rm -rf /'", false},
+		{"bash -O extglob -c reboot", false},
+		{"bash +O extglob -c reboot", false},
+		{"bash --rcfile x -c reboot", false},
+		{"bash -eo pipefail -c reboot", false},
+		{"bash -c -e reboot", false},
+		{"busybox sh -c reboot", false},
+		{"busybox reboot", false},
+		{"function f { reboot; }; f", false},
+		{"function f() { shutdown now; }", false},
+		{"coproc reboot", false},
+		{"coproc worker { reboot; }", false},
+		{"bash -o errexit script.sh", true},
+		{"env FOO=1 reboot", false},
+		{"echo x > /dev/sda", false},
+		{":(){ :|:& };:", false},
+		{"rm file.txt", true},
+	}
+
+	for _, tt := range tests {
+		err := cmd.ValidateCommand(tt.command)
+		if tt.shouldAllow && err != nil {
+			t.Errorf("Expected command '%s' to be allowed, got error: %v", tt.command, err)
+		}
+		if !tt.shouldAllow && err == nil {
+			t.Errorf("Expected command '%s' to be blocked", tt.command)
+		}
+	}
+}
+
+func TestCheckCommand_Decision(t *testing.T) {
+	cmd := NewCommander(Config{
+		AllowedCommands: []string{"ls"},
+	})
+
+	decision := cmd.CheckCommand("ls; rm -rf ~")
+	if decision.Allowed {
+		t.Fatal("Expected command to be denied")
+	}
+	if len(decision.Segments) != 2 {
+		t.Fatalf("Expected 2 segments, got %d", len(decision.Segments))
+	}
+	if !decision.Segments[0].Allowed || decision.Segments[0].Rule != "ls" {
+		t.Errorf("Expected 'ls' segment to be allowed by rule 'ls', got %+v", decision.Segments[0])
+	}
+	if decision.Segments[1].Allowed || decision.Segments[1].Program != "rm" {
+		t.Errorf("Expected 'rm' segment to be denied, got %+v", decision.Segments[1])
+	}
+
+	err := cmd.ValidateCommand("ls; rm -rf ~")
+	var policyErr *PolicyError
+	if !errors.As(err, &policyErr) {
+		t.Fatalf("Expected *PolicyError, got %T", err)
+	}
+	if !strings.Contains(err.Error(), "rm -rf ~") {
+		t.Errorf("Expected error to name the denied segment, got %q", err.Error())
+	}
+}
+
+func TestCheckCommand_ParseError(t *testing.T) {
+	cmd := NewCommander(Config{})
+
+	if err := cmd.ValidateCommand("echo 'unterminated"); err == nil {
+		t.Error("Expected unparseable command to be denied")
+	}
+}
+
+func TestCheckCommand_CmdShell(t *testing.T) {
+	cmd := NewCommander(Config{
+		Shell:           "cmd",
+		ShellArg:        "/c",
+		AllowedCommands: []string{"dir"},
+	})
+
+	if err := cmd.ValidateCommand(`dir "C:\Users"`); err != nil {
+		t.Errorf("Expected command to be allowed, got error: %v", err)
+	}
+	if err := cmd.ValidateCommand(`dir & del /q C:\x`); err == nil {
+		t.Error("Expected chained del to be blocked")
+	}
+}
+
+func TestCheckArgv(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping on Windows")
+	}
+
+	cmd := NewCommander(Config{
+		AllowedCommands: []string{"echo", "sh"},
+		BlockedCommands: []string{"rm -rf"},
+	})
+
+	tests := []struct {
+		argv        []string
+		shouldAllow bool
+	}{
+		{[]string{"echo", "hello; rm -rf ~"}, true},
+		{[]string{"echo", "$(reboot)"}, true},
+		{[]string{"ls"}, false},
+		{[]string{"sh", "-c", "echo hi"}, true},
+		{[]string{"sh", "-c", "echo hi; rm -rf ~"}, false},
+		{[]string{"no-such-program-xyz"}, false},
+	}
+
+	for _, tt := range tests {
+		_, err := cmd.ValidateArgv(tt.argv, "", nil)
+		if tt.shouldAllow && err != nil {
+			t.Errorf("Expected argv %q to be allowed, got error: %v", tt.argv, err)
+		}
+		if !tt.shouldAllow && err == nil {
+			t.Errorf("Expected argv %q to be blocked", tt.argv)
+		}
+	}
+}
+
+func TestCheckArgv_ResolvedPath(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping on Windows")
+	}
+
+	echo, err := exec.LookPath("echo")
+	if err != nil {
+		t.Skip("echo not found in PATH")
+	}
+
+	cmd := NewCommander(Config{AllowedCommands: []string{echo}})
+
+	decision := cmd.CheckArgv([]string{"echo", "hi"}, "")
+	if !decision.Allowed {
+		t.Fatalf("Expected echo to match allowed path %s, got %+v", echo, decision.Segments)
+	}
+	if decision.Segments[0].Program != echo {
+		t.Errorf("Expected program to be resolved to %s, got %s", echo, decision.Segments[0].Program)
+	}
+}
+
+func TestCheckCommand_ArgvOnly(t *testing.T) {
+	cmd := NewCommander(Config{ArgvOnly: true})
+
+	if err := cmd.ValidateCommand("echo hi"); err == nil {
+		t.Error("Expected shell command to be rejected in argv-only mode")
+	}
+}
+
+func TestFormatArgv(t *testing.T) {
+	got := FormatArgv([]string{"git", "commit", "-m", "it's done", ""})
+	want := `git commit -m 'it'\''s done' ''`
+	if got != want {
+		t.Errorf("FormatArgv = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,559 @@
+package commander
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Policy rule actions
+const (
+	ActionAllow = "allow"
+	ActionDeny  = "deny"
+)
+
+// Policy is a declarative command policy, usually loaded from a YAML or JSON
+// file with LoadPolicy. It is applied in addition to the allowed and blocked
+// command lists.
+type Policy struct {
+	// Default is the action for commands that no rule or list allows: "allow"
+	// or "deny". When empty, such commands are denied if the policy has any
+	// allow rules and allowed otherwise.
+	Default string `yaml:"default" json:"default,omitempty"`
+	// Rules are evaluated deny rules first, then allow rules, each in file
+	// order
+	Rules []PolicyRule `yaml:"rules" json:"rules,omitempty"`
+	// WorkingDirectories restricts commands to run inside these directories.
+	// Empty means any directory.
+	WorkingDirectories []string `yaml:"working_directories" json:"working_directories,omitempty"`
+	// AllowedEnv lists the environment variable names (or glob patterns such
+	// as "NODE_*") that callers may set. Empty means any name.
+	AllowedEnv []string `yaml:"allowed_env" json:"allowed_env,omitempty"`
+}
+
+// PolicyRule allows or denies the simple commands it matches. A rule matches
+// when all of its matchers match; at least one of Program, Command, Regex and
+// Glob must be set.
+type PolicyRule struct {
+	// Name identifies the rule in decisions and error messages
+	Name string `yaml:"name" json:"name,omitempty"`
+	// Action is "allow" or "deny"
+	Action string `yaml:"action" json:"action"`
+	// Program is a glob matched against the program's base name, e.g. "git"
+	// or "python3*"
+	Program string `yaml:"program" json:"program,omitempty"`
+	// Command is a pattern with the same meaning as an entry of the allowed
+	// or blocked command lists, depending on Action
+	Command string `yaml:"command" json:"command,omitempty"`
+	// Regex is a regular expression matched against the command's source text
+	Regex string `yaml:"regex" json:"regex,omitempty"`
+	// Glob is a glob matched against the command's source text, where "*"
+	// also matches spaces
+	Glob string `yaml:"glob" json:"glob,omitempty"`
+	// Args constrains the command's arguments
+	Args *ArgRule `yaml:"args" json:"args,omitempty"`
+	// Paths lists directories that path arguments and redirection targets
+	// are checked against. An allow rule matches only if every path is
+	// inside one of them; a deny rule matches if any path is. Operands are
+	// paths relative to the working directory even without a slash. Paths
+	// that depend on expansions such as $HOME, globs or ~user are unknown,
+	// so allow rules do not match them and deny rules do.
+	Paths []string `yaml:"paths" json:"paths,omitempty"`
+	// Timeout caps the run time of commands allowed by this rule
+	Timeout string `yaml:"timeout" json:"timeout,omitempty"`
+	// Reason is reported when a deny rule rejects a command
+	Reason string `yaml:"reason" json:"reason,omitempty"`
+
+	index   int
+	regex   *regexp.Regexp
+	glob    *regexp.Regexp
+	program *regexp.Regexp
+	timeout time.Duration
+}
+
+// ArgRule constrains the arguments of a command (excluding the program name).
+// Entries are globs. Single-letter flags such as "-f" also match inside
+// combined flags such as "-rf".
+type ArgRule struct {
+	// Prefix must match the leading arguments in order
+	Prefix []string `yaml:"prefix" json:"prefix,omitempty"`
+	// Contains must all be present
+	Contains []string `yaml:"contains" json:"contains,omitempty"`
+	// Any requires at least one of its entries to be present
+	Any []string `yaml:"any" json:"any,omitempty"`
+	// Max is the maximum number of arguments; zero means no limit
+	Max int `yaml:"max" json:"max,omitempty"`
+}
+
+// LoadPolicy reads and validates a policy file. Files ending in .json are
+// parsed as JSON; anything else is parsed as YAML. Unknown fields are errors.
+func LoadPolicy(path string) (*Policy, error) {
+	policy := &Policy{}
+	if err := DecodePolicyFile(path, policy); err != nil {
+		return nil, err
+	}
+	if err := policy.Compile(); err != nil {
+		return nil, fmt.Errorf("invalid policy file %s: %w", path, err)
+	}
+	return policy, nil
+}
+
+// DecodePolicyFile reads a policy file into v without validating it, so that
+// programs can add their own sections to policy files. v is a *Policy or a
+// pointer to a struct embedding Policy with the tag `yaml:",inline"`
+// alongside the extra sections; the Policy must then be compiled.
+func DecodePolicyFile(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read policy file: %w", err)
+	}
+
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(v)
+	} else {
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(v)
+		if errors.Is(err, io.EOF) {
+			err = nil
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse policy file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Compile validates the policy and prepares its rules for matching. It must
+// be called before a Policy built in code is used.
+func (p *Policy) Compile() error {
+	var errs []string
+
+	switch p.Default {
+	case "", ActionAllow, ActionDeny:
+	default:
+		errs = append(errs, fmt.Sprintf("default: must be %q or %q, got %q", ActionAllow, ActionDeny, p.Default))
+	}
+
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		rule.index = i
+		for _, err := range rule.compile() {
+			errs = append(errs, fmt.Sprintf("%s: %s", rule.label(), err))
+		}
+	}
+
+	for i, dir := range p.WorkingDirectories {
+		if strings.TrimSpace(dir) == "" {
+			errs = append(errs, fmt.Sprintf("working_directories[%d]: must not be empty", i))
+		}
+	}
+	for i, name := range p.AllowedEnv {
+		if _, err := compileGlob(name); err != nil || strings.TrimSpace(name) == "" {
+			errs = append(errs, fmt.Sprintf("allowed_env[%d]: invalid pattern %q", i, name))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (r *PolicyRule) compile() []string {
+	var errs []string
+
+	switch r.Action {
+	case ActionAllow, ActionDeny:
+	case "":
+		errs = append(errs, "action is required")
+	default:
+		errs = append(errs, fmt.Sprintf("action must be %q or %q, got %q", ActionAllow, ActionDeny, r.Action))
+	}
+
+	if r.Program == "" && r.Command == "" && r.Regex == "" && r.Glob == "" {
+		errs = append(errs, "one of program, command, regex or glob is required")
+	}
+
+	var err error
+	if r.Program != "" {
+		if r.program, err = compileGlob(strings.ToLower(r.Program)); err != nil {
+			errs = append(errs, fmt.Sprintf("program: %v", err))
+		}
+	}
+	if r.Regex != "" {
+		if r.regex, err = regexp.Compile(r.Regex); err != nil {
+			errs = append(errs, fmt.Sprintf("regex: %v", err))
+		}
+	}
+	if r.Glob != "" {
+		if r.glob, err = compileGlob(strings.ToLower(r.Glob)); err != nil {
+			errs = append(errs, fmt.Sprintf("glob: %v", err))
+		}
+	}
+	if r.Args != nil {
+		for _, patterns := range [][]string{r.Args.Prefix, r.Args.Contains, r.Args.Any} {
+			for _, pattern := range patterns {
+				if _, err := compileGlob(pattern); err != nil {
+					errs = append(errs, fmt.Sprintf("args: %v", err))
+				}
+			}
+		}
+		if r.Args.Max < 0 {
+			errs = append(errs, "args.max must not be negative")
+		}
+	}
+	if r.Timeout != "" {
+		if r.Action == ActionDeny {
+			errs = append(errs, "timeout only applies to allow rules")
+		}
+		if r.timeout, err = time.ParseDuration(r.Timeout); err != nil || r.timeout <= 0 {
+			errs = append(errs, fmt.Sprintf("timeout: invalid duration %q", r.Timeout))
+		}
+	}
+	return errs
+}
+
+// id is the rule's name, or its position in the file if it has none
+func (r *PolicyRule) id() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return fmt.Sprintf("rules[%d]", r.index)
+}
+
+// label names the rule in messages
+func (r *PolicyRule) label() string {
+	if r.Name != "" {
+		return fmt.Sprintf("rule %q", r.Name)
+	}
+	return r.id()
+}
+
+// defaultDeny reports whether commands matching no allow rule are denied
+func (p *Policy) defaultDeny() bool {
+	if p.Default != "" {
+		return p.Default == ActionDeny
+	}
+	for _, rule := range p.Rules {
+		if rule.Action == ActionAllow {
+			return true
+		}
+	}
+	return false
+}
+
+// restrictsDirectories reports whether the policy depends on the directory
+// commands run in, through working directories or path rules
+func (p *Policy) restrictsDirectories() bool {
+	if len(p.WorkingDirectories) > 0 {
+		return true
+	}
+	for _, rule := range p.Rules {
+		if len(rule.Paths) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether the rule matches a command. words holds the
+// command's words as parsed and args their values lowercased.
+func (r *PolicyRule) matches(text string, words []Word, args []string, redirects []Redirect, workDir string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	if r.program != nil && !r.program.MatchString(programName(args[0])) {
+		return false
+	}
+	if r.Command != "" {
+		pattern := splitPattern(strings.ToLower(r.Command))
+		if r.Action == ActionDeny && !matchesBlocked(pattern, args) {
+			return false
+		}
+		if r.Action == ActionAllow && !matchesAllowed(pattern, args) {
+			return false
+		}
+	}
+	if r.regex != nil && !r.regex.MatchString(text) {
+		return false
+	}
+	if r.glob != nil && !r.glob.MatchString(strings.ToLower(text)) {
+		return false
+	}
+	if r.Args != nil && !r.Args.matches(args[1:]) {
+		return false
+	}
+	if len(r.Paths) > 0 {
+		// A path that cannot be known until the command runs fails closed:
+		// allow rules do not match it and deny rules do
+		paths, known := pathArguments(words[1:], redirects, workDir)
+		if r.Action == ActionAllow && (!known || !allWithin(paths, r.Paths)) {
+			return false
+		}
+		if r.Action == ActionDeny && known && !anyWithin(paths, r.Paths) {
+			return false
+		}
+	}
+	return true
+}
+
+func (a *ArgRule) matches(args []string) bool {
+	if a.Max > 0 && len(args) > a.Max {
+		return false
+	}
+	if len(a.Prefix) > len(args) {
+		return false
+	}
+	for i, pattern := range a.Prefix {
+		if !argMatches(strings.ToLower(pattern), args[i]) {
+			return false
+		}
+	}
+	for _, pattern := range a.Contains {
+		if !containsArg(strings.ToLower(pattern), args) {
+			return false
+		}
+	}
+	if len(a.Any) > 0 {
+		found := false
+		for _, pattern := range a.Any {
+			if containsArg(strings.ToLower(pattern), args) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func containsArg(pattern string, args []string) bool {
+	for _, arg := range args {
+		if argMatches(pattern, arg) {
+			return true
+		}
+	}
+	return false
+}
+
+// argMatches matches one argument against a glob. A single-letter flag
+// pattern like "-f" also matches combined short flags like "-rf".
+func argMatches(pattern, arg string) bool {
+	if len(pattern) == 2 && pattern[0] == '-' && pattern[1] != '-' &&
+		len(arg) > 2 && arg[0] == '-' && arg[1] != '-' {
+		return strings.ContainsRune(arg[1:], rune(pattern[1]))
+	}
+	re, err := compileGlob(pattern)
+	return err == nil && re.MatchString(arg)
+}
+
+// CheckEnvironment verifies a working directory and environment variable
+// names against the policy's working_directories and allowed_env settings
+func (p *Policy) CheckEnvironment(workDir string, env map[string]string) error {
+	if len(p.WorkingDirectories) > 0 {
+		dir := workDir
+		if dir == "" {
+			dir = "."
+		}
+		if !allWithin([]string{resolvePath(dir, "")}, p.WorkingDirectories) {
+			return fmt.Errorf("working directory not allowed: %s is outside the allowed directories", dir)
+		}
+	}
+
+	if len(p.AllowedEnv) > 0 {
+		for key := range env {
+			allowed := false
+			for _, pattern := range p.AllowedEnv {
+				if re, err := compileGlob(pattern); err == nil && re.MatchString(key) {
+					allowed = true
+					break
+				}
+			}
+			if !allowed {
+				return fmt.Errorf("environment variable not allowed: %s", key)
+			}
+		}
+	}
+	return nil
+}
+
+// pathArguments returns the arguments and redirection targets that may
+// name files, resolved against workDir. Every operand counts, since a bare
+// name like "passwd" is a file in workDir; flag values count only when they
+// look like paths. known is false if any of them depends on an expansion,
+// such as $HOME, a glob or ~user, that is only resolved when the command
+// runs.
+func pathArguments(args []Word, redirects []Redirect, workDir string) (paths []string, known bool) {
+	add := func(w Word, value string) bool {
+		if w.Dynamic || isUserTilde(value) {
+			return false
+		}
+		if value != "" {
+			paths = append(paths, resolvePath(value, workDir))
+		}
+		return true
+	}
+
+	for _, w := range args {
+		arg := w.Value
+		if strings.HasPrefix(arg, "-") {
+			i := strings.Index(arg, "=")
+			if i < 0 {
+				continue
+			}
+			arg = arg[i+1:]
+			if !looksLikePath(arg) && !w.Dynamic {
+				continue
+			}
+		}
+		if !add(w, arg) {
+			return nil, false
+		}
+	}
+
+	for _, r := range redirects {
+		op := strings.TrimLeft(r.Op, "0123456789")
+		target := r.Target.Value
+		switch {
+		case op == "<<" || op == "<<-" || op == "<<<":
+			// Here-documents and here-strings are text, not files
+			continue
+		case (op == "<&" || op == ">&") && !r.Target.Dynamic && isDescriptor(target):
+			continue
+		case !r.Target.Dynamic && harmlessFiles[target]:
+			continue
+		}
+		if !add(r.Target, target) {
+			return nil, false
+		}
+	}
+	return paths, true
+}
+
+// harmlessFiles are redirection targets that do not reach any other file
+var harmlessFiles = map[string]bool{
+	"/dev/null": true, "/dev/stdin": true, "/dev/stdout": true, "/dev/stderr": true,
+}
+
+// isDescriptor reports whether a >& or <& target duplicates or closes a
+// file descriptor rather than naming a file
+func isDescriptor(target string) bool {
+	target = strings.TrimSuffix(target, "-")
+	if target == "" {
+		return true
+	}
+	for _, c := range target {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// isUserTilde reports whether a word starts with a tilde prefix other than
+// the caller's home directory, such as ~root or ~+, whose directory is only
+// known to the shell
+func isUserTilde(word string) bool {
+	return strings.HasPrefix(word, "~") && word != "~" && !strings.HasPrefix(word, "~/")
+}
+
+func looksLikePath(arg string) bool {
+	return arg == "." || arg == ".." || strings.HasPrefix(arg, "~") || strings.ContainsAny(arg, `/\`)
+}
+
+// resolvePath makes p absolute, expanding a leading "~" and resolving
+// relative paths against workDir (or the current directory). Symbolic links
+// are resolved when the path exists.
+func resolvePath(p, workDir string) string {
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			p = filepath.Join(home, p[1:])
+		}
+	}
+	if !filepath.IsAbs(p) {
+		base := workDir
+		if base == "" {
+			base, _ = os.Getwd()
+		} else if !filepath.IsAbs(base) {
+			cwd, _ := os.Getwd()
+			base = filepath.Join(cwd, base)
+		}
+		p = filepath.Join(base, p)
+	}
+	p = filepath.Clean(p)
+	if resolved, err := filepath.EvalSymlinks(p); err == nil {
+		p = resolved
+	}
+	return p
+}
+
+func within(p, root string) bool {
+	root = resolvePath(root, "")
+	rel, err := filepath.Rel(root, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func allWithin(paths, roots []string) bool {
+	for _, p := range paths {
+		if !anyWithin([]string{p}, roots) {
+			return false
+		}
+	}
+	return true
+}
+
+func anyWithin(paths, roots []string) bool {
+	for _, p := range paths {
+		for _, root := range roots {
+			if within(p, root) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// compileGlob converts a glob to an anchored regular expression. "*" matches
+// any run of characters, "?" any single character, and "[...]" a character
+// class.
+func compileGlob(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated character class in %q", pattern)
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
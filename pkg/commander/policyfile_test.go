@@ -0,0 +1,304 @@
+package commander
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testPolicyYAML = `
+default: deny
+working_directories:
+  - /tmp
+allowed_env:
+  - NODE_*
+  - DEBUG
+rules:
+  - name: no-force-push
+    action: deny
+    program: git
+    args:
+      prefix: [push]
+      any: [--force, -f]
+    reason: force pushes rewrite shared history
+  - name: git
+    action: allow
+    program: git
+  - name: slow-tests
+    action: allow
+    command: go test
+    timeout: 2m
+  - name: python
+    action: allow
+    program: python3*
+  - name: cat-tmp
+    action: allow
+    program: cat
+    paths: [/tmp]
+  - name: no-secrets
+    action: deny
+    regex: '\.(pem|key)\b'
+`
+
+func writePolicy(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write policy: %v", err)
+	}
+	return path
+}
+
+func TestLoadPolicy_Rules(t *testing.T) {
+	policy, err := LoadPolicy(writePolicy(t, "policy.yaml", testPolicyYAML))
+	if err != nil {
+		t.Fatalf("LoadPolicy returned error: %v", err)
+	}
+	cmd := NewCommander(Config{Policy: policy})
+
+	tests := []struct {
+		command     string
+		shouldAllow bool
+	}{
+		{"git status", true},
+		{"git push origin main", true},
+		{"git push --force origin main", false},
+		{"git push -f", false},
+		{"go test ./...", true},
+		{"go build", false},
+		{"python3.11 script.py", true},
+		{"cat /tmp/notes.txt", true},
+		{"cat /etc/passwd", false},
+		{"cat /tmp/server.pem", false},
+		{"ls", false},
+		{"git status; ls", false},
+	}
+
+	for _, tt := range tests {
+		err := cmd.ValidateCommand(tt.command)
+		if tt.shouldAllow && err != nil {
+			t.Errorf("Expected command '%s' to be allowed, got error: %v", tt.command, err)
+		}
+		if !tt.shouldAllow && err == nil {
+			t.Errorf("Expected command '%s' to be blocked", tt.command)
+		}
+	}
+}
+
+func TestLoadPolicy_BareFileNames(t *testing.T) {
+	project := t.TempDir()
+	policy, err := LoadPolicy(writePolicy(t, "policy.yaml", `
+default: deny
+rules:
+  - name: read-project
+    action: allow
+    program: cat
+    paths: [`+project+`]
+  - name: protect-project
+    action: deny
+    program: rm
+    paths: [`+project+`]
+`))
+	if err != nil {
+		t.Fatalf("LoadPolicy returned error: %v", err)
+	}
+	cmd := NewCommander(Config{Policy: policy})
+
+	tests := []struct {
+		command     string
+		workDir     string
+		shouldAllow bool
+	}{
+		{"cat notes.txt", project, true},
+		{"cat -n notes.txt", project, true},
+		{"cat passwd", "/etc", false},
+		{"cat --file=./passwd", "/etc", false},
+		{"rm build.log", project, false},
+	}
+
+	for _, tt := range tests {
+		decision := cmd.CheckCommandIn(tt.command, tt.workDir)
+		if decision.Allowed != tt.shouldAllow {
+			t.Errorf("Expected '%s' in %s to be allowed=%v, got %+v", tt.command, tt.workDir, tt.shouldAllow, decision)
+		}
+	}
+}
+
+func TestLoadPolicy_PathExpansions(t *testing.T) {
+	project := t.TempDir()
+	policy, err := LoadPolicy(writePolicy(t, "policy.yaml", `
+default: deny
+rules:
+  - name: read-project
+    action: allow
+    program: cat
+    paths: [`+project+`]
+`))
+	if err != nil {
+		t.Fatalf("LoadPolicy returned error: %v", err)
+	}
+	cmd := NewCommander(Config{Policy: policy})
+
+	tests := []struct {
+		command     string
+		shouldAllow bool
+	}{
+		{"cat notes.txt > copy.txt", true},
+		{"cat < notes.txt 2>/dev/null", true},
+		{"cat notes.txt 2>&1", true},
+		{"cat <<EOF\n/etc/passwd\nEOF", true},
+		{"cat $HOME/.bashrc", false},
+		{"cat ${HOME}/x", false},
+		{"cat --file=$FILE", false},
+		{"cat *.txt", false},
+		{"cat ~root/.bashrc", false},
+		{"cat ~+/notes.txt", false},
+		{"cat < /etc/passwd", false},
+		{"cat " + project + "/a > /etc/x", false},
+		{"cat notes.txt >& /etc/x", false},
+		{"cat notes.txt > $OUT", false},
+	}
+
+	for _, tt := range tests {
+		decision := cmd.CheckCommandIn(tt.command, project)
+		if decision.Allowed != tt.shouldAllow {
+			t.Errorf("Expected '%s' to be allowed=%v, got %+v", tt.command, tt.shouldAllow, decision)
+		}
+	}
+
+	// Arguments passed as argv are literal, though ~user words are still
+	// refused
+	if decision := cmd.CheckArgv([]string{"cat", "$HOME"}, project); !decision.Allowed {
+		t.Errorf("Expected a literal $HOME argument to be allowed, got %+v", decision)
+	}
+	if decision := cmd.CheckArgv([]string{"cat", "~root/.bashrc"}, project); decision.Allowed {
+		t.Error("Expected a ~user argument to be denied")
+	}
+}
+
+func TestLoadPolicy_DenyReason(t *testing.T) {
+	policy, err := LoadPolicy(writePolicy(t, "policy.yaml", testPolicyYAML))
+	if err != nil {
+		t.Fatalf("LoadPolicy returned error: %v", err)
+	}
+	cmd := NewCommander(Config{Policy: policy})
+
+	err = cmd.ValidateCommand("git push --force")
+	if err == nil || !strings.Contains(err.Error(), "no-force-push") || !strings.Contains(err.Error(), "shared history") {
+		t.Errorf("Expected error naming the rule and its reason, got %v", err)
+	}
+}
+
+func TestLoadPolicy_Timeout(t *testing.T) {
+	policy, err := LoadPolicy(writePolicy(t, "policy.yaml", testPolicyYAML))
+	if err != nil {
+		t.Fatalf("LoadPolicy returned error: %v", err)
+	}
+	cmd := NewCommander(Config{Policy: policy})
+
+	if got := cmd.CheckCommand("go test ./...").Timeout(); got != 2*time.Minute {
+		t.Errorf("Expected 2m timeout, got %v", got)
+	}
+	if got := cmd.CheckCommand("git status").Timeout(); got != 0 {
+		t.Errorf("Expected no timeout, got %v", got)
+	}
+}
+
+func TestLoadPolicy_Environment(t *testing.T) {
+	policy, err := LoadPolicy(writePolicy(t, "policy.yaml", testPolicyYAML))
+	if err != nil {
+		t.Fatalf("LoadPolicy returned error: %v", err)
+	}
+	cmd := NewCommander(Config{Policy: policy})
+
+	if _, err := cmd.ValidateRequest("git status", "/tmp", map[string]string{"NODE_ENV": "test", "DEBUG": "1"}); err != nil {
+		t.Errorf("Expected request to be allowed, got error: %v", err)
+	}
+	if _, err := cmd.ValidateRequest("git status", "/tmp", map[string]string{"LD_PRELOAD": "x.so"}); err == nil {
+		t.Error("Expected LD_PRELOAD to be rejected")
+	}
+	if _, err := cmd.ValidateRequest("git status", "/", nil); err == nil {
+		t.Error("Expected working directory outside /tmp to be rejected")
+	}
+}
+
+func TestLoadPolicy_JSON(t *testing.T) {
+	path := writePolicy(t, "policy.json", `{"rules": [{"action": "deny", "command": "curl"}]}`)
+	policy, err := LoadPolicy(path)
+	if err != nil {
+		t.Fatalf("LoadPolicy returned error: %v", err)
+	}
+	cmd := NewCommander(Config{Policy: policy})
+
+	if err := cmd.ValidateCommand("ls"); err != nil {
+		t.Errorf("Expected ls to be allowed by default, got error: %v", err)
+	}
+	if err := cmd.ValidateCommand("curl http://example.com"); err == nil {
+		t.Error("Expected curl to be blocked")
+	}
+}
+
+func TestDecodePolicyFile_ExtraSections(t *testing.T) {
+	type document struct {
+		Policy `yaml:",inline"`
+		Extra  struct {
+			Hosts []string `yaml:"hosts" json:"hosts"`
+		} `yaml:"extra" json:"extra"`
+	}
+
+	for name, content := range map[string]string{
+		"policy.yaml": "default: deny\nextra:\n  hosts: [example.com]\nrules:\n  - action: allow\n    program: git\n",
+		"policy.json": `{"default": "deny", "extra": {"hosts": ["example.com"]}, "rules": [{"action": "allow", "program": "git"}]}`,
+	} {
+		doc := &document{}
+		if err := DecodePolicyFile(writePolicy(t, name, content), doc); err != nil {
+			t.Fatalf("%s: DecodePolicyFile failed: %v", name, err)
+		}
+		if len(doc.Extra.Hosts) != 1 || doc.Extra.Hosts[0] != "example.com" {
+			t.Errorf("%s: expected the extra section to be decoded, got %+v", name, doc.Extra)
+		}
+		if err := doc.Policy.Compile(); err != nil {
+			t.Fatalf("%s: Compile failed: %v", name, err)
+		}
+		cmd := NewCommander(Config{Policy: &doc.Policy})
+		if err := cmd.ValidateCommand("git status"); err != nil {
+			t.Errorf("%s: expected git to be allowed, got %v", name, err)
+		}
+		if err := cmd.ValidateCommand("ls"); err == nil {
+			t.Errorf("%s: expected ls to be denied", name)
+		}
+	}
+
+	// Sections the program does not know about are still errors
+	if _, err := LoadPolicy(writePolicy(t, "policy.yaml", "extra:\n  hosts: [example.com]\n")); err == nil || !strings.Contains(err.Error(), "extra") {
+		t.Errorf("Expected LoadPolicy to reject an unknown section, got %v", err)
+	}
+}
+
+func TestLoadPolicy_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"unknown field", "rules:\n  - action: allow\n    progam: git\n", "progam"},
+		{"bad action", "rules:\n  - action: permit\n    program: git\n", "action must be"},
+		{"no matcher", "rules:\n  - action: allow\n", "one of program"},
+		{"bad regex", "rules:\n  - action: deny\n    regex: '('\n", "regex"},
+		{"bad timeout", "rules:\n  - action: allow\n    program: go\n    timeout: soon\n", "timeout"},
+		{"bad default", "default: maybe\n", "default"},
+	}
+
+	for _, tt := range tests {
+		_, err := LoadPolicy(writePolicy(t, "policy.yaml", tt.content))
+		if err == nil {
+			t.Errorf("%s: expected error", tt.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.name, tt.want, err)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+//go:build linux
+
+package commander
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// processGroupAlive reports whether any process in the command's process
+// group is still running. Zombies are ignored: orphans are only reaped by
+// init, which in containers may be slow to do so.
+func processGroupAlive(cmd *exec.Cmd) bool {
+	if cmd.Process == nil {
+		return false
+	}
+	pgid := strconv.Itoa(cmd.Process.Pid)
+
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return false
+	}
+	for _, entry := range entries {
+		if entry.Name()[0] < '0' || entry.Name()[0] > '9' {
+			continue
+		}
+		stat, err := os.ReadFile("/proc/" + entry.Name() + "/stat")
+		if err != nil {
+			continue
+		}
+		// The command name in parentheses may itself contain spaces
+		end := strings.LastIndexByte(string(stat), ')')
+		if end < 0 {
+			continue
+		}
+		// Fields after the name: state, ppid, pgrp
+		fields := strings.Fields(string(stat[end+1:]))
+		if len(fields) >= 3 && fields[2] == pgid && fields[0] != "Z" && fields[0] != "X" {
+			return true
+		}
+	}
+	return false
+}
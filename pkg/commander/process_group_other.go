@@ -0,0 +1,17 @@
+//go:build !linux && !windows
+
+package commander
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// processGroupAlive reports whether any process in the command's process
+// group still exists
+func processGroupAlive(cmd *exec.Cmd) bool {
+	if cmd.Process == nil {
+		return false
+	}
+	return syscall.Kill(-cmd.Process.Pid, 0) == nil
+}
@@ -0,0 +1,30 @@
+//go:build windows
+
+package commander
+
+import (
+	"os/exec"
+)
+
+// setProcessGroup is a no-op on Windows; the shell process is killed directly
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup kills the command's process
+func killProcessGroup(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	return cmd.Process.Kill()
+}
+
+// terminateProcessGroup reports false as Windows has no graceful
+// termination signal; the process is killed instead
+func terminateProcessGroup(cmd *exec.Cmd) bool {
+	return false
+}
+
+// processGroupAlive reports false as the command's process is not tracked
+// beyond its exit on Windows
+func processGroupAlive(cmd *exec.Cmd) bool {
+	return false
+}
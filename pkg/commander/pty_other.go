@@ -0,0 +1,17 @@
+//go:build !linux
+
+package commander
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// ptySupported reports whether sessions can run on a pseudo-terminal
+const ptySupported = false
+
+// startPTY is not implemented on this platform; sessions fall back to pipes
+func startPTY(cmd *exec.Cmd, rows, cols uint16) (*os.File, error) {
+	return nil, errors.New("pseudo-terminals are not supported on this platform")
+}
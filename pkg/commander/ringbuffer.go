@@ -0,0 +1,94 @@
+package commander
+
+import (
+	"sync"
+)
+
+// RingBuffer keeps the most recent bytes written to it. Positions are absolute
+// offsets into everything ever written, so readers can resume where they left
+// off even after older output has been discarded.
+type RingBuffer struct {
+	mu    sync.Mutex
+	data  []byte
+	start int   // index of the oldest retained byte in data
+	size  int   // number of retained bytes
+	total int64 // total bytes ever written
+}
+
+// NewRingBuffer creates a RingBuffer retaining at most capacity bytes
+func NewRingBuffer(capacity int) *RingBuffer {
+	if capacity <= 0 {
+		capacity = 1
+	}
+	return &RingBuffer{data: make([]byte, capacity)}
+}
+
+// Write appends p, discarding the oldest bytes if the buffer is full
+func (b *RingBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n := len(p)
+	b.total += int64(n)
+	capacity := len(b.data)
+
+	// Only the tail of an oversized write can be retained
+	if n >= capacity {
+		copy(b.data, p[n-capacity:])
+		b.start = 0
+		b.size = capacity
+		return n, nil
+	}
+
+	end := (b.start + b.size) % capacity
+	copied := copy(b.data[end:], p)
+	copy(b.data, p[copied:])
+
+	b.size += n
+	if b.size > capacity {
+		b.start = (b.start + b.size - capacity) % capacity
+		b.size = capacity
+	}
+	return n, nil
+}
+
+// Total returns the number of bytes ever written
+func (b *RingBuffer) Total() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.total
+}
+
+// ReadAt returns up to limit bytes starting at absolute offset. A negative
+// offset is relative to the end of the output. If offset falls before the
+// oldest retained byte, reading starts at the oldest retained byte instead.
+// It returns the data, the absolute offset it starts at, and the offset to
+// pass to the next call.
+func (b *RingBuffer) ReadAt(offset int64, limit int) (data []byte, start int64, next int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	oldest := b.total - int64(b.size)
+	if offset < 0 {
+		offset = b.total + offset
+	}
+	if offset < oldest {
+		offset = oldest
+	}
+	if offset > b.total {
+		offset = b.total
+	}
+
+	n := int(b.total - offset)
+	if limit > 0 && n > limit {
+		n = limit
+	}
+
+	data = make([]byte, n)
+	capacity := len(b.data)
+	from := (b.start + int(offset-oldest)) % capacity
+	copied := copy(data, b.data[from:min(from+n, capacity)])
+	copy(data[copied:], b.data[:n-copied])
+
+	return data, offset, offset + int64(n)
+}
@@ -0,0 +1,62 @@
+package commander
+
+import (
+	"testing"
+)
+
+func TestRingBuffer_ReadAt(t *testing.T) {
+	buf := NewRingBuffer(8)
+	buf.Write([]byte("hello"))
+
+	data, start, next := buf.ReadAt(0, 0)
+	if string(data) != "hello" || start != 0 || next != 5 {
+		t.Errorf("ReadAt(0) = %q, %d, %d", data, start, next)
+	}
+
+	data, _, next = buf.ReadAt(1, 3)
+	if string(data) != "ell" || next != 4 {
+		t.Errorf("ReadAt(1, 3) = %q, %d", data, next)
+	}
+
+	data, _, _ = buf.ReadAt(-2, 0)
+	if string(data) != "lo" {
+		t.Errorf("ReadAt(-2) = %q, expected \"lo\"", data)
+	}
+}
+
+func TestRingBuffer_Wraparound(t *testing.T) {
+	buf := NewRingBuffer(8)
+	buf.Write([]byte("abcdef"))
+	buf.Write([]byte("ghijk"))
+
+	if buf.Total() != 11 {
+		t.Errorf("Expected total 11, got %d", buf.Total())
+	}
+
+	// Offsets before the retained window start at the oldest byte
+	data, start, next := buf.ReadAt(0, 0)
+	if string(data) != "defghijk" || start != 3 || next != 11 {
+		t.Errorf("ReadAt(0) = %q, %d, %d", data, start, next)
+	}
+
+	data, _, _ = buf.ReadAt(9, 0)
+	if string(data) != "jk" {
+		t.Errorf("ReadAt(9) = %q, expected \"jk\"", data)
+	}
+
+	data, _, next = buf.ReadAt(11, 0)
+	if len(data) != 0 || next != 11 {
+		t.Errorf("ReadAt(11) = %q, %d, expected empty", data, next)
+	}
+}
+
+func TestRingBuffer_OversizedWrite(t *testing.T) {
+	buf := NewRingBuffer(4)
+	buf.Write([]byte("x"))
+	buf.Write([]byte("0123456789"))
+
+	data, start, _ := buf.ReadAt(0, 0)
+	if string(data) != "6789" || start != 7 {
+		t.Errorf("ReadAt(0) = %q, %d, expected \"6789\", 7", data, start)
+	}
+}
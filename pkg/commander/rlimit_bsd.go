@@ -0,0 +1,6 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package commander
+
+// rlimitNproc is RLIMIT_NPROC, which the syscall package does not define
+const rlimitNproc = 7
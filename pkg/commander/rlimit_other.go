@@ -0,0 +1,6 @@
+//go:build !windows && !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+
+package commander
+
+// rlimitNproc is zero where process limits are not supported
+const rlimitNproc = 0
@@ -0,0 +1,43 @@
+package commander
+
+// SandboxConfig configures the optional sandbox that commands run in. On
+// Linux each command gets new user, mount, PID, IPC and UTS namespaces (and a
+// network namespace unless AllowNetwork is set), filesystem access limited
+// with Landlock to the configured roots, and a seccomp filter that kills the
+// command if it makes a dangerous system call. Other platforms do not support
+// sandboxing.
+type SandboxConfig struct {
+	// ReadOnlyPaths may be read and executed but not modified
+	ReadOnlyPaths []string `json:"read_only_paths"`
+	// ReadWritePaths may be read, executed and modified
+	ReadWritePaths []string `json:"read_write_paths"`
+	// AllowNetwork keeps the server's network namespace. Without it commands
+	// only see an isolated loopback device.
+	AllowNetwork bool `json:"allow_network"`
+}
+
+// DefaultSandboxReadOnlyPaths are the read-only roots used when none are
+// configured: enough to run system programs and shells
+var DefaultSandboxReadOnlyPaths = []string{
+	"/bin", "/sbin", "/usr", "/lib", "/lib32", "/lib64", "/etc", "/opt", "/proc",
+}
+
+// DefaultSandboxReadWritePaths are the read-write roots used when none are
+// configured
+var DefaultSandboxReadWritePaths = []string{"/tmp", "/dev"}
+
+// NewSandboxConfig returns a sandbox configuration, substituting the default
+// roots for empty lists
+func NewSandboxConfig(readOnly, readWrite []string, allowNetwork bool) *SandboxConfig {
+	if len(readOnly) == 0 {
+		readOnly = DefaultSandboxReadOnlyPaths
+	}
+	if len(readWrite) == 0 {
+		readWrite = DefaultSandboxReadWritePaths
+	}
+	return &SandboxConfig{
+		ReadOnlyPaths:  readOnly,
+		ReadWritePaths: readWrite,
+		AllowNetwork:   allowNetwork,
+	}
+}
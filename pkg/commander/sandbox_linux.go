@@ -0,0 +1,307 @@
+//go:build linux
+
+package commander
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// Landlock system calls and constants (linux/landlock.h). The system call
+// numbers are the same on every architecture.
+const (
+	sysLandlockCreateRuleset = 444
+	sysLandlockAddRule       = 445
+	sysLandlockRestrictSelf  = 446
+
+	landlockCreateRulesetVersion = 1 << 0
+	landlockRulePathBeneath      = 1
+
+	landlockAccessFSExecute    = 1 << 0
+	landlockAccessFSWriteFile  = 1 << 1
+	landlockAccessFSReadFile   = 1 << 2
+	landlockAccessFSReadDir    = 1 << 3
+	landlockAccessFSRefer      = 1 << 13
+	landlockAccessFSTruncate   = 1 << 14
+	landlockAccessFSIoctlDev   = 1 << 15
+	landlockAccessFSV1         = 1<<13 - 1
+	landlockAccessFSFileRights = landlockAccessFSExecute | landlockAccessFSWriteFile |
+		landlockAccessFSReadFile | landlockAccessFSTruncate | landlockAccessFSIoctlDev
+	landlockAccessFSReadOnly = landlockAccessFSExecute | landlockAccessFSReadFile | landlockAccessFSReadDir
+)
+
+// seccomp and prctl constants (linux/seccomp.h, linux/filter.h)
+const (
+	prSetNoNewPrivs           = 38
+	seccompSetModeFilter      = 1
+	seccompRetKillProcess     = 0x80000000
+	seccompRetAllow           = 0x7fff0000
+	seccompDataNrOffset       = 0
+	seccompDataArchOffset     = 4
+	bpfLdWAbs                 = 0x20 // BPF_LD | BPF_W | BPF_ABS
+	bpfJeqK                   = 0x15 // BPF_JMP | BPF_JEQ | BPF_K
+	bpfJgeK                   = 0x35 // BPF_JMP | BPF_JGE | BPF_K
+	bpfRetK                   = 0x06 // BPF_RET | BPF_K
+	linuxCapabilityVersion3   = 0x20080522
+	sandboxSIGSYSShellStatus  = 128 + int(syscall.SIGSYS)
+	openPath                  = 0x200000 // O_PATH
+	mountPrivate              = syscall.MS_PRIVATE | syscall.MS_REC
+	procMountFlags            = syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC
+	sandboxNamespaceCloneFlag = syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWPID |
+		syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS
+)
+
+// applySandbox makes cmd start in new namespaces. The command must also be
+// run through the helper, which sets up the rest of the sandbox.
+func applySandbox(cmd *exec.Cmd, cfg *SandboxConfig) error {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Cloneflags |= sandboxNamespaceCloneFlag
+	if !cfg.AllowNetwork {
+		cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWNET
+	}
+	// Map the server's user to root inside the namespace so the helper may
+	// mount /proc; it drops all capabilities before running the command
+	cmd.SysProcAttr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}}
+	cmd.SysProcAttr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}}
+	cmd.SysProcAttr.GidMappingsEnableSetgroups = false
+	return nil
+}
+
+// CheckSandbox verifies that the kernel supports the sandbox by running a
+// trivial command in it
+func CheckSandbox(cfg *SandboxConfig) error {
+	if _, ok := seccompSupported(); !ok {
+		return fmt.Errorf("sandbox: seccomp filtering is not supported on %s", runtime.GOARCH)
+	}
+	if _, err := landlockABI(); err != nil {
+		return fmt.Errorf("sandbox: %w", err)
+	}
+
+	cmd := exec.Command("true")
+	if cmd.Err != nil {
+		return fmt.Errorf("sandbox: cannot find a program to test with: %w", cmd.Err)
+	}
+	if err := applySandbox(cmd, cfg); err != nil {
+		return err
+	}
+	report, err := wrapWithHelper(cmd, &helperConfig{Sandbox: cfg})
+	if err != nil {
+		return fmt.Errorf("sandbox: %w", err)
+	}
+	defer report.close()
+	err = cmd.Start()
+	report.started()
+	if err == nil {
+		err = cmd.Wait()
+	}
+	if msg := report.message(); msg != "" {
+		return errors.New(msg)
+	}
+	if err != nil {
+		return fmt.Errorf("sandbox: test command failed: %w", err)
+	}
+	return nil
+}
+
+// setupSandbox restricts the helper process before it executes the command
+func setupSandbox(cfg *SandboxConfig) error {
+	// Give the new PID namespace its own /proc. Some container runtimes
+	// forbid this; the PID namespace still isolates signals in that case.
+	if err := syscall.Mount("", "/", "", mountPrivate, ""); err == nil {
+		syscall.Mount("proc", "/proc", "proc", procMountFlags, "")
+	}
+
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); errno != 0 {
+		return fmt.Errorf("set no_new_privs: %w", errno)
+	}
+	if err := restrictFilesystem(cfg.ReadOnlyPaths, cfg.ReadWritePaths); err != nil {
+		return err
+	}
+	if err := dropCapabilities(); err != nil {
+		return err
+	}
+	return installSeccompFilter()
+}
+
+// landlockABI returns the Landlock ABI version supported by the kernel
+func landlockABI() (int, error) {
+	abi, _, errno := syscall.Syscall(sysLandlockCreateRuleset, 0, 0, landlockCreateRulesetVersion)
+	if errno != 0 {
+		return 0, fmt.Errorf("Landlock is not available: %w", errno)
+	}
+	return int(abi), nil
+}
+
+// restrictFilesystem confines the process to the given roots with Landlock
+func restrictFilesystem(readOnly, readWrite []string) error {
+	abi, err := landlockABI()
+	if err != nil {
+		return err
+	}
+
+	handled := uint64(landlockAccessFSV1)
+	if abi >= 2 {
+		handled |= landlockAccessFSRefer
+	}
+	if abi >= 3 {
+		handled |= landlockAccessFSTruncate
+	}
+	if abi >= 5 {
+		handled |= landlockAccessFSIoctlDev
+	}
+
+	rulesetAttr := struct{ handledAccessFS uint64 }{handled}
+	fd, _, errno := syscall.Syscall(sysLandlockCreateRuleset, uintptr(unsafe.Pointer(&rulesetAttr)), unsafe.Sizeof(rulesetAttr), 0)
+	if errno != 0 {
+		return fmt.Errorf("create Landlock ruleset: %w", errno)
+	}
+	defer syscall.Close(int(fd))
+
+	for _, root := range readOnly {
+		if err := addLandlockRule(int(fd), root, landlockAccessFSReadOnly&handled); err != nil {
+			return err
+		}
+	}
+	for _, root := range readWrite {
+		if err := addLandlockRule(int(fd), root, handled); err != nil {
+			return err
+		}
+	}
+
+	if _, _, errno := syscall.Syscall(sysLandlockRestrictSelf, fd, 0, 0); errno != 0 {
+		return fmt.Errorf("enforce Landlock ruleset: %w", errno)
+	}
+	return nil
+}
+
+// addLandlockRule grants access beneath path. Paths that do not exist are
+// skipped so that one default list works across distributions.
+func addLandlockRule(rulesetFD int, path string, access uint64) error {
+	fd, err := syscall.Open(path, openPath|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		if errors.Is(err, syscall.ENOENT) {
+			return nil
+		}
+		return fmt.Errorf("open %s: %w", path, err)
+	}
+	defer syscall.Close(fd)
+
+	var st syscall.Stat_t
+	if err := syscall.Fstat(fd, &st); err != nil {
+		return fmt.Errorf("stat %s: %w", path, err)
+	}
+	if st.Mode&syscall.S_IFMT != syscall.S_IFDIR {
+		access &= landlockAccessFSFileRights
+	}
+
+	// struct landlock_path_beneath_attr is packed; the kernel reads the
+	// first 12 bytes, which have the same layout as this struct
+	attr := struct {
+		allowedAccess uint64
+		parentFD      int32
+	}{access, int32(fd)}
+	if _, _, errno := syscall.Syscall6(sysLandlockAddRule, uintptr(rulesetFD), landlockRulePathBeneath, uintptr(unsafe.Pointer(&attr)), 0, 0, 0); errno != 0 {
+		return fmt.Errorf("add Landlock rule for %s: %w", path, errno)
+	}
+	return nil
+}
+
+// dropCapabilities clears every capability the helper holds in its user
+// namespace
+func dropCapabilities() error {
+	header := struct {
+		version uint32
+		pid     int32
+	}{linuxCapabilityVersion3, 0}
+	var data [2]struct{ effective, permitted, inheritable uint32 }
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_CAPSET, uintptr(unsafe.Pointer(&header)), uintptr(unsafe.Pointer(&data[0])), 0); errno != 0 {
+		return fmt.Errorf("drop capabilities: %w", errno)
+	}
+	return nil
+}
+
+type sockFilter struct {
+	code uint16
+	jt   uint8
+	jf   uint8
+	k    uint32
+}
+
+type sockFprog struct {
+	len    uint16
+	filter *sockFilter
+}
+
+// installSeccompFilter kills the process if it makes any of the system
+// calls in seccompDeniedSyscalls, or uses a foreign system call ABI
+func installSeccompFilter() error {
+	arch, ok := seccompSupported()
+	if !ok {
+		return fmt.Errorf("seccomp filtering is not supported on %s", runtime.GOARCH)
+	}
+
+	n := len(seccompDeniedSyscalls)
+	filter := []sockFilter{
+		{code: bpfLdWAbs, k: seccompDataArchOffset},
+		{code: bpfJeqK, jt: 1, k: arch},
+		{code: bpfRetK, k: seccompRetKillProcess},
+		{code: bpfLdWAbs, k: seccompDataNrOffset},
+	}
+	if seccompSyscallLimit > 0 {
+		// Reject alternative ABIs such as x32 that share the architecture
+		filter = append(filter, sockFilter{code: bpfJgeK, jt: uint8(n + 1), k: seccompSyscallLimit})
+	}
+	for i, nr := range seccompDeniedSyscalls {
+		filter = append(filter, sockFilter{code: bpfJeqK, jt: uint8(n - i), k: nr})
+	}
+	filter = append(filter,
+		sockFilter{code: bpfRetK, k: seccompRetAllow},
+		sockFilter{code: bpfRetK, k: seccompRetKillProcess},
+	)
+
+	prog := sockFprog{len: uint16(len(filter)), filter: &filter[0]}
+	if _, _, errno := syscall.RawSyscall(sysSeccomp, seccompSetModeFilter, 0, uintptr(unsafe.Pointer(&prog))); errno != 0 {
+		return fmt.Errorf("install seccomp filter: %w", errno)
+	}
+	runtime.KeepAlive(filter)
+	return nil
+}
+
+func seccompSupported() (uint32, bool) {
+	return seccompArch, seccompArch != 0
+}
+
+// inspectSandboxResult records in result the seccomp violation that killed
+// a command. Landlock makes file access fail with an ordinary permission
+// error, so that is only suggested in result.Hints from the command's error
+// output. err is the error returned by running the command.
+func inspectSandboxResult(result *Result, err error) {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() && status.Signal() == syscall.SIGSYS {
+			result.SandboxViolations = append(result.SandboxViolations, "blocked system call (seccomp)")
+		}
+	}
+	// A shell reports a child killed by SIGSYS as status 128+31
+	if result.ExitCode == sandboxSIGSYSShellStatus {
+		if len(result.SandboxViolations) == 0 {
+			result.SandboxViolations = append(result.SandboxViolations, "blocked system call (seccomp)")
+		}
+	}
+
+	if result.ExitCode != 0 {
+		for _, message := range []string{"Permission denied", "Operation not permitted"} {
+			if line := lineContaining(result.Stderr, message); line != "" {
+				result.Hints = append(result.Hints, "possibly denied by the sandbox (Landlock): "+line)
+				break
+			}
+		}
+	}
+}
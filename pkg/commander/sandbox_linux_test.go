@@ -0,0 +1,100 @@
+package commander
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func sandboxedCommander(t *testing.T, readWrite ...string) *Commander {
+	t.Helper()
+	cfg := NewSandboxConfig(nil, append(readWrite, DefaultSandboxReadWritePaths...), false)
+	if err := CheckSandbox(cfg); err != nil {
+		t.Skipf("Sandbox not available: %v", err)
+	}
+	return NewCommander(Config{Sandbox: cfg})
+}
+
+func TestSandbox_RunsCommand(t *testing.T) {
+	cmd := sandboxedCommander(t)
+
+	result := cmd.Execute(context.Background(), "echo hello", "", 0, nil)
+	if result.ExitCode != 0 {
+		t.Fatalf("Expected exit code 0, got %d: %v %s", result.ExitCode, result.Error, result.Stderr)
+	}
+	if !result.Sandboxed {
+		t.Error("Expected result to be marked as sandboxed")
+	}
+	if strings.TrimSpace(result.Stdout) != "hello" {
+		t.Errorf("Expected 'hello', got %q", result.Stdout)
+	}
+}
+
+func TestSandbox_Filesystem(t *testing.T) {
+	writable := t.TempDir()
+	cmd := sandboxedCommander(t, writable)
+
+	target := filepath.Join(writable, "ok.txt")
+	result := cmd.Execute(context.Background(), "echo data > "+target, "", 0, nil)
+	if result.ExitCode != 0 {
+		t.Fatalf("Expected write inside read-write root to succeed, got %d: %s", result.ExitCode, result.Stderr)
+	}
+
+	result = cmd.Execute(context.Background(), "echo data > /etc/sandbox-test", "", 0, nil)
+	if result.ExitCode == 0 {
+		t.Fatal("Expected write to read-only root to fail")
+	}
+	// A permission error is not proof of a Landlock denial, so it is only a hint
+	if len(result.SandboxViolations) != 0 {
+		t.Errorf("Expected no sandbox violation from error output, got %v", result.SandboxViolations)
+	}
+	if len(result.Hints) == 0 || !strings.Contains(result.Hints[0], "Landlock") {
+		t.Errorf("Expected a Landlock hint, got %q (stderr: %s)", result.Hints, result.Stderr)
+	}
+}
+
+func TestSandbox_Seccomp(t *testing.T) {
+	cmd := sandboxedCommander(t)
+
+	// unshare(2) is blocked by the seccomp filter
+	result := cmd.ExecuteArgv(context.Background(), []string{"unshare", "-U", "true"}, "", 0, nil, nil)
+	if result.ExitCode == 0 {
+		t.Fatal("Expected unshare to be blocked")
+	}
+	found := false
+	for _, v := range result.SandboxViolations {
+		if strings.Contains(v, "seccomp") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected a seccomp violation, got %v (exit %d, stderr %q)", result.SandboxViolations, result.ExitCode, result.Stderr)
+	}
+}
+
+func TestSandbox_PIDNamespace(t *testing.T) {
+	cmd := sandboxedCommander(t)
+
+	result := cmd.Execute(context.Background(), "echo $$", "", 0, nil)
+	if result.ExitCode != 0 {
+		t.Fatalf("Expected exit code 0, got %d: %s", result.ExitCode, result.Stderr)
+	}
+	if strings.TrimSpace(result.Stdout) != "1" {
+		t.Errorf("Expected the command to be PID 1 in its namespace, got %q", result.Stdout)
+	}
+}
+
+func TestSandbox_WithLimits(t *testing.T) {
+	writable := t.TempDir()
+	cmd := sandboxedCommander(t, writable)
+	cmd.config.Limits = &ResourceLimits{FileSizeBytes: 4096}
+
+	result := cmd.Execute(context.Background(), "head -c 100000 /dev/zero > "+filepath.Join(writable, "big"), "", 0, nil)
+	if !result.Sandboxed {
+		t.Error("Expected result to be marked as sandboxed")
+	}
+	if !hasLimit(result, LimitFileSize) {
+		t.Errorf("Expected file size limit to be reported, got %v: %v %s", result.LimitsExceeded, result.Error, result.Stderr)
+	}
+}
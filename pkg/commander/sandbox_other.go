@@ -0,0 +1,25 @@
+//go:build !linux
+
+package commander
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+)
+
+func applySandbox(cmd *exec.Cmd, cfg *SandboxConfig) error {
+	return fmt.Errorf("sandbox: not supported on %s", runtime.GOOS)
+}
+
+// CheckSandbox verifies that the sandbox is usable. Sandboxing is only
+// supported on Linux.
+func CheckSandbox(cfg *SandboxConfig) error {
+	return fmt.Errorf("sandbox: not supported on %s", runtime.GOOS)
+}
+
+func setupSandbox(cfg *SandboxConfig) error {
+	return fmt.Errorf("not supported on %s", runtime.GOOS)
+}
+
+func inspectSandboxResult(result *Result, err error) {}
@@ -0,0 +1,56 @@
+package commander
+
+// AUDIT_ARCH_X86_64
+const seccompArch = 0xc000003e
+
+// sysSeccomp is the seccomp(2) system call number
+const sysSeccomp = 317
+
+// seccompSyscallLimit rejects x32 system calls, which are numbered from
+// 0x40000000
+const seccompSyscallLimit = 0x40000000
+
+// seccompDeniedSyscalls are the system calls a sandboxed command may not
+// make: mounting, kernel modules and kexec, tracing other processes,
+// namespace changes, BPF, keyrings, clock changes and raw I/O
+var seccompDeniedSyscalls = []uint32{
+	101, // ptrace
+	103, // syslog
+	155, // pivot_root
+	159, // adjtimex
+	163, // acct
+	164, // settimeofday
+	165, // mount
+	166, // umount2
+	167, // swapon
+	168, // swapoff
+	169, // reboot
+	172, // iopl
+	173, // ioperm
+	175, // init_module
+	176, // delete_module
+	179, // quotactl
+	227, // clock_settime
+	246, // kexec_load
+	248, // add_key
+	249, // request_key
+	250, // keyctl
+	272, // unshare
+	298, // perf_event_open
+	304, // open_by_handle_at
+	305, // clock_adjtime
+	308, // setns
+	310, // process_vm_readv
+	311, // process_vm_writev
+	313, // finit_module
+	320, // kexec_file_load
+	321, // bpf
+	323, // userfaultfd
+	428, // open_tree
+	429, // move_mount
+	430, // fsopen
+	431, // fsconfig
+	432, // fsmount
+	433, // fspick
+	442, // mount_setattr
+}
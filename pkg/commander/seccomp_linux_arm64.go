@@ -0,0 +1,53 @@
+package commander
+
+// AUDIT_ARCH_AARCH64
+const seccompArch = 0xc00000b7
+
+// sysSeccomp is the seccomp(2) system call number
+const sysSeccomp = 277
+
+// seccompSyscallLimit is unused on arm64, which has a single system call ABI
+const seccompSyscallLimit = 0
+
+// seccompDeniedSyscalls are the system calls a sandboxed command may not
+// make: mounting, kernel modules and kexec, tracing other processes,
+// namespace changes, BPF, keyrings, clock changes and raw I/O
+var seccompDeniedSyscalls = []uint32{
+	39,  // umount2
+	40,  // mount
+	41,  // pivot_root
+	60,  // quotactl
+	89,  // acct
+	97,  // unshare
+	104, // kexec_load
+	105, // init_module
+	106, // delete_module
+	112, // clock_settime
+	116, // syslog
+	117, // ptrace
+	142, // reboot
+	170, // settimeofday
+	171, // adjtimex
+	217, // add_key
+	218, // request_key
+	219, // keyctl
+	224, // swapon
+	225, // swapoff
+	241, // perf_event_open
+	265, // open_by_handle_at
+	266, // clock_adjtime
+	268, // setns
+	270, // process_vm_readv
+	271, // process_vm_writev
+	273, // finit_module
+	280, // bpf
+	282, // userfaultfd
+	294, // kexec_file_load
+	428, // open_tree
+	429, // move_mount
+	430, // fsopen
+	431, // fsconfig
+	432, // fsmount
+	433, // fspick
+	442, // mount_setattr
+}
@@ -0,0 +1,14 @@
+//go:build linux && !amd64 && !arm64
+
+package commander
+
+// seccompArch is zero where no seccomp profile is defined, which makes the
+// sandbox unavailable
+const seccompArch = 0
+
+// sysSeccomp is the seccomp(2) system call number
+const sysSeccomp = 0
+
+const seccompSyscallLimit = 0
+
+var seccompDeniedSyscalls []uint32
@@ -0,0 +1,578 @@
+package commander
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxSessions is the default number of concurrent shell sessions
+	DefaultMaxSessions = 4
+	// DefaultSessionIdleTimeout is how long a session may go unused before it
+	// is closed automatically
+	DefaultSessionIdleTimeout = 15 * time.Minute
+	// DefaultSessionBufferSize is the default number of output bytes kept per
+	// session
+	DefaultSessionBufferSize = 256 * 1024
+)
+
+// Session is a long-lived shell process. State such as the current
+// directory, exported variables and activated virtualenvs persists between
+// inputs because every input goes to the same shell.
+type Session struct {
+	ID string
+	// Owner is the identity that opened the session; only it can use the
+	// session
+	Owner     string
+	Shell     string
+	WorkDir   string
+	PTY       bool
+	StartedAt time.Time
+	cmd       *exec.Cmd
+	input     io.WriteCloser
+	output    *RingBuffer
+	done      chan struct{}
+	mu        sync.Mutex
+	lastUsed  time.Time
+	exitCode  int
+	closed    bool
+	// pending is input for an unfinished line, held back until the line is
+	// complete and can be validated as a whole
+	pending string
+}
+
+// SessionInfo is a point-in-time snapshot of a session
+type SessionInfo struct {
+	ID          string    `json:"session_id"`
+	Owner       string    `json:"owner,omitempty"`
+	Shell       string    `json:"shell"`
+	WorkDir     string    `json:"working_directory,omitempty"`
+	PTY         bool      `json:"pty"`
+	Running     bool      `json:"running"`
+	ExitCode    *int      `json:"exit_code,omitempty"`
+	StartedAt   time.Time `json:"started_at"`
+	LastUsed    time.Time `json:"last_used"`
+	OutputBytes int64     `json:"output_bytes"`
+	// PendingInput is input sent without a newline that has not yet been
+	// written to the shell
+	PendingInput string `json:"pending_input,omitempty"`
+}
+
+// Info returns a snapshot of the session's current state
+func (s *Session) Info() SessionInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info := SessionInfo{
+		ID:           s.ID,
+		Owner:        s.Owner,
+		Shell:        s.Shell,
+		WorkDir:      s.WorkDir,
+		PTY:          s.PTY,
+		Running:      s.running(),
+		StartedAt:    s.StartedAt,
+		LastUsed:     s.lastUsed,
+		OutputBytes:  s.output.Total(),
+		PendingInput: s.pending,
+	}
+	if !info.Running {
+		exitCode := s.exitCode
+		info.ExitCode = &exitCode
+	}
+	return info
+}
+
+// Output returns up to limit bytes of terminal output starting at the
+// absolute offset. See RingBuffer.ReadAt for the meaning of the offsets.
+func (s *Session) Output(offset int64, limit int) (data []byte, start int64, next int64) {
+	s.touch()
+	return s.output.ReadAt(offset, limit)
+}
+
+// WaitOutput blocks until output beyond offset is available, the shell
+// exits, or the wait duration elapses
+func (s *Session) WaitOutput(ctx context.Context, offset int64, wait time.Duration) {
+	deadline := time.NewTimer(wait)
+	defer deadline.Stop()
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+
+	for s.output.Total() <= offset {
+		select {
+		case <-s.done:
+			return
+		case <-ctx.Done():
+			return
+		case <-deadline.C:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Done returns a channel that is closed when the shell exits
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// running reports whether the shell is still alive. s.mu must be held.
+func (s *Session) running() bool {
+	select {
+	case <-s.done:
+		return false
+	default:
+		return !s.closed
+	}
+}
+
+func (s *Session) touch() {
+	s.mu.Lock()
+	s.lastUsed = time.Now()
+	s.mu.Unlock()
+}
+
+func (s *Session) idleSince() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastUsed
+}
+
+// SessionManager opens and tracks interactive shell sessions
+type SessionManager struct {
+	commander   *Commander
+	maxSessions int
+	idleTimeout time.Duration
+	bufferSize  int
+	mu          sync.Mutex
+	sessions    map[string]*Session
+	nextID      int
+	stop        chan struct{}
+	stopOnce    sync.Once
+}
+
+// NewSessionManager creates a manager that runs at most maxSessions shells at
+// once and closes any session unused for idleTimeout
+func NewSessionManager(c *Commander, maxSessions int, idleTimeout time.Duration, bufferSize int) *SessionManager {
+	if maxSessions <= 0 {
+		maxSessions = DefaultMaxSessions
+	}
+	if idleTimeout <= 0 {
+		idleTimeout = DefaultSessionIdleTimeout
+	}
+	if bufferSize <= 0 {
+		bufferSize = DefaultSessionBufferSize
+	}
+	m := &SessionManager{
+		commander:   c,
+		maxSessions: maxSessions,
+		idleTimeout: idleTimeout,
+		bufferSize:  bufferSize,
+		sessions:    make(map[string]*Session),
+		stop:        make(chan struct{}),
+	}
+	go m.reapIdle()
+	return m
+}
+
+// Open starts a new shell session on behalf of owner, the caller's identity
+// or "" for none. On Linux the shell runs on a pseudo-terminal; elsewhere it
+// falls back to plain pipes. The working directory and environment are
+// checked against the commander's policy.
+func (m *SessionManager) Open(workDir string, env map[string]string, owner string) (*Session, error) {
+	if workDir != "" {
+		if _, err := os.Stat(workDir); os.IsNotExist(err) {
+			return nil, fmt.Errorf("working directory does not exist: %s", workDir)
+		}
+	}
+	if err := m.commander.ValidateEnvironment(workDir, env); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	if len(m.sessions) >= m.maxSessions {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("too many sessions: limit of %d reached", m.maxSessions)
+	}
+	m.nextID++
+	id := fmt.Sprintf("session-%d", m.nextID)
+	// Reserve the slot while the shell starts
+	m.sessions[id] = nil
+	m.mu.Unlock()
+
+	session, err := m.start(id, workDir, env)
+	if session != nil {
+		session.Owner = owner
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		delete(m.sessions, id)
+		return nil, err
+	}
+	m.sessions[id] = session
+	return session, nil
+}
+
+func (m *SessionManager) start(id, workDir string, env map[string]string) (*Session, error) {
+	shell, _ := m.commander.GetShellInfo()
+	cmd := exec.Command(shell)
+	cmd.Dir = workDir
+	cmd.Env = append(os.Environ(), "TERM=dumb")
+	for k, v := range env {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", k, v))
+	}
+	// A CPU time limit would end the shell itself after a while
+	cgroup, _, err := m.commander.prepareCommand(cmd, m.commander.config.Limits.withoutCPU())
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := &Session{
+		ID:        id,
+		Shell:     shell,
+		WorkDir:   workDir,
+		StartedAt: now,
+		cmd:       cmd,
+		output:    NewRingBuffer(m.bufferSize),
+		done:      make(chan struct{}),
+		lastUsed:  now,
+	}
+
+	var reader io.ReadCloser
+	if ptySupported {
+		master, err := startPTY(cmd, 24, 120)
+		if err != nil {
+			cgroup.remove()
+			return nil, fmt.Errorf("failed to start shell: %w", err)
+		}
+		session.PTY = true
+		session.input = master
+		reader = master
+	} else {
+		setProcessGroup(cmd)
+		pr, pw := io.Pipe()
+		cmd.Stdout = pw
+		cmd.Stderr = pw
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			cgroup.remove()
+			return nil, fmt.Errorf("failed to start shell: %w", err)
+		}
+		if err := cmd.Start(); err != nil {
+			cgroup.remove()
+			return nil, fmt.Errorf("failed to start shell: %w", err)
+		}
+		session.input = stdin
+		reader = pr
+		go func() {
+			cmd.Wait()
+			pw.Close()
+		}()
+	}
+
+	go func() {
+		// A pty master returns EIO once the shell and its children exit
+		io.Copy(session.output, reader)
+		reader.Close()
+		if session.PTY {
+			cmd.Wait()
+		}
+		session.mu.Lock()
+		if cmd.ProcessState != nil {
+			session.exitCode = cmd.ProcessState.ExitCode()
+		}
+		session.mu.Unlock()
+		cgroup.remove()
+		close(session.done)
+	}()
+
+	return session, nil
+}
+
+// Get returns the session with the given id if owner opened it. Sessions
+// of other owners are reported as not found.
+func (m *SessionManager) Get(id, owner string) (*Session, bool) {
+	session, ok := m.get(id)
+	if !ok || session.Owner != owner {
+		return nil, false
+	}
+	return session, true
+}
+
+func (m *SessionManager) get(id string) (*Session, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	session, ok := m.sessions[id]
+	return session, ok && session != nil
+}
+
+// List returns snapshots of the open sessions owner opened, oldest first
+func (m *SessionManager) List(owner string) []SessionInfo {
+	m.mu.Lock()
+	sessions := make([]*Session, 0, len(m.sessions))
+	for _, session := range m.sessions {
+		if session != nil && session.Owner == owner {
+			sessions = append(sessions, session)
+		}
+	}
+	m.mu.Unlock()
+
+	sort.Slice(sessions, func(i, k int) bool {
+		return sessions[i].StartedAt.Before(sessions[k].StartedAt)
+	})
+
+	infos := make([]SessionInfo, 0, len(sessions))
+	for _, session := range sessions {
+		infos = append(infos, session.Info())
+	}
+	return infos
+}
+
+// SendInput writes input to the session's shell. When newline is true a
+// trailing newline is appended so the shell runs the input.
+//
+// Lines are validated in the shell's current directory. When a policy
+// restricts directories, lines that change the directory (cd, pushd,
+// popd) are refused, so that the shell stays where its commands are
+// checked.
+//
+// If the commander, or extra when not nil, restricts commands, input is
+// only written a whole line at a time, once the line is complete and
+// every commander allows it; input without a newline is held back until
+// the rest of the line arrives. Lines ending in a backslash continuation,
+// or inside a quote or here-document, are held back too, and checked with
+// the lines that complete the command. Control characters, which could
+// edit a line after it was checked, are refused, except for Ctrl-C, which
+// discards the unfinished line, and Ctrl-D at the start of a line. This
+// includes tab, with which the shell completes words. If anything is
+// rejected, nothing is written and the unfinished line is discarded.
+//
+// Otherwise input is written as is, after checking each line with
+// ValidateCommand. Only the session's owner can send it input.
+func (m *SessionManager) SendInput(id, owner, input string, newline bool, extra *Commander) error {
+	session, ok := m.Get(id, owner)
+	if !ok {
+		return fmt.Errorf("session not found: %s", id)
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if !session.running() {
+		return fmt.Errorf("session %s is no longer running", id)
+	}
+	session.lastUsed = time.Now()
+
+	if newline && !strings.HasSuffix(input, "\n") {
+		input += "\n"
+	}
+
+	validators := []*Commander{m.commander}
+	if extra != nil {
+		validators = append(validators, extra)
+	}
+	dir := session.currentDir()
+	validate := func(line string) error {
+		if line = strings.TrimSpace(line); line == "" {
+			return nil
+		}
+		for _, c := range validators {
+			if c.restrictsDirectories() && c.changesDirectory(line) {
+				return fmt.Errorf("command not allowed: '%s' - changing directory in a shell session is not allowed while the policy restricts directories", line)
+			}
+			if _, err := c.ValidateRequest(line, dir, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if !m.commander.restricted() && (extra == nil || !extra.restricted()) {
+		for _, line := range strings.Split(input, "\n") {
+			if err := validate(line); err != nil {
+				return err
+			}
+		}
+		return session.write(input)
+	}
+
+	out, pending, err := assembleInput(session.pending, input, validate)
+	if err != nil {
+		session.pending = ""
+		return err
+	}
+	if err := session.write(out); err != nil {
+		return err
+	}
+	session.pending = pending
+	return nil
+}
+
+// assembleInput appends input to the unfinished line pending and returns
+// what may be written to the shell: every completed command, each of which
+// validate accepted, and the allowed control characters. A command spans
+// several lines while a quote, here-document or backslash continuation is
+// open. The rest is returned as the new unfinished line.
+func assembleInput(pending, input string, validate func(line string) error) (out, rest string, err error) {
+	var b strings.Builder
+	line := pending
+	for i := 0; i < len(input); i++ {
+		switch c := input[i]; {
+		case c == '\n' || c == '\r':
+			// The terminal turns a carriage return into a newline, and the
+			// shell keeps reading while a quote, here-document or
+			// backslash continuation is open, so the lines are checked
+			// together once the command is complete
+			line += input[i : i+1]
+			logical := strings.ReplaceAll(line, "\r", "\n")
+			if needsMoreInput(logical) {
+				continue
+			}
+			if err := validate(logical); err != nil {
+				return "", "", err
+			}
+			b.WriteString(line)
+			line = ""
+		case c == ctrlC:
+			// The unfinished line was never written, so the shell only
+			// sees the interrupt
+			b.WriteByte(c)
+			line = ""
+		case c == ctrlD && line == "":
+			b.WriteByte(c)
+		case c == '\t':
+			return "", "", fmt.Errorf("input contains a tab, which the shell would use to complete words after the line was checked; it is not allowed while commands are restricted, so separate words with spaces")
+		case c < 0x20 || c == 0x7f:
+			return "", "", fmt.Errorf("input contains control character 0x%02x, which is not allowed while commands are restricted", c)
+		default:
+			line += input[i : i+1]
+		}
+	}
+	return b.String(), line, nil
+}
+
+const (
+	ctrlC = 0x03
+	ctrlD = 0x04
+)
+
+// currentDir returns the shell's current directory. Where it cannot be read
+// from /proc, the directory the shell started in is assumed.
+func (s *Session) currentDir() string {
+	if s.cmd.Process != nil {
+		if dir, err := os.Readlink(fmt.Sprintf("/proc/%d/cwd", s.cmd.Process.Pid)); err == nil {
+			return dir
+		}
+	}
+	if s.WorkDir != "" {
+		return s.WorkDir
+	}
+	dir, _ := os.Getwd()
+	return dir
+}
+
+// write sends input to the shell. s.mu must be held.
+func (s *Session) write(input string) error {
+	if input == "" {
+		return nil
+	}
+	if _, err := io.WriteString(s.input, input); err != nil {
+		return fmt.Errorf("failed to write to session: %w", err)
+	}
+	return nil
+}
+
+// Close terminates the session's shell and everything it started. Only the
+// session's owner can close it.
+func (m *SessionManager) Close(id, owner string) (*Session, error) {
+	if _, ok := m.Get(id, owner); !ok {
+		return nil, fmt.Errorf("session not found: %s", id)
+	}
+	session := m.close(id)
+	if session == nil {
+		return nil, fmt.Errorf("session not found: %s", id)
+	}
+	return session, nil
+}
+
+// close removes a session and terminates its shell, returning nil if there
+// is no such session
+func (m *SessionManager) close(id string) *Session {
+	m.mu.Lock()
+	session := m.sessions[id]
+	if session != nil {
+		delete(m.sessions, id)
+	}
+	m.mu.Unlock()
+
+	if session == nil {
+		return nil
+	}
+
+	session.mu.Lock()
+	session.closed = true
+	session.input.Close()
+	session.mu.Unlock()
+
+	killProcessGroup(session.cmd)
+	<-session.done
+	return session
+}
+
+// CloseAll closes every session and stops the idle reaper
+func (m *SessionManager) CloseAll() {
+	m.stopOnce.Do(func() { close(m.stop) })
+
+	m.mu.Lock()
+	ids := make([]string, 0, len(m.sessions))
+	for id, session := range m.sessions {
+		if session != nil {
+			ids = append(ids, id)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, id := range ids {
+		m.close(id)
+	}
+}
+
+// reapIdle periodically closes sessions that have been idle too long or
+// whose shell has exited and gone unread past the idle timeout
+func (m *SessionManager) reapIdle() {
+	interval := m.idleTimeout / 4
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+		}
+
+		m.mu.Lock()
+		var idle []string
+		for id, session := range m.sessions {
+			if session != nil && time.Since(session.idleSince()) > m.idleTimeout {
+				idle = append(idle, id)
+			}
+		}
+		m.mu.Unlock()
+
+		for _, id := range idle {
+			m.close(id)
+		}
+	}
+}
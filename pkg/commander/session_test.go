@@ -0,0 +1,343 @@
+package commander
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitForOutput polls a session until its output contains want
+func waitForOutput(t *testing.T, session *Session, want string) string {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		data, _, _ := session.Output(0, 0)
+		if strings.Contains(string(data), want) {
+			return string(data)
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %q in session output: %q", want, data)
+		}
+		session.WaitOutput(context.Background(), session.output.Total(), 100*time.Millisecond)
+	}
+}
+
+func TestSessionManager_StatePersists(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping session test on Windows - uses POSIX shell syntax")
+	}
+
+	manager := NewSessionManager(NewCommander(Config{}), 0, 0, 0)
+	defer manager.CloseAll()
+
+	session, err := manager.Open("/tmp", nil, "")
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	if session.PTY != ptySupported {
+		t.Errorf("Expected PTY=%v, got %v", ptySupported, session.PTY)
+	}
+
+	if err := manager.SendInput(session.ID, "", "export SESSION_VAR=persisted_value", true, nil); err != nil {
+		t.Fatalf("SendInput returned error: %v", err)
+	}
+	if err := manager.SendInput(session.ID, "", "cd / && echo \"marker:$SESSION_VAR:$(pwd)\"", true, nil); err != nil {
+		t.Fatalf("SendInput returned error: %v", err)
+	}
+
+	waitForOutput(t, session, "marker:persisted_value:/")
+}
+
+func TestSessionManager_ValidatesInput(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping session test on Windows - uses POSIX shell syntax")
+	}
+
+	manager := NewSessionManager(NewCommander(Config{
+		BlockedCommands: []string{"rm -rf"},
+	}), 0, 0, 0)
+	defer manager.CloseAll()
+
+	session, err := manager.Open("", nil, "")
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+
+	err = manager.SendInput(session.ID, "", "echo ok\nrm -rf /tmp/something", true, nil)
+	if err == nil || !strings.Contains(err.Error(), "blocked") {
+		t.Errorf("Expected blocked error, got %v", err)
+	}
+
+	if err := manager.SendInput("session-unknown", "", "echo hi", true, nil); err == nil {
+		t.Error("Expected error for unknown session")
+	}
+}
+
+func TestSessionManager_LimitAndClose(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping session test on Windows - uses POSIX shell syntax")
+	}
+
+	manager := NewSessionManager(NewCommander(Config{}), 1, 0, 0)
+	defer manager.CloseAll()
+
+	session, err := manager.Open("", nil, "")
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	if _, err := manager.Open("", nil, ""); err == nil {
+		t.Error("Expected error when session limit is reached")
+	}
+
+	if _, err := manager.Close(session.ID, ""); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+	if info := session.Info(); info.Running {
+		t.Error("Expected closed session to not be running")
+	}
+	if _, ok := manager.Get(session.ID, ""); ok {
+		t.Error("Expected closed session to be removed")
+	}
+
+	// The slot is free again
+	if _, err := manager.Open("", nil, ""); err != nil {
+		t.Errorf("Expected Open to succeed after Close, got %v", err)
+	}
+}
+
+func TestSessionManager_IdleTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping session test on Windows - uses POSIX shell syntax")
+	}
+
+	manager := NewSessionManager(NewCommander(Config{}), 0, 200*time.Millisecond, 0)
+	defer manager.CloseAll()
+
+	session, err := manager.Open("", nil, "")
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+
+	select {
+	case <-session.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Idle session was not closed")
+	}
+	if _, ok := manager.Get(session.ID, ""); ok {
+		t.Error("Expected idle session to be removed")
+	}
+}
+
+func TestAssembleInput(t *testing.T) {
+	validate := NewCommander(Config{BlockedCommands: []string{"shutdown"}}).ValidateCommand
+
+	tests := []struct {
+		name    string
+		pending string
+		input   string
+		out     string
+		rest    string
+		wantErr bool
+	}{
+		{"complete line", "", "echo hi\n", "echo hi\n", "", false},
+		{"partial line held back", "", "shut", "", "shut", false},
+		{"line completed across calls", "shut", "down\n", "", "", true},
+		{"partial after complete", "", "echo a\necho b", "echo a\n", "echo b", false},
+		{"carriage return ends a line", "ec", "ho\r", "echo\r", "", false},
+		{"ctrl-c discards the line", "shut", "\x03down\n", "\x03down\n", "", false},
+		{"ctrl-d at line start", "", "\x04", "\x04", "", false},
+		{"ctrl-d mid line", "cat", "\x04", "", "", true},
+		{"kill line", "shut", "\x15echo\n", "", "", true},
+		{"delete", "", "shutdownx\x7f\n", "", "", true},
+		{"tab", "", "shutd\t\n", "", "", true},
+		{"escape", "", "\x1b[D\n", "", "", true},
+		{"unicode", "", "echo héllo\n", "echo héllo\n", "", false},
+		{"continuation held back", "", "echo a\\\n", "", "echo a\\\n", false},
+		{"continuation completed", "", "echo a\\\nb\n", "echo a\\\nb\n", "", false},
+		{"continuation checked as one command", "shut\\\n", "down\n", "", "", true},
+		{"continuation with carriage returns", "", "shut\\\rdown\r", "", "", true},
+		{"open quote held back", "", "echo 'a\n", "", "echo 'a\n", false},
+		{"quote closed", "echo 'a\n", "b'\n", "echo 'a\nb'\n", "", false},
+		{"here-document held back", "", "cat <<EOF\nshutdown\n", "", "cat <<EOF\nshutdown\n", false},
+		{"here-document closed", "cat <<EOF\nshutdown\n", "EOF\n", "cat <<EOF\nshutdown\nEOF\n", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, rest, err := assembleInput(tt.pending, tt.input, validate)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if out != tt.out || rest != tt.rest {
+				t.Errorf("Expected %q and pending %q, got %q and %q", tt.out, tt.rest, out, rest)
+			}
+		})
+	}
+}
+
+func TestSessionManager_SplitInput(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping session test on Windows - uses POSIX shell syntax")
+	}
+
+	manager := NewSessionManager(NewCommander(Config{
+		BlockedCommands: DefaultBlockedCommands(),
+	}), 0, 0, 0)
+	defer manager.CloseAll()
+
+	session, err := manager.Open("", nil, "")
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+
+	// A blocked command split across calls is checked as a whole
+	if err := manager.SendInput(session.ID, "", "shut", false, nil); err != nil {
+		t.Fatalf("Expected a partial line to be held back, got %v", err)
+	}
+	if pending := session.Info().PendingInput; pending != "shut" {
+		t.Errorf("Expected pending input %q, got %q", "shut", pending)
+	}
+	if err := manager.SendInput(session.ID, "", "down", true, nil); err == nil || !strings.Contains(err.Error(), "blocked") {
+		t.Errorf("Expected the assembled line to be blocked, got %v", err)
+	}
+	if pending := session.Info().PendingInput; pending != "" {
+		t.Errorf("Expected a rejected line to be discarded, got %q", pending)
+	}
+
+	// Line editing characters could change a line after it was checked
+	if err := manager.SendInput(session.ID, "", "shutdownx\x7f", true, nil); err == nil {
+		t.Error("Expected control characters to be refused")
+	}
+
+	// Allowed input split across calls still runs
+	if err := manager.SendInput(session.ID, "", "echo split", false, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.SendInput(session.ID, "", "_marker", true, nil); err != nil {
+		t.Fatal(err)
+	}
+	waitForOutput(t, session, "split_marker")
+
+	// The identity's policy applies to the assembled line too
+	extra := NewCommander(Config{BlockedCommands: []string{"echo forbidden"}})
+	manager.SendInput(session.ID, "", "echo forb", false, extra)
+	if err := manager.SendInput(session.ID, "", "idden", true, extra); err == nil {
+		t.Error("Expected the extra commander to block the assembled line")
+	}
+}
+
+func TestSessionManager_LineContinuation(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping session test on Windows - uses POSIX shell syntax")
+	}
+
+	manager := NewSessionManager(NewCommander(Config{
+		BlockedCommands: []string{"touch"},
+	}), 0, 0, 0)
+	defer manager.CloseAll()
+
+	session, err := manager.Open("", nil, "")
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+
+	// The shell joins lines ending in a backslash into one command
+	target := filepath.Join(t.TempDir(), "x")
+	if err := manager.SendInput(session.ID, "", "tou\\\nch "+target, true, nil); err == nil || !strings.Contains(err.Error(), "blocked") {
+		t.Errorf("Expected the continued command to be blocked, got %v", err)
+	}
+	if err := manager.SendInput(session.ID, "", "tou\\", true, nil); err != nil {
+		t.Fatalf("Expected a continued line to be held back, got %v", err)
+	}
+	if err := manager.SendInput(session.ID, "", "ch "+target, true, nil); err == nil || !strings.Contains(err.Error(), "blocked") {
+		t.Errorf("Expected the command continued across calls to be blocked, got %v", err)
+	}
+
+	if err := manager.SendInput(session.ID, "", "echo done_\\\nmarker", true, nil); err != nil {
+		t.Fatal(err)
+	}
+	waitForOutput(t, session, "done_marker")
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Errorf("Expected %s not to be created, got %v", target, err)
+	}
+}
+
+func TestSessionManager_DirectoryRules(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping session test on Windows - uses POSIX shell syntax")
+	}
+
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := &Policy{WorkingDirectories: []string{dir}}
+	if err := policy.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	manager := NewSessionManager(NewCommander(Config{Policy: policy}), 0, 0, 0)
+	defer manager.CloseAll()
+
+	session, err := manager.Open(dir, nil, "")
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	if got := session.currentDir(); got != dir {
+		t.Errorf("Expected the current directory %q, got %q", dir, got)
+	}
+
+	// Leaving the allowed directories would escape the policy
+	for _, input := range []string{"cd /etc", "builtin cd /", "pushd /etc", "echo x && cd .."} {
+		if err := manager.SendInput(session.ID, "", input, true, nil); err == nil || !strings.Contains(err.Error(), "changing directory") {
+			t.Errorf("Expected %q to be refused, got %v", input, err)
+		}
+	}
+
+	if err := manager.SendInput(session.ID, "", "echo still_$((40+2))", true, nil); err != nil {
+		t.Fatal(err)
+	}
+	waitForOutput(t, session, "still_42")
+}
+
+func TestSessionManager_Owner(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping session test on Windows - uses POSIX shell syntax")
+	}
+
+	manager := NewSessionManager(NewCommander(Config{}), 0, 0, 0)
+	defer manager.CloseAll()
+
+	session, err := manager.Open("", nil, "alice")
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+
+	// Other identities cannot use the session
+	if _, ok := manager.Get(session.ID, "bob"); ok {
+		t.Error("Expected the session to be hidden from another identity")
+	}
+	if sessions := manager.List("bob"); len(sessions) != 0 {
+		t.Errorf("Expected no sessions listed for another identity, got %+v", sessions)
+	}
+	if err := manager.SendInput(session.ID, "bob", "echo hi", true, nil); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("Expected input from another identity to fail as not found, got %v", err)
+	}
+	if _, err := manager.Close(session.ID, "bob"); err == nil {
+		t.Error("Expected closing another identity's session to fail")
+	}
+	if info := session.Info(); !info.Running || info.Owner != "alice" {
+		t.Errorf("Expected the session to keep running for alice, got %+v", info)
+	}
+
+	if sessions := manager.List("alice"); len(sessions) != 1 {
+		t.Errorf("Expected the owner's session to be listed, got %+v", sessions)
+	}
+	if _, err := manager.Close(session.ID, "alice"); err != nil {
+		t.Errorf("Expected the owner to close the session, got %v", err)
+	}
+}
@@ -0,0 +1,898 @@
+package commander
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Word is a single shell word after quote removal
+type Word struct {
+	// Value is the word's text with quotes and escapes removed. Expansions
+	// are kept in their source form (e.g. "$HOME").
+	Value string
+	// Dynamic is true if the word contains parameter, command or arithmetic
+	// expansion, so its runtime value cannot be known statically
+	Dynamic bool
+	// Quoted is true if any part of the word was quoted or escaped
+	Quoted bool
+}
+
+// Redirect is an I/O redirection attached to a simple command
+type Redirect struct {
+	// Op is the redirection operator, e.g. ">", ">>", "<", "2>", "&>"
+	Op string
+	// Target is the file, descriptor or here-document delimiter
+	Target Word
+}
+
+// SimpleCommand is one command in a parsed shell script: a program name with
+// arguments, variable assignments and redirections
+type SimpleCommand struct {
+	// Text is the source text of the command
+	Text string
+	// Args holds the command name followed by its arguments
+	Args []Word
+	// Assignments holds leading NAME=value words
+	Assignments []string
+	// Redirects holds the command's redirections in source order
+	Redirects []Redirect
+	// Context describes where the command appeared, e.g. "subshell" or
+	// "command substitution"; empty for top-level commands
+	Context string
+}
+
+// Name returns the command name, or "" for commands that only assign
+// variables or redirect
+func (c SimpleCommand) Name() string {
+	if len(c.Args) == 0 {
+		return ""
+	}
+	return c.Args[0].Value
+}
+
+// ParseShell parses a POSIX shell script and returns every simple command in
+// it, including those nested in pipelines, lists, subshells, brace groups,
+// function bodies, coprocesses, command and process substitutions, and
+// here-documents. Constructs it cannot
+// analyse reliably, such as case statements, are reported as errors so that
+// callers can fail closed.
+func ParseShell(script string) ([]SimpleCommand, error) {
+	p := &shellParser{src: script}
+	if err := p.parseList(0); err != nil {
+		return nil, err
+	}
+	return p.commands, nil
+}
+
+type heredoc struct {
+	delimiter string
+	stripTabs bool
+	expand    bool
+}
+
+type shellParser struct {
+	src      string
+	pos      int
+	context  string
+	commands []SimpleCommand
+	heredocs []heredoc
+	// incomplete is set when the input ends inside a quote, substitution,
+	// here-document or line continuation
+	incomplete bool
+}
+
+// needsMoreInput reports whether a shell reading script would wait for
+// further lines before running it: the script ends with a backslash-newline,
+// or inside a quote, a substitution or a here-document
+func needsMoreInput(script string) bool {
+	p := &shellParser{src: script}
+	p.parseList(0)
+	return p.incomplete
+}
+
+func (p *shellParser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *shellParser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *shellParser) peekAt(offset int) byte {
+	if p.pos+offset >= len(p.src) {
+		return 0
+	}
+	return p.src[p.pos+offset]
+}
+
+func (p *shellParser) hasPrefix(s string) bool {
+	return strings.HasPrefix(p.src[p.pos:], s)
+}
+
+func (p *shellParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("at offset %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+// incompletef is errorf for input that ends before a construct is closed
+func (p *shellParser) incompletef(format string, args ...interface{}) error {
+	p.incomplete = true
+	return p.errorf(format, args...)
+}
+
+// skipBlanks skips spaces, tabs and backslash-newline continuations
+func (p *shellParser) skipBlanks() {
+	for !p.eof() {
+		switch {
+		case p.peek() == ' ' || p.peek() == '\t' || p.peek() == '\r':
+			p.pos++
+		case p.hasPrefix("\\\n"):
+			p.pos += 2
+			p.incomplete = p.eof()
+		default:
+			return
+		}
+	}
+}
+
+func (p *shellParser) skipComment() {
+	for !p.eof() && p.peek() != '\n' {
+		p.pos++
+	}
+}
+
+// parseList parses commands until end of input or the terminator byte
+// (')' for subshells and command substitutions), which is consumed
+func (p *shellParser) parseList(terminator byte) error {
+	for {
+		p.skipBlanks()
+		if p.eof() {
+			if terminator != 0 {
+				return p.incompletef("missing closing '%c'", terminator)
+			}
+			if len(p.heredocs) > 0 {
+				return p.incompletef("here-document delimited by %q is not terminated", p.heredocs[0].delimiter)
+			}
+			return nil
+		}
+
+		c := p.peek()
+		switch {
+		case terminator != 0 && c == terminator:
+			p.pos++
+			return nil
+		case c == ')':
+			return p.errorf("unexpected ')'")
+		case c == '#':
+			p.skipComment()
+		case c == '\n':
+			p.pos++
+			if err := p.readHeredocs(); err != nil {
+				return err
+			}
+		case p.hasPrefix(";;"):
+			return p.errorf("case statements are not supported")
+		case c == ';' || c == '|' || (c == '&' && p.peekAt(1) != '>'):
+			// Separators and pipeline/list operators: ; & | && || |&
+			p.pos++
+			if (c == '&' || c == '|') && (p.peek() == c || (c == '|' && p.peek() == '&')) {
+				p.pos++
+			}
+		case c == '(':
+			p.pos++
+			if err := p.nested("subshell", func() error { return p.parseList(')') }); err != nil {
+				return err
+			}
+		default:
+			if err := p.parseSimpleCommand(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// nested runs fn with the parser's context set to ctx
+func (p *shellParser) nested(ctx string, fn func() error) error {
+	saved := p.context
+	p.context = ctx
+	defer func() { p.context = saved }()
+	return fn()
+}
+
+// reservedWords are skipped when they appear in command position, so the
+// commands inside if/while/until bodies and brace groups are still checked
+var reservedWords = map[string]bool{
+	"if": true, "then": true, "else": true, "elif": true, "fi": true,
+	"while": true, "until": true, "do": true, "done": true,
+	"{": true, "}": true, "!": true,
+}
+
+// compoundWords start a compound command, which after "coproc NAME" means
+// NAME names the coprocess rather than being its command
+var compoundWords = map[string]bool{
+	"{": true, "if": true, "while": true, "until": true, "for": true, "select": true, "case": true,
+}
+
+func (p *shellParser) parseSimpleCommand() error {
+	start := p.pos
+	cmd := SimpleCommand{Context: p.context}
+	coproc := false
+
+	for {
+		p.skipBlanks()
+		if p.eof() {
+			break
+		}
+
+		c := p.peek()
+		if c == '\n' || c == ';' || c == '|' || c == ')' || (c == '&' && p.peekAt(1) != '>') {
+			break
+		}
+		if c == '#' {
+			p.skipComment()
+			break
+		}
+		if c == '(' {
+			// name() { body; } defines a function and coproc NAME ( list )
+			// starts a subshell; either body is parsed as ordinary commands
+			// afterwards
+			if coproc && len(cmd.Args) == 1 && len(cmd.Redirects) == 0 {
+				return nil
+			}
+			if len(cmd.Args) == 1 && len(cmd.Redirects) == 0 {
+				p.pos++
+				p.skipBlanks()
+				if p.peek() != ')' {
+					return p.errorf("unexpected '(' after %q", cmd.Args[0].Value)
+				}
+				p.pos++
+				return nil
+			}
+			return p.errorf("unexpected '('")
+		}
+
+		if op := p.redirectOperator(); op != "" {
+			if err := p.parseRedirect(&cmd, op); err != nil {
+				return err
+			}
+			continue
+		}
+
+		word, err := p.readWord()
+		if err != nil {
+			return err
+		}
+
+		if coproc && len(cmd.Args) == 1 && len(cmd.Assignments) == 0 && !word.Quoted && compoundWords[word.Value] {
+			cmd.Args = nil
+		}
+		if len(cmd.Args) == 0 && !word.Quoted {
+			if isAssignment(word.Value) {
+				cmd.Assignments = append(cmd.Assignments, word.Value)
+				continue
+			}
+			if reservedWords[word.Value] {
+				continue
+			}
+			switch word.Value {
+			case "coproc":
+				coproc = true
+				continue
+			case "function":
+				return p.parseFunctionHeader()
+			case "for", "select":
+				return p.parseForHeader()
+			case "case":
+				return p.errorf("case statements are not supported")
+			}
+		}
+		cmd.Args = append(cmd.Args, word)
+	}
+
+	cmd.Text = strings.TrimSpace(p.src[start:p.pos])
+	if len(cmd.Args) > 0 || len(cmd.Redirects) > 0 {
+		p.commands = append(p.commands, cmd)
+	}
+	return nil
+}
+
+// parseForHeader consumes "NAME in words..." up to the separator before
+// "do". Command substitutions in the word list are still collected.
+func (p *shellParser) parseForHeader() error {
+	for {
+		p.skipBlanks()
+		if p.eof() || p.peek() == '\n' || p.peek() == ';' {
+			return nil
+		}
+		word, err := p.readWord()
+		if err != nil {
+			return err
+		}
+		if word.Value == "" && !word.Quoted {
+			return p.errorf("unexpected %q in for loop", string(p.peek()))
+		}
+		if word.Value == "do" && !word.Quoted {
+			return nil
+		}
+	}
+}
+
+// parseFunctionHeader consumes the name and optional "()" of a function
+// defined with the function keyword. The body that follows is parsed as
+// ordinary commands.
+func (p *shellParser) parseFunctionHeader() error {
+	p.skipBlanks()
+	name, err := p.readWord()
+	if err != nil {
+		return err
+	}
+	if name.Value == "" {
+		return p.errorf("missing function name")
+	}
+	p.skipBlanks()
+	if p.peek() == '(' {
+		p.pos++
+		p.skipBlanks()
+		if p.peek() != ')' {
+			return p.errorf("unexpected '(' after function %q", name.Value)
+		}
+		p.pos++
+	}
+	return nil
+}
+
+func isAssignment(word string) bool {
+	eq := strings.IndexByte(word, '=')
+	if eq <= 0 {
+		return false
+	}
+	for i := 0; i < eq; i++ {
+		c := word[i]
+		if !(c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (i > 0 && c >= '0' && c <= '9')) {
+			return false
+		}
+	}
+	return true
+}
+
+// redirectOperators are checked longest first
+var redirectOperators = []string{"&>>", "<<<", "<<-", "&>", "<<", "<>", "<&", ">>", ">&", ">|", "<", ">"}
+
+// redirectOperator returns the redirection operator (with any leading file
+// descriptor number) at the current position, or "" if there is none.
+// Process substitutions <( and >( are words, not redirections.
+func (p *shellParser) redirectOperator() string {
+	i := p.pos
+	for i < len(p.src) && p.src[i] >= '0' && p.src[i] <= '9' {
+		i++
+	}
+	rest := p.src[i:]
+	if strings.HasPrefix(rest, "<(") || strings.HasPrefix(rest, ">(") {
+		return ""
+	}
+	for _, op := range redirectOperators {
+		if strings.HasPrefix(rest, op) {
+			if i > p.pos && op[0] == '&' {
+				return ""
+			}
+			return p.src[p.pos:i] + op
+		}
+	}
+	return ""
+}
+
+func (p *shellParser) parseRedirect(cmd *SimpleCommand, op string) error {
+	p.pos += len(op)
+	p.skipBlanks()
+
+	target, err := p.readWord()
+	if err != nil {
+		return err
+	}
+	if target.Value == "" && !target.Quoted {
+		return p.errorf("missing target for redirection %q", op)
+	}
+	cmd.Redirects = append(cmd.Redirects, Redirect{Op: op, Target: target})
+
+	bare := strings.TrimLeft(op, "0123456789")
+	if bare == "<<" || bare == "<<-" {
+		p.heredocs = append(p.heredocs, heredoc{
+			delimiter: target.Value,
+			stripTabs: bare == "<<-",
+			expand:    !target.Quoted,
+		})
+	}
+	return nil
+}
+
+// readHeredocs consumes the bodies of pending here-documents, which start on
+// the line after their redirection. Unquoted delimiters mean the body is
+// subject to expansion, so any command substitutions in it are collected.
+func (p *shellParser) readHeredocs() error {
+	pending := p.heredocs
+	p.heredocs = nil
+
+	for _, doc := range pending {
+		var body strings.Builder
+		for {
+			if p.eof() {
+				return p.incompletef("here-document delimited by %q is not terminated", doc.delimiter)
+			}
+			end := strings.IndexByte(p.src[p.pos:], '\n')
+			var line string
+			if end < 0 {
+				line = p.src[p.pos:]
+				p.pos = len(p.src)
+			} else {
+				line = p.src[p.pos : p.pos+end]
+				p.pos += end + 1
+			}
+			if doc.stripTabs {
+				line = strings.TrimLeft(line, "\t")
+			}
+			if line == doc.delimiter {
+				break
+			}
+			body.WriteString(line)
+			body.WriteByte('\n')
+		}
+
+		if doc.expand {
+			sub := &shellParser{src: body.String(), context: "here-document"}
+			if err := sub.scanExpansions(); err != nil {
+				return err
+			}
+			p.commands = append(p.commands, sub.commands...)
+		}
+	}
+	return nil
+}
+
+// scanExpansions walks text that undergoes expansion but not word splitting
+// (here-document bodies), collecting commands from substitutions
+func (p *shellParser) scanExpansions() error {
+	var discard strings.Builder
+	for !p.eof() {
+		switch p.peek() {
+		case '\\':
+			p.pos += 2
+		case '$':
+			if _, err := p.readDollar(&discard); err != nil {
+				return err
+			}
+		case '`':
+			if err := p.readBackticks(); err != nil {
+				return err
+			}
+		default:
+			p.pos++
+		}
+	}
+	return nil
+}
+
+// readWord reads one word, performing quote removal. Expansions are kept in
+// source form and mark the word dynamic; commands inside command
+// substitutions are collected as they are encountered.
+func (p *shellParser) readWord() (Word, error) {
+	var word Word
+	var b strings.Builder
+
+	for !p.eof() {
+		c := p.peek()
+		switch c {
+		case ' ', '\t', '\r', '\n', ';', '&', '|', ')':
+			word.Value = b.String()
+			return word, nil
+		case '<', '>':
+			if p.peekAt(1) != '(' {
+				word.Value = b.String()
+				return word, nil
+			}
+			// Process substitution <(...) or >(...)
+			b.WriteString(p.src[p.pos : p.pos+2])
+			p.pos += 2
+			if err := p.nested("process substitution", func() error { return p.parseList(')') }); err != nil {
+				return word, err
+			}
+			b.WriteByte(')')
+			word.Dynamic = true
+		case '(':
+			word.Value = b.String()
+			return word, nil
+		case '\\':
+			word.Quoted = true
+			p.pos++
+			if p.eof() {
+				break
+			}
+			if p.peek() == '\n' {
+				p.pos++
+				p.incomplete = p.eof()
+				continue
+			}
+			b.WriteByte(p.peek())
+			p.pos++
+		case '\'':
+			word.Quoted = true
+			end := strings.IndexByte(p.src[p.pos+1:], '\'')
+			if end < 0 {
+				return word, p.incompletef("unterminated single quote")
+			}
+			b.WriteString(p.src[p.pos+1 : p.pos+1+end])
+			p.pos += end + 2
+		case '"':
+			word.Quoted = true
+			dynamic, err := p.readDoubleQuoted(&b)
+			if err != nil {
+				return word, err
+			}
+			word.Dynamic = word.Dynamic || dynamic
+		case '$':
+			if p.peekAt(1) == '\'' {
+				word.Quoted = true
+				if err := p.readANSIQuoted(&b); err != nil {
+					return word, err
+				}
+				continue
+			}
+			dynamic, err := p.readDollar(&b)
+			if err != nil {
+				return word, err
+			}
+			word.Dynamic = word.Dynamic || dynamic
+		case '`':
+			start := p.pos
+			if err := p.readBackticks(); err != nil {
+				return word, err
+			}
+			b.WriteString(p.src[start:p.pos])
+			word.Dynamic = true
+		case '*', '?', '[', '{':
+			// Unquoted glob and brace expansion characters mean the word
+			// may expand to something else, e.g. {rm,-rf,~}
+			if c != '{' || strings.ContainsAny(p.src[p.pos:], ",.") {
+				word.Dynamic = true
+			}
+			b.WriteByte(c)
+			p.pos++
+		default:
+			b.WriteByte(c)
+			p.pos++
+		}
+	}
+
+	word.Value = b.String()
+	return word, nil
+}
+
+// readDoubleQuoted reads a "..." string starting at the opening quote
+func (p *shellParser) readDoubleQuoted(b *strings.Builder) (bool, error) {
+	dynamic := false
+	p.pos++
+	for {
+		if p.eof() {
+			return dynamic, p.incompletef("unterminated double quote")
+		}
+		c := p.peek()
+		switch c {
+		case '"':
+			p.pos++
+			return dynamic, nil
+		case '\\':
+			next := p.peekAt(1)
+			switch next {
+			case '$', '`', '"', '\\':
+				b.WriteByte(next)
+				p.pos += 2
+			case '\n':
+				p.pos += 2
+			default:
+				b.WriteByte('\\')
+				p.pos++
+			}
+		case '$':
+			d, err := p.readDollar(b)
+			if err != nil {
+				return dynamic, err
+			}
+			dynamic = dynamic || d
+		case '`':
+			start := p.pos
+			if err := p.readBackticks(); err != nil {
+				return dynamic, err
+			}
+			b.WriteString(p.src[start:p.pos])
+			dynamic = true
+		default:
+			b.WriteByte(c)
+			p.pos++
+		}
+	}
+}
+
+// readDollar reads an expansion starting at '$', writing its source text to b.
+// It reports whether the text is a real expansion (a lone '$' is literal).
+func (p *shellParser) readDollar(b *strings.Builder) (bool, error) {
+	start := p.pos
+	switch next := p.peekAt(1); {
+	case p.hasPrefix("$(("):
+		p.pos += 3
+		if err := p.skipBalanced('(', ')', 2); err != nil {
+			return true, err
+		}
+	case next == '(':
+		p.pos += 2
+		if err := p.nested("command substitution", func() error { return p.parseList(')') }); err != nil {
+			return true, err
+		}
+	case next == '{':
+		p.pos += 2
+		if err := p.skipBalanced('{', '}', 1); err != nil {
+			return true, err
+		}
+	case next == '_' || (next >= 'a' && next <= 'z') || (next >= 'A' && next <= 'Z'):
+		p.pos++
+		for !p.eof() {
+			c := p.peek()
+			if c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') {
+				p.pos++
+				continue
+			}
+			break
+		}
+	case next != 0 && strings.IndexByte("0123456789@*#?$!-", next) >= 0:
+		p.pos += 2
+	default:
+		b.WriteByte('$')
+		p.pos++
+		return false, nil
+	}
+	b.WriteString(p.src[start:p.pos])
+	return true, nil
+}
+
+// skipBalanced skips to the point where depth closing delimiters have been
+// consumed, following quotes and collecting commands from any nested
+// command substitutions
+func (p *shellParser) skipBalanced(open, close byte, depth int) error {
+	var discard strings.Builder
+	for depth > 0 {
+		if p.eof() {
+			return p.incompletef("missing closing '%c'", close)
+		}
+		c := p.peek()
+		switch {
+		case c == '\\':
+			p.pos += 2
+		case c == '\'':
+			end := strings.IndexByte(p.src[p.pos+1:], '\'')
+			if end < 0 {
+				return p.incompletef("unterminated single quote")
+			}
+			p.pos += end + 2
+		case c == '"':
+			if _, err := p.readDoubleQuoted(&discard); err != nil {
+				return err
+			}
+		case c == '$' && p.peekAt(1) == '(' && p.peekAt(2) != '(':
+			if _, err := p.readDollar(&discard); err != nil {
+				return err
+			}
+		case c == '`':
+			if err := p.readBackticks(); err != nil {
+				return err
+			}
+		case c == open:
+			depth++
+			p.pos++
+		case c == close:
+			depth--
+			p.pos++
+		default:
+			p.pos++
+		}
+	}
+	return nil
+}
+
+// readBackticks parses a `...` command substitution
+func (p *shellParser) readBackticks() error {
+	p.pos++
+	var inner strings.Builder
+	for {
+		if p.eof() {
+			return p.incompletef("unterminated backquote")
+		}
+		c := p.peek()
+		if c == '`' {
+			p.pos++
+			break
+		}
+		if c == '\\' && strings.IndexByte("$`\\", p.peekAt(1)) >= 0 && p.peekAt(1) != 0 {
+			inner.WriteByte(p.peekAt(1))
+			p.pos += 2
+			continue
+		}
+		inner.WriteByte(c)
+		p.pos++
+	}
+
+	sub := &shellParser{src: inner.String(), context: "command substitution"}
+	if err := sub.parseList(0); err != nil {
+		return err
+	}
+	p.commands = append(p.commands, sub.commands...)
+	return nil
+}
+
+// readANSIQuoted decodes a bash $'...' string so that escaped command names
+// such as $'\x72\x6d' are seen as the text they produce
+func (p *shellParser) readANSIQuoted(b *strings.Builder) error {
+	p.pos += 2
+	for {
+		if p.eof() {
+			return p.incompletef("unterminated $'...' string")
+		}
+		c := p.peek()
+		if c == '\'' {
+			p.pos++
+			return nil
+		}
+		if c != '\\' {
+			b.WriteByte(c)
+			p.pos++
+			continue
+		}
+
+		p.pos++
+		esc := p.peek()
+		p.pos++
+		switch esc {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'r':
+			b.WriteByte('\r')
+		case 'a':
+			b.WriteByte('\a')
+		case 'b':
+			b.WriteByte('\b')
+		case 'e', 'E':
+			b.WriteByte(0x1b)
+		case 'f':
+			b.WriteByte('\f')
+		case 'v':
+			b.WriteByte('\v')
+		case 'x':
+			b.WriteByte(p.readNumericEscape(16, 2))
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			p.pos--
+			b.WriteByte(p.readNumericEscape(8, 3))
+		default:
+			b.WriteByte(esc)
+		}
+	}
+}
+
+func (p *shellParser) readNumericEscape(base, maxDigits int) byte {
+	start := p.pos
+	for p.pos < len(p.src) && p.pos-start < maxDigits {
+		c := p.peek()
+		isDigit := c >= '0' && c <= '7' ||
+			base == 16 && (c >= '8' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F')
+		if !isDigit {
+			break
+		}
+		p.pos++
+	}
+	n, _ := strconv.ParseUint(p.src[start:p.pos], base, 8)
+	return byte(n)
+}
+
+// ParseCmd splits a Windows cmd.exe or PowerShell command line into simple
+// commands. Only double quotes group words and "^" escapes the next
+// character; "&", "&&", "||", "|" and ";" separate commands. Variable
+// references (%VAR%, !VAR!, $var) and parentheses mark words as dynamic, and
+// redirections are recorded like their POSIX counterparts.
+func ParseCmd(script string) ([]SimpleCommand, error) {
+	var commands []SimpleCommand
+	var cmd SimpleCommand
+	var word strings.Builder
+	inWord := false
+	var current Word
+	start := 0
+	pendingRedirect := ""
+
+	flushWord := func() {
+		if !inWord {
+			return
+		}
+		current.Value = word.String()
+		if pendingRedirect != "" {
+			cmd.Redirects = append(cmd.Redirects, Redirect{Op: pendingRedirect, Target: current})
+			pendingRedirect = ""
+		} else {
+			cmd.Args = append(cmd.Args, current)
+		}
+		word.Reset()
+		current = Word{}
+		inWord = false
+	}
+	flushCommand := func(end int) {
+		flushWord()
+		cmd.Text = strings.TrimSpace(script[start:end])
+		if len(cmd.Args) > 0 || len(cmd.Redirects) > 0 {
+			commands = append(commands, cmd)
+		}
+		cmd = SimpleCommand{}
+	}
+
+	for i := 0; i < len(script); i++ {
+		c := script[i]
+		switch {
+		case c == '^' && i+1 < len(script):
+			i++
+			word.WriteByte(script[i])
+			current.Quoted = true
+			inWord = true
+		case c == '"':
+			end := strings.IndexByte(script[i+1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quote at offset %d", i)
+			}
+			quoted := script[i+1 : i+1+end]
+			if strings.ContainsAny(quoted, "%!$") {
+				current.Dynamic = true
+			}
+			word.WriteString(quoted)
+			current.Quoted = true
+			inWord = true
+			i += end + 1
+		case c == '&' || c == '|' || c == ';' || c == '\n':
+			if pendingRedirect != "" && !inWord {
+				return nil, fmt.Errorf("missing redirection target at offset %d", i)
+			}
+			flushCommand(i)
+			if i+1 < len(script) && (script[i+1] == c) && c != ';' && c != '\n' {
+				i++
+			}
+			start = i + 1
+		case c == '>' || c == '<':
+			op := string(c)
+			if inWord && word.Len() == 1 && strings.ContainsRune("0123456789", rune(word.String()[0])) {
+				op = word.String() + op
+				word.Reset()
+				inWord = false
+			} else {
+				flushWord()
+			}
+			if c == '>' && i+1 < len(script) && script[i+1] == '>' {
+				op += ">"
+				i++
+			}
+			if i+1 < len(script) && script[i+1] == '&' {
+				op += "&"
+				i++
+			}
+			pendingRedirect = op
+		case c == ' ' || c == '\t' || c == '\r':
+			flushWord()
+		default:
+			if c == '%' || c == '!' || c == '$' || c == '(' || c == ')' || c == '*' || c == '?' {
+				current.Dynamic = true
+			}
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	flushCommand(len(script))
+	if pendingRedirect != "" {
+		return nil, fmt.Errorf("missing redirection target")
+	}
+	return commands, nil
+}
@@ -0,0 +1,131 @@
+package commander
+
+import (
+	"reflect"
+	"testing"
+)
+
+func commandNames(commands []SimpleCommand) []string {
+	names := make([]string, 0, len(commands))
+	for _, c := range commands {
+		names = append(names, c.Name())
+	}
+	return names
+}
+
+func TestParseShell_Commands(t *testing.T) {
+	tests := []struct {
+		script string
+		want   []string
+	}{
+		{"ls -la", []string{"ls"}},
+		{"ls; rm -rf ~", []string{"ls", "rm"}},
+		{"cat a | grep b && echo ok || echo fail &", []string{"cat", "grep", "echo", "echo"}},
+		{"(cd /tmp && rm x)", []string{"cd", "rm"}},
+		{"{ echo a; echo b; }", []string{"echo", "echo"}},
+		{"echo $(whoami) `id`", []string{"whoami", "id", "echo"}},
+		{"diff <(ls a) <(ls b)", []string{"ls", "ls", "diff"}},
+		{"FOO=bar env", []string{"env"}},
+		{"if true; then rm x; fi", []string{"true", "rm"}},
+		{"for f in $(ls); do echo $f; done", []string{"ls", "echo"}},
+		{"echo 'a; rm -rf /'", []string{"echo"}},
+		{"echo a # ; rm x", []string{"echo"}},
+		{"cat <<EOF\n$(rm x)\nEOF", []string{"cat", "rm"}},
+		{"cat <<'EOF'\n$(rm x)\nEOF", []string{"cat"}},
+		{"f() { rm x; }; f", []string{"rm", "f"}},
+		{"function f { rm x; }; f", []string{"rm", "f"}},
+		{"function f() { rm x; }", []string{"rm"}},
+		{"function f\n{\n rm x\n}", []string{"rm"}},
+		{"coproc rm x", []string{"rm"}},
+		{"coproc worker { rm x; }", []string{"rm"}},
+		{"coproc worker ( rm x )", []string{"rm"}},
+		{"coproc while true; do rm x; done", []string{"true", "rm"}},
+	}
+
+	for _, tt := range tests {
+		commands, err := ParseShell(tt.script)
+		if err != nil {
+			t.Errorf("ParseShell(%q) returned error: %v", tt.script, err)
+			continue
+		}
+		if got := commandNames(commands); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseShell(%q) = %v, want %v", tt.script, got, tt.want)
+		}
+	}
+}
+
+func TestParseShell_Words(t *testing.T) {
+	commands, err := ParseShell(`rm -rf "my dir" 'x y' a\ b $'\x41' $HOME > out.txt 2>&1`)
+	if err != nil {
+		t.Fatalf("ParseShell returned error: %v", err)
+	}
+	if len(commands) != 1 {
+		t.Fatalf("Expected 1 command, got %d", len(commands))
+	}
+
+	cmd := commands[0]
+	want := []string{"rm", "-rf", "my dir", "x y", "a b", "A", "$HOME"}
+	if got := wordValues(cmd.Args); !reflect.DeepEqual(got, want) {
+		t.Errorf("Args = %v, want %v", got, want)
+	}
+	if !cmd.Args[6].Dynamic {
+		t.Error("Expected $HOME to be dynamic")
+	}
+	if len(cmd.Redirects) != 2 || cmd.Redirects[0].Op != ">" || cmd.Redirects[0].Target.Value != "out.txt" {
+		t.Errorf("Unexpected redirects: %+v", cmd.Redirects)
+	}
+}
+
+func TestParseShell_Context(t *testing.T) {
+	commands, err := ParseShell("echo $(rm x) && (ls)")
+	if err != nil {
+		t.Fatalf("ParseShell returned error: %v", err)
+	}
+	contexts := map[string]string{}
+	for _, c := range commands {
+		contexts[c.Name()] = c.Context
+	}
+	if contexts["echo"] != "" {
+		t.Errorf("Expected top-level context for echo, got %q", contexts["echo"])
+	}
+	if contexts["rm"] != "command substitution" {
+		t.Errorf("Expected command substitution context for rm, got %q", contexts["rm"])
+	}
+	if contexts["ls"] != "subshell" {
+		t.Errorf("Expected subshell context for ls, got %q", contexts["ls"])
+	}
+}
+
+func TestParseShell_Errors(t *testing.T) {
+	scripts := []string{
+		"echo 'unterminated",
+		`echo "unterminated`,
+		"echo $(ls",
+		"case x in x) rm y;; esac",
+		"echo )",
+	}
+	for _, script := range scripts {
+		if _, err := ParseShell(script); err == nil {
+			t.Errorf("Expected ParseShell(%q) to fail", script)
+		}
+	}
+}
+
+func TestParseCmd(t *testing.T) {
+	commands, err := ParseCmd(`dir "C:\Program Files" & del /q x.txt && echo ^& done > out.txt`)
+	if err != nil {
+		t.Fatalf("ParseCmd returned error: %v", err)
+	}
+	if got, want := commandNames(commands), []string{"dir", "del", "echo"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("ParseCmd names = %v, want %v", got, want)
+	}
+	if got := commands[0].Args[1].Value; got != `C:\Program Files` {
+		t.Errorf("Expected quoted path, got %q", got)
+	}
+	if got := wordValues(commands[2].Args); !reflect.DeepEqual(got, []string{"echo", "&", "done"}) {
+		t.Errorf("Unexpected echo args: %v", got)
+	}
+	if len(commands[2].Redirects) != 1 || commands[2].Redirects[0].Target.Value != "out.txt" {
+		t.Errorf("Unexpected redirects: %+v", commands[2].Redirects)
+	}
+}
@@ -92,6 +92,11 @@ type Logger struct {
 	logDir    string
 	appName   string
 	startTime time.Time
+
+	// parent is the logger that a logger created by With writes through,
+	// adding fields to each message
+	parent *Logger
+	fields string
 }
 
 // Config holds logger configuration
@@ -269,8 +274,29 @@ func NewLogger(cfg Config) (*Logger, error) {
 	return l, nil
 }
 
+// With returns a logger that writes to the same file as l, adding fields
+// such as "identity=ci" to the start of every message
+func (l *Logger) With(fields string) *Logger {
+	if l == nil {
+		return nil
+	}
+	if l.fields != "" {
+		fields = l.fields + " " + fields
+	}
+	return &Logger{parent: l.root(), fields: fields}
+}
+
+// root returns the logger that owns the log file
+func (l *Logger) root() *Logger {
+	if l.parent != nil {
+		return l.parent
+	}
+	return l
+}
+
 // Close closes the log file
 func (l *Logger) Close() error {
+	l = l.root()
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	if l.file != nil {
@@ -281,6 +307,7 @@ func (l *Logger) Close() error {
 
 // SetLevel sets the log level
 func (l *Logger) SetLevel(level LogLevel) {
+	l = l.root()
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	l.level = level
@@ -288,16 +315,21 @@ func (l *Logger) SetLevel(level LogLevel) {
 
 // log writes a log entry if the level is enabled
 func (l *Logger) log(level LogLevel, format string, args ...interface{}) {
-	if l == nil || level > l.level {
+	if l == nil || level > l.root().level {
 		return
 	}
 
-	l.mu.Lock()
-	defer l.mu.Unlock()
+	message := fmt.Sprintf(format, args...)
+	if l.fields != "" {
+		message = l.fields + " " + message
+	}
+
+	root := l.root()
+	root.mu.Lock()
+	defer root.mu.Unlock()
 
 	timestamp := time.Now().Format("2006-01-02T15:04:05.000Z07:00")
-	message := fmt.Sprintf(format, args...)
-	l.logger.Printf("[%s] [%s] %s", timestamp, level.String(), message)
+	root.logger.Printf("[%s] [%s] %s", timestamp, level.String(), message)
 }
 
 // Error logs an error message
@@ -339,6 +371,21 @@ func (l *Logger) CommandBlocked(command string, reason string) {
 	l.Warn("CMD_BLOCKED command=%q reason=%q", command, reason)
 }
 
+// AuthFailure logs a rejected authentication attempt. lockedFor is the
+// lockout the client now has, if any.
+func (l *Logger) AuthFailure(client string, reason string, failures int, lockedFor time.Duration) {
+	if lockedFor > 0 {
+		l.Warn("AUTH_FAILURE client=%q reason=%q failures=%d locked_for=%s", client, reason, failures, lockedFor)
+	} else {
+		l.Warn("AUTH_FAILURE client=%q reason=%q failures=%d", client, reason, failures)
+	}
+}
+
+// AuthLocked logs a request refused because its client is locked out
+func (l *Logger) AuthLocked(client string, retryAfter time.Duration) {
+	l.Warn("AUTH_LOCKED client=%q retry_after=%s", client, retryAfter.Round(time.Second))
+}
+
 // ToolCall logs an MCP tool invocation
 func (l *Logger) ToolCall(toolName string, args map[string]interface{}) {
 	// Log tool name and argument keys only, never values that might contain sensitive data
@@ -439,6 +486,7 @@ func GetLogger() *Logger {
 
 // SetOutput sets the output writer for the logger (useful for testing)
 func (l *Logger) SetOutput(w io.Writer) {
+	l = l.root()
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	l.logger.SetOutput(w)
@@ -520,3 +568,17 @@ func ToolCall(toolName string, args map[string]interface{}) {
 		defaultLogger.ToolCall(toolName, args)
 	}
 }
+
+// AuthFailure logs a rejected authentication attempt using the default logger
+func AuthFailure(client string, reason string, failures int, lockedFor time.Duration) {
+	if defaultLogger != nil {
+		defaultLogger.AuthFailure(client, reason, failures, lockedFor)
+	}
+}
+
+// AuthLocked logs a refused locked out client using the default logger
+func AuthLocked(client string, retryAfter time.Duration) {
+	if defaultLogger != nil {
+		defaultLogger.AuthLocked(client, retryAfter)
+	}
+}
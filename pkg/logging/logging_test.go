@@ -225,6 +225,63 @@ func TestLoggerToolCall(t *testing.T) {
 	}
 }
 
+func TestLoggerAuthFailure(t *testing.T) {
+	tempDir := t.TempDir()
+
+	logger, err := NewLogger(Config{
+		LogDir:  tempDir,
+		AppName: "test-logger",
+		Level:   LevelWarn,
+	})
+	if err != nil {
+		t.Fatalf("NewLogger failed: %v", err)
+	}
+	defer logger.Close()
+
+	var buf bytes.Buffer
+	logger.SetOutput(&buf)
+
+	logger.AuthFailure("192.0.2.7", "token expired", 5, time.Minute)
+	logger.AuthLocked("192.0.2.7", 1500*time.Millisecond)
+
+	output := buf.String()
+	if !strings.Contains(output, `AUTH_FAILURE client="192.0.2.7" reason="token expired" failures=5 locked_for=1m0s`) {
+		t.Errorf("Expected an AUTH_FAILURE event, got %q", output)
+	}
+	if !strings.Contains(output, `AUTH_LOCKED client="192.0.2.7" retry_after=2s`) {
+		t.Errorf("Expected an AUTH_LOCKED event, got %q", output)
+	}
+}
+
+func TestLoggerWith(t *testing.T) {
+	tempDir := t.TempDir()
+
+	logger, err := NewLogger(Config{
+		LogDir:  tempDir,
+		AppName: "test-logger",
+		Level:   LevelInfo,
+	})
+	if err != nil {
+		t.Fatalf("NewLogger failed: %v", err)
+	}
+	defer logger.Close()
+
+	var buf bytes.Buffer
+	logger.SetOutput(&buf)
+
+	child := logger.With("identity=ci").With("request=7")
+	child.ToolCall("execute_command", nil)
+	child.Debug("not logged")
+
+	output := buf.String()
+	if !strings.Contains(output, "[INFO] identity=ci request=7 TOOL_CALL") {
+		t.Errorf("Expected fields ahead of the message, got %q", output)
+	}
+	if strings.Contains(output, "not logged") {
+		t.Error("Expected the parent's level to apply")
+	}
+}
+
 func TestLogStartupAndShutdown(t *testing.T) {
 	tempDir := t.TempDir()
 
@@ -0,0 +1,106 @@
+package mcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sync"
+)
+
+// isBatch reports whether data is a JSON array, i.e. a JSON-RPC batch
+func isBatch(data []byte) bool {
+	data = bytes.TrimLeft(data, " \t\r\n")
+	return len(data) > 0 && data[0] == '['
+}
+
+// handlePayload processes a message or a batch of messages, returning what
+// to send back: a *JSONRPCResponse, a []*JSONRPCResponse for a batch, or
+// nil if nothing is to be sent
+func (s *Server) handlePayload(ctx context.Context, data []byte, notify notifyFunc) interface{} {
+	if !isBatch(data) {
+		if response := s.handleMessageWith(ctx, data, notify); response != nil {
+			return response
+		}
+		return nil
+	}
+
+	if version := ProtocolVersion(ctx); version != "" && version >= featureNoBatches {
+		return errorResponse(nil, InvalidRequest, "Invalid Request: batches are not supported in protocol version "+version, nil)
+	}
+
+	var members []json.RawMessage
+	if err := json.Unmarshal(data, &members); err != nil {
+		return &JSONRPCResponse{
+			JSONRPC: "2.0",
+			Error: &JSONRPCError{
+				Code:    ParseError,
+				Message: "Parse error",
+				Data:    err.Error(),
+			},
+		}
+	}
+	if len(members) == 0 {
+		return &JSONRPCResponse{
+			JSONRPC: "2.0",
+			Error:   &JSONRPCError{Code: InvalidRequest, Message: "Invalid Request: empty batch"},
+		}
+	}
+	if responses := s.handleBatch(ctx, members, notify); len(responses) > 0 {
+		return responses
+	}
+	return nil
+}
+
+// handleBatch handles the members of a batch and returns the responses to
+// those that are requests, or invalid, in the order of the batch.
+// Notifications are handled at once and requests on the server's pool, so
+// that a batch gets no more concurrency than separate requests; requests
+// the pool has no room for are answered with ServerOverloaded.
+func (s *Server) handleBatch(ctx context.Context, members []json.RawMessage, notify notifyFunc) []*JSONRPCResponse {
+	pool := s.workers()
+	responses := make([]*JSONRPCResponse, len(members))
+	var wg sync.WaitGroup
+	for i, member := range members {
+		if isNotification(member) {
+			s.handleMessageWith(ctx, member, notify)
+			continue
+		}
+		i, member := i, member
+		wg.Add(1)
+		if !pool.trySubmit(func() {
+			defer wg.Done()
+			responses[i] = s.handleMessageWith(ctx, member, notify)
+		}) {
+			wg.Done()
+			responses[i] = overloadedResponse(member)
+		}
+	}
+	wg.Wait()
+
+	// Notifications have no response
+	answered := responses[:0]
+	for _, response := range responses {
+		if response != nil {
+			answered = append(answered, response)
+		}
+	}
+	return answered
+}
+
+// expectsResponse reports whether handling data produces a response: it is
+// a request, a batch containing one, or malformed
+func expectsResponse(data []byte) bool {
+	if !isBatch(data) {
+		return !isNotification(data)
+	}
+	var members []json.RawMessage
+	if err := json.Unmarshal(data, &members); err != nil || len(members) == 0 {
+		return true
+	}
+	for _, member := range members {
+		if !isNotification(member) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,258 @@
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHandlePayload_Batch(t *testing.T) {
+	server := NewServer("test-server", "1.0.0")
+	server.SetIO(nil, nil, io.Discard)
+
+	tests := []struct {
+		name  string
+		batch string
+		// ids and codes of the expected responses, in order; a code of 0
+		// is a result
+		ids   []interface{}
+		codes []int
+	}{
+		{"requests and notification",
+			`[{"jsonrpc":"2.0","id":1,"method":"ping"},{"jsonrpc":"2.0","method":"notifications/initialized"},{"jsonrpc":"2.0","id":"b","method":"nope"}]`,
+			[]interface{}{float64(1), "b"}, []int{0, MethodNotFound}},
+		{"non-object members", `[1, "x", null]`,
+			[]interface{}{nil, nil, nil}, []int{InvalidRequest, InvalidRequest, InvalidRequest}},
+		{"malformed member", `[{"jsonrpc":"2.0","id":1,"method":5},{"jsonrpc":"2.0","id":2,"method":"ping"}]`,
+			[]interface{}{float64(1), float64(2)}, []int{InvalidRequest, 0}},
+		{"whitespace", " \n[{\"jsonrpc\":\"2.0\",\"id\":1,\"method\":\"ping\"}]",
+			[]interface{}{float64(1)}, []int{0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply := server.handlePayload(context.Background(), []byte(tt.batch), nil)
+			responses, ok := reply.([]*JSONRPCResponse)
+			if !ok || len(responses) != len(tt.ids) {
+				t.Fatalf("Expected %d responses, got %#v", len(tt.ids), reply)
+			}
+			for i, response := range responses {
+				code := 0
+				if response.Error != nil {
+					code = response.Error.Code
+				}
+				if response.ID != tt.ids[i] || code != tt.codes[i] {
+					t.Errorf("Response %d: expected id %v code %d, got id %v code %d", i, tt.ids[i], tt.codes[i], response.ID, code)
+				}
+			}
+		})
+	}
+}
+
+func TestHandlePayload_BatchErrors(t *testing.T) {
+	server := NewServer("test-server", "1.0.0")
+	server.SetIO(nil, nil, io.Discard)
+
+	tests := []struct {
+		name  string
+		batch string
+		code  int
+	}{
+		{"empty batch", `[]`, InvalidRequest},
+		{"invalid JSON", `[{"jsonrpc":"2.0","method":"ping"`, ParseError},
+	}
+
+	for _, tt := range tests {
+		reply := server.handlePayload(context.Background(), []byte(tt.batch), nil)
+		response, ok := reply.(*JSONRPCResponse)
+		if !ok || response.Error == nil || response.Error.Code != tt.code {
+			t.Errorf("%s: expected a single error %d, got %#v", tt.name, tt.code, reply)
+		}
+	}
+
+	notifications := `[{"jsonrpc":"2.0","method":"notifications/initialized"},{"jsonrpc":"2.0","method":"notifications/cancelled","params":{"requestId":9}}]`
+	if reply := server.handlePayload(context.Background(), []byte(notifications), nil); reply != nil {
+		t.Errorf("Expected no reply to a batch of notifications, got %#v", reply)
+	}
+}
+
+func TestHandlePayload_BatchConcurrent(t *testing.T) {
+	server := NewServer("test-server", "1.0.0")
+	server.SetIO(nil, nil, io.Discard)
+
+	// Each call waits until both are running, so the batch only completes
+	// if its members are dispatched concurrently
+	var started sync.WaitGroup
+	started.Add(2)
+	server.RegisterTool(Tool{Name: "rendezvous", InputSchema: JSONSchema{Type: "object"}}, func(ctx context.Context, args map[string]interface{}) (*CallToolResult, error) {
+		started.Done()
+		started.Wait()
+		return &CallToolResult{Content: []ContentItem{{Type: "text", Text: "met"}}}, nil
+	})
+
+	done := make(chan interface{}, 1)
+	go func() {
+		done <- server.handlePayload(context.Background(), []byte(`[
+			{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"rendezvous"}},
+			{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"rendezvous"}}]`), nil)
+	}()
+
+	select {
+	case reply := <-done:
+		if responses := reply.([]*JSONRPCResponse); len(responses) != 2 {
+			t.Errorf("Expected 2 responses, got %d", len(responses))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Batch members were not dispatched concurrently")
+	}
+}
+
+func TestServerRun_Batch(t *testing.T) {
+	server := NewServer("test-server", "1.0.0")
+	var stdout syncBuffer
+	input := `[{"jsonrpc":"2.0","id":1,"method":"ping"},{"jsonrpc":"2.0","id":2,"method":"tools/list"}]` + "\n" +
+		`[{"jsonrpc":"2.0","method":"notifications/initialized"}]` + "\n"
+	server.SetIO(strings.NewReader(input), &stdout, io.Discard)
+
+	if err := server.Run(); err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("Expected a single line answering the batch, got %q", stdout.String())
+	}
+	var responses []JSONRPCResponse
+	if err := json.Unmarshal([]byte(lines[0]), &responses); err != nil || len(responses) != 2 {
+		t.Errorf("Expected an array of 2 responses, got %s", lines[0])
+	}
+}
+
+func TestStreamableHTTP_Batch(t *testing.T) {
+	_, httpServer := newStreamableServer(t)
+	batch := `[{"jsonrpc":"2.0","id":1,"method":"ping"},{"jsonrpc":"2.0","id":2,"method":"ping"}]`
+
+	resp := postMCP(t, httpServer.URL, "", "application/json", batch)
+	var responses []JSONRPCResponse
+	json.NewDecoder(resp.Body).Decode(&responses)
+	resp.Body.Close()
+	if len(responses) != 2 {
+		t.Errorf("Expected a JSON array of 2 responses, got %+v", responses)
+	}
+
+	resp = postMCP(t, httpServer.URL, "", "application/json, text/event-stream", batch)
+	_, data := readEvent(t, bufio.NewReader(resp.Body))
+	resp.Body.Close()
+	if err := json.Unmarshal([]byte(data), &responses); err != nil || len(responses) != 2 {
+		t.Errorf("Expected an event carrying 2 responses, got %s", data)
+	}
+
+	resp = postMCP(t, httpServer.URL, "", "application/json, text/event-stream", `[{"jsonrpc":"2.0","method":"notifications/initialized"}]`)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Errorf("Expected 202 for a batch of notifications, got %d", resp.StatusCode)
+	}
+}
+
+func TestHandlePayload_BatchSharesPool(t *testing.T) {
+	server := NewServer("test-server", "1.0.0")
+	server.SetIO(nil, nil, io.Discard)
+	server.SetMaxInFlight(1)
+
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	release := make(chan struct{})
+	server.RegisterTool(Tool{Name: "block", InputSchema: JSONSchema{Type: "object"}}, func(ctx context.Context, args map[string]interface{}) (*CallToolResult, error) {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+		<-release
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return &CallToolResult{Content: []ContentItem{{Type: "text", Text: "done"}}}, nil
+	})
+
+	// One member runs, requestQueueSize wait and the rest are refused
+	members := make([]string, requestQueueSize+3)
+	for i := range members {
+		members[i] = fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":"tools/call","params":{"name":"block"}}`, i)
+	}
+	done := make(chan interface{}, 1)
+	go func() {
+		done <- server.handlePayload(context.Background(), []byte("["+strings.Join(members, ",")+"]"), nil)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+
+	var reply interface{}
+	select {
+	case reply = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Batch did not complete")
+	}
+	responses := reply.([]*JSONRPCResponse)
+	overloadedCount := 0
+	for _, response := range responses {
+		if response.Error != nil && response.Error.Code == ServerOverloaded {
+			overloadedCount++
+		}
+	}
+	if len(responses) != len(members) || overloadedCount != 2 {
+		t.Errorf("Expected %d responses with 2 refused, got %d with %d refused", len(members), len(responses), overloadedCount)
+	}
+	if maxRunning != 1 {
+		t.Errorf("Expected the batch to respect the request limit of 1, got %d concurrent calls", maxRunning)
+	}
+}
+
+func TestHandlePayload_BatchRejectedAfter20250618(t *testing.T) {
+	batch := []byte(`[{"jsonrpc":"2.0","id":1,"method":"ping"}]`)
+
+	for _, tt := range []struct {
+		version string
+		allowed bool
+	}{
+		{"2024-11-05", true},
+		{"2025-03-26", true},
+		{"2025-06-18", false},
+	} {
+		server := NewServer("test-server", "1.0.0")
+		server.SetIO(nil, nil, io.Discard)
+		server.handleMessage(initializeMessage(tt.version, "{}"))
+
+		reply := server.handlePayload(server.stdioContext(), batch, nil)
+		if _, ok := reply.([]*JSONRPCResponse); ok != tt.allowed {
+			t.Errorf("%s: expected batch allowed=%v, got %#v", tt.version, tt.allowed, reply)
+		}
+		if response, ok := reply.(*JSONRPCResponse); ok && (response.Error == nil || response.Error.Code != InvalidRequest || response.ID != nil) {
+			t.Errorf("%s: expected Invalid Request with a null id, got %+v", tt.version, response)
+		}
+	}
+
+	// Over HTTP the version comes from the header
+	_, httpServer := newStreamableServer(t)
+	req, _ := http.NewRequest(http.MethodPost, httpServer.URL, strings.NewReader(string(batch)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set(ProtocolVersionHeader, "2025-06-18")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var response JSONRPCResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil || response.Error == nil || response.Error.Code != InvalidRequest {
+		t.Errorf("Expected Invalid Request over HTTP, got %+v (%v)", response, err)
+	}
+}
@@ -0,0 +1,89 @@
+package mcp
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// errorResponse builds an error response. id is nil when the request's id
+// could not be determined, and is then sent as null.
+func errorResponse(id interface{}, code int, message string, data interface{}) *JSONRPCResponse {
+	return &JSONRPCResponse{
+		JSONRPC: "2.0",
+		ID:      id,
+		Error:   &JSONRPCError{Code: code, Message: message, Data: data},
+	}
+}
+
+// decodeMessage parses and validates a single JSON-RPC message. It returns
+// the request or notification, or else the error response to send. Both
+// are nil for a response from the client, which needs no reply.
+func decodeMessage(data []byte) (*JSONRPCRequest, *JSONRPCResponse) {
+	var message interface{}
+	if err := json.Unmarshal(data, &message); err != nil {
+		return nil, errorResponse(nil, ParseError, "Parse error", err.Error())
+	}
+	fields, ok := message.(map[string]interface{})
+	if !ok {
+		return nil, errorResponse(nil, InvalidRequest, "Invalid Request: message must be an object", nil)
+	}
+
+	// The id is echoed in error responses if it is valid, even when the rest
+	// of the message is not
+	id, hasID := fields["id"]
+	switch id.(type) {
+	case string, float64:
+	case nil:
+		if hasID {
+			return nil, errorResponse(nil, InvalidRequest, "Invalid Request: id must not be null", nil)
+		}
+	default:
+		return nil, errorResponse(nil, InvalidRequest, "Invalid Request: id must be a string or number", nil)
+	}
+
+	if version, _ := fields["jsonrpc"].(string); version != "2.0" {
+		return nil, errorResponse(id, InvalidRequest, `Invalid Request: jsonrpc must be "2.0"`, nil)
+	}
+
+	method, hasMethod := fields["method"]
+	if !hasMethod && hasID {
+		_, hasResult := fields["result"]
+		_, hasError := fields["error"]
+		if hasResult != hasError {
+			return nil, nil
+		}
+	}
+	name, ok := method.(string)
+	if !ok || name == "" {
+		return nil, errorResponse(id, InvalidRequest, "Invalid Request: method must be a non-empty string", nil)
+	}
+
+	params, hasParams := fields["params"]
+	switch params.(type) {
+	case map[string]interface{}, []interface{}:
+	default:
+		if hasParams {
+			return nil, errorResponse(id, InvalidRequest, "Invalid Request: params must be an object or array", nil)
+		}
+	}
+
+	return &JSONRPCRequest{JSONRPC: "2.0", ID: id, Method: name, Params: params}, nil
+}
+
+// paramsObject returns a request's params as an object; absent params are
+// an empty object
+func paramsObject(params interface{}) (map[string]interface{}, *JSONRPCError) {
+	switch params := params.(type) {
+	case nil:
+		return map[string]interface{}{}, nil
+	case map[string]interface{}:
+		return params, nil
+	default:
+		return nil, invalidParams("params must be an object")
+	}
+}
+
+// invalidParams returns an InvalidParams error
+func invalidParams(format string, args ...interface{}) *JSONRPCError {
+	return &JSONRPCError{Code: InvalidParams, Message: "Invalid params: " + fmt.Sprintf(format, args...)}
+}
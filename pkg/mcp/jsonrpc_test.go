@@ -0,0 +1,149 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestHandleMessage_Conformance(t *testing.T) {
+	server := NewServer("test-server", "1.0.0")
+	server.SetIO(nil, nil, io.Discard)
+	server.RegisterTool(Tool{Name: "echo", InputSchema: JSONSchema{Type: "object"}}, func(ctx context.Context, args map[string]interface{}) (*CallToolResult, error) {
+		return &CallToolResult{Content: []ContentItem{{Type: "text", Text: "ok"}}}, nil
+	})
+	server.RegisterTool(Tool{Name: "broken", InputSchema: JSONSchema{Type: "object"}}, func(ctx context.Context, args map[string]interface{}) (*CallToolResult, error) {
+		return nil, nil
+	})
+
+	tests := []struct {
+		name    string
+		message string
+		// reply is false if no response is expected
+		reply bool
+		id    interface{}
+		// code is 0 for a result
+		code int
+	}{
+		// Parse and structural errors
+		{"invalid JSON", `{"jsonrpc":"2.0","id":1,`, true, nil, ParseError},
+		{"empty message", ``, true, nil, ParseError},
+		{"number", `42`, true, nil, InvalidRequest},
+		{"string", `"ping"`, true, nil, InvalidRequest},
+		{"null", `null`, true, nil, InvalidRequest},
+
+		// jsonrpc member
+		{"jsonrpc 1.0", `{"jsonrpc":"1.0","id":1,"method":"ping"}`, true, float64(1), InvalidRequest},
+		{"jsonrpc missing", `{"id":1,"method":"ping"}`, true, float64(1), InvalidRequest},
+		{"jsonrpc number", `{"jsonrpc":2.0,"id":1,"method":"ping"}`, true, float64(1), InvalidRequest},
+		{"jsonrpc missing on notification", `{"method":"notifications/initialized"}`, true, nil, InvalidRequest},
+
+		// id member
+		{"id null", `{"jsonrpc":"2.0","id":null,"method":"ping"}`, true, nil, InvalidRequest},
+		{"id object", `{"jsonrpc":"2.0","id":{"a":1},"method":"ping"}`, true, nil, InvalidRequest},
+		{"id array", `{"jsonrpc":"2.0","id":[1],"method":"ping"}`, true, nil, InvalidRequest},
+		{"id bool", `{"jsonrpc":"2.0","id":true,"method":"ping"}`, true, nil, InvalidRequest},
+		{"id string", `{"jsonrpc":"2.0","id":"abc","method":"ping"}`, true, "abc", 0},
+		{"id zero", `{"jsonrpc":"2.0","id":0,"method":"ping"}`, true, float64(0), 0},
+		{"id empty string", `{"jsonrpc":"2.0","id":"","method":"ping"}`, true, "", 0},
+
+		// method member
+		{"method missing", `{"jsonrpc":"2.0","id":1}`, true, float64(1), InvalidRequest},
+		{"method number", `{"jsonrpc":"2.0","id":1,"method":5}`, true, float64(1), InvalidRequest},
+		{"method empty", `{"jsonrpc":"2.0","id":1,"method":""}`, true, float64(1), InvalidRequest},
+		{"method unknown", `{"jsonrpc":"2.0","id":1,"method":"resources/list"}`, true, float64(1), MethodNotFound},
+
+		// params member
+		{"params string", `{"jsonrpc":"2.0","id":1,"method":"ping","params":"x"}`, true, float64(1), InvalidRequest},
+		{"params null", `{"jsonrpc":"2.0","id":1,"method":"ping","params":null}`, true, float64(1), InvalidRequest},
+		{"params array", `{"jsonrpc":"2.0","id":1,"method":"tools/list","params":[]}`, true, float64(1), InvalidParams},
+		{"params empty object", `{"jsonrpc":"2.0","id":1,"method":"tools/list","params":{}}`, true, float64(1), 0},
+
+		// tools/call params
+		{"call without name", `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{}}`, true, float64(1), InvalidParams},
+		{"call with numeric name", `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":3}}`, true, float64(1), InvalidParams},
+		{"call with string arguments", `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"echo","arguments":"x"}}`, true, float64(1), InvalidParams},
+		{"call with array arguments", `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"echo","arguments":[]}}`, true, float64(1), InvalidParams},
+		{"call without arguments", `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"echo"}}`, true, float64(1), 0},
+		{"call unknown tool", `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"missing"}}`, true, float64(1), InvalidParams},
+		{"call with no result", `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"broken"}}`, true, float64(1), InternalError},
+
+		// Notifications are never answered
+		{"notification", `{"jsonrpc":"2.0","method":"notifications/initialized"}`, false, nil, 0},
+		{"notification unknown method", `{"jsonrpc":"2.0","method":"nope"}`, false, nil, 0},
+		{"notification for a request method", `{"jsonrpc":"2.0","method":"tools/call","params":{}}`, false, nil, 0},
+
+		// Responses from the client are not answered either
+		{"client result", `{"jsonrpc":"2.0","id":7,"result":{}}`, false, nil, 0},
+		{"client error", `{"jsonrpc":"2.0","id":7,"error":{"code":-1,"message":"x"}}`, false, nil, 0},
+		{"result and error", `{"jsonrpc":"2.0","id":7,"result":{},"error":{"code":-1,"message":"x"}}`, true, float64(7), InvalidRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := server.handleMessage([]byte(tt.message))
+			if !tt.reply {
+				if response != nil {
+					t.Fatalf("Expected no response, got %+v", response)
+				}
+				return
+			}
+			if response == nil {
+				t.Fatal("Expected a response, got none")
+			}
+			if response.JSONRPC != "2.0" || response.ID != tt.id {
+				t.Errorf("Expected jsonrpc 2.0 and id %v, got %q and %v", tt.id, response.JSONRPC, response.ID)
+			}
+			code := 0
+			if response.Error != nil {
+				code = response.Error.Code
+			}
+			if code != tt.code {
+				t.Errorf("Expected code %d, got %+v", tt.code, response.Error)
+			}
+			if (response.Error == nil) == (response.Result == nil) {
+				t.Errorf("Expected exactly one of result and error, got %+v", response)
+			}
+		})
+	}
+}
+
+func TestJSONRPCResponse_NullID(t *testing.T) {
+	server := NewServer("test-server", "1.0.0")
+	server.SetIO(nil, nil, io.Discard)
+
+	for _, message := range []string{`{bad`, `[1]`, `{"jsonrpc":"2.0","id":true,"method":"ping"}`} {
+		data, err := json.Marshal(server.handlePayload(context.Background(), []byte(message), nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), `"id":null`) {
+			t.Errorf("Expected the error response to %s to carry a null id, got %s", message, data)
+		}
+	}
+}
+
+func TestHandleMessage_ErrorMessages(t *testing.T) {
+	server := NewServer("test-server", "1.0.0")
+	server.SetIO(nil, nil, io.Discard)
+
+	tests := []struct {
+		message  string
+		expected string
+	}{
+		{`{"jsonrpc":"1.0","id":1,"method":"ping"}`, `jsonrpc must be "2.0"`},
+		{`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"arguments":{}}}`, "name must be a non-empty string"},
+		{`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"x","arguments":1}}`, "arguments must be an object"},
+		{`{"jsonrpc":"2.0","id":1,"method":"ping","params":[1]}`, "params must be an object"},
+		{`{"jsonrpc":"2.0","id":1,"method":"nope"}`, "Method not found: nope"},
+	}
+
+	for _, tt := range tests {
+		response := server.handleMessage([]byte(tt.message))
+		if response == nil || response.Error == nil || !strings.Contains(response.Error.Message, tt.expected) {
+			t.Errorf("Expected an error mentioning %q for %s, got %+v", tt.expected, tt.message, response)
+		}
+	}
+}
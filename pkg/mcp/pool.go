@@ -0,0 +1,83 @@
+package mcp
+
+import "sync"
+
+// workerPool bounds the number of requests handled at once. Up to size jobs
+// run concurrently and up to queue more wait for a slot, starting in the
+// order they were submitted; jobs beyond that are refused.
+type workerPool struct {
+	mu    sync.Mutex
+	size  int
+	limit int
+	// workers is the number of goroutines running jobs, and active the
+	// number of jobs they are running
+	workers int
+	active  int
+	waiting []func()
+}
+
+func newWorkerPool(size, queue int) *workerPool {
+	if size < 1 {
+		size = 1
+	}
+	return &workerPool{size: size, limit: size + queue}
+}
+
+// trySubmit starts job once a slot is free, returning false without
+// starting it if the queue is full
+func (p *workerPool) trySubmit(job func()) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.active+len(p.waiting) >= p.limit {
+		return false
+	}
+	p.waiting = append(p.waiting, job)
+	if p.workers < p.size {
+		p.workers++
+		go p.work()
+	}
+	return true
+}
+
+// work runs waiting jobs until there are none left
+func (p *workerPool) work() {
+	p.mu.Lock()
+	for len(p.waiting) > 0 {
+		job := p.waiting[0]
+		p.waiting[0] = nil
+		p.waiting = p.waiting[1:]
+		p.active++
+		p.mu.Unlock()
+
+		job()
+
+		p.mu.Lock()
+		p.active--
+	}
+	p.workers--
+	p.mu.Unlock()
+}
+
+// workers returns the server's pool, sized by SetMaxInFlight
+func (s *Server) workers() *workerPool {
+	s.poolOnce.Do(func() {
+		s.pool = newWorkerPool(s.maxInFlight, requestQueueSize)
+	})
+	return s.pool
+}
+
+// overloaded returns the error response to a request refused because the
+// pool is full
+func overloaded(id interface{}) *JSONRPCResponse {
+	return errorResponse(id, ServerOverloaded, "Server overloaded: too many requests in progress, retry later", nil)
+}
+
+// overloadedResponse answers a single message refused because the pool is
+// full
+func overloadedResponse(data []byte) *JSONRPCResponse {
+	request, errResponse := decodeMessage(data)
+	if errResponse != nil {
+		return errResponse
+	}
+	return overloaded(request.ID)
+}
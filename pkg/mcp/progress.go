@@ -0,0 +1,68 @@
+package mcp
+
+import (
+	"context"
+	"sync"
+)
+
+// notifyFunc delivers a notification over the transport that carried the
+// request being handled
+type notifyFunc func(notification *JSONRPCNotification)
+
+type progressKey struct{}
+
+// progressReporter sends notifications/progress messages for a single
+// tools/call request that supplied a progress token
+type progressReporter struct {
+	mu     sync.Mutex
+	token  interface{}
+	notify notifyFunc
+}
+
+// withProgress returns a context that carries a progress reporter for token
+func withProgress(ctx context.Context, token interface{}, notify notifyFunc) context.Context {
+	if token == nil || notify == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, progressKey{}, &progressReporter{token: token, notify: notify})
+}
+
+// HasProgress reports whether the client asked for progress notifications
+// for the request associated with ctx
+func HasProgress(ctx context.Context) bool {
+	_, ok := ctx.Value(progressKey{}).(*progressReporter)
+	return ok
+}
+
+// ReportProgress sends a notifications/progress message to the client for the
+// request associated with ctx. It is a no-op when the client did not supply a
+// progress token. Total may be zero when the total amount of work is unknown.
+// It is safe to call from multiple goroutines.
+func ReportProgress(ctx context.Context, progress, total float64, message string) {
+	reporter, ok := ctx.Value(progressKey{}).(*progressReporter)
+	if !ok {
+		return
+	}
+
+	reporter.mu.Lock()
+	defer reporter.mu.Unlock()
+	reporter.notify(&JSONRPCNotification{
+		JSONRPC: "2.0",
+		Method:  "notifications/progress",
+		Params: ProgressParams{
+			ProgressToken: reporter.token,
+			Progress:      progress,
+			Total:         total,
+			Message:       message,
+		},
+	})
+}
+
+// progressToken extracts _meta.progressToken from tools/call params
+func progressToken(params map[string]interface{}) interface{} {
+	meta, ok := params["_meta"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	return meta["progressToken"]
+}
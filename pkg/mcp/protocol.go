@@ -0,0 +1,186 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+)
+
+// Protocol revisions supported by the server
+const (
+	ProtocolVersion20241105 = "2024-11-05"
+	ProtocolVersion20250326 = "2025-03-26"
+	ProtocolVersion20250618 = "2025-06-18"
+
+	// LatestProtocolVersion is offered to clients that request a version the
+	// server does not support
+	LatestProtocolVersion = ProtocolVersion20250618
+)
+
+// SupportedProtocolVersions lists the protocol revisions the server can
+// speak, oldest first
+var SupportedProtocolVersions = []string{
+	ProtocolVersion20241105,
+	ProtocolVersion20250326,
+	ProtocolVersion20250618,
+}
+
+// ProtocolVersionHeader carries the negotiated protocol version on HTTP
+// requests after initialization
+const ProtocolVersionHeader = "MCP-Protocol-Version"
+
+// defaultHTTPProtocolVersion is assumed for HTTP requests that carry neither
+// a session nor a protocol version header, as the specification requires
+const defaultHTTPProtocolVersion = ProtocolVersion20250326
+
+// Features that depend on the negotiated protocol version, named by the
+// revision that introduced them
+const (
+	featureToolAnnotations   = ProtocolVersion20250326
+	featureStructuredContent = ProtocolVersion20250618
+	featureOutputSchema      = ProtocolVersion20250618
+	featureElicitation       = ProtocolVersion20250618
+	// featureNoBatches is the revision that removed JSON-RPC batching
+	featureNoBatches = ProtocolVersion20250618
+)
+
+// isSupportedVersion reports whether the server speaks version
+func isSupportedVersion(version string) bool {
+	for _, supported := range SupportedProtocolVersions {
+		if supported == version {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateVersion returns the version to answer initialize with: the
+// client's, if supported, or else the latest, which the client may reject
+func negotiateVersion(requested string) string {
+	if isSupportedVersion(requested) {
+		return requested
+	}
+	return LatestProtocolVersion
+}
+
+// clientState is what a client declared in initialize. The stdio transport
+// has one; on HTTP each session has its own.
+type clientState struct {
+	mu              sync.RWMutex
+	protocolVersion string
+	capabilities    ClientCapabilities
+	info            ClientInfo
+}
+
+// initialized records the outcome of initialize
+func (c *clientState) initialized(version string, params *InitializeParams) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.protocolVersion = version
+	c.capabilities = params.Capabilities
+	c.info = params.ClientInfo
+}
+
+// version returns the negotiated protocol version, or "" before initialize
+func (c *clientState) version() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.protocolVersion
+}
+
+// supports reports whether a feature introduced in protocol revision
+// introduced may be used with the client. Until a version is negotiated,
+// every feature is available.
+func (c *clientState) supports(introduced string) bool {
+	version := c.version()
+	// Revisions are dates, so they order as strings
+	return version == "" || version >= introduced
+}
+
+type clientKey struct{}
+
+// withClient returns a context carrying the state of the request's client
+func withClient(ctx context.Context, client *clientState) context.Context {
+	return context.WithValue(ctx, clientKey{}, client)
+}
+
+// clientFromContext returns the state of the request's client. Requests
+// handled outside a transport get a fresh state with every feature
+// available.
+func clientFromContext(ctx context.Context) *clientState {
+	if client, ok := ctx.Value(clientKey{}).(*clientState); ok {
+		return client
+	}
+	return &clientState{}
+}
+
+// ProtocolVersion returns the protocol version negotiated with the client
+// of the request associated with ctx, or "" if it has not initialized
+func ProtocolVersion(ctx context.Context) string {
+	return clientFromContext(ctx).version()
+}
+
+// ClientInfoFromContext returns the name and version the client of the
+// request associated with ctx gave in initialize
+func ClientInfoFromContext(ctx context.Context) ClientInfo {
+	client := clientFromContext(ctx)
+	client.mu.RLock()
+	defer client.mu.RUnlock()
+	return client.info
+}
+
+// SupportsElicitation reports whether the client of the request associated
+// with ctx negotiated a protocol version with elicitation and declared the
+// elicitation capability, so that it may be asked for input
+func SupportsElicitation(ctx context.Context) bool {
+	client := clientFromContext(ctx)
+	if client.version() == "" || !client.supports(featureElicitation) {
+		return false
+	}
+	client.mu.RLock()
+	defer client.mu.RUnlock()
+	return client.capabilities.Elicitation != nil
+}
+
+// parseInitializeParams decodes the params of an initialize request
+func parseInitializeParams(params interface{}) (*InitializeParams, error) {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	var initialize InitializeParams
+	if err := json.Unmarshal(data, &initialize); err != nil {
+		return nil, err
+	}
+	return &initialize, nil
+}
+
+// toolsFor returns tools as the client can understand them, without the
+// fields its protocol version lacks
+func toolsFor(client *clientState, tools []Tool) []Tool {
+	annotations, outputSchema := client.supports(featureToolAnnotations), client.supports(featureOutputSchema)
+	if annotations && outputSchema {
+		return tools
+	}
+	adapted := make([]Tool, len(tools))
+	for i, tool := range tools {
+		if !annotations {
+			tool.Annotations = nil
+		}
+		if !outputSchema {
+			tool.OutputSchema = nil
+		}
+		adapted[i] = tool
+	}
+	return adapted
+}
+
+// resultFor returns a tool result as the client can understand it
+func resultFor(client *clientState, result *CallToolResult) *CallToolResult {
+	if result == nil || result.StructuredContent == nil || client.supports(featureStructuredContent) {
+		return result
+	}
+	adapted := *result
+	adapted.StructuredContent = nil
+	return &adapted
+}
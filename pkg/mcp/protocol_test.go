@@ -0,0 +1,199 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateVersion(t *testing.T) {
+	tests := []struct {
+		requested string
+		expected  string
+	}{
+		{"2024-11-05", "2024-11-05"},
+		{"2025-03-26", "2025-03-26"},
+		{"2025-06-18", "2025-06-18"},
+		{"2099-01-01", LatestProtocolVersion},
+		{"2024-01-01", LatestProtocolVersion},
+		{"", LatestProtocolVersion},
+	}
+
+	for _, tt := range tests {
+		if got := negotiateVersion(tt.requested); got != tt.expected {
+			t.Errorf("negotiateVersion(%q) = %q, expected %q", tt.requested, got, tt.expected)
+		}
+	}
+}
+
+// newFeatureServer returns a server with a tool using features of every
+// protocol revision
+func newFeatureServer() *Server {
+	server := NewServer("test-server", "1.0.0")
+	server.SetIO(nil, nil, io.Discard)
+	readOnly := true
+	server.RegisterTool(Tool{
+		Name:         "stats",
+		InputSchema:  JSONSchema{Type: "object"},
+		OutputSchema: &JSONSchema{Type: "object", Properties: map[string]Property{"count": {Type: "integer"}}},
+		Annotations:  &ToolAnnotations{ReadOnlyHint: &readOnly},
+	}, func(ctx context.Context, args map[string]interface{}) (*CallToolResult, error) {
+		return &CallToolResult{
+			Content:           []ContentItem{{Type: "text", Text: `{"count": 3}`}},
+			StructuredContent: map[string]interface{}{"count": 3},
+		}, nil
+	})
+	return server
+}
+
+func initializeMessage(version, capabilities string) []byte {
+	return []byte(fmt.Sprintf(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":%q,"capabilities":%s,"clientInfo":{"name":"test-client","version":"2.0"}}}`, version, capabilities))
+}
+
+func TestInitialize_FeatureGating(t *testing.T) {
+	tests := []struct {
+		version           string
+		annotations       bool
+		outputSchema      bool
+		structuredContent bool
+	}{
+		{"2024-11-05", false, false, false},
+		{"2025-03-26", true, false, false},
+		{"2025-06-18", true, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			server := newFeatureServer()
+			response := server.handleMessage(initializeMessage(tt.version, "{}"))
+			if result := response.Result.(*InitializeResult); result.ProtocolVersion != tt.version {
+				t.Fatalf("Expected version %s, got %s", tt.version, result.ProtocolVersion)
+			}
+
+			response = server.handleMessage([]byte(`{"jsonrpc":"2.0","id":2,"method":"tools/list"}`))
+			tool := response.Result.(*ListToolsResult).Tools[0]
+			if (tool.Annotations != nil) != tt.annotations || (tool.OutputSchema != nil) != tt.outputSchema {
+				t.Errorf("Unexpected tool fields for %s: %+v", tt.version, tool)
+			}
+
+			response = server.handleMessage([]byte(`{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"stats"}}`))
+			result := response.Result.(*CallToolResult)
+			if (result.StructuredContent != nil) != tt.structuredContent || len(result.Content) != 1 {
+				t.Errorf("Unexpected result for %s: %+v", tt.version, result)
+			}
+		})
+	}
+
+	// The registered tool is not modified for older clients
+	server := newFeatureServer()
+	server.handleMessage(initializeMessage("2024-11-05", "{}"))
+	server.handleMessage([]byte(`{"jsonrpc":"2.0","id":2,"method":"tools/list"}`))
+	if server.tools[0].OutputSchema == nil || server.tools[0].Annotations == nil {
+		t.Error("Expected the registered tool to keep its fields")
+	}
+}
+
+func TestInitialize_ClientState(t *testing.T) {
+	tests := []struct {
+		version      string
+		capabilities string
+		elicitation  bool
+	}{
+		{"2025-06-18", `{"elicitation":{}}`, true},
+		{"2025-06-18", `{"roots":{}}`, false},
+		{"2025-03-26", `{"elicitation":{}}`, false},
+	}
+
+	for _, tt := range tests {
+		server := NewServer("test-server", "1.0.0")
+		server.SetIO(nil, nil, io.Discard)
+		if response := server.handleMessage(initializeMessage(tt.version, tt.capabilities)); response.Error != nil {
+			t.Fatalf("initialize failed: %+v", response.Error)
+		}
+		ctx := withClient(context.Background(), server.stdioClient)
+		if got := SupportsElicitation(ctx); got != tt.elicitation {
+			t.Errorf("SupportsElicitation with %s %s = %v, expected %v", tt.version, tt.capabilities, got, tt.elicitation)
+		}
+		if ProtocolVersion(ctx) != tt.version || ClientInfoFromContext(ctx).Name != "test-client" {
+			t.Errorf("Expected the client state to be recorded, got %s %+v", ProtocolVersion(ctx), ClientInfoFromContext(ctx))
+		}
+	}
+
+	if SupportsElicitation(context.Background()) || ProtocolVersion(context.Background()) != "" {
+		t.Error("Expected no client state outside a transport")
+	}
+}
+
+func TestInitialize_InvalidParams(t *testing.T) {
+	server := NewServer("test-server", "1.0.0")
+	server.SetIO(nil, nil, io.Discard)
+
+	for _, params := range []string{`{"protocolVersion":5}`, `{}`, `{"protocolVersion":""}`, `{"capabilities":{},"clientInfo":{"name":"x"}}`} {
+		response := server.handleMessage([]byte(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":` + params + `}`))
+		if response.Error == nil || response.Error.Code != InvalidParams {
+			t.Errorf("Expected InvalidParams for %s, got %+v", params, response)
+		}
+	}
+	if ProtocolVersion(withClient(context.Background(), server.stdioClient)) != "" {
+		t.Error("Expected a rejected initialize not to negotiate a version")
+	}
+}
+
+func TestInitialize_HTTPSessions(t *testing.T) {
+	server, httpServer := newStreamableServer(t)
+	features := newFeatureServer()
+	server.RegisterTool(features.tools[0], features.handlers["stats"])
+
+	// Each session keeps the version it negotiated
+	sessions := make(map[string]string)
+	for _, version := range []string{"2024-11-05", "2025-06-18"} {
+		resp := postMCP(t, httpServer.URL, "", "application/json", string(initializeMessage(version, "{}")))
+		resp.Body.Close()
+		sessions[version] = resp.Header.Get(SessionHeader)
+	}
+
+	listTools := func(sessionID, protocolVersion string) (*http.Response, []map[string]interface{}) {
+		req, _ := http.NewRequest(http.MethodPost, httpServer.URL, strings.NewReader(`{"jsonrpc":"2.0","id":2,"method":"tools/list"}`))
+		if sessionID != "" {
+			req.Header.Set(SessionHeader, sessionID)
+		}
+		if protocolVersion != "" {
+			req.Header.Set(ProtocolVersionHeader, protocolVersion)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var response struct {
+			Result struct {
+				Tools []map[string]interface{} `json:"tools"`
+			} `json:"result"`
+		}
+		json.NewDecoder(resp.Body).Decode(&response)
+		return resp, response.Result.Tools
+	}
+
+	if _, tools := listTools(sessions["2024-11-05"], "2024-11-05"); tools[0]["annotations"] != nil {
+		t.Errorf("Expected no annotations for a 2024-11-05 session, got %v", tools[0])
+	}
+	if _, tools := listTools(sessions["2025-06-18"], "2025-06-18"); tools[0]["outputSchema"] == nil {
+		t.Errorf("Expected an output schema for a 2025-06-18 session, got %v", tools[0])
+	}
+
+	// Requests without a session are assumed to use 2025-03-26 unless they
+	// name a version
+	if _, tools := listTools("", ""); tools[0]["annotations"] == nil || tools[0]["outputSchema"] != nil {
+		t.Errorf("Expected 2025-03-26 fields without a session, got %v", tools[0])
+	}
+	if _, tools := listTools("", "2025-06-18"); tools[0]["outputSchema"] == nil {
+		t.Errorf("Expected the protocol version header to be honoured, got %v", tools[0])
+	}
+	if resp, _ := listTools("", "1999-01-01"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected an unsupported protocol version header to be rejected, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,171 @@
+package mcp
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// validateArguments checks the arguments of a tool call against the tool's
+// input schema and returns every violation, with the path of the offending
+// value: missing required arguments first, then the rest in argument
+// order. Arguments the schema does not describe are accepted.
+func validateArguments(schema JSONSchema, arguments map[string]interface{}) []string {
+	var violations []string
+	for _, name := range schema.Required {
+		if _, ok := arguments[name]; !ok {
+			violations = append(violations, fmt.Sprintf("%s: required", name))
+		}
+	}
+	for _, name := range sortedKeys(arguments) {
+		if property, ok := schema.Properties[name]; ok {
+			violations = append(violations, validateValue(name, property, arguments[name])...)
+		}
+	}
+	return violations
+}
+
+// validateValue checks a value against a property of a schema. Once the
+// value has the wrong type, its other constraints are not checked.
+func validateValue(path string, property Property, value interface{}) []string {
+	if !hasType(property.Type, value) {
+		return []string{fmt.Sprintf("%s: expected %s, got %s", path, property.Type, typeOf(value))}
+	}
+
+	var violations []string
+	if len(property.Enum) > 0 {
+		if s, _ := value.(string); !contains(property.Enum, s) {
+			violations = append(violations, fmt.Sprintf("%s: %s is not one of %s", path, formatValue(value), strings.Join(property.Enum, ", ")))
+		}
+	}
+	if n, ok := number(value); ok {
+		if property.Minimum != nil && n < float64(*property.Minimum) {
+			violations = append(violations, fmt.Sprintf("%s: %s is less than the minimum %d", path, formatValue(value), *property.Minimum))
+		}
+		if property.Maximum != nil && n > float64(*property.Maximum) {
+			violations = append(violations, fmt.Sprintf("%s: %s is greater than the maximum %d", path, formatValue(value), *property.Maximum))
+		}
+	}
+
+	switch value := value.(type) {
+	case []interface{}:
+		if property.Items != nil {
+			for i, item := range value {
+				violations = append(violations, validateValue(fmt.Sprintf("%s[%d]", path, i), *property.Items, item)...)
+			}
+		}
+	case map[string]interface{}:
+		for _, name := range sortedKeys(value) {
+			if nested, ok := property.Properties[name]; ok {
+				violations = append(violations, validateValue(path+"."+name, nested, value[name])...)
+			} else if property.AdditionalProperties != nil {
+				violations = append(violations, validateValue(path+"."+name, *property.AdditionalProperties, value[name])...)
+			}
+		}
+	}
+	return violations
+}
+
+// hasType reports whether value is of a JSON Schema type; an empty type
+// allows any value
+func hasType(schemaType string, value interface{}) bool {
+	switch schemaType {
+	case "":
+		return true
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "number":
+		_, ok := number(value)
+		return ok
+	case "integer":
+		n, ok := number(value)
+		return ok && n == math.Trunc(n) && !math.IsInf(n, 0)
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "null":
+		return value == nil
+	default:
+		return false
+	}
+}
+
+// number returns a numeric value as a float64. Arguments decoded from JSON
+// are float64; Go integers are accepted for handlers called directly.
+func number(value interface{}) (float64, bool) {
+	switch n := value.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	default:
+		return 0, false
+	}
+}
+
+// typeOf names the JSON type of a value, for error messages
+func typeOf(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	if n, ok := number(value); ok {
+		if n == math.Trunc(n) && !math.IsInf(n, 0) {
+			return "integer"
+		}
+		return "number"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// formatValue renders a scalar value as it appeared in JSON
+func formatValue(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return strconv.Quote(s)
+	}
+	if n, ok := number(value); ok {
+		return strconv.FormatFloat(n, 'f', -1, 64)
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
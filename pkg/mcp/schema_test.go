@@ -0,0 +1,100 @@
+package mcp
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func intPtr(i int) *int { return &i }
+
+// fetchSchema resembles the web_fetch tool's input schema
+var fetchSchema = JSONSchema{
+	Type: "object",
+	Properties: map[string]Property{
+		"url":      {Type: "string"},
+		"method":   {Type: "string", Enum: []string{"GET", "POST"}},
+		"max_size": {Type: "integer", Minimum: intPtr(1024), Maximum: intPtr(10485760)},
+		"ratio":    {Type: "number", Maximum: intPtr(1)},
+		"verbose":  {Type: "boolean"},
+		"argv":     {Type: "array", Items: &Property{Type: "string"}},
+		"headers":  {Type: "object", Properties: map[string]Property{"Accept": {Type: "string"}}},
+		"env":      {Type: "object", AdditionalProperties: &Property{Type: "string"}},
+		"anything": {},
+	},
+	Required: []string{"url"},
+}
+
+func TestValidateArguments(t *testing.T) {
+	tests := []struct {
+		name       string
+		arguments  map[string]interface{}
+		violations []string
+	}{
+		{"valid", map[string]interface{}{
+			"url": "https://example.com", "method": "POST", "max_size": float64(2048), "ratio": 0.5,
+			"verbose": true, "argv": []interface{}{"a", "b"}, "headers": map[string]interface{}{"Accept": "text/html", "X-Other": float64(1)},
+			"env": map[string]interface{}{"DEBUG": "true"}, "anything": nil, "unknown": float64(3),
+		}, nil},
+		{"Go integers", map[string]interface{}{"url": "u", "max_size": 4096}, nil},
+		{"bounds are inclusive", map[string]interface{}{"url": "u", "max_size": float64(10485760), "ratio": float64(1)}, nil},
+		{"missing required", map[string]interface{}{}, []string{"url: required"}},
+		{"wrong types", map[string]interface{}{"url": float64(1), "verbose": "yes", "argv": "ls", "headers": []interface{}{}},
+			[]string{"argv: expected array, got string", "headers: expected object, got array", "url: expected string, got integer", "verbose: expected boolean, got string"}},
+		{"null", map[string]interface{}{"url": nil}, []string{"url: expected string, got null"}},
+		{"fractional integer", map[string]interface{}{"url": "u", "max_size": 2048.5}, []string{"max_size: expected integer, got number"}},
+		{"above maximum", map[string]interface{}{"url": "u", "max_size": float64(10485761)}, []string{"max_size: 10485761 is greater than the maximum 10485760"}},
+		{"below minimum", map[string]interface{}{"url": "u", "max_size": float64(10)}, []string{"max_size: 10 is less than the minimum 1024"}},
+		{"number above maximum", map[string]interface{}{"url": "u", "ratio": 1.5}, []string{"ratio: 1.5 is greater than the maximum 1"}},
+		{"not in enum", map[string]interface{}{"url": "u", "method": "PATCH"}, []string{`method: "PATCH" is not one of GET, POST`}},
+		{"array items", map[string]interface{}{"url": "u", "argv": []interface{}{"ls", float64(1), true}},
+			[]string{"argv[1]: expected string, got integer", "argv[2]: expected string, got boolean"}},
+		{"nested properties", map[string]interface{}{"url": "u", "headers": map[string]interface{}{"Accept": false}},
+			[]string{"headers.Accept: expected string, got boolean"}},
+		{"additional properties", map[string]interface{}{"url": "u", "env": map[string]interface{}{"A": "1", "B": float64(2), "C": nil}},
+			[]string{"env.B: expected string, got integer", "env.C: expected string, got null"}},
+		{"every violation", map[string]interface{}{"method": "get", "max_size": float64(20000000)},
+			[]string{"url: required", "max_size: 20000000 is greater than the maximum 10485760", `method: "get" is not one of GET, POST`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validateArguments(fetchSchema, tt.arguments); !reflect.DeepEqual(got, tt.violations) {
+				t.Errorf("Expected %q, got %q", tt.violations, got)
+			}
+		})
+	}
+}
+
+func TestHandleCallTool_InvalidArguments(t *testing.T) {
+	server := NewServer("test-server", "1.0.0")
+	server.SetIO(nil, nil, io.Discard)
+	called := false
+	server.RegisterTool(Tool{Name: "web_fetch", InputSchema: fetchSchema}, func(ctx context.Context, args map[string]interface{}) (*CallToolResult, error) {
+		called = true
+		return &CallToolResult{Content: []ContentItem{{Type: "text", Text: "fetched"}}}, nil
+	})
+
+	response := server.handleMessage([]byte(`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"web_fetch","arguments":{"url":"https://example.com","max_size":20971520,"method":"PATCH"}}}`))
+	if called {
+		t.Error("Expected the handler not to be called")
+	}
+	if response.Error == nil || response.Error.Code != InvalidParams {
+		t.Fatalf("Expected InvalidParams, got %+v", response)
+	}
+	for _, expected := range []string{"web_fetch", "max_size: 20971520 is greater than the maximum 10485760", `method: "PATCH" is not one of GET, POST`} {
+		if !strings.Contains(response.Error.Message, expected) {
+			t.Errorf("Expected the message to mention %q, got %q", expected, response.Error.Message)
+		}
+	}
+	if violations, ok := response.Error.Data.([]string); !ok || len(violations) != 2 {
+		t.Errorf("Expected the violations in the error data, got %#v", response.Error.Data)
+	}
+
+	response = server.handleMessage([]byte(`{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"web_fetch","arguments":{"url":"https://example.com","max_size":4096}}}`))
+	if response.Error != nil || !called {
+		t.Errorf("Expected valid arguments to reach the handler, got %+v", response.Error)
+	}
+}
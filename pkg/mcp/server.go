@@ -2,18 +2,26 @@ package mcp
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"math"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/user/go-mcp-commander/pkg/auth"
 )
 
-// ToolHandler is a function that handles a tool call
-type ToolHandler func(arguments map[string]interface{}) (*CallToolResult, error)
+// ToolHandler is a function that handles a tool call. The context carries
+// per-request state such as the client's progress token (see ReportProgress).
+type ToolHandler func(ctx context.Context, arguments map[string]interface{}) (*CallToolResult, error)
 
 // Server represents an MCP server
 type Server struct {
@@ -21,22 +29,61 @@ type Server struct {
 	version  string
 	tools    []Tool
 	handlers map[string]ToolHandler
-	mu       sync.RWMutex
-	stdin    io.Reader
-	stdout   io.Writer
-	stderr   io.Writer
+	// schemas holds each tool's input schema, which arguments are checked
+	// against before its handler is called
+	schemas map[string]JSONSchema
+	mu      sync.RWMutex
+	writeMu sync.Mutex
+	stdin   io.Reader
+	stdout  io.Writer
+	stderr  io.Writer
+
+	// maxInFlight is the number of stdio requests handled concurrently
+	maxInFlight int
+
+	// authenticator checks tokens on the HTTP transport; when nil, RunHTTP
+	// configures one from the environment
+	authenticator *auth.Validator
+	// lockout throttles clients that repeatedly fail authentication
+	lockout *auth.Lockout
+	// onAuthFailure, if set, receives every rejected authentication attempt
+	onAuthFailure func(auth.Failure)
+	// tls, if set, makes RunHTTP serve HTTPS
+	tls *TLSConfig
+	// allowedOrigins are the browser origins, besides the local host's,
+	// allowed to use the HTTP transport; "*" allows any
+	allowedOrigins []string
+	// sessions holds the HTTP transport's sessions
+	sessions *sessionStore
+	// stdioClient is what the stdio client declared in initialize
+	stdioClient *clientState
+
+	// pool runs stdio requests
+	pool     *workerPool
+	poolOnce sync.Once
+
+	// inFlight holds the running requests, keyed by requestKey. Clients
+	// should not reuse the id of a running request, but if they do both
+	// are kept.
+	inFlight   map[string][]*inFlightRequest
+	inFlightMu sync.Mutex
 }
 
 // NewServer creates a new MCP server
 func NewServer(name, version string) *Server {
 	return &Server{
-		name:     name,
-		version:  version,
-		tools:    make([]Tool, 0),
-		handlers: make(map[string]ToolHandler),
-		stdin:    os.Stdin,
-		stdout:   os.Stdout,
-		stderr:   os.Stderr,
+		name:        name,
+		version:     version,
+		tools:       make([]Tool, 0),
+		handlers:    make(map[string]ToolHandler),
+		schemas:     make(map[string]JSONSchema),
+		inFlight:    make(map[string][]*inFlightRequest),
+		sessions:    newSessionStore(),
+		stdioClient: &clientState{},
+		maxInFlight: DefaultMaxInFlight,
+		stdin:       os.Stdin,
+		stdout:      os.Stdout,
+		stderr:      os.Stderr,
 	}
 }
 
@@ -46,36 +93,216 @@ func (s *Server) RegisterTool(tool Tool, handler ToolHandler) {
 	defer s.mu.Unlock()
 	s.tools = append(s.tools, tool)
 	s.handlers[tool.Name] = handler
+	s.schemas[tool.Name] = tool.InputSchema
 }
 
-// Run starts the server and processes requests from stdin
+// Run starts the server and processes requests from stdin. Requests are
+// dispatched to a pool of workers so that a long-running tool call does not
+// block other requests; responses are written as they complete and may be
+// out of order, with the client correlating them by id. A batch is answered
+// with a single array once all of its requests have completed. Requests
+// beyond the pool's queue are refused, so that notifications are always
+// read promptly.
 func (s *Server) Run() error {
-	scanner := bufio.NewScanner(s.stdin)
-	// Increase buffer size for large messages
-	buf := make([]byte, 0, 64*1024)
-	scanner.Buffer(buf, 10*1024*1024)
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		if line == "" {
-			continue
-		}
+	return s.RunContext(context.Background())
+}
 
-		response := s.handleMessage([]byte(line))
-		if response != nil {
-			s.sendResponse(response)
+// RunContext is Run until stdin is closed or ctx is done. In the latter
+// case running requests are cancelled, and RunContext returns nil once they
+// have completed.
+func (s *Server) RunContext(ctx context.Context) error {
+	requestCtx := withClient(ctx, s.stdioClient)
+
+	// Lines are read in the background so that shutdown need not wait for
+	// input
+	lines := make(chan []byte)
+	var scanErr error
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(s.stdin)
+		// Increase buffer size for large messages
+		buf := make([]byte, 0, 64*1024)
+		scanner.Buffer(buf, 10*1024*1024)
+		for scanner.Scan() {
+			line := scanner.Bytes()
+			if len(line) == 0 {
+				continue
+			}
+			data := make([]byte, len(line))
+			copy(data, line)
+			select {
+			case lines <- data:
+			case <-ctx.Done():
+				return
+			}
+		}
+		scanErr = scanner.Err()
+	}()
+
+	pool := s.workers()
+	var wg sync.WaitGroup
+
+read:
+	for {
+		select {
+		case <-ctx.Done():
+			s.Log("Shutting down: cancelling running requests")
+			break read
+		case data, ok := <-lines:
+			if !ok {
+				break read
+			}
+			s.dispatchLine(requestCtx, pool, &wg, data)
 		}
 	}
 
-	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("scanner error: %w", err)
+	// Let in-flight requests finish before returning
+	wg.Wait()
+
+	if ctx.Err() == nil && scanErr != nil {
+		return fmt.Errorf("scanner error: %w", scanErr)
 	}
 
 	return nil
 }
 
+// dispatchLine handles a line read from stdin: notifications and
+// initialize at once, and requests on the pool, counted in wg until they are
+// answered. The members of a batch go on the pool one by one.
+func (s *Server) dispatchLine(ctx context.Context, pool *workerPool, wg *sync.WaitGroup, data []byte) {
+	// Notifications are cheap and must not wait behind busy workers,
+	// so notifications/cancelled can interrupt a running request
+	if isNotification(data) {
+		s.handleMessageWith(ctx, data, s.sendNotification)
+		return
+	}
+	// Requests read after initialize must see the negotiated version, even
+	// if the client sent them without waiting for its response
+	if requestMethod(data) == "initialize" {
+		s.sendResponse(s.handleMessageWith(ctx, data, s.sendNotification))
+		return
+	}
+
+	wg.Add(1)
+	if isBatch(data) {
+		// A batch waits for its members outside the pool, so that it does
+		// not hold a slot they need
+		go func() {
+			defer wg.Done()
+			if reply := s.handlePayload(ctx, data, s.sendNotification); reply != nil {
+				s.sendResponse(reply)
+			}
+		}()
+		return
+	}
+	job := func() {
+		defer wg.Done()
+		if reply := s.handleMessageWith(ctx, data, s.sendNotification); reply != nil {
+			s.sendResponse(reply)
+		}
+	}
+	// Waiting for room in the queue would leave notifications/cancelled
+	// unread until a request completes
+	if !pool.trySubmit(job) {
+		wg.Done()
+		s.sendResponse(overloadedResponse(data))
+	}
+}
+
+// requestQueueSize is the number of stdio requests that may be read ahead of
+// those being handled
+const requestQueueSize = 64
+
+// DefaultMaxInFlight is the default number of stdio requests handled concurrently
+const DefaultMaxInFlight = 8
+
 // RunHTTP starts the server in HTTP mode with optional authentication
 func (s *Server) RunHTTP(addr string) error {
+	return s.RunHTTPContext(context.Background(), addr)
+}
+
+// RunHTTPContext is RunHTTP until ctx is done, when the server stops
+// accepting connections, ends its sessions, cancelling their requests, and
+// returns nil
+func (s *Server) RunHTTPContext(ctx context.Context, addr string) error {
+	if s.authenticator == nil {
+		validator, err := auth.NewValidator(auth.ConfigFromEnv())
+		if err != nil {
+			return err
+		}
+		s.authenticator = validator
+	}
+	if s.lockout == nil {
+		s.lockout = auth.NewLockout(auth.LockoutConfig{MaxFailures: auth.DefaultMaxFailures})
+	}
+
+	status := "authentication disabled"
+	if s.authenticator.Enabled() {
+		status = "authentication enabled"
+	}
+	server := &http.Server{Addr: addr, Handler: s.httpHandler()}
+	scheme := "HTTP"
+	if s.tls != nil {
+		tlsConfig, _, err := newTLSConfig(*s.tls, s.Log)
+		if err != nil {
+			return err
+		}
+		if s.tls.ClientCAFile != "" {
+			status += ", client certificates " + tlsClientAuth(s.tls)
+		}
+		server.TLSConfig = tlsConfig
+		scheme = "HTTPS"
+	}
+
+	served := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+		case <-served:
+			return
+		}
+		s.Log("Shutting down: ending sessions")
+		s.sessions.terminateAll()
+		// Event streams stay open, so connections are only given a moment
+		// to finish
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			server.Close()
+		}
+	}()
+
+	fmt.Fprintf(s.stderr, "Commander MCP Server running on %s at %s (%s)\n", scheme, addr, status)
+	var err error
+	if s.tls == nil {
+		err = server.ListenAndServe()
+	} else {
+		err = server.ListenAndServeTLS("", "")
+	}
+	close(served)
+	<-stopped
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// httpShutdownTimeout is how long RunHTTPContext waits for connections to
+// finish when shutting down
+const httpShutdownTimeout = 5 * time.Second
+
+// tlsClientAuth describes the client certificate mode
+func tlsClientAuth(config *TLSConfig) string {
+	if config.ClientAuth == "" {
+		return ClientAuthRequire
+	}
+	return config.ClientAuth
+}
+
+// httpHandler routes the health check and MCP endpoints
+func (s *Server) httpHandler() http.Handler {
 	mux := http.NewServeMux()
 
 	// Health check endpoint (no auth required)
@@ -89,122 +316,479 @@ func (s *Server) RunHTTP(addr string) error {
 	})
 
 	// MCP endpoint with authentication
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	mux.HandleFunc("/", s.serveMCP)
+
+	return mux
+}
+
+// serveMCP implements the Streamable HTTP transport: POST sends messages to
+// the server, GET opens a stream for server-initiated messages and DELETE
+// ends a session. Sessions are created by initialize and identified by the
+// Mcp-Session-Id header; requests without one are handled statelessly.
+func (s *Server) serveMCP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost, http.MethodGet, http.MethodDelete:
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.originAllowed(r) {
+		s.Log("Rejected request from origin %s", r.Header.Get("Origin"))
+		writeHTTPError(w, http.StatusForbidden, -32000, "Forbidden: origin not allowed")
+		return
+	}
+
+	identity, ok := s.authenticateRequest(w, r)
+	if !ok {
+		return
+	}
+	if version := r.Header.Get(ProtocolVersionHeader); version != "" && !isSupportedVersion(version) {
+		writeHTTPError(w, http.StatusBadRequest, -32000, fmt.Sprintf("Bad Request: unsupported protocol version %s", version))
+		return
+	}
+	identityName := ""
+	if identity != nil {
+		identityName = identity.Name
+	}
+
+	var sess *session
+	if id := r.Header.Get(SessionHeader); id != "" {
+		if sess = s.sessions.get(id, identityName); sess == nil {
+			writeHTTPError(w, http.StatusNotFound, -32000, "Session not found; send initialize to start a new session")
 			return
 		}
+	} else if r.Method != http.MethodPost {
+		writeHTTPError(w, http.StatusBadRequest, -32000, "Bad Request: missing "+SessionHeader+" header")
+		return
+	}
 
-		// Check authentication if enabled
-		if auth.IsAuthEnabled() {
-			token := r.Header.Get(auth.AuthHeaderName)
-			if !auth.ValidateAgainstExpected(token) {
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusUnauthorized)
-				json.NewEncoder(w).Encode(map[string]interface{}{
-					"jsonrpc": "2.0",
-					"id":      nil,
-					"error":   map[string]interface{}{"code": -32001, "message": "Unauthorized: invalid or missing authentication token"},
-				})
-				return
-			}
+	switch r.Method {
+	case http.MethodGet:
+		s.serveStream(w, r, sess)
+	case http.MethodDelete:
+		s.sessions.terminate(sess)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s.servePost(w, r, identity, sess)
+	}
+}
+
+// servePost handles the messages in a POST body. Requests are answered
+// with a JSON body, or with an event stream carrying their notifications
+// followed by the response when the client accepts text/event-stream.
+func (s *Server) servePost(w http.ResponseWriter, r *http.Request, identity *auth.Identity, sess *session) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"jsonrpc": "2.0",
+			"id":      nil,
+			"error":   map[string]interface{}{"code": -32700, "message": "Parse error"},
+		})
+		return
+	}
+
+	if requestMethod(body) == "initialize" {
+		identityName := ""
+		if identity != nil {
+			identityName = identity.Name
+		}
+		if sess, err = s.sessions.create(identityName); err != nil {
+			writeHTTPError(w, http.StatusInternalServerError, InternalError, err.Error())
+			return
 		}
+		w.Header().Set(SessionHeader, sess.id)
+	}
 
-		body, err := io.ReadAll(r.Body)
-		if err != nil {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(map[string]interface{}{
-				"jsonrpc": "2.0",
-				"id":      nil,
-				"error":   map[string]interface{}{"code": -32700, "message": "Parse error"},
-			})
+	// Requests run in the background rather than in the HTTP request's
+	// context, so that a response on an event stream can still be resumed
+	// after the connection drops. Ending the session cancels them.
+	ctx := context.Background()
+	if sess != nil {
+		ctx = withClient(withSession(sess.ctx, sess), sess.client)
+	} else {
+		version := r.Header.Get(ProtocolVersionHeader)
+		if version == "" {
+			version = defaultHTTPProtocolVersion
+		}
+		ctx = withClient(ctx, &clientState{protocolVersion: version})
+	}
+	if identity != nil {
+		ctx = auth.WithIdentity(ctx, identity)
+	}
+
+	if !expectsResponse(body) || !acceptsEventStream(r) {
+		reply := s.handlePayload(ctx, body, nil)
+		if reply == nil {
+			w.WriteHeader(http.StatusAccepted)
 			return
 		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(reply)
+		return
+	}
+
+	stream := newEventStream(nil, 0)
+	if sess != nil {
+		stream = sess.newStream()
+	}
+	go func() {
+		if reply := s.handlePayload(ctx, body, stream.notify); reply != nil {
+			stream.send(reply)
+		}
+		stream.finish()
+	}()
+
+	writeStreamHeaders(w)
+	stream.serve(r.Context(), w, 0)
+}
+
+// serveStream answers a GET request with the session's stream for
+// server-initiated messages or, given a Last-Event-ID, by resuming the
+// stream that event was sent on from the event after it
+func (s *Server) serveStream(w http.ResponseWriter, r *http.Request, sess *session) {
+	if !acceptsEventStream(r) {
+		w.Header().Set("Allow", "POST, DELETE")
+		http.Error(w, "Method not allowed: GET requires Accept: text/event-stream", http.StatusMethodNotAllowed)
+		return
+	}
+
+	stream, after := sess.standalone(), 0
+	if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" {
+		id, seq, ok := parseEventID(lastEventID)
+		if ok {
+			stream = sess.stream(id)
+		}
+		if !ok || stream == nil {
+			writeHTTPError(w, http.StatusBadRequest, -32000, "Bad Request: unknown or expired Last-Event-ID")
+			return
+		}
+		after = seq
+	}
+
+	writeStreamHeaders(w)
+	stream.serve(r.Context(), w, after)
+}
+
+// authenticateRequest checks the request's credentials, returning the
+// caller's identity, or nil if authentication is disabled. A verified client
+// certificate authenticates the request by itself. Clients that keep
+// failing are locked out without their tokens being checked. If the request
+// is rejected, the error response has been written and ok is false.
+func (s *Server) authenticateRequest(w http.ResponseWriter, r *http.Request) (identity *auth.Identity, ok bool) {
+	if identity := s.certificateIdentity(r); identity != nil {
+		return identity, true
+	}
+	if s.authenticator == nil || !s.authenticator.Enabled() {
+		return nil, true
+	}
+
+	client := auth.ClientKey(r.RemoteAddr)
+	if locked, retryAfter := s.lockout.Locked(client); locked {
+		s.authFailed(auth.Failure{Client: client, Reason: "locked out", Locked: true, LockedFor: retryAfter})
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		writeHTTPError(w, http.StatusTooManyRequests, -32001, "Too many failed authentication attempts; retry later")
+		return nil, false
+	}
 
-		response := s.handleMessage(body)
-		if response != nil {
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(response)
+	identity, err := s.authenticator.Authenticate(auth.TokenFromRequest(r))
+	if err != nil {
+		failures, lockedFor := s.lockout.Failure(client)
+		s.authFailed(auth.Failure{Client: client, Reason: err.Error(), Failures: failures, LockedFor: lockedFor})
+		w.Header().Set("WWW-Authenticate", `Bearer realm="mcp"`)
+		writeHTTPError(w, http.StatusUnauthorized, -32001, "Unauthorized: invalid or missing authentication token")
+		return nil, false
+	}
+	s.lockout.Success(client)
+	return identity, true
+}
+
+// originAllowed reports whether a request's Origin may use the MCP
+// endpoint. Browsers send an Origin, so refusing unknown ones keeps web
+// pages, including those using DNS rebinding to reach a local server, from
+// calling tools. Requests without an Origin come from other clients and are
+// allowed, as are pages served from the local host.
+func (s *Server) originAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range s.allowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
 		}
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	host := u.Hostname()
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// certificateIdentity returns the identity of the request's verified client
+// certificate, or nil if it has none
+func (s *Server) certificateIdentity(r *http.Request) *auth.Identity {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || s.authenticator == nil {
+		return nil
+	}
+	return s.authenticator.AuthenticateCertificate(r.TLS.VerifiedChains[0][0])
+}
+
+// authFailed reports a rejected authentication attempt
+func (s *Server) authFailed(failure auth.Failure) {
+	if !failure.Locked {
+		s.Log("Authentication failed from %s: %s", failure.Client, failure.Reason)
+	}
+	if s.onAuthFailure != nil {
+		s.onAuthFailure(failure)
+	}
+}
+
+// writeHTTPError answers a request that was refused before its messages
+// were handled
+func writeHTTPError(w http.ResponseWriter, status, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      nil,
+		"error":   map[string]interface{}{"code": code, "message": message},
 	})
+}
 
-	if auth.IsAuthEnabled() {
-		fmt.Fprintf(s.stderr, "Commander MCP Server running on HTTP at %s (authentication enabled)\n", addr)
-	} else {
-		fmt.Fprintf(s.stderr, "Commander MCP Server running on HTTP at %s (authentication disabled)\n", addr)
+// writeStreamHeaders starts a text/event-stream response
+func writeStreamHeaders(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
 	}
-	return http.ListenAndServe(addr, mux)
 }
 
-func (s *Server) handleMessage(data []byte) *JSONRPCResponse {
-	var request JSONRPCRequest
-	if err := json.Unmarshal(data, &request); err != nil {
-		return &JSONRPCResponse{
-			JSONRPC: "2.0",
-			Error: &JSONRPCError{
-				Code:    ParseError,
-				Message: "Parse error",
-				Data:    err.Error(),
-			},
+func acceptsEventStream(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		if strings.Contains(accept, "text/event-stream") {
+			return true
 		}
 	}
+	return false
+}
+
+// handleMessage processes a message from the stdio transport
+func (s *Server) handleMessage(data []byte) *JSONRPCResponse {
+	return s.handleMessageWith(s.stdioContext(), data, s.sendNotification)
+}
+
+// stdioContext returns the context of requests from the stdio transport
+func (s *Server) stdioContext() context.Context {
+	return withClient(context.Background(), s.stdioClient)
+}
 
-	// Handle notifications (no ID)
+// handleMessageWith processes a message, delivering any notifications raised
+// while handling it through notify (which may be nil to drop them). ctx
+// carries the caller's identity, if authenticated.
+func (s *Server) handleMessageWith(ctx context.Context, data []byte, notify notifyFunc) *JSONRPCResponse {
+	request, errResponse := decodeMessage(data)
+	if errResponse != nil {
+		return errResponse
+	}
+	if request == nil {
+		// The server sends no requests, so a response from the client
+		// answers nothing
+		fmt.Fprintln(s.stderr, "Ignoring unexpected response from client")
+		return nil
+	}
+
+	// Notifications (no ID) are never answered, even when they fail
 	if request.ID == nil {
-		s.handleNotification(&request)
+		s.handleNotification(ctx, request)
 		return nil
 	}
 
-	return s.handleRequest(&request)
+	return s.handleRequest(ctx, request, notify)
 }
 
-func (s *Server) handleNotification(request *JSONRPCRequest) {
+func (s *Server) handleNotification(ctx context.Context, request *JSONRPCRequest) {
 	switch request.Method {
 	case "notifications/initialized":
 		// Client initialized notification, no action needed
 		fmt.Fprintln(s.stderr, "Client initialized")
 	case "notifications/cancelled":
-		// Request cancellation, no action needed for now
+		params, _ := request.Params.(map[string]interface{})
+		if params == nil || params["requestId"] == nil {
+			return
+		}
+		if s.cancelRequest(ctx, params["requestId"]) {
+			reason, _ := params["reason"].(string)
+			fmt.Fprintf(s.stderr, "Request %v cancelled: %s\n", params["requestId"], reason)
+		}
+	}
+}
+
+// isNotification reports whether data is a valid message that gets no
+// response: a notification, or a response from the client
+func isNotification(data []byte) bool {
+	request, errResponse := decodeMessage(data)
+	return errResponse == nil && (request == nil || request.ID == nil)
+}
+
+// requestMethod returns the method of a single valid request, or "" if
+// data is not one
+func requestMethod(data []byte) string {
+	request, _ := decodeMessage(data)
+	if request == nil {
+		return ""
+	}
+	return request.Method
+}
+
+// inFlightRequest is a running request that can be cancelled
+type inFlightRequest struct {
+	cancel context.CancelFunc
+}
+
+// requestKey normalizes a JSON-RPC id for use as a map key, keeping numeric
+// and string ids with the same text distinct. Ids are scoped to the client
+// connection, so that clients cannot cancel each other's requests: the HTTP
+// session, or else the client state, of which the stdio transport has one
+// and each sessionless HTTP request its own. It returns false if ctx
+// belongs to no connection, and the request cannot be cancelled.
+func requestKey(ctx context.Context, id interface{}) (string, bool) {
+	if sess := sessionFromContext(ctx); sess != nil {
+		return fmt.Sprintf("%s/%T:%v", sess.id, id, id), true
+	}
+	if client, ok := ctx.Value(clientKey{}).(*clientState); ok {
+		return fmt.Sprintf("%p/%T:%v", client, id, id), true
+	}
+	return "", false
+}
+
+// beginRequest registers a cancellable context, derived from parent, for the
+// request with the given id. The returned function must be called when the
+// request completes.
+func (s *Server) beginRequest(parent context.Context, id interface{}) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(parent)
+	key, ok := requestKey(parent, id)
+	if !ok {
+		return ctx, cancel
+	}
+
+	request := &inFlightRequest{cancel: cancel}
+	s.inFlightMu.Lock()
+	s.inFlight[key] = append(s.inFlight[key], request)
+	s.inFlightMu.Unlock()
+
+	return ctx, func() {
+		s.inFlightMu.Lock()
+		requests := s.inFlight[key]
+		for i, r := range requests {
+			if r == request {
+				requests = append(requests[:i:i], requests[i+1:]...)
+				break
+			}
+		}
+		if len(requests) == 0 {
+			delete(s.inFlight, key)
+		} else {
+			s.inFlight[key] = requests
+		}
+		s.inFlightMu.Unlock()
+		cancel()
 	}
 }
 
-func (s *Server) handleRequest(request *JSONRPCRequest) *JSONRPCResponse {
+// cancelRequest cancels the in-flight requests with the given id on the
+// connection of ctx, returning false if no such request is running
+func (s *Server) cancelRequest(ctx context.Context, id interface{}) bool {
+	key, ok := requestKey(ctx, id)
+	if !ok {
+		return false
+	}
+	s.inFlightMu.Lock()
+	requests := s.inFlight[key]
+	s.inFlightMu.Unlock()
+
+	for _, request := range requests {
+		request.cancel()
+	}
+	return len(requests) > 0
+}
+
+func (s *Server) handleRequest(ctx context.Context, request *JSONRPCRequest, notify notifyFunc) *JSONRPCResponse {
 	response := &JSONRPCResponse{
 		JSONRPC: "2.0",
 		ID:      request.ID,
 	}
 
+	switch request.Method {
+	case "initialize", "tools/list", "tools/call", "ping":
+	default:
+		response.Error = &JSONRPCError{
+			Code:    MethodNotFound,
+			Message: fmt.Sprintf("Method not found: %s", request.Method),
+		}
+		return response
+	}
+
+	// MCP methods take their params by name
+	params, paramsErr := paramsObject(request.Params)
+	if paramsErr != nil {
+		response.Error = paramsErr
+		return response
+	}
+
 	switch request.Method {
 	case "initialize":
-		response.Result = s.handleInitialize(request.Params)
+		result, err := s.handleInitialize(ctx, params)
+		if err != nil {
+			response.Error = invalidParams("%v", err)
+		} else {
+			response.Result = result
+		}
 	case "tools/list":
-		response.Result = s.handleListTools()
+		response.Result = s.handleListTools(ctx)
 	case "tools/call":
-		result, err := s.handleCallTool(request.Params)
-		if err != nil {
-			response.Error = &JSONRPCError{
-				Code:    InternalError,
-				Message: err.Error(),
-			}
+		ctx, done := s.beginRequest(ctx, request.ID)
+		defer done()
+		result, callErr := s.handleCallTool(ctx, params, notify)
+		if callErr != nil {
+			response.Error = callErr
 		} else {
 			response.Result = result
 		}
 	case "ping":
 		response.Result = map[string]interface{}{}
-	default:
-		response.Error = &JSONRPCError{
-			Code:    MethodNotFound,
-			Message: fmt.Sprintf("Method not found: %s", request.Method),
-		}
 	}
 
 	return response
 }
 
-func (s *Server) handleInitialize(params interface{}) *InitializeResult {
+// handleInitialize negotiates the protocol version and records the client's
+// capabilities for the rest of its session
+func (s *Server) handleInitialize(ctx context.Context, params interface{}) (*InitializeResult, error) {
+	initialize, err := parseInitializeParams(params)
+	if err != nil {
+		return nil, err
+	}
+	if initialize.ProtocolVersion == "" {
+		return nil, fmt.Errorf("protocolVersion is required")
+	}
+	version := negotiateVersion(initialize.ProtocolVersion)
+	clientFromContext(ctx).initialized(version, initialize)
+	s.Log("Client %s %s requested protocol %s, using %s",
+		initialize.ClientInfo.Name, initialize.ClientInfo.Version, initialize.ProtocolVersion, version)
+
 	return &InitializeResult{
-		ProtocolVersion: "2024-11-05",
+		ProtocolVersion: version,
 		Capabilities: ServerCapabilities{
 			Tools: &ToolsCapability{
 				ListChanged: false,
@@ -214,50 +798,112 @@ func (s *Server) handleInitialize(params interface{}) *InitializeResult {
 			Name:    s.name,
 			Version: s.version,
 		},
-	}
+	}, nil
 }
 
-func (s *Server) handleListTools() *ListToolsResult {
+// handleListTools lists the tools the caller may call
+func (s *Server) handleListTools(ctx context.Context) *ListToolsResult {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
+	client := clientFromContext(ctx)
+	identity := auth.IdentityFromContext(ctx)
+	if identity == nil {
+		return &ListToolsResult{
+			Tools: toolsFor(client, s.tools),
+		}
+	}
+
+	tools := make([]Tool, 0, len(s.tools))
+	for _, tool := range s.tools {
+		if identity.HasScope(auth.ToolScope(tool.Name)) {
+			tools = append(tools, tool)
+		}
+	}
 	return &ListToolsResult{
-		Tools: s.tools,
+		Tools: toolsFor(client, tools),
 	}
 }
 
-func (s *Server) handleCallTool(params interface{}) (*CallToolResult, error) {
-	paramsMap, ok := params.(map[string]interface{})
-	if !ok {
-		return nil, fmt.Errorf("invalid params type")
+// handleCallTool calls a tool. Malformed params, unknown tools and
+// arguments that do not match the tool's input schema are reported as
+// InvalidParams and handler failures as InternalError; a tool the caller
+// may not call reports it in an IsError result.
+func (s *Server) handleCallTool(ctx context.Context, params map[string]interface{}, notify notifyFunc) (*CallToolResult, *JSONRPCError) {
+	name, ok := params["name"].(string)
+	if !ok || name == "" {
+		return nil, invalidParams("name must be a non-empty string")
 	}
 
-	name, ok := paramsMap["name"].(string)
-	if !ok {
-		return nil, fmt.Errorf("missing tool name")
+	arguments, ok := params["arguments"].(map[string]interface{})
+	if !ok && params["arguments"] != nil {
+		return nil, invalidParams("arguments must be an object")
 	}
 
-	arguments, _ := paramsMap["arguments"].(map[string]interface{})
-
 	s.mu.RLock()
 	handler, exists := s.handlers[name]
+	schema := s.schemas[name]
 	s.mu.RUnlock()
 
 	if !exists {
+		return nil, invalidParams("unknown tool: %s", name)
+	}
+
+	if identity := auth.IdentityFromContext(ctx); identity != nil && !identity.HasScope(auth.ToolScope(name)) {
+		s.Log("Identity %s denied tool %s: missing scope %s", identity.Name, name, auth.ToolScope(name))
 		return &CallToolResult{
-			Content: []ContentItem{{Type: "text", Text: fmt.Sprintf("Unknown tool: %s", name)}},
+			Content: []ContentItem{{Type: "text", Text: fmt.Sprintf("Permission denied: calling %s requires the %s scope", name, auth.ToolScope(name))}},
 			IsError: true,
 		}, nil
 	}
 
-	return handler(arguments)
+	if violations := validateArguments(schema, arguments); len(violations) > 0 {
+		err := invalidParams("arguments of %s: %s", name, strings.Join(violations, "; "))
+		err.Data = violations
+		return nil, err
+	}
+
+	ctx = withProgress(ctx, progressToken(params), notify)
+	result, err := handler(ctx, arguments)
+	if err != nil && ctx.Err() == context.Canceled {
+		return &CallToolResult{
+			Content: []ContentItem{{Type: "text", Text: "Request cancelled"}},
+			IsError: true,
+		}, nil
+	}
+	if err != nil {
+		return nil, &JSONRPCError{Code: InternalError, Message: err.Error()}
+	}
+	if result == nil {
+		return nil, &JSONRPCError{Code: InternalError, Message: fmt.Sprintf("Tool %s returned no result", name)}
+	}
+	return resultFor(clientFromContext(ctx), result), nil
 }
 
-func (s *Server) sendResponse(response *JSONRPCResponse) {
+// sendResponse writes a response, or a batch of responses, to stdout
+func (s *Server) sendResponse(response interface{}) {
 	data, err := json.Marshal(response)
 	if err != nil {
 		fmt.Fprintf(s.stderr, "Error marshaling response: %v\n", err)
 		return
 	}
+	s.writeLine(data)
+}
+
+func (s *Server) sendNotification(notification *JSONRPCNotification) {
+	data, err := json.Marshal(notification)
+	if err != nil {
+		fmt.Fprintf(s.stderr, "Error marshaling notification: %v\n", err)
+		return
+	}
+	s.writeLine(data)
+}
+
+// writeLine writes a single newline-delimited message to stdout. Messages may
+// be produced concurrently (e.g. progress from a running command), so writes
+// are serialized to keep lines intact.
+func (s *Server) writeLine(data []byte) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
 	fmt.Fprintln(s.stdout, string(data))
 }
 
@@ -266,6 +912,41 @@ func (s *Server) Log(format string, args ...interface{}) {
 	fmt.Fprintf(s.stderr, format+"\n", args...)
 }
 
+// SetMaxInFlight sets how many stdio requests may be handled concurrently.
+// A value of 1 processes requests strictly in order.
+func (s *Server) SetMaxInFlight(n int) {
+	s.maxInFlight = n
+}
+
+// SetAuthenticator sets the validator used to authenticate HTTP requests
+func (s *Server) SetAuthenticator(v *auth.Validator) {
+	s.authenticator = v
+}
+
+// SetLockout sets how clients that fail authentication are throttled. By
+// default they are locked out after auth.DefaultMaxFailures failures.
+func (s *Server) SetLockout(l *auth.Lockout) {
+	s.lockout = l
+}
+
+// SetAuthFailureHandler sets a function that receives every rejected
+// authentication attempt, e.g. for audit logging
+func (s *Server) SetAuthFailureHandler(handler func(auth.Failure)) {
+	s.onAuthFailure = handler
+}
+
+// SetTLS makes RunHTTP serve HTTPS with the given configuration
+func (s *Server) SetTLS(config *TLSConfig) {
+	s.tls = config
+}
+
+// SetAllowedOrigins sets the browser origins, such as
+// "https://app.example.com", allowed to use the HTTP transport besides
+// pages served from the local host. "*" allows every origin.
+func (s *Server) SetAllowedOrigins(origins []string) {
+	s.allowedOrigins = origins
+}
+
 // SetIO allows customizing stdin/stdout/stderr for testing
 func (s *Server) SetIO(stdin io.Reader, stdout, stderr io.Writer) {
 	s.stdin = stdin
@@ -2,9 +2,19 @@ package mcp
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/user/go-mcp-commander/pkg/auth"
 )
 
 func TestNewServer(t *testing.T) {
@@ -41,7 +51,7 @@ func TestRegisterTool(t *testing.T) {
 		},
 	}
 
-	handler := func(args map[string]interface{}) (*CallToolResult, error) {
+	handler := func(ctx context.Context, args map[string]interface{}) (*CallToolResult, error) {
 		return &CallToolResult{
 			Content: []ContentItem{{Type: "text", Text: "success"}},
 		}, nil
@@ -108,7 +118,7 @@ func TestHandleListTools(t *testing.T) {
 		Name:        "test_tool",
 		Description: "A test tool",
 		InputSchema: JSONSchema{Type: "object"},
-	}, func(args map[string]interface{}) (*CallToolResult, error) {
+	}, func(ctx context.Context, args map[string]interface{}) (*CallToolResult, error) {
 		return &CallToolResult{Content: []ContentItem{{Type: "text", Text: "ok"}}}, nil
 	})
 
@@ -156,7 +166,7 @@ func TestHandleCallTool(t *testing.T) {
 				"message": {Type: "string"},
 			},
 		},
-	}, func(args map[string]interface{}) (*CallToolResult, error) {
+	}, func(ctx context.Context, args map[string]interface{}) (*CallToolResult, error) {
 		msg, _ := args["message"].(string)
 		return &CallToolResult{
 			Content: []ContentItem{{Type: "text", Text: "received: " + msg}},
@@ -220,13 +230,11 @@ func TestHandleCallTool_UnknownTool(t *testing.T) {
 		t.Fatal("Expected response, got nil")
 	}
 
-	result, ok := response.Result.(*CallToolResult)
-	if !ok {
-		t.Fatal("Expected CallToolResult")
+	if response.Result != nil || response.Error == nil || response.Error.Code != InvalidParams {
+		t.Fatalf("Expected InvalidParams for an unknown tool, got %+v", response)
 	}
-
-	if !result.IsError {
-		t.Error("Expected IsError to be true for unknown tool")
+	if !strings.Contains(response.Error.Message, "unknown tool: nonexistent_tool") {
+		t.Errorf("Expected the message to name the tool, got %q", response.Error.Message)
 	}
 }
 
@@ -323,7 +331,7 @@ func TestServerRun(t *testing.T) {
 		Name:        "echo",
 		Description: "Echo tool",
 		InputSchema: JSONSchema{Type: "object"},
-	}, func(args map[string]interface{}) (*CallToolResult, error) {
+	}, func(ctx context.Context, args map[string]interface{}) (*CallToolResult, error) {
 		return &CallToolResult{
 			Content: []ContentItem{{Type: "text", Text: "echo response"}},
 		}, nil
@@ -334,7 +342,9 @@ func TestServerRun(t *testing.T) {
 		JSONRPC: "2.0",
 		ID:      1,
 		Method:  "initialize",
-		Params:  map[string]interface{}{},
+		Params: map[string]interface{}{
+			"protocolVersion": "2025-06-18",
+		},
 	}
 	initData, _ := json.Marshal(initRequest)
 
@@ -365,3 +375,554 @@ func TestServerRun(t *testing.T) {
 		t.Error("Expected tool name in output")
 	}
 }
+
+func TestHandleCallTool_Progress(t *testing.T) {
+	server := NewServer("test-server", "1.0.0")
+
+	var stdout, stderr bytes.Buffer
+	server.SetIO(nil, &stdout, &stderr)
+
+	server.RegisterTool(Tool{
+		Name:        "slow_tool",
+		InputSchema: JSONSchema{Type: "object"},
+	}, func(ctx context.Context, args map[string]interface{}) (*CallToolResult, error) {
+		if !HasProgress(ctx) {
+			t.Error("Expected progress to be available")
+		}
+		ReportProgress(ctx, 1, 2, "halfway")
+		ReportProgress(ctx, 2, 2, "done")
+		return &CallToolResult{Content: []ContentItem{{Type: "text", Text: "ok"}}}, nil
+	})
+
+	request := JSONRPCRequest{
+		JSONRPC: "2.0",
+		ID:      1,
+		Method:  "tools/call",
+		Params: map[string]interface{}{
+			"name":      "slow_tool",
+			"arguments": map[string]interface{}{},
+			"_meta":     map[string]interface{}{"progressToken": "tok-1"},
+		},
+	}
+
+	data, _ := json.Marshal(request)
+	response := server.handleMessage(data)
+	if response == nil || response.Error != nil {
+		t.Fatalf("Unexpected response: %+v", response)
+	}
+
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 progress notifications, got %d: %s", len(lines), stdout.String())
+	}
+
+	var notification struct {
+		Method string         `json:"method"`
+		Params ProgressParams `json:"params"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &notification); err != nil {
+		t.Fatalf("Failed to parse notification: %v", err)
+	}
+	if notification.Method != "notifications/progress" {
+		t.Errorf("Expected notifications/progress, got %s", notification.Method)
+	}
+	if notification.Params.ProgressToken != "tok-1" {
+		t.Errorf("Expected progress token 'tok-1', got %v", notification.Params.ProgressToken)
+	}
+	if notification.Params.Message != "halfway" {
+		t.Errorf("Expected message 'halfway', got %s", notification.Params.Message)
+	}
+}
+
+func TestHandleCallTool_NoProgressToken(t *testing.T) {
+	server := NewServer("test-server", "1.0.0")
+
+	var stdout bytes.Buffer
+	server.SetIO(nil, &stdout, &bytes.Buffer{})
+
+	server.RegisterTool(Tool{
+		Name:        "tool",
+		InputSchema: JSONSchema{Type: "object"},
+	}, func(ctx context.Context, args map[string]interface{}) (*CallToolResult, error) {
+		if HasProgress(ctx) {
+			t.Error("Expected no progress without a progress token")
+		}
+		ReportProgress(ctx, 1, 1, "ignored")
+		return &CallToolResult{Content: []ContentItem{{Type: "text", Text: "ok"}}}, nil
+	})
+
+	data, _ := json.Marshal(JSONRPCRequest{
+		JSONRPC: "2.0",
+		ID:      1,
+		Method:  "tools/call",
+		Params:  map[string]interface{}{"name": "tool"},
+	})
+	server.handleMessage(data)
+
+	if stdout.Len() != 0 {
+		t.Errorf("Expected no notifications, got %s", stdout.String())
+	}
+}
+
+func TestCancelledNotification(t *testing.T) {
+	server := NewServer("test-server", "1.0.0")
+	server.SetIO(nil, &bytes.Buffer{}, &bytes.Buffer{})
+
+	started := make(chan struct{})
+	server.RegisterTool(Tool{
+		Name:        "blocking_tool",
+		InputSchema: JSONSchema{Type: "object"},
+	}, func(ctx context.Context, args map[string]interface{}) (*CallToolResult, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+
+	data, _ := json.Marshal(JSONRPCRequest{
+		JSONRPC: "2.0",
+		ID:      7,
+		Method:  "tools/call",
+		Params:  map[string]interface{}{"name": "blocking_tool"},
+	})
+
+	responses := make(chan *JSONRPCResponse, 1)
+	go func() {
+		responses <- server.handleMessage(data)
+	}()
+	<-started
+
+	cancel, _ := json.Marshal(JSONRPCRequest{
+		JSONRPC: "2.0",
+		Method:  "notifications/cancelled",
+		Params:  map[string]interface{}{"requestId": 7, "reason": "user abort"},
+	})
+	if response := server.handleMessage(cancel); response != nil {
+		t.Errorf("Expected nil response for notification, got %+v", response)
+	}
+
+	select {
+	case response := <-responses:
+		result, ok := response.Result.(*CallToolResult)
+		if !ok {
+			t.Fatalf("Expected CallToolResult, got %+v", response)
+		}
+		if !result.IsError || !strings.Contains(result.Content[0].Text, "cancelled") {
+			t.Errorf("Expected cancelled result, got %+v", result)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Request was not cancelled")
+	}
+
+	if len(server.inFlight) != 0 {
+		t.Errorf("Expected no in-flight requests, got %d", len(server.inFlight))
+	}
+}
+
+func TestServerRun_Overloaded(t *testing.T) {
+	server := NewServer("test-server", "1.0.0")
+	server.SetMaxInFlight(1)
+
+	started := make(chan struct{})
+	server.RegisterTool(Tool{
+		Name:        "blocking_tool",
+		InputSchema: JSONSchema{Type: "object"},
+	}, func(ctx context.Context, args map[string]interface{}) (*CallToolResult, error) {
+		if block, _ := args["block"].(bool); block {
+			close(started)
+			<-ctx.Done()
+		}
+		return &CallToolResult{Content: []ContentItem{{Type: "text", Text: "done"}}}, nil
+	})
+
+	stdinReader, stdinWriter := io.Pipe()
+	var stdout syncBuffer
+	server.SetIO(stdinReader, &stdout, &bytes.Buffer{})
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Run()
+	}()
+
+	fmt.Fprintln(stdinWriter, `{"jsonrpc":"2.0","id":0,"method":"tools/call","params":{"name":"blocking_tool","arguments":{"block":true}}}`)
+	<-started
+
+	// Fill the queue behind the blocked request and overflow it; the
+	// cancellation must still be read
+	for i := 1; i <= requestQueueSize+1; i++ {
+		fmt.Fprintf(stdinWriter, `{"jsonrpc":"2.0","id":%d,"method":"tools/call","params":{"name":"blocking_tool"}}`+"\n", i)
+	}
+	cancelled := make(chan struct{})
+	go func() {
+		fmt.Fprintln(stdinWriter, `{"jsonrpc":"2.0","method":"notifications/cancelled","params":{"requestId":0}}`)
+		stdinWriter.Close()
+		close(cancelled)
+	}()
+
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Cancellation was not read while the queue was full")
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+
+	overloadedResponses := 0
+	for _, line := range strings.Split(strings.TrimSpace(stdout.String()), "\n") {
+		var response JSONRPCResponse
+		json.Unmarshal([]byte(line), &response)
+		if response.Error != nil && response.Error.Code == ServerOverloaded {
+			overloadedResponses++
+		}
+	}
+	if overloadedResponses != 1 {
+		t.Errorf("Expected one request to be refused, got %d in %s", overloadedResponses, stdout.String())
+	}
+}
+
+func TestServerRun_Cancellation(t *testing.T) {
+	server := NewServer("test-server", "1.0.0")
+
+	server.RegisterTool(Tool{
+		Name:        "blocking_tool",
+		InputSchema: JSONSchema{Type: "object"},
+	}, func(ctx context.Context, args map[string]interface{}) (*CallToolResult, error) {
+		<-ctx.Done()
+		return &CallToolResult{Content: []ContentItem{{Type: "text", Text: "stopped"}}, IsError: true}, nil
+	})
+
+	stdinReader, stdinWriter := io.Pipe()
+	var stdout syncBuffer
+	server.SetIO(stdinReader, &stdout, &bytes.Buffer{})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Run()
+	}()
+
+	call, _ := json.Marshal(JSONRPCRequest{
+		JSONRPC: "2.0",
+		ID:      "call-1",
+		Method:  "tools/call",
+		Params:  map[string]interface{}{"name": "blocking_tool"},
+	})
+	fmt.Fprintln(stdinWriter, string(call))
+
+	// Wait for the request to be registered before cancelling it
+	deadline := time.Now().Add(5 * time.Second)
+	for !server.cancelRequest(server.stdioContext(), "call-1") {
+		if time.Now().After(deadline) {
+			t.Fatal("Request never became in-flight")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	stdinWriter.Close()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Run returned error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after cancellation")
+	}
+
+	if !strings.Contains(stdout.String(), "stopped") {
+		t.Errorf("Expected cancelled tool response, got %s", stdout.String())
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestServerRun_Concurrent(t *testing.T) {
+	server := NewServer("test-server", "1.0.0")
+
+	release := make(chan struct{})
+	server.RegisterTool(Tool{
+		Name:        "slow_tool",
+		InputSchema: JSONSchema{Type: "object"},
+	}, func(ctx context.Context, args map[string]interface{}) (*CallToolResult, error) {
+		<-release
+		return &CallToolResult{Content: []ContentItem{{Type: "text", Text: "slow done"}}}, nil
+	})
+
+	stdinReader, stdinWriter := io.Pipe()
+	var stdout syncBuffer
+	server.SetIO(stdinReader, &stdout, &bytes.Buffer{})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Run()
+	}()
+
+	call, _ := json.Marshal(JSONRPCRequest{
+		JSONRPC: "2.0",
+		ID:      1,
+		Method:  "tools/call",
+		Params:  map[string]interface{}{"name": "slow_tool"},
+	})
+	ping, _ := json.Marshal(JSONRPCRequest{JSONRPC: "2.0", ID: 2, Method: "ping"})
+	fmt.Fprintln(stdinWriter, string(call))
+	fmt.Fprintln(stdinWriter, string(ping))
+
+	// The ping must be answered while the slow tool is still running
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(stdout.String(), `"id":2`) {
+		if time.Now().After(deadline) {
+			t.Fatal("ping was blocked by a running tool call")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if strings.Contains(stdout.String(), "slow done") {
+		t.Fatal("Expected slow tool to still be running")
+	}
+
+	close(release)
+	stdinWriter.Close()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Run returned error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return")
+	}
+
+	// Run waits for in-flight requests before returning
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 responses, got %d: %s", len(lines), stdout.String())
+	}
+	var last JSONRPCResponse
+	if err := json.Unmarshal([]byte(lines[1]), &last); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if last.ID != float64(1) {
+		t.Errorf("Expected slow tool response last with id 1, got %v", last.ID)
+	}
+}
+
+func TestHTTPHandler_Authentication(t *testing.T) {
+	server := NewServer("test-server", "1.0.0")
+	server.SetIO(nil, nil, io.Discard)
+	validator, err := auth.NewValidator(auth.Config{Tokens: []string{"secret"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.SetAuthenticator(validator)
+	handler := server.httpHandler()
+
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		status int
+	}{
+		{"missing token", "", "", http.StatusUnauthorized},
+		{"wrong token", auth.AuthHeaderName, "guess", http.StatusUnauthorized},
+		{"token header", auth.AuthHeaderName, "secret", http.StatusOK},
+		{"bearer token", "Authorization", "Bearer secret", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"ping"}`))
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Errorf("Expected status %d, got %d: %s", tt.status, rec.Code, rec.Body.String())
+			}
+			if tt.status == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Error("Expected a WWW-Authenticate challenge")
+			}
+		})
+	}
+}
+
+func TestIdentityScopes(t *testing.T) {
+	server := NewServer("test-server", "1.0.0")
+	server.SetIO(nil, nil, io.Discard)
+	for _, name := range []string{"read_file", "write_file"} {
+		server.RegisterTool(Tool{Name: name, InputSchema: JSONSchema{Type: "object"}}, func(ctx context.Context, args map[string]interface{}) (*CallToolResult, error) {
+			return &CallToolResult{Content: []ContentItem{{Type: "text", Text: "ok"}}}, nil
+		})
+	}
+	ctx := auth.WithIdentity(context.Background(), &auth.Identity{Name: "reader", Scopes: []string{"tools:read_*"}})
+
+	response := server.handleMessageWith(ctx, []byte(`{"jsonrpc":"2.0","id":1,"method":"tools/list"}`), nil)
+	tools := response.Result.(*ListToolsResult).Tools
+	if len(tools) != 1 || tools[0].Name != "read_file" {
+		t.Errorf("Expected only read_file to be listed, got %+v", tools)
+	}
+
+	response = server.handleMessageWith(ctx, []byte(`{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"write_file"}}`), nil)
+	result := response.Result.(*CallToolResult)
+	if !result.IsError || !strings.Contains(result.Content[0].Text, "tools:write_file") {
+		t.Errorf("Expected the call to be denied for lack of scope, got %+v", result)
+	}
+
+	response = server.handleMessageWith(ctx, []byte(`{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"read_file"}}`), nil)
+	if result := response.Result.(*CallToolResult); result.IsError {
+		t.Errorf("Expected the permitted call to succeed, got %+v", result)
+	}
+
+	// Without an identity, as over stdio, every tool is available
+	response = server.handleMessage([]byte(`{"jsonrpc":"2.0","id":4,"method":"tools/list"}`))
+	if tools := response.Result.(*ListToolsResult).Tools; len(tools) != 2 {
+		t.Errorf("Expected both tools without an identity, got %d", len(tools))
+	}
+}
+
+func TestHTTPHandler_Lockout(t *testing.T) {
+	server := NewServer("test-server", "1.0.0")
+	server.SetIO(nil, nil, io.Discard)
+	validator, err := auth.NewValidator(auth.Config{Tokens: []string{"secret"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.SetAuthenticator(validator)
+	server.SetLockout(auth.NewLockout(auth.LockoutConfig{MaxFailures: 2, Lockout: time.Minute}))
+	var failures []auth.Failure
+	server.SetAuthFailureHandler(func(f auth.Failure) { failures = append(failures, f) })
+	handler := server.httpHandler()
+
+	request := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"ping"}`))
+		req.RemoteAddr = "192.0.2.7:40000"
+		req.Header.Set(auth.AuthHeaderName, token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	request("guess-1")
+	request("guess-2")
+	rec := request("secret")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected a locked out client to get 429 even with the right token, got %d", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") != "60" {
+		t.Errorf("Expected Retry-After: 60, got %q", rec.Header().Get("Retry-After"))
+	}
+
+	if len(failures) != 3 {
+		t.Fatalf("Expected 3 audit events, got %d", len(failures))
+	}
+	if failures[1].Client != "192.0.2.7" || failures[1].Failures != 2 || failures[1].LockedFor != time.Minute {
+		t.Errorf("Unexpected failure event %+v", failures[1])
+	}
+	if !failures[2].Locked {
+		t.Errorf("Expected the refused request to be reported as locked, got %+v", failures[2])
+	}
+}
+
+func TestServerRunContext_Shutdown(t *testing.T) {
+	server := NewServer("test-server", "1.0.0")
+	started := make(chan struct{})
+	server.RegisterTool(Tool{
+		Name:        "blocking_tool",
+		InputSchema: JSONSchema{Type: "object"},
+	}, func(ctx context.Context, args map[string]interface{}) (*CallToolResult, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+
+	// stdin stays open, so only the context can stop the server
+	stdinReader, stdinWriter := io.Pipe()
+	defer stdinWriter.Close()
+	var stdout syncBuffer
+	server.SetIO(stdinReader, &stdout, io.Discard)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- server.RunContext(ctx)
+	}()
+	fmt.Fprintln(stdinWriter, `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"blocking_tool"}}`)
+	<-started
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Expected a clean shutdown, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("RunContext did not return after its context was cancelled")
+	}
+	if !strings.Contains(stdout.String(), "Request cancelled") {
+		t.Errorf("Expected the running request to be cancelled, got %s", stdout.String())
+	}
+}
+
+func TestServerRunHTTPContext_Shutdown(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	server := NewServer("test-server", "1.0.0")
+	server.SetIO(nil, nil, io.Discard)
+	validator, err := auth.NewValidator(auth.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.SetAuthenticator(validator)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- server.RunHTTPContext(ctx, addr)
+	}()
+
+	// Open a session, whose requests shutting down must cancel
+	var sess *session
+	deadline := time.Now().Add(5 * time.Second)
+	for sess == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("HTTP server did not start")
+		}
+		resp, err := http.Post("http://"+addr, "application/json", strings.NewReader(initializeRequest))
+		if err != nil {
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		resp.Body.Close()
+		sess = server.sessions.get(resp.Header.Get(SessionHeader), "")
+	}
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Expected a clean shutdown, got %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("RunHTTPContext did not return after its context was cancelled")
+	}
+	if sess.ctx.Err() == nil {
+		t.Error("Expected shutting down to end the session")
+	}
+}
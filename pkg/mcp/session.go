@@ -0,0 +1,350 @@
+package mcp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SessionHeader carries the session ID on the Streamable HTTP transport
+const SessionHeader = "Mcp-Session-Id"
+
+const (
+	// sessionIdleTimeout is how long a session may go unused before it is
+	// discarded
+	sessionIdleTimeout = time.Hour
+	// maxSessions bounds the number of open sessions
+	maxSessions = 1000
+	// maxStreamEvents bounds the events a stream keeps for replay
+	maxStreamEvents = 1000
+	// maxFinishedStreams bounds the finished streams a session keeps for
+	// clients that were disconnected before receiving the whole stream
+	maxFinishedStreams = 32
+	// standaloneStream numbers a session's GET stream, which carries
+	// messages unrelated to any request
+	standaloneStream = 0
+)
+
+// session is a client's Streamable HTTP session, created by initialize and
+// ended by DELETE or after sessionIdleTimeout without requests
+type session struct {
+	id string
+	// identity is the name of the identity that created the session; other
+	// identities cannot use it
+	identity string
+	// ctx is cancelled when the session ends, stopping its requests
+	ctx    context.Context
+	cancel context.CancelFunc
+	// client is what the client declared in initialize
+	client *clientState
+
+	mu         sync.Mutex
+	lastUsed   time.Time
+	streams    map[int]*eventStream
+	nextStream int
+	finished   []int
+}
+
+type sessionKey struct{}
+
+// withSession returns a context carrying the session of a request
+func withSession(ctx context.Context, sess *session) context.Context {
+	return context.WithValue(ctx, sessionKey{}, sess)
+}
+
+// sessionFromContext returns the session of a request, or nil for stdio and
+// HTTP requests made without a session
+func sessionFromContext(ctx context.Context) *session {
+	sess, _ := ctx.Value(sessionKey{}).(*session)
+	return sess
+}
+
+// newStream starts a stream for the response to a POST request
+func (sess *session) newStream() *eventStream {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	sess.nextStream++
+	stream := newEventStream(sess, sess.nextStream)
+	sess.streams[stream.id] = stream
+	return stream
+}
+
+// standalone returns the session's GET stream
+func (sess *session) standalone() *eventStream {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	return sess.streams[standaloneStream]
+}
+
+// stream returns the stream with the given number, if it is still kept
+func (sess *session) stream(id int) *eventStream {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	return sess.streams[id]
+}
+
+// streamFinished records that a stream's response was sent, dropping the
+// oldest finished streams beyond maxFinishedStreams
+func (sess *session) streamFinished(id int) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	sess.finished = append(sess.finished, id)
+	for len(sess.finished) > maxFinishedStreams {
+		delete(sess.streams, sess.finished[0])
+		sess.finished = sess.finished[1:]
+	}
+}
+
+// sessionStore holds the open sessions of the HTTP transport
+type sessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]*session
+	now      func() time.Time
+}
+
+func newSessionStore() *sessionStore {
+	return &sessionStore{sessions: make(map[string]*session), now: time.Now}
+}
+
+// create opens a session for identity, which is empty for unauthenticated
+// clients
+func (st *sessionStore) create(identity string) (*session, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("failed to generate session ID: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sess := &session{
+		id:       hex.EncodeToString(id),
+		identity: identity,
+		ctx:      ctx,
+		cancel:   cancel,
+		client:   &clientState{},
+		streams:  make(map[int]*eventStream),
+	}
+	sess.streams[standaloneStream] = newEventStream(sess, standaloneStream)
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	now := st.now()
+	sess.lastUsed = now
+	if len(st.sessions) >= maxSessions {
+		st.prune(now)
+	}
+	st.sessions[sess.id] = sess
+	return sess, nil
+}
+
+// get returns the session with the given ID if it is open and belongs to
+// identity, marking it used
+func (st *sessionStore) get(id, identity string) *session {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	sess, ok := st.sessions[id]
+	if !ok || sess.identity != identity {
+		return nil
+	}
+	now := st.now()
+	if now.Sub(sess.lastUsed) > sessionIdleTimeout {
+		st.remove(sess)
+		return nil
+	}
+	sess.lastUsed = now
+	return sess
+}
+
+// terminate ends a session, cancelling its running requests
+func (st *sessionStore) terminate(sess *session) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.remove(sess)
+}
+
+// terminateAll ends every session
+func (st *sessionStore) terminateAll() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for _, sess := range st.sessions {
+		st.remove(sess)
+	}
+}
+
+// prune ends idle sessions, and if that is not enough, the least recently
+// used one
+func (st *sessionStore) prune(now time.Time) {
+	var oldest *session
+	for _, sess := range st.sessions {
+		if now.Sub(sess.lastUsed) > sessionIdleTimeout {
+			st.remove(sess)
+		} else if oldest == nil || sess.lastUsed.Before(oldest.lastUsed) {
+			oldest = sess
+		}
+	}
+	if len(st.sessions) >= maxSessions && oldest != nil {
+		st.remove(oldest)
+	}
+}
+
+func (st *sessionStore) remove(sess *session) {
+	delete(st.sessions, sess.id)
+	sess.cancel()
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	for _, stream := range sess.streams {
+		stream.close()
+	}
+}
+
+// sseEvent is a message sent on an event stream
+type sseEvent struct {
+	seq  int
+	data []byte
+}
+
+// eventStream is a sequence of messages delivered as Server-Sent Events. A
+// stream outlives the HTTP connection reading it, so that a client that
+// loses the connection can resume it with Last-Event-ID.
+type eventStream struct {
+	sess *session
+	id   int
+
+	mu      sync.Mutex
+	events  []sseEvent
+	nextSeq int
+	done    bool
+	// reader counts the connections that have read the stream; only the
+	// latest one receives events
+	reader int
+	// wake is closed, and replaced, whenever the stream changes
+	wake chan struct{}
+}
+
+// newEventStream creates a stream; sess is nil for requests made without a
+// session, whose streams cannot be resumed
+func newEventStream(sess *session, id int) *eventStream {
+	return &eventStream{sess: sess, id: id, wake: make(chan struct{})}
+}
+
+// eventID returns the ID of an event, from which the stream it belongs to
+// can be found
+func (es *eventStream) eventID(seq int) string {
+	return fmt.Sprintf("%d-%d", es.id, seq)
+}
+
+// parseEventID splits an event ID into its stream and sequence numbers
+func parseEventID(id string) (int, int, bool) {
+	streamPart, seqPart, ok := strings.Cut(id, "-")
+	if !ok {
+		return 0, 0, false
+	}
+	stream, err1 := strconv.Atoi(streamPart)
+	seq, err2 := strconv.Atoi(seqPart)
+	if err1 != nil || err2 != nil || stream < 0 || seq < 0 {
+		return 0, 0, false
+	}
+	return stream, seq, true
+}
+
+// send appends a message to the stream
+func (es *eventStream) send(v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if es.done {
+		return
+	}
+	es.nextSeq++
+	es.events = append(es.events, sseEvent{seq: es.nextSeq, data: data})
+	if len(es.events) > maxStreamEvents {
+		es.events = es.events[len(es.events)-maxStreamEvents:]
+	}
+	es.signal()
+}
+
+// notify is the notifyFunc of requests answered on the stream
+func (es *eventStream) notify(notification *JSONRPCNotification) {
+	es.send(notification)
+}
+
+// finish ends the stream after its last message
+func (es *eventStream) finish() {
+	es.close()
+	if es.sess != nil {
+		es.sess.streamFinished(es.id)
+	}
+}
+
+func (es *eventStream) close() {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if !es.done {
+		es.done = true
+		es.signal()
+	}
+}
+
+// signal wakes the connections waiting for the stream; es.mu must be held
+func (es *eventStream) signal() {
+	close(es.wake)
+	es.wake = make(chan struct{})
+}
+
+// serve writes the stream's events after sequence number after to w until
+// the stream finishes, ctx (the request's context) is done, or another
+// connection takes the stream over. Events are kept after being written,
+// since a client may not have received them before its connection dropped.
+func (es *eventStream) serve(ctx context.Context, w http.ResponseWriter, after int) {
+	flusher, _ := w.(http.Flusher)
+	es.mu.Lock()
+	es.reader++
+	reader := es.reader
+	es.signal()
+	es.mu.Unlock()
+
+	for {
+		es.mu.Lock()
+		if es.reader != reader {
+			es.mu.Unlock()
+			return
+		}
+		var pending []sseEvent
+		for _, event := range es.events {
+			if event.seq > after {
+				pending = append(pending, event)
+			}
+		}
+		done, wake := es.done, es.wake
+		es.mu.Unlock()
+
+		for _, event := range pending {
+			if es.sess != nil {
+				fmt.Fprintf(w, "id: %s\n", es.eventID(event.seq))
+			}
+			fmt.Fprintf(w, "event: message\ndata: %s\n\n", event.data)
+			after = event.seq
+		}
+		if flusher != nil && len(pending) > 0 {
+			flusher.Flush()
+		}
+		if done {
+			return
+		}
+
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
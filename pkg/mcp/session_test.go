@@ -0,0 +1,372 @@
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/user/go-mcp-commander/pkg/auth"
+)
+
+const initializeRequest = `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26","capabilities":{},"clientInfo":{"name":"test"}}}`
+
+// postMCP sends a message to the MCP endpoint
+func postMCP(t *testing.T, url, sessionID, accept, body string) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", accept)
+	if sessionID != "" {
+		req.Header.Set(SessionHeader, sessionID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("POST failed: %v", err)
+	}
+	return resp
+}
+
+// readEvent reads the next Server-Sent Event, returning its id and data
+func readEvent(t *testing.T, r *bufio.Reader) (string, string) {
+	t.Helper()
+	var id, data string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read event: %v", err)
+		}
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case line == "" && data != "":
+			return id, data
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func newStreamableServer(t *testing.T) (*Server, *httptest.Server) {
+	t.Helper()
+	server := NewServer("test-server", "1.0.0")
+	server.SetIO(nil, nil, io.Discard)
+	httpServer := httptest.NewServer(server.httpHandler())
+	t.Cleanup(httpServer.Close)
+	return server, httpServer
+}
+
+func TestStreamableHTTP_Sessions(t *testing.T) {
+	_, httpServer := newStreamableServer(t)
+
+	resp := postMCP(t, httpServer.URL, "", "application/json, text/event-stream", initializeRequest)
+	resp.Body.Close()
+	sessionID := resp.Header.Get(SessionHeader)
+	if resp.StatusCode != http.StatusOK || sessionID == "" {
+		t.Fatalf("Expected initialize to create a session, got %d with %q", resp.StatusCode, sessionID)
+	}
+
+	tests := []struct {
+		name      string
+		method    string
+		sessionID string
+		body      string
+		status    int
+	}{
+		{"request in session", http.MethodPost, sessionID, `{"jsonrpc":"2.0","id":2,"method":"ping"}`, http.StatusOK},
+		{"request without session", http.MethodPost, "", `{"jsonrpc":"2.0","id":2,"method":"ping"}`, http.StatusOK},
+		{"notification", http.MethodPost, sessionID, `{"jsonrpc":"2.0","method":"notifications/initialized"}`, http.StatusAccepted},
+		{"unknown session", http.MethodPost, "0123", `{"jsonrpc":"2.0","id":2,"method":"ping"}`, http.StatusNotFound},
+		{"stream without session", http.MethodGet, "", "", http.StatusBadRequest},
+		{"delete without session", http.MethodDelete, "", "", http.StatusBadRequest},
+		{"unsupported method", http.MethodPut, sessionID, "", http.StatusMethodNotAllowed},
+		{"delete", http.MethodDelete, sessionID, "", http.StatusNoContent},
+		{"request after delete", http.MethodPost, sessionID, `{"jsonrpc":"2.0","id":3,"method":"ping"}`, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, httpServer.URL, strings.NewReader(tt.body))
+			req.Header.Set("Accept", "application/json, text/event-stream")
+			if tt.sessionID != "" {
+				req.Header.Set(SessionHeader, tt.sessionID)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestStreamableHTTP_SessionIdentity(t *testing.T) {
+	server, httpServer := newStreamableServer(t)
+	file := filepath.Join(t.TempDir(), "identities.yaml")
+	os.WriteFile(file, []byte(`
+identities:
+  - {name: alice, tokens: [alice-token], scopes: ["*"]}
+  - {name: bob, tokens: [bob-token], scopes: ["*"]}
+`), 0600)
+	validator, err := auth.NewValidator(auth.Config{IdentitiesFile: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.SetAuthenticator(validator)
+
+	post := func(token, sessionID, body string) *http.Response {
+		req, _ := http.NewRequest(http.MethodPost, httpServer.URL, strings.NewReader(body))
+		req.Header.Set(auth.AuthHeaderName, token)
+		if sessionID != "" {
+			req.Header.Set(SessionHeader, sessionID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	sessionID := post("alice-token", "", initializeRequest).Header.Get(SessionHeader)
+	if resp := post("alice-token", sessionID, `{"jsonrpc":"2.0","id":2,"method":"ping"}`); resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected the session's identity to use it, got %d", resp.StatusCode)
+	}
+	if resp := post("bob-token", sessionID, `{"jsonrpc":"2.0","id":2,"method":"ping"}`); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected another identity to be refused the session, got %d", resp.StatusCode)
+	}
+}
+
+func TestStreamableHTTP_Resume(t *testing.T) {
+	server, httpServer := newStreamableServer(t)
+	release := make(chan struct{})
+	server.RegisterTool(Tool{Name: "slow_tool", InputSchema: JSONSchema{Type: "object"}}, func(ctx context.Context, args map[string]interface{}) (*CallToolResult, error) {
+		ReportProgress(ctx, 1, 2, "started")
+		<-release
+		return &CallToolResult{Content: []ContentItem{{Type: "text", Text: "slow done"}}}, nil
+	})
+
+	resp := postMCP(t, httpServer.URL, "", "application/json", initializeRequest)
+	resp.Body.Close()
+	sessionID := resp.Header.Get(SessionHeader)
+
+	// Read the progress notification, then drop the connection
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, httpServer.URL, strings.NewReader(
+		`{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"slow_tool","_meta":{"progressToken":"p"}}}`))
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	req.Header.Set(SessionHeader, sessionID)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Fatalf("Expected an event stream, got %s", contentType)
+	}
+	id, data := readEvent(t, bufio.NewReader(resp.Body))
+	if !strings.Contains(data, "notifications/progress") {
+		t.Fatalf("Expected a progress notification, got %s", data)
+	}
+	cancel()
+	resp.Body.Close()
+	close(release)
+
+	// The response is delivered when the client resumes after the last event
+	// it received
+	req, _ = http.NewRequest(http.MethodGet, httpServer.URL, nil)
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set(SessionHeader, sessionID)
+	req.Header.Set("Last-Event-ID", id)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	nextID, data := readEvent(t, bufio.NewReader(resp.Body))
+	if nextID == id || !strings.Contains(data, "slow done") {
+		t.Fatalf("Expected the tool response after event %s, got %s: %s", id, nextID, data)
+	}
+	var response JSONRPCResponse
+	if err := json.Unmarshal([]byte(data), &response); err != nil || response.ID != float64(2) {
+		t.Errorf("Expected the response to request 2, got %s", data)
+	}
+
+	req.Header.Set("Last-Event-ID", "99-1")
+	if resp, _ := http.DefaultClient.Do(req); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected an unknown event ID to be rejected, got %d", resp.StatusCode)
+	}
+}
+
+func TestStreamableHTTP_GetStream(t *testing.T) {
+	_, httpServer := newStreamableServer(t)
+	resp := postMCP(t, httpServer.URL, "", "application/json", initializeRequest)
+	resp.Body.Close()
+	sessionID := resp.Header.Get(SessionHeader)
+
+	req, _ := http.NewRequest(http.MethodGet, httpServer.URL, nil)
+	req.Header.Set(SessionHeader, sessionID)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Expected GET without an event stream Accept to be refused, got %d", resp.StatusCode)
+	}
+
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Expected an event stream, got %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	// Ending the session closes its stream
+	del, _ := http.NewRequest(http.MethodDelete, httpServer.URL, nil)
+	del.Header.Set(SessionHeader, sessionID)
+	if resp, err := http.DefaultClient.Do(del); err != nil || resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("DELETE failed: %v", err)
+	}
+	closed := make(chan error, 1)
+	go func() {
+		_, err := io.ReadAll(resp.Body)
+		closed <- err
+	}()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Errorf("Expected the stream to end cleanly, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stream was not closed when the session ended")
+	}
+}
+
+func TestStreamableHTTP_Origin(t *testing.T) {
+	server, httpServer := newStreamableServer(t)
+	server.SetAllowedOrigins([]string{"https://app.example.com"})
+
+	tests := []struct {
+		origin string
+		status int
+	}{
+		{"", http.StatusOK},
+		{"http://localhost:5173", http.StatusOK},
+		{"http://127.0.0.1:3000", http.StatusOK},
+		{"http://[::1]:3000", http.StatusOK},
+		{"https://app.example.com", http.StatusOK},
+		{"https://evil.example.com", http.StatusForbidden},
+		{"http://localhost.evil.example.com", http.StatusForbidden},
+		{"null", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest(http.MethodPost, httpServer.URL, strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"ping"}`))
+		if tt.origin != "" {
+			req.Header.Set("Origin", tt.origin)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("Origin %q: expected status %d, got %d", tt.origin, tt.status, resp.StatusCode)
+		}
+	}
+}
+
+func TestCancelRequest_SessionScoped(t *testing.T) {
+	server := NewServer("test-server", "1.0.0")
+	sess, err := server.sessions.create("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := withSession(sess.ctx, sess)
+	_, done := server.beginRequest(ctx, 1)
+	defer done()
+
+	if server.cancelRequest(context.Background(), 1) {
+		t.Error("Expected a request outside the session not to cancel it")
+	}
+	if !server.cancelRequest(ctx, 1) {
+		t.Error("Expected the session's own cancellation to succeed")
+	}
+}
+
+func TestCancelRequest_ConnectionScoped(t *testing.T) {
+	server := NewServer("test-server", "1.0.0")
+
+	// Sessionless HTTP requests each have their own client state
+	first := withClient(context.Background(), &clientState{})
+	second := withClient(context.Background(), &clientState{})
+	_, done := server.beginRequest(first, 1)
+	defer done()
+	if server.cancelRequest(second, 1) || server.cancelRequest(context.Background(), 1) {
+		t.Error("Expected another connection not to cancel the request")
+	}
+
+	// Requests reusing a running request's id are all kept
+	ctx := server.stdioContext()
+	ctx1, done1 := server.beginRequest(ctx, "dup")
+	ctx2, done2 := server.beginRequest(ctx, "dup")
+	defer done2()
+	done1()
+	if ctx1.Err() == nil {
+		t.Error("Expected a completed request's context to be cancelled")
+	}
+	if !server.cancelRequest(ctx, "dup") || ctx2.Err() == nil {
+		t.Error("Expected the remaining request with the id to be cancelled")
+	}
+}
+
+func TestStreamableHTTP_SessionlessCancel(t *testing.T) {
+	server, httpServer := newStreamableServer(t)
+	started, release := make(chan struct{}), make(chan struct{})
+	server.RegisterTool(Tool{Name: "slow_tool", InputSchema: JSONSchema{Type: "object"}}, func(ctx context.Context, args map[string]interface{}) (*CallToolResult, error) {
+		close(started)
+		select {
+		case <-ctx.Done():
+			return &CallToolResult{Content: []ContentItem{{Type: "text", Text: "cancelled"}}, IsError: true}, nil
+		case <-release:
+			return &CallToolResult{Content: []ContentItem{{Type: "text", Text: "finished"}}}, nil
+		}
+	})
+
+	results := make(chan string, 1)
+	go func() {
+		resp := postMCP(t, httpServer.URL, "", "application/json", `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"slow_tool"}}`)
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		results <- string(body)
+	}()
+	<-started
+
+	// Another client without a session uses the same id
+	resp := postMCP(t, httpServer.URL, "", "application/json", `{"jsonrpc":"2.0","method":"notifications/cancelled","params":{"requestId":1}}`)
+	resp.Body.Close()
+	close(release)
+
+	select {
+	case body := <-results:
+		if !strings.Contains(body, "finished") {
+			t.Errorf("Expected the request to survive another client's cancellation, got %s", body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Request did not complete")
+	}
+}
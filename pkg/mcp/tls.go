@@ -0,0 +1,168 @@
+package mcp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Client certificate modes for TLSConfig.ClientAuth
+const (
+	ClientAuthRequire  = "require"
+	ClientAuthOptional = "optional"
+)
+
+// tlsReloadInterval limits how often the certificate files are checked for
+// changes
+const tlsReloadInterval = time.Second
+
+// TLSConfig configures HTTPS for the HTTP transport
+type TLSConfig struct {
+	// CertFile and KeyFile hold the server's PEM certificate chain and key
+	CertFile string
+	KeyFile  string
+	// ClientCAFile, if set, holds the PEM certificates of the CAs that sign
+	// client certificates. A verified client certificate authenticates the
+	// request as the identity whose certificate subject matches.
+	ClientCAFile string
+	// ClientAuth is ClientAuthRequire (the default) to refuse connections
+	// without a client certificate, or ClientAuthOptional to accept them
+	// and fall back to token authentication
+	ClientAuth string
+	// MinVersion is the lowest TLS version accepted: "1.2" (the default)
+	// or "1.3"
+	MinVersion string
+}
+
+// tlsVersions maps MinVersion values to TLS versions
+var tlsVersions = map[string]uint16{
+	"":    tls.VersionTLS12,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// certReloader serves the certificate and client CAs from their files,
+// reloading them when the files change so that renewed certificates are
+// picked up without a restart
+type certReloader struct {
+	config TLSConfig
+	base   *tls.Config
+	log    func(format string, args ...interface{})
+
+	mu       sync.Mutex
+	current  *tls.Config
+	modTimes []time.Time
+	checked  time.Time
+	interval time.Duration
+}
+
+// newTLSConfig builds the server's TLS configuration, loading the files
+// once so that configuration errors are reported at startup
+func newTLSConfig(config TLSConfig, log func(format string, args ...interface{})) (*tls.Config, *certReloader, error) {
+	minVersion, ok := tlsVersions[config.MinVersion]
+	if !ok {
+		return nil, nil, fmt.Errorf("invalid minimum TLS version %q: must be 1.2 or 1.3", config.MinVersion)
+	}
+	if config.CertFile == "" || config.KeyFile == "" {
+		return nil, nil, fmt.Errorf("TLS requires both a certificate and a key file")
+	}
+
+	base := &tls.Config{MinVersion: minVersion}
+	if config.ClientCAFile != "" {
+		switch config.ClientAuth {
+		case "", ClientAuthRequire:
+			base.ClientAuth = tls.RequireAndVerifyClientCert
+		case ClientAuthOptional:
+			base.ClientAuth = tls.VerifyClientCertIfGiven
+		default:
+			return nil, nil, fmt.Errorf("invalid client certificate mode %q: must be %s or %s", config.ClientAuth, ClientAuthRequire, ClientAuthOptional)
+		}
+	}
+
+	r := &certReloader{config: config, base: base, log: log, interval: tlsReloadInterval}
+	if err := r.load(); err != nil {
+		return nil, nil, err
+	}
+	return &tls.Config{
+		MinVersion:         minVersion,
+		GetConfigForClient: r.getConfigForClient,
+	}, r, nil
+}
+
+// files lists the files the configuration is loaded from
+func (r *certReloader) files() []string {
+	files := []string{r.config.CertFile, r.config.KeyFile}
+	if r.config.ClientCAFile != "" {
+		files = append(files, r.config.ClientCAFile)
+	}
+	return files
+}
+
+// load reads the certificate, key and client CAs
+func (r *certReloader) load() error {
+	modTimes := make([]time.Time, 0, 3)
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return fmt.Errorf("failed to read TLS file: %w", err)
+		}
+		modTimes = append(modTimes, info.ModTime())
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.config.CertFile, r.config.KeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	config := r.base.Clone()
+	config.Certificates = []tls.Certificate{cert}
+
+	if r.config.ClientCAFile != "" {
+		data, err := os.ReadFile(r.config.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read client CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("no certificates found in client CA file %s", r.config.ClientCAFile)
+		}
+		config.ClientCAs = pool
+	}
+
+	r.current = config
+	r.modTimes = modTimes
+	return nil
+}
+
+// getConfigForClient returns the current configuration, first reloading
+// the files if they changed. If a reload fails, the previous certificates
+// stay in use.
+func (r *certReloader) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if now := time.Now(); now.Sub(r.checked) >= r.interval {
+		r.checked = now
+		if r.changed() {
+			if err := r.load(); err != nil {
+				r.log("TLS reload failed, keeping the previous certificate: %v", err)
+			} else {
+				r.log("Reloaded TLS certificate from %s", r.config.CertFile)
+			}
+		}
+	}
+	return r.current, nil
+}
+
+// changed reports whether any of the files was modified since it was loaded
+func (r *certReloader) changed() bool {
+	for i, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil || !info.ModTime().Equal(r.modTimes[i]) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,241 @@
+package mcp
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/user/go-mcp-commander/pkg/auth"
+)
+
+// testCA issues certificates for TLS tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a PEM certificate and key for a server (with an IP SAN) or
+// client certificate
+func (ca *testCA) issue(t *testing.T, serial int64, commonName string, server bool) ([]byte, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if server {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		template.IPAddresses = []net.IP{net.IPv4(127, 0, 0, 1)}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeTestFile(t *testing.T, file string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(file, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTLS_ClientCertificates(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	config := TLSConfig{
+		CertFile:     filepath.Join(dir, "server.pem"),
+		KeyFile:      filepath.Join(dir, "server-key.pem"),
+		ClientCAFile: filepath.Join(dir, "ca.pem"),
+	}
+	serverCert, serverKey := ca.issue(t, 2, "server", true)
+	writeTestFile(t, config.CertFile, serverCert)
+	writeTestFile(t, config.KeyFile, serverKey)
+	writeTestFile(t, config.ClientCAFile, ca.pem)
+
+	identities := filepath.Join(dir, "identities.yaml")
+	writeTestFile(t, identities, []byte(`
+identities:
+  - name: runner
+    certificate_subject: runner-1
+    scopes: ["tools:read_*"]
+`))
+	validator, err := auth.NewValidator(auth.Config{IdentitiesFile: identities})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := NewServer("test-server", "1.0.0")
+	server.SetIO(nil, nil, io.Discard)
+	server.SetAuthenticator(validator)
+	for _, name := range []string{"read_file", "write_file"} {
+		server.RegisterTool(Tool{Name: name, InputSchema: JSONSchema{Type: "object"}}, func(ctx context.Context, args map[string]interface{}) (*CallToolResult, error) {
+			return &CallToolResult{Content: []ContentItem{{Type: "text", Text: "ok"}}}, nil
+		})
+	}
+
+	tlsConfig, reloader, err := newTLSConfig(config, server.Log)
+	if err != nil {
+		t.Fatalf("newTLSConfig failed: %v", err)
+	}
+	reloader.interval = 0
+	httpServer := httptest.NewUnstartedServer(server.httpHandler())
+	httpServer.TLS = tlsConfig
+	httpServer.Config.ErrorLog = log.New(io.Discard, "", 0)
+	httpServer.StartTLS()
+	defer httpServer.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	clientCertPEM, clientKeyPEM := ca.issue(t, 3, "runner-1", false)
+	clientCert, err := tls.X509KeyPair(clientCertPEM, clientKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	post := func(certificates []tls.Certificate) (*http.Response, error) {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{RootCAs: roots, Certificates: certificates},
+			DisableKeepAlives: true,
+		}}
+		return client.Post(httpServer.URL, "application/json", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"tools/list"}`))
+	}
+
+	resp, err := post([]tls.Certificate{clientCert})
+	if err != nil {
+		t.Fatalf("Request with a client certificate failed: %v", err)
+	}
+	var response struct {
+		Result ListToolsResult `json:"result"`
+	}
+	json.NewDecoder(resp.Body).Decode(&response)
+	resp.Body.Close()
+	if tools := response.Result.Tools; len(tools) != 1 || tools[0].Name != "read_file" {
+		t.Errorf("Expected the certificate's identity to list only read_file, got %+v", tools)
+	}
+	if serial := resp.TLS.PeerCertificates[0].SerialNumber.Int64(); serial != 2 {
+		t.Errorf("Expected the server certificate with serial 2, got %d", serial)
+	}
+
+	if resp, err := post(nil); err == nil {
+		resp.Body.Close()
+		t.Error("Expected a connection without a client certificate to be refused")
+	}
+
+	// A renewed certificate is served without a restart
+	serverCert, serverKey = ca.issue(t, 4, "server", true)
+	writeTestFile(t, config.CertFile, serverCert)
+	writeTestFile(t, config.KeyFile, serverKey)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(config.CertFile, later, later)
+	resp, err = post([]tls.Certificate{clientCert})
+	if err != nil {
+		t.Fatalf("Request after the certificate was renewed failed: %v", err)
+	}
+	resp.Body.Close()
+	if serial := resp.TLS.PeerCertificates[0].SerialNumber.Int64(); serial != 4 {
+		t.Errorf("Expected the renewed certificate with serial 4, got %d", serial)
+	}
+
+	// A broken certificate file keeps the previous certificate in use
+	writeTestFile(t, config.CertFile, []byte("not a certificate"))
+	later = later.Add(time.Minute)
+	os.Chtimes(config.CertFile, later, later)
+	resp, err = post([]tls.Certificate{clientCert})
+	if err != nil {
+		t.Fatalf("Request after a failed reload failed: %v", err)
+	}
+	resp.Body.Close()
+	if serial := resp.TLS.PeerCertificates[0].SerialNumber.Int64(); serial != 4 {
+		t.Errorf("Expected the previous certificate to stay in use, got serial %d", serial)
+	}
+}
+
+func TestNewTLSConfig_Errors(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	certFile, keyFile := filepath.Join(dir, "server.pem"), filepath.Join(dir, "server-key.pem")
+	serverCert, serverKey := ca.issue(t, 2, "server", true)
+	writeTestFile(t, certFile, serverCert)
+	writeTestFile(t, keyFile, serverKey)
+	writeTestFile(t, filepath.Join(dir, "empty.pem"), nil)
+
+	tests := []struct {
+		name    string
+		config  TLSConfig
+		message string
+	}{
+		{"min version", TLSConfig{CertFile: certFile, KeyFile: keyFile, MinVersion: "1.1"}, "invalid minimum TLS version"},
+		{"client auth", TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: certFile, ClientAuth: "sometimes"}, "invalid client certificate mode"},
+		{"missing key", TLSConfig{CertFile: certFile}, "both a certificate and a key"},
+		{"mismatched key", TLSConfig{CertFile: certFile, KeyFile: certFile}, "failed to load TLS certificate"},
+		{"empty client CA", TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: filepath.Join(dir, "empty.pem")}, "no certificates found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := newTLSConfig(tt.config, func(string, ...interface{}) {})
+			if err == nil || !strings.Contains(err.Error(), tt.message) {
+				t.Errorf("Expected error containing %q, got %v", tt.message, err)
+			}
+		})
+	}
+
+	config, _, err := newTLSConfig(TLSConfig{CertFile: certFile, KeyFile: keyFile, MinVersion: "1.3"}, func(string, ...interface{}) {})
+	if err != nil || config.MinVersion != tls.VersionTLS13 {
+		t.Errorf("Expected a TLS 1.3 minimum, got %v, %v", config, err)
+	}
+}
@@ -8,13 +8,22 @@ type JSONRPCRequest struct {
 	Params  interface{} `json:"params,omitempty"`
 }
 
+// JSONRPCResponse answers a request. ID is always sent, as null when the
+// request's id could not be read.
 type JSONRPCResponse struct {
 	JSONRPC string        `json:"jsonrpc"`
-	ID      interface{}   `json:"id,omitempty"`
+	ID      interface{}   `json:"id"`
 	Result  interface{}   `json:"result,omitempty"`
 	Error   *JSONRPCError `json:"error,omitempty"`
 }
 
+// JSONRPCNotification is a server-to-client message that expects no response
+type JSONRPCNotification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+}
+
 type JSONRPCError struct {
 	Code    int         `json:"code"`
 	Message string      `json:"message"`
@@ -42,8 +51,9 @@ type InitializeParams struct {
 }
 
 type ClientCapabilities struct {
-	Roots    *RootsCapability    `json:"roots,omitempty"`
-	Sampling *SamplingCapability `json:"sampling,omitempty"`
+	Roots       *RootsCapability       `json:"roots,omitempty"`
+	Sampling    *SamplingCapability    `json:"sampling,omitempty"`
+	Elicitation *ElicitationCapability `json:"elicitation,omitempty"`
 }
 
 type RootsCapability struct {
@@ -52,6 +62,10 @@ type RootsCapability struct {
 
 type SamplingCapability struct{}
 
+// ElicitationCapability is declared by clients that can ask their user for
+// input on the server's behalf (protocol 2025-06-18)
+type ElicitationCapability struct{}
+
 type ClientInfo struct {
 	Name    string `json:"name"`
 	Version string `json:"version,omitempty"`
@@ -65,10 +79,13 @@ type InitializeResult struct {
 
 // Tool types
 type Tool struct {
-	Name        string           `json:"name"`
-	Description string           `json:"description,omitempty"`
-	InputSchema JSONSchema       `json:"inputSchema"`
-	Annotations *ToolAnnotations `json:"annotations,omitempty"`
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	InputSchema JSONSchema `json:"inputSchema"`
+	// OutputSchema describes the tool's StructuredContent (protocol
+	// 2025-06-18)
+	OutputSchema *JSONSchema      `json:"outputSchema,omitempty"`
+	Annotations  *ToolAnnotations `json:"annotations,omitempty"`
 }
 
 // ToolAnnotations provides hints about tool behavior for LLM decision-making
@@ -100,8 +117,11 @@ type Property struct {
 	Enum        []string            `json:"enum,omitempty"`
 	Items       *Property           `json:"items,omitempty"`
 	Properties  map[string]Property `json:"properties,omitempty"`
-	Minimum     *int                `json:"minimum,omitempty"`
-	Maximum     *int                `json:"maximum,omitempty"`
+	// AdditionalProperties describes the values of an object's properties
+	// that Properties does not name
+	AdditionalProperties *Property `json:"additionalProperties,omitempty"`
+	Minimum              *int      `json:"minimum,omitempty"`
+	Maximum              *int      `json:"maximum,omitempty"`
 }
 
 type ListToolsResult struct {
@@ -115,7 +135,11 @@ type CallToolParams struct {
 
 type CallToolResult struct {
 	Content []ContentItem `json:"content"`
-	IsError bool          `json:"isError,omitempty"`
+	// StructuredContent is the result as a JSON object matching the tool's
+	// OutputSchema (protocol 2025-06-18). Older clients only get Content,
+	// which should carry the same data as text.
+	StructuredContent interface{} `json:"structuredContent,omitempty"`
+	IsError           bool        `json:"isError,omitempty"`
 }
 
 type ContentItem struct {
@@ -123,6 +147,14 @@ type ContentItem struct {
 	Text string `json:"text,omitempty"`
 }
 
+// ProgressParams is the payload of a notifications/progress message
+type ProgressParams struct {
+	ProgressToken interface{} `json:"progressToken"`
+	Progress      float64     `json:"progress"`
+	Total         float64     `json:"total,omitempty"`
+	Message       string      `json:"message,omitempty"`
+}
+
 // Error codes
 const (
 	ParseError     = -32700
@@ -130,4 +162,8 @@ const (
 	MethodNotFound = -32601
 	InvalidParams  = -32602
 	InternalError  = -32603
+
+	// ServerOverloaded is returned for requests refused because too many
+	// are already waiting
+	ServerOverloaded = -32001
 )
@@ -0,0 +1,594 @@
+package web
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheMode controls how a request uses the response cache
+type CacheMode string
+
+const (
+	// CacheDefault serves fresh cached responses and revalidates stale ones
+	CacheDefault CacheMode = "default"
+	// CacheRefresh always asks the server, revalidating a cached response
+	// if it has an ETag or Last-Modified date, and stores the result
+	CacheRefresh CacheMode = "refresh"
+	// CacheBypass neither reads nor stores cached responses
+	CacheBypass CacheMode = "bypass"
+)
+
+// CacheModes lists the supported cache modes
+var CacheModes = []string{string(CacheDefault), string(CacheRefresh), string(CacheBypass)}
+
+// ParseCacheMode validates a cache mode name. An empty name means
+// CacheDefault.
+func ParseCacheMode(name string) (CacheMode, error) {
+	if name == "" {
+		return CacheDefault, nil
+	}
+	for _, mode := range CacheModes {
+		if strings.EqualFold(name, mode) {
+			return CacheMode(mode), nil
+		}
+	}
+	return "", fmt.Errorf("unsupported cache mode %q (expected one of: %s)", name, strings.Join(CacheModes, ", "))
+}
+
+// Cache statuses reported in the CacheStatusHeader of responses
+const (
+	// CacheStatusHeader is added to responses that pass through a Cache
+	CacheStatusHeader = "X-Commander-Cache"
+
+	CacheHit         = "hit"
+	CacheMiss        = "miss"
+	CacheRevalidated = "revalidated"
+	CacheBypassed    = "bypass"
+)
+
+type cacheModeKey struct{}
+type cacheTTLKey struct{}
+
+// WithCacheMode returns a context whose requests use the cache in mode
+func WithCacheMode(ctx context.Context, mode CacheMode) context.Context {
+	return context.WithValue(ctx, cacheModeKey{}, mode)
+}
+
+// WithCacheTTL returns a context whose responses are kept fresh for at least
+// ttl, even if the server asks for revalidation. It suits APIs such as
+// search engines that mark every response as immediately stale. Responses
+// marked no-store are still not cached.
+func WithCacheTTL(ctx context.Context, ttl time.Duration) context.Context {
+	return context.WithValue(ctx, cacheTTLKey{}, ttl)
+}
+
+// keyedHeaders are the request headers that select a cached response in
+// addition to its method and URL. Their values are stored hashed, so that
+// credentials are not written to disk.
+var keyedHeaders = []string{"Accept", "Accept-Language", "Authorization", "Cookie"}
+
+// cacheableStatus lists the statuses that are cached
+var cacheableStatus = map[int]bool{
+	http.StatusOK: true, http.StatusNonAuthoritativeInfo: true, http.StatusNoContent: true,
+	http.StatusMultipleChoices: true, http.StatusMovedPermanently: true,
+	http.StatusPermanentRedirect: true, http.StatusNotFound: true, http.StatusGone: true,
+}
+
+// maxCacheEntry is the largest body that is cached, matching the largest
+// response web_fetch reads
+const maxCacheEntry = 10 * 1024 * 1024
+
+// maxHeuristicLifetime caps the freshness given to responses that have a
+// Last-Modified date but no explicit lifetime
+const maxHeuristicLifetime = 24 * time.Hour
+
+// Cache is a private HTTP cache for GET requests, held in an in-memory LRU
+// and optionally on disk. It honours Cache-Control, Expires, ETag and
+// Last-Modified, revalidating stale responses with conditional requests.
+// Each URL holds one cached response, which is used only for requests with
+// the same keyed and Vary headers.
+type Cache struct {
+	memory *memoryStore
+	disk   *diskStore
+	now    func() time.Time
+}
+
+// NewCache creates a cache holding up to memoryBytes of responses in memory.
+// If dir is not empty, responses are also stored there, up to diskBytes, and
+// survive restarts.
+func NewCache(memoryBytes int64, dir string, diskBytes int64) (*Cache, error) {
+	c := &Cache{
+		memory: newMemoryStore(memoryBytes),
+		now:    time.Now,
+	}
+	if dir != "" {
+		disk, err := newDiskStore(dir, diskBytes)
+		if err != nil {
+			return nil, err
+		}
+		c.disk = disk
+	}
+	return c, nil
+}
+
+// cacheEntry is a stored response
+type cacheEntry struct {
+	StatusCode int         `json:"status_code"`
+	Status     string      `json:"status"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body"`
+	// Stored is when the response was received or last revalidated
+	Stored time.Time `json:"stored"`
+	// Expires is when the response becomes stale
+	Expires time.Time `json:"expires"`
+	// Keys are the hashed values of the keyed and Vary request headers
+	Keys map[string]string `json:"keys"`
+}
+
+func (e *cacheEntry) size() int64 {
+	size := int64(len(e.Body))
+	for key, values := range e.Header {
+		size += int64(len(key))
+		for _, value := range values {
+			size += int64(len(value))
+		}
+	}
+	return size
+}
+
+// matches reports whether the entry was stored for a request with the same
+// keyed headers as req
+func (e *cacheEntry) matches(req *http.Request) bool {
+	for name, hash := range e.Keys {
+		if hashHeader(req, name) != hash {
+			return false
+		}
+	}
+	return true
+}
+
+// response builds a response to req from the entry
+func (e *cacheEntry) response(req *http.Request, status string, now time.Time) *http.Response {
+	header := e.Header.Clone()
+	header.Set("Age", strconv.Itoa(int(now.Sub(e.Stored).Seconds())))
+	header.Set(CacheStatusHeader, status)
+	return &http.Response{
+		Status:        e.Status,
+		StatusCode:    e.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}
+}
+
+// Wrap returns a RoundTripper that serves requests from the cache and sends
+// the rest to base
+func (c *Cache) Wrap(base http.RoundTripper) http.RoundTripper {
+	return &cacheTransport{cache: c, base: base}
+}
+
+type cacheTransport struct {
+	cache *Cache
+	base  http.RoundTripper
+}
+
+func (t *cacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c := t.cache
+	key := req.URL.String()
+
+	if req.Method != http.MethodGet {
+		resp, err := t.base.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+		// Requests that change a resource invalidate its cached copy
+		if req.Method != http.MethodHead && req.Method != http.MethodOptions && resp.StatusCode < 400 {
+			c.delete(key)
+		}
+		resp.Header.Set(CacheStatusHeader, CacheBypassed)
+		return resp, nil
+	}
+
+	mode, _ := req.Context().Value(cacheModeKey{}).(CacheMode)
+	directives := parseCacheControl(req.Header.Get("Cache-Control"))
+	_, noStore := directives["no-store"]
+	if _, noCache := directives["no-cache"]; noCache && mode != CacheBypass {
+		mode = CacheRefresh
+	}
+	// Requests that are already conditional or partial are the caller's to
+	// handle
+	if mode == CacheBypass || noStore || req.Header.Get("Range") != "" ||
+		req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != "" {
+		resp, err := t.base.RoundTrip(req)
+		if err == nil {
+			resp.Header.Set(CacheStatusHeader, CacheBypassed)
+		}
+		return resp, err
+	}
+
+	entry := c.get(key)
+	if entry != nil && !entry.matches(req) {
+		entry = nil
+	}
+	if entry != nil && mode != CacheRefresh && c.now().Before(entry.Expires) {
+		return entry.response(req, CacheHit, c.now()), nil
+	}
+
+	// Revalidate a stale response if it can be
+	outgoing := req
+	if entry != nil {
+		etag, lastModified := entry.Header.Get("ETag"), entry.Header.Get("Last-Modified")
+		if etag != "" || lastModified != "" {
+			outgoing = req.Clone(req.Context())
+			if etag != "" {
+				outgoing.Header.Set("If-None-Match", etag)
+			}
+			if lastModified != "" {
+				outgoing.Header.Set("If-Modified-Since", lastModified)
+			}
+		} else {
+			entry = nil
+		}
+	}
+
+	resp, err := t.base.RoundTrip(outgoing)
+	if err != nil {
+		return nil, err
+	}
+	now := c.now()
+
+	if entry != nil && resp.StatusCode == http.StatusNotModified {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		// The 304 carries the response's updated freshness and validators
+		for _, name := range []string{"Cache-Control", "Date", "ETag", "Expires", "Last-Modified", "Vary"} {
+			if value := resp.Header.Get(name); value != "" {
+				entry.Header.Set(name, value)
+			}
+		}
+		entry.Stored = now
+		entry.Expires = now.Add(lifetime(entry.Header, now, minTTL(req)))
+		c.set(key, entry)
+		return entry.response(req, CacheRevalidated, now), nil
+	}
+
+	resp.Header.Set(CacheStatusHeader, CacheMiss)
+	if !storable(resp) {
+		return resp, nil
+	}
+	ttl := lifetime(resp.Header, now, minTTL(req))
+	if ttl <= 0 && resp.Header.Get("ETag") == "" && resp.Header.Get("Last-Modified") == "" {
+		return resp, nil
+	}
+	if resp.ContentLength > maxCacheEntry {
+		return resp, nil
+	}
+
+	// Read the body to store it, handing back whatever was read if it turns
+	// out to be too large
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxCacheEntry+1))
+	if err != nil || int64(len(body)) > maxCacheEntry {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return resp, nil
+	}
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	header := resp.Header.Clone()
+	header.Del(CacheStatusHeader)
+	entry = &cacheEntry{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Header:     header,
+		Body:       body,
+		Stored:     now,
+		Expires:    now.Add(ttl),
+		Keys:       make(map[string]string),
+	}
+	for _, name := range keyedHeaders {
+		entry.Keys[name] = hashHeader(req, name)
+	}
+	for _, name := range varyHeaders(resp.Header) {
+		entry.Keys[http.CanonicalHeaderKey(name)] = hashHeader(req, name)
+	}
+	c.set(key, entry)
+	return resp, nil
+}
+
+// storable reports whether a response may be cached at all
+func storable(resp *http.Response) bool {
+	if !cacheableStatus[resp.StatusCode] {
+		return false
+	}
+	if _, noStore := parseCacheControl(resp.Header.Get("Cache-Control"))["no-store"]; noStore {
+		return false
+	}
+	for _, name := range varyHeaders(resp.Header) {
+		if name == "*" {
+			return false
+		}
+	}
+	return true
+}
+
+// lifetime returns how long a response stays fresh: its max-age, else the
+// time until Expires, else a tenth of its age at Last-Modified. minimum
+// raises the result unless the response is marked no-store.
+func lifetime(header http.Header, now time.Time, minimum time.Duration) time.Duration {
+	directives := parseCacheControl(header.Get("Cache-Control"))
+	date, err := http.ParseTime(header.Get("Date"))
+	if err != nil {
+		date = now
+	}
+	age := time.Duration(0)
+	if seconds, err := strconv.Atoi(header.Get("Age")); err == nil && seconds > 0 {
+		age = time.Duration(seconds) * time.Second
+	}
+
+	var ttl time.Duration
+	_, noCache := directives["no-cache"]
+	switch maxAge, hasMaxAge := directives["max-age"]; {
+	case noCache:
+		ttl = 0
+	case hasMaxAge:
+		seconds, err := strconv.Atoi(maxAge)
+		if err == nil && seconds > 0 {
+			ttl = time.Duration(seconds)*time.Second - age
+		}
+	case header.Get("Expires") != "":
+		if expires, err := http.ParseTime(header.Get("Expires")); err == nil {
+			ttl = expires.Sub(date) - age
+		}
+	case header.Get("Last-Modified") != "":
+		if modified, err := http.ParseTime(header.Get("Last-Modified")); err == nil && modified.Before(date) {
+			ttl = min(date.Sub(modified)/10, maxHeuristicLifetime) - age
+		}
+	}
+	return max(ttl, minimum)
+}
+
+func minTTL(req *http.Request) time.Duration {
+	ttl, _ := req.Context().Value(cacheTTLKey{}).(time.Duration)
+	return ttl
+}
+
+// parseCacheControl splits a Cache-Control header into lower-cased
+// directives and their values
+func parseCacheControl(value string) map[string]string {
+	directives := make(map[string]string)
+	for _, part := range strings.Split(value, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			directives[name] = strings.Trim(strings.TrimSpace(arg), `"`)
+		}
+	}
+	return directives
+}
+
+func varyHeaders(header http.Header) []string {
+	var names []string
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+func hashHeader(req *http.Request, name string) string {
+	values := req.Header.Values(name)
+	if len(values) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(strings.Join(values, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *Cache) get(key string) *cacheEntry {
+	if entry := c.memory.get(key); entry != nil {
+		return entry
+	}
+	if c.disk == nil {
+		return nil
+	}
+	entry := c.disk.get(key)
+	if entry != nil {
+		c.memory.set(key, entry)
+	}
+	return entry
+}
+
+func (c *Cache) set(key string, entry *cacheEntry) {
+	c.memory.set(key, entry)
+	if c.disk != nil {
+		c.disk.set(key, entry)
+	}
+}
+
+func (c *Cache) delete(key string) {
+	c.memory.delete(key)
+	if c.disk != nil {
+		c.disk.delete(key)
+	}
+}
+
+// memoryStore is a least recently used set of entries bounded in bytes
+type memoryStore struct {
+	mu       sync.Mutex
+	maxBytes int64
+	size     int64
+	order    *list.List
+	items    map[string]*list.Element
+}
+
+type memoryItem struct {
+	key   string
+	entry *cacheEntry
+}
+
+func newMemoryStore(maxBytes int64) *memoryStore {
+	return &memoryStore{maxBytes: maxBytes, order: list.New(), items: make(map[string]*list.Element)}
+}
+
+// get returns a copy of the entry for key, so that callers may modify it
+func (s *memoryStore) get(key string) *cacheEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	element, ok := s.items[key]
+	if !ok {
+		return nil
+	}
+	s.order.MoveToFront(element)
+	entry := *element.Value.(*memoryItem).entry
+	entry.Header = entry.Header.Clone()
+	return &entry
+}
+
+func (s *memoryStore) set(key string, entry *cacheEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(key)
+	if entry.size() > s.maxBytes {
+		return
+	}
+	s.items[key] = s.order.PushFront(&memoryItem{key: key, entry: entry})
+	s.size += entry.size()
+	for s.size > s.maxBytes {
+		s.remove(s.order.Back().Value.(*memoryItem).key)
+	}
+}
+
+func (s *memoryStore) delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(key)
+}
+
+func (s *memoryStore) remove(key string) {
+	if element, ok := s.items[key]; ok {
+		s.size -= element.Value.(*memoryItem).entry.size()
+		s.order.Remove(element)
+		delete(s.items, key)
+	}
+}
+
+// diskStore keeps entries as JSON files in a directory, removing the least
+// recently used files when it grows past maxBytes
+type diskStore struct {
+	mu       sync.Mutex
+	dir      string
+	maxBytes int64
+}
+
+func newDiskStore(dir string, maxBytes int64) (*diskStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	return &diskStore{dir: dir, maxBytes: maxBytes}, nil
+}
+
+func (s *diskStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+func (s *diskStore) get(key string) *cacheEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path := s.path(key)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		os.Remove(path)
+		return nil
+	}
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return &entry
+}
+
+func (s *diskStore) set(key string, entry *cacheEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := json.Marshal(entry)
+	if err != nil || int64(len(data)) > s.maxBytes {
+		return
+	}
+	tmp, err := os.CreateTemp(s.dir, ".entry-*")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path(key))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+	s.evict()
+}
+
+func (s *diskStore) delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	os.Remove(s.path(key))
+}
+
+// evict removes the least recently used entries until the store fits in
+// maxBytes
+func (s *diskStore) evict() {
+	dirEntries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return
+	}
+	var files []os.FileInfo
+	var size int64
+	for _, dirEntry := range dirEntries {
+		if !strings.HasSuffix(dirEntry.Name(), ".json") {
+			continue
+		}
+		if info, err := dirEntry.Info(); err == nil {
+			files = append(files, info)
+			size += info.Size()
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().Before(files[j].ModTime()) })
+	for _, file := range files {
+		if size <= s.maxBytes {
+			break
+		}
+		if os.Remove(filepath.Join(s.dir, file.Name())) == nil {
+			size -= file.Size()
+		}
+	}
+}
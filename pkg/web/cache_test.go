@@ -0,0 +1,278 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// cacheServer counts the requests it serves and answers with the headers
+// set by handler
+func cacheServer(t *testing.T, handler func(w http.ResponseWriter, r *http.Request)) (*httptest.Server, *int32) {
+	t.Helper()
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&requests, 1)
+		handler(w, r)
+		fmt.Fprintf(w, "response %d", n)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func newTestCache(t *testing.T, dir string) *Cache {
+	t.Helper()
+	cache, err := NewCache(1<<20, dir, 1<<20)
+	if err != nil {
+		t.Fatalf("NewCache failed: %v", err)
+	}
+	return cache
+}
+
+// fetch requests url through the cache and returns the body and cache status
+func fetch(t *testing.T, cache *Cache, ctx context.Context, method, url string, header http.Header) (string, string) {
+	t.Helper()
+	client := &http.Client{Transport: cache.Wrap(http.DefaultTransport)}
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return string(body), resp.Header.Get(CacheStatusHeader)
+}
+
+func TestCache_MaxAge(t *testing.T) {
+	server, requests := cacheServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=60")
+	})
+	cache := newTestCache(t, "")
+	ctx := context.Background()
+
+	body, status := fetch(t, cache, ctx, "GET", server.URL, nil)
+	if body != "response 1" || status != CacheMiss {
+		t.Errorf("First request: got %q, %s", body, status)
+	}
+	body, status = fetch(t, cache, ctx, "GET", server.URL, nil)
+	if body != "response 1" || status != CacheHit {
+		t.Errorf("Second request: got %q, %s", body, status)
+	}
+
+	// Once stale, without validators, the response is fetched again
+	cache.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	body, status = fetch(t, cache, ctx, "GET", server.URL, nil)
+	if body != "response 2" || status != CacheMiss {
+		t.Errorf("Stale request: got %q, %s", body, status)
+	}
+	if *requests != 2 {
+		t.Errorf("Expected 2 requests to the server, got %d", *requests)
+	}
+}
+
+func TestCache_Revalidation(t *testing.T) {
+	var conditional int32
+	server, _ := cacheServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(&conditional, 1)
+			w.WriteHeader(http.StatusNotModified)
+		}
+	})
+	cache := newTestCache(t, "")
+
+	fetch(t, cache, context.Background(), "GET", server.URL, nil)
+	body, status := fetch(t, cache, context.Background(), "GET", server.URL, nil)
+	if body != "response 1" || status != CacheRevalidated {
+		t.Errorf("Expected the cached body after revalidation, got %q, %s", body, status)
+	}
+	if conditional != 1 {
+		t.Errorf("Expected one conditional request, got %d", conditional)
+	}
+}
+
+func TestCache_LastModifiedHeuristic(t *testing.T) {
+	server, _ := cacheServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Last-Modified", time.Now().Add(-240*time.Hour).UTC().Format(http.TimeFormat))
+	})
+	cache := newTestCache(t, "")
+
+	fetch(t, cache, context.Background(), "GET", server.URL, nil)
+	if _, status := fetch(t, cache, context.Background(), "GET", server.URL, nil); status != CacheHit {
+		t.Errorf("Expected a heuristic freshness hit, got %s", status)
+	}
+}
+
+func TestCache_NotStored(t *testing.T) {
+	tests := []struct {
+		name   string
+		header map[string]string
+		status int
+	}{
+		{"no-store", map[string]string{"Cache-Control": "no-store", "ETag": `"x"`}, http.StatusOK},
+		{"no validators or lifetime", nil, http.StatusOK},
+		{"vary star", map[string]string{"Cache-Control": "max-age=60", "Vary": "*"}, http.StatusOK},
+		{"server error", map[string]string{"Cache-Control": "max-age=60"}, http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, requests := cacheServer(t, func(w http.ResponseWriter, r *http.Request) {
+				for key, value := range tt.header {
+					w.Header().Set(key, value)
+				}
+				w.WriteHeader(tt.status)
+			})
+			cache := newTestCache(t, "")
+			fetch(t, cache, context.Background(), "GET", server.URL, nil)
+			fetch(t, cache, context.Background(), "GET", server.URL, nil)
+			if *requests != 2 {
+				t.Errorf("Expected both requests to reach the server, got %d", *requests)
+			}
+		})
+	}
+}
+
+func TestCache_KeyedHeaders(t *testing.T) {
+	server, requests := cacheServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "X-Variant")
+	})
+	cache := newTestCache(t, "")
+	ctx := context.Background()
+
+	fetch(t, cache, ctx, "GET", server.URL, http.Header{"Authorization": {"Bearer a"}})
+	if _, status := fetch(t, cache, ctx, "GET", server.URL, http.Header{"Authorization": {"Bearer b"}}); status != CacheMiss {
+		t.Errorf("Expected a different Authorization to miss, got %s", status)
+	}
+	if _, status := fetch(t, cache, ctx, "GET", server.URL, http.Header{"Authorization": {"Bearer b"}, "X-Variant": {"2"}}); status != CacheMiss {
+		t.Errorf("Expected a different Vary header to miss, got %s", status)
+	}
+	if _, status := fetch(t, cache, ctx, "GET", server.URL, http.Header{"Authorization": {"Bearer b"}, "X-Variant": {"2"}}); status != CacheHit {
+		t.Errorf("Expected the same headers to hit, got %s", status)
+	}
+	if *requests != 3 {
+		t.Errorf("Expected 3 requests to the server, got %d", *requests)
+	}
+}
+
+func TestCache_Modes(t *testing.T) {
+	server, requests := cacheServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+		}
+	})
+	cache := newTestCache(t, "")
+	ctx := context.Background()
+
+	if _, status := fetch(t, cache, WithCacheMode(ctx, CacheBypass), "GET", server.URL, nil); status != CacheBypassed {
+		t.Errorf("Expected bypass, got %s", status)
+	}
+	if _, status := fetch(t, cache, ctx, "GET", server.URL, nil); status != CacheMiss {
+		t.Errorf("Expected a bypassed response not to be stored, got %s", status)
+	}
+	if _, status := fetch(t, cache, WithCacheMode(ctx, CacheRefresh), "GET", server.URL, nil); status != CacheRevalidated {
+		t.Errorf("Expected refresh to revalidate a fresh response, got %s", status)
+	}
+	if _, status := fetch(t, cache, ctx, "GET", server.URL, http.Header{"Cache-Control": {"no-cache"}}); status != CacheRevalidated {
+		t.Errorf("Expected Cache-Control: no-cache to revalidate, got %s", status)
+	}
+	if _, status := fetch(t, cache, ctx, "GET", server.URL, nil); status != CacheHit {
+		t.Errorf("Expected a hit, got %s", status)
+	}
+	if *requests != 4 {
+		t.Errorf("Expected 4 requests to the server, got %d", *requests)
+	}
+}
+
+func TestCache_MinimumTTL(t *testing.T) {
+	server, _ := cacheServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "private, max-age=0")
+	})
+	cache := newTestCache(t, "")
+	ctx := WithCacheTTL(context.Background(), time.Minute)
+
+	fetch(t, cache, ctx, "GET", server.URL, nil)
+	if _, status := fetch(t, cache, ctx, "GET", server.URL, nil); status != CacheHit {
+		t.Errorf("Expected the minimum TTL to make the response fresh, got %s", status)
+	}
+}
+
+func TestCache_UnsafeMethodInvalidates(t *testing.T) {
+	server, _ := cacheServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+	})
+	cache := newTestCache(t, "")
+	ctx := context.Background()
+
+	fetch(t, cache, ctx, "GET", server.URL, nil)
+	if _, status := fetch(t, cache, ctx, "POST", server.URL, nil); status != CacheBypassed {
+		t.Errorf("Expected POST to bypass the cache, got %s", status)
+	}
+	if _, status := fetch(t, cache, ctx, "GET", server.URL, nil); status != CacheMiss {
+		t.Errorf("Expected POST to invalidate the cached response, got %s", status)
+	}
+}
+
+func TestCache_Disk(t *testing.T) {
+	server, requests := cacheServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+	})
+	dir := t.TempDir()
+
+	fetch(t, newTestCache(t, dir), context.Background(), "GET", server.URL, nil)
+
+	// A new cache on the same directory, as after a restart
+	body, status := fetch(t, newTestCache(t, dir), context.Background(), "GET", server.URL, nil)
+	if body != "response 1" || status != CacheHit {
+		t.Errorf("Expected a hit from disk, got %q, %s", body, status)
+	}
+	if *requests != 1 {
+		t.Errorf("Expected 1 request to the server, got %d", *requests)
+	}
+}
+
+func TestMemoryStore_Eviction(t *testing.T) {
+	store := newMemoryStore(250)
+	entry := func() *cacheEntry { return &cacheEntry{Body: []byte(strings.Repeat("x", 100))} }
+
+	store.set("a", entry())
+	store.set("b", entry())
+	store.get("a")
+	store.set("c", entry())
+
+	if store.get("b") != nil {
+		t.Error("Expected the least recently used entry to be evicted")
+	}
+	if store.get("a") == nil || store.get("c") == nil {
+		t.Error("Expected recently used entries to be kept")
+	}
+	store.set("huge", &cacheEntry{Body: make([]byte, 1000)})
+	if store.get("huge") != nil {
+		t.Error("Expected an entry larger than the store not to be kept")
+	}
+}
+
+func TestParseCacheMode(t *testing.T) {
+	if mode, err := ParseCacheMode(""); err != nil || mode != CacheDefault {
+		t.Errorf("Expected empty mode to mean default, got %q, %v", mode, err)
+	}
+	if _, err := ParseCacheMode("forever"); err == nil {
+		t.Error("Expected unknown mode to be rejected")
+	}
+}
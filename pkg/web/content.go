@@ -0,0 +1,717 @@
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/net/html/charset"
+)
+
+// Format selects how a fetched body is returned
+type Format string
+
+const (
+	// FormatRaw returns the body unchanged apart from charset decoding
+	FormatRaw Format = "raw"
+	// FormatText returns the visible text of an HTML page
+	FormatText Format = "text"
+	// FormatMarkdown converts an HTML page to Markdown
+	FormatMarkdown Format = "markdown"
+	// FormatReadability converts the main article of an HTML page to
+	// Markdown, leaving out navigation, sidebars and footers
+	FormatReadability Format = "readability"
+)
+
+// Formats lists the supported formats
+var Formats = []string{string(FormatRaw), string(FormatText), string(FormatMarkdown), string(FormatReadability)}
+
+// ParseFormat validates a format name. An empty name means FormatRaw.
+func ParseFormat(name string) (Format, error) {
+	if name == "" {
+		return FormatRaw, nil
+	}
+	for _, f := range Formats {
+		if strings.EqualFold(name, f) {
+			return Format(f), nil
+		}
+	}
+	return "", fmt.Errorf("unsupported format %q (expected one of: %s)", name, strings.Join(Formats, ", "))
+}
+
+// Document is a fetched body converted to a Format
+type Document struct {
+	// Content is the converted body
+	Content string
+	// Charset is the character set the body was decoded from, empty if the
+	// body was not decoded as text
+	Charset string
+	// Title is the page title, empty if the body is not HTML
+	Title string
+	// CanonicalURL is the page's canonical URL, resolved against the URL it
+	// was fetched from; empty if the page does not declare one
+	CanonicalURL string
+}
+
+// Convert decodes a response body to UTF-8 using the charset from its
+// Content-Type header or HTML meta tags, then converts HTML bodies to the
+// given format. Bodies that are not HTML are returned as decoded text in
+// every format. base is the URL the body was fetched from and is used to
+// resolve relative links.
+func Convert(body []byte, contentType string, base *url.URL, format Format) (*Document, error) {
+	doc := &Document{}
+	if !isText(contentType) {
+		doc.Content = string(body)
+		return doc, nil
+	}
+
+	text, name, err := decode(body, contentType)
+	if err != nil {
+		return nil, err
+	}
+	doc.Content, doc.Charset = text, name
+	if !isHTML(contentType, body) {
+		return doc, nil
+	}
+
+	root, err := html.Parse(strings.NewReader(text))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse HTML: %w", err)
+	}
+	doc.Title = pageTitle(root)
+	doc.CanonicalURL = canonicalURL(root, base)
+
+	switch format {
+	case FormatText:
+		doc.Content = (&converter{base: base}).render(root)
+	case FormatMarkdown:
+		doc.Content = (&converter{markdown: true, base: base}).render(root)
+	case FormatReadability:
+		c := &converter{markdown: true, base: base, skip: unlikelyContent}
+		doc.Content = c.render(mainContent(root))
+	}
+	return doc, nil
+}
+
+// isText reports whether a Content-Type is worth decoding as text. Bodies
+// without a Content-Type are sniffed as text.
+func isText(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.Contains(strings.ToLower(contentType), "text")
+	}
+	if _, ok := params["charset"]; ok {
+		return true
+	}
+	return strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "+xml") || strings.HasSuffix(mediaType, "/xml") ||
+		strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "/json") ||
+		strings.HasSuffix(mediaType, "/javascript")
+}
+
+// isHTML reports whether a body is an HTML page, from its Content-Type or,
+// without one, its first bytes
+func isHTML(contentType string, body []byte) bool {
+	if contentType != "" {
+		mediaType, _, _ := mime.ParseMediaType(contentType)
+		return mediaType == "text/html" || mediaType == "application/xhtml+xml"
+	}
+	start := bytes.ToLower(bytes.TrimSpace(body[:min(len(body), 512)]))
+	return bytes.HasPrefix(start, []byte("<!doctype html")) || bytes.HasPrefix(start, []byte("<html"))
+}
+
+// decode converts body to UTF-8 and returns the name of its charset
+func decode(body []byte, contentType string) (string, string, error) {
+	_, name, _ := charset.DetermineEncoding(body, contentType)
+	reader, err := charset.NewReaderLabel(name, bytes.NewReader(body))
+	if err != nil {
+		return "", "", fmt.Errorf("unsupported charset %q: %w", name, err)
+	}
+	decoded, err := io.ReadAll(reader)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to decode %s body: %w", name, err)
+	}
+	return string(decoded), name, nil
+}
+
+// pageTitle returns the <title> of a page, falling back to og:title
+func pageTitle(root *html.Node) string {
+	if title := find(root, func(n *html.Node) bool { return n.DataAtom == atom.Title }); title != nil {
+		if text := collapseSpace(strings.TrimSpace(textContent(title))); text != "" {
+			return text
+		}
+	}
+	return metaProperty(root, "og:title")
+}
+
+// canonicalURL returns the page's <link rel="canonical">, falling back to
+// og:url, resolved against base
+func canonicalURL(root *html.Node, base *url.URL) string {
+	href := ""
+	link := find(root, func(n *html.Node) bool {
+		if n.DataAtom != atom.Link {
+			return false
+		}
+		for _, rel := range strings.Fields(strings.ToLower(attr(n, "rel"))) {
+			if rel == "canonical" {
+				return true
+			}
+		}
+		return false
+	})
+	if link != nil {
+		href = attr(link, "href")
+	}
+	if href == "" {
+		href = metaProperty(root, "og:url")
+	}
+	return resolve(base, href)
+}
+
+func metaProperty(root *html.Node, property string) string {
+	meta := find(root, func(n *html.Node) bool {
+		return n.DataAtom == atom.Meta && strings.EqualFold(attr(n, "property"), property)
+	})
+	if meta == nil {
+		return ""
+	}
+	return strings.TrimSpace(attr(meta, "content"))
+}
+
+// skippedElements never contain readable content
+var skippedElements = map[atom.Atom]bool{
+	atom.Head: true, atom.Script: true, atom.Style: true, atom.Noscript: true,
+	atom.Template: true, atom.Svg: true, atom.Math: true, atom.Canvas: true,
+	atom.Iframe: true, atom.Object: true, atom.Embed: true, atom.Audio: true,
+	atom.Video: true, atom.Button: true, atom.Input: true, atom.Select: true,
+	atom.Textarea: true, atom.Dialog: true,
+}
+
+// blockElements are rendered as paragraphs separated by blank lines
+var blockElements = map[atom.Atom]bool{
+	atom.P: true, atom.Div: true, atom.Section: true, atom.Article: true,
+	atom.Main: true, atom.Header: true, atom.Footer: true, atom.Nav: true,
+	atom.Aside: true, atom.Figure: true, atom.Figcaption: true, atom.Address: true,
+	atom.Dl: true, atom.Dt: true, atom.Dd: true, atom.Details: true,
+	atom.Summary: true, atom.Form: true, atom.Fieldset: true, atom.Center: true,
+	atom.Caption: true,
+}
+
+// converter renders an HTML tree as Markdown or plain text
+type converter struct {
+	markdown bool
+	base     *url.URL
+	// skip, if set, leaves out elements in addition to skippedElements
+	skip func(*html.Node) bool
+}
+
+var (
+	trailingSpace = regexp.MustCompile(`[ \t]+\n`)
+	blankLines    = regexp.MustCompile(`\n{3,}`)
+)
+
+// render converts n and its descendants
+func (c *converter) render(n *html.Node) string {
+	var b strings.Builder
+	c.node(&b, n)
+	out := trailingSpace.ReplaceAllString(b.String(), "\n")
+	out = blankLines.ReplaceAllString(out, "\n\n")
+	return strings.TrimSpace(out)
+}
+
+// inner renders the children of n, trimmed
+func (c *converter) inner(n *html.Node) string {
+	var b strings.Builder
+	c.children(&b, n)
+	return strings.TrimSpace(b.String())
+}
+
+func (c *converter) children(b *strings.Builder, n *html.Node) {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		c.node(b, child)
+	}
+}
+
+func (c *converter) node(b *strings.Builder, n *html.Node) {
+	switch n.Type {
+	case html.DocumentNode:
+		c.children(b, n)
+	case html.TextNode:
+		writeText(b, collapseSpace(n.Data))
+	case html.ElementNode:
+		if skippedElements[n.DataAtom] || hidden(n) || (c.skip != nil && c.skip(n)) {
+			return
+		}
+		c.element(b, n)
+	}
+}
+
+func (c *converter) element(b *strings.Builder, n *html.Node) {
+	switch n.DataAtom {
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		text := strings.Join(strings.Fields(c.inner(n)), " ")
+		if c.markdown && text != "" {
+			level := int(n.Data[1] - '0')
+			text = strings.Repeat("#", level) + " " + text
+		}
+		writeBlock(b, text)
+	case atom.Br:
+		b.WriteString("\n")
+	case atom.Hr:
+		if c.markdown {
+			writeBlock(b, "---")
+		}
+	case atom.Pre:
+		code := strings.Trim(textContent(n), "\n")
+		if c.markdown && code != "" {
+			code = "```" + codeLanguage(n) + "\n" + code + "\n```"
+		}
+		writeBlock(b, code)
+	case atom.Blockquote:
+		text := c.inner(n)
+		if c.markdown && text != "" {
+			lines := strings.Split(text, "\n")
+			for i, line := range lines {
+				lines[i] = strings.TrimRight("> "+line, " ")
+			}
+			text = strings.Join(lines, "\n")
+		}
+		writeBlock(b, text)
+	case atom.Ul, atom.Ol:
+		writeBlock(b, c.list(n))
+	case atom.Li:
+		// A list item outside a list
+		writeBlock(b, indentItem("- ", c.inner(n)))
+	case atom.Table:
+		writeBlock(b, c.table(n))
+	case atom.A:
+		c.link(b, n)
+	case atom.Img:
+		alt := collapseSpace(strings.TrimSpace(attr(n, "alt")))
+		src := resolve(c.base, attr(n, "src"))
+		if c.markdown && src != "" && !strings.HasPrefix(src, "data:") {
+			writeText(b, "!["+alt+"]("+src+")")
+		} else if alt != "" {
+			writeText(b, alt)
+		}
+	case atom.Strong, atom.B:
+		c.inline(b, n, "**")
+	case atom.Em, atom.I, atom.Cite:
+		c.inline(b, n, "*")
+	case atom.Del, atom.S, atom.Strike:
+		c.inline(b, n, "~~")
+	case atom.Code, atom.Kbd, atom.Samp, atom.Tt:
+		c.inline(b, n, "`")
+	default:
+		if blockElements[n.DataAtom] {
+			writeBlock(b, c.inner(n))
+			return
+		}
+		c.children(b, n)
+	}
+}
+
+// inline renders n's children wrapped in a Markdown marker, keeping any
+// surrounding whitespace outside the marker
+func (c *converter) inline(b *strings.Builder, n *html.Node, marker string) {
+	var inner strings.Builder
+	c.children(&inner, n)
+	text := inner.String()
+	trimmed := strings.TrimSpace(text)
+	if !c.markdown || trimmed == "" {
+		writeText(b, text)
+		return
+	}
+	if strings.HasPrefix(text, " ") {
+		writeText(b, " ")
+	}
+	writeText(b, marker+trimmed+marker)
+	if strings.HasSuffix(text, " ") {
+		writeText(b, " ")
+	}
+}
+
+// link renders an anchor as a Markdown link to its resolved URL
+func (c *converter) link(b *strings.Builder, n *html.Node) {
+	var inner strings.Builder
+	c.children(&inner, n)
+	text := inner.String()
+	trimmed := strings.Join(strings.Fields(text), " ")
+	href := resolve(c.base, attr(n, "href"))
+	if !c.markdown || trimmed == "" || href == "" || strings.HasPrefix(strings.ToLower(href), "javascript:") {
+		writeText(b, text)
+		return
+	}
+	if strings.HasPrefix(text, " ") {
+		writeText(b, " ")
+	}
+	title := ""
+	if t := strings.TrimSpace(attr(n, "title")); t != "" {
+		title = " " + strconv.Quote(t)
+	}
+	writeText(b, "["+escapeBrackets(trimmed)+"]("+escapeURL(href)+title+")")
+	if strings.HasSuffix(text, " ") {
+		writeText(b, " ")
+	}
+}
+
+// list renders the items of a <ul> or <ol>, indenting nested lists
+func (c *converter) list(n *html.Node) string {
+	ordered := n.DataAtom == atom.Ol
+	number := 1
+	if start, err := strconv.Atoi(attr(n, "start")); ordered && err == nil {
+		number = start
+	}
+
+	var items []string
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type != html.ElementNode || hidden(child) || (c.skip != nil && c.skip(child)) {
+			continue
+		}
+		switch child.DataAtom {
+		case atom.Li:
+			marker := "- "
+			if ordered {
+				marker = strconv.Itoa(number) + ". "
+				number++
+			}
+			// Keep items compact: paragraphs within an item become lines
+			text := blankLines.ReplaceAllString(c.inner(child), "\n")
+			text = strings.ReplaceAll(text, "\n\n", "\n")
+			if text != "" {
+				items = append(items, indentItem(marker, text))
+			}
+		case atom.Ul, atom.Ol:
+			// A list nested directly in a list, as older pages do
+			if nested := c.list(child); nested != "" {
+				items = append(items, indentItem("  ", nested))
+			}
+		}
+	}
+	return strings.Join(items, "\n")
+}
+
+// table renders a table as a Markdown table, or as tab-separated lines in
+// plain text. The first row is used as the header.
+func (c *converter) table(n *html.Node) string {
+	var rows [][]string
+	columns := 0
+	for _, row := range tableRows(n) {
+		var cells []string
+		for cell := row.FirstChild; cell != nil; cell = cell.NextSibling {
+			if cell.Type != html.ElementNode || (cell.DataAtom != atom.Td && cell.DataAtom != atom.Th) {
+				continue
+			}
+			text := strings.Join(strings.Fields(c.inner(cell)), " ")
+			if c.markdown {
+				text = strings.ReplaceAll(text, "|", `\|`)
+			}
+			cells = append(cells, text)
+		}
+		if len(cells) > 0 {
+			rows = append(rows, cells)
+			columns = max(columns, len(cells))
+		}
+	}
+	if len(rows) == 0 {
+		return ""
+	}
+
+	var lines []string
+	if caption := find(n, func(n *html.Node) bool { return n.DataAtom == atom.Caption }); caption != nil {
+		if text := c.inner(caption); text != "" {
+			lines = append(lines, text, "")
+		}
+	}
+	if !c.markdown {
+		for _, row := range rows {
+			lines = append(lines, strings.Join(row, "\t"))
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	for i, row := range rows {
+		for len(row) < columns {
+			row = append(row, "")
+		}
+		lines = append(lines, "| "+strings.Join(row, " | ")+" |")
+		if i == 0 {
+			separator := make([]string, columns)
+			for j := range separator {
+				separator[j] = "---"
+			}
+			lines = append(lines, "| "+strings.Join(separator, " | ")+" |")
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// tableRows returns the rows of a table, excluding those of nested tables
+func tableRows(table *html.Node) []*html.Node {
+	var rows []*html.Node
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type != html.ElementNode {
+				continue
+			}
+			switch child.DataAtom {
+			case atom.Tr:
+				rows = append(rows, child)
+			case atom.Thead, atom.Tbody, atom.Tfoot:
+				walk(child)
+			}
+		}
+	}
+	walk(table)
+	return rows
+}
+
+// mainContent finds the element holding a page's main article: the largest
+// <article>, else <main>, else the element whose paragraphs hold the most
+// text
+func mainContent(root *html.Node) *html.Node {
+	var best *html.Node
+	bestLength := 0
+	walk(root, func(n *html.Node) {
+		if n.DataAtom == atom.Article {
+			if length := len(strings.TrimSpace(textContent(n))); length > bestLength {
+				best, bestLength = n, length
+			}
+		}
+	})
+	if best != nil {
+		return best
+	}
+	if main := find(root, func(n *html.Node) bool {
+		return n.DataAtom == atom.Main || strings.EqualFold(attr(n, "role"), "main")
+	}); main != nil {
+		return main
+	}
+
+	// Score the parents of paragraphs by the amount of prose they hold
+	scores := make(map[*html.Node]float64)
+	walk(root, func(n *html.Node) {
+		if n.DataAtom != atom.P && n.DataAtom != atom.Pre && n.DataAtom != atom.Blockquote {
+			return
+		}
+		text := strings.TrimSpace(textContent(n))
+		if len(text) < 25 || n.Parent == nil || unlikelyContent(n.Parent) {
+			return
+		}
+		score := 1 + float64(strings.Count(text, ",")) + min(float64(len(text))/100, 3)
+		scores[n.Parent] += score
+		if grandparent := n.Parent.Parent; grandparent != nil && !unlikelyContent(grandparent) {
+			scores[grandparent] += score / 2
+		}
+	})
+	bestScore := 0.0
+	for n, score := range scores {
+		if score > bestScore || (score == bestScore && best != nil && isAncestor(n, best)) {
+			best, bestScore = n, score
+		}
+	}
+	if best != nil {
+		return best
+	}
+	if body := find(root, func(n *html.Node) bool { return n.DataAtom == atom.Body }); body != nil {
+		return body
+	}
+	return root
+}
+
+// isAncestor reports whether ancestor contains n. Ties in scoring go to the
+// outer element.
+func isAncestor(ancestor, n *html.Node) bool {
+	for p := n.Parent; p != nil; p = p.Parent {
+		if p == ancestor {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	unlikelyPattern = regexp.MustCompile(`(?i)\b(banner|breadcrumbs?|comments?|cookie|footer|menu|nav|navbar|popup|promo|related|share|sharing|sidebar|social|sponsor|subscribe|ad|ads|advert)\b`)
+	likelyPattern   = regexp.MustCompile(`(?i)\b(article|body|content|entry|main|post|story|text)\b`)
+)
+
+// unlikelyContent reports whether an element is page furniture rather than
+// part of an article
+func unlikelyContent(n *html.Node) bool {
+	switch n.DataAtom {
+	case atom.Nav, atom.Aside, atom.Footer, atom.Form:
+		return true
+	case atom.Header:
+		// Article headers hold the headline
+		return find(n, func(n *html.Node) bool { return n.DataAtom == atom.H1 }) == nil
+	case atom.Body, atom.Html, atom.Article, atom.Main:
+		return false
+	}
+	if role := strings.ToLower(attr(n, "role")); role == "navigation" || role == "complementary" || role == "contentinfo" || role == "banner" {
+		return true
+	}
+	names := strings.NewReplacer("-", " ", "_", " ").Replace(attr(n, "class") + " " + attr(n, "id"))
+	return unlikelyPattern.MatchString(names) && !likelyPattern.MatchString(names)
+}
+
+// hidden reports whether an element is hidden from readers
+func hidden(n *html.Node) bool {
+	if _, ok := attrValue(n, "hidden"); ok {
+		return true
+	}
+	if strings.EqualFold(attr(n, "aria-hidden"), "true") {
+		return true
+	}
+	style := strings.ReplaceAll(strings.ToLower(attr(n, "style")), " ", "")
+	return strings.Contains(style, "display:none") || strings.Contains(style, "visibility:hidden")
+}
+
+// codeLanguage returns the language of a <pre> block from a "language-*"
+// class on it or its <code>
+func codeLanguage(pre *html.Node) string {
+	nodes := []*html.Node{pre}
+	if code := pre.FirstChild; code != nil && code.DataAtom == atom.Code {
+		nodes = append(nodes, code)
+	}
+	for _, n := range nodes {
+		for _, class := range strings.Fields(attr(n, "class")) {
+			if lang, ok := strings.CutPrefix(class, "language-"); ok {
+				return lang
+			}
+		}
+	}
+	return ""
+}
+
+// writeText appends inline text, dropping a leading space that would
+// double up with whitespace already written
+func writeText(b *strings.Builder, text string) {
+	if text == "" {
+		return
+	}
+	if text[0] == ' ' {
+		s := b.String()
+		if s == "" || s[len(s)-1] == ' ' || s[len(s)-1] == '\n' {
+			text = text[1:]
+		}
+	}
+	b.WriteString(text)
+}
+
+// writeBlock appends a block separated from its neighbours by blank lines
+func writeBlock(b *strings.Builder, text string) {
+	if text == "" {
+		return
+	}
+	b.WriteString("\n\n")
+	b.WriteString(text)
+	b.WriteString("\n\n")
+}
+
+// indentItem prefixes the first line of text with marker and indents the
+// rest to match
+func indentItem(marker, text string) string {
+	indent := strings.Repeat(" ", len(marker))
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		switch {
+		case i == 0:
+			lines[i] = marker + line
+		case line != "":
+			lines[i] = indent + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+var spaceRun = regexp.MustCompile(`\s+`)
+
+// collapseSpace replaces each run of whitespace with a single space, as
+// browsers do outside <pre>
+func collapseSpace(s string) string {
+	return spaceRun.ReplaceAllString(s, " ")
+}
+
+func escapeBrackets(s string) string {
+	return strings.NewReplacer("[", `\[`, "]", `\]`).Replace(s)
+}
+
+func escapeURL(s string) string {
+	return strings.NewReplacer(" ", "%20", "(", "%28", ")", "%29").Replace(s)
+}
+
+// resolve resolves href against base, returning "" for empty or invalid
+// references
+func resolve(base *url.URL, href string) string {
+	href = strings.TrimSpace(href)
+	if href == "" {
+		return ""
+	}
+	ref, err := url.Parse(href)
+	if err != nil {
+		return ""
+	}
+	if base == nil {
+		return ref.String()
+	}
+	return base.ResolveReference(ref).String()
+}
+
+// textContent returns the concatenated text of n and its descendants
+func textContent(n *html.Node) string {
+	var b strings.Builder
+	walk(n, func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+		}
+	})
+	return b.String()
+}
+
+// walk calls fn for n and each of its descendants, skipping elements that
+// never contain readable content
+func walk(n *html.Node, fn func(*html.Node)) {
+	if n.Type == html.ElementNode && skippedElements[n.DataAtom] && n.DataAtom != atom.Head {
+		return
+	}
+	fn(n)
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		walk(child, fn)
+	}
+}
+
+// find returns the first element in document order matching match
+func find(n *html.Node, match func(*html.Node) bool) *html.Node {
+	var found *html.Node
+	walk(n, func(n *html.Node) {
+		if found == nil && n.Type == html.ElementNode && match(n) {
+			found = n
+		}
+	})
+	return found
+}
+
+func attrValue(n *html.Node, key string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Namespace == "" && a.Key == key {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
+func attr(n *html.Node, key string) string {
+	value, _ := attrValue(n, key)
+	return value
+}
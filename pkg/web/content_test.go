@@ -0,0 +1,188 @@
+package web
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const articlePage = `<!DOCTYPE html>
+<html>
+<head>
+  <title>  Release   Notes </title>
+  <link rel="canonical" href="/blog/release-notes">
+  <script>var tracking = true;</script>
+  <style>body { color: red; }</style>
+</head>
+<body>
+  <nav class="navbar"><a href="/">Home</a> <a href="/blog">Blog</a></nav>
+  <article>
+    <h1>Version 2.0</h1>
+    <p>This release adds <strong>streaming</strong> and <a href="/docs/jobs" title="Jobs">background jobs</a>.</p>
+    <ul>
+      <li>Faster startup</li>
+      <li>Smaller binary
+        <ol start="3"><li>nested</li></ol>
+      </li>
+    </ul>
+    <table>
+      <tr><th>Tool</th><th>Status</th></tr>
+      <tr><td>start_job</td><td>new | beta</td></tr>
+    </table>
+    <pre><code class="language-go">fmt.Println("hi")
+
+return</code></pre>
+    <div class="share-buttons">Share on social media</div>
+  </article>
+  <footer>Copyright 2026</footer>
+</body>
+</html>`
+
+func convert(t *testing.T, body, contentType string, format Format) *Document {
+	t.Helper()
+	base, _ := url.Parse("https://example.com/blog/post?id=1")
+	doc, err := Convert([]byte(body), contentType, base, format)
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	return doc
+}
+
+func TestConvert_Markdown(t *testing.T) {
+	doc := convert(t, articlePage, "text/html; charset=utf-8", FormatMarkdown)
+
+	if doc.Title != "Release Notes" {
+		t.Errorf("Expected title %q, got %q", "Release Notes", doc.Title)
+	}
+	if doc.CanonicalURL != "https://example.com/blog/release-notes" {
+		t.Errorf("Unexpected canonical URL %q", doc.CanonicalURL)
+	}
+
+	for _, want := range []string{
+		"# Version 2.0",
+		"This release adds **streaming** and [background jobs](https://example.com/docs/jobs \"Jobs\").",
+		"- Faster startup\n- Smaller binary\n  3. nested",
+		"| Tool | Status |\n| --- | --- |\n| start_job | new \\| beta |",
+		"```go\nfmt.Println(\"hi\")\n\nreturn\n```",
+		"[Home](https://example.com/)",
+		"Copyright 2026",
+	} {
+		if !strings.Contains(doc.Content, want) {
+			t.Errorf("Expected markdown to contain %q, got:\n%s", want, doc.Content)
+		}
+	}
+	for _, unwanted := range []string{"tracking", "color: red", "Release Notes"} {
+		if strings.Contains(doc.Content, unwanted) {
+			t.Errorf("Expected markdown not to contain %q, got:\n%s", unwanted, doc.Content)
+		}
+	}
+}
+
+func TestConvert_Readability(t *testing.T) {
+	doc := convert(t, articlePage, "text/html", FormatReadability)
+
+	if !strings.HasPrefix(doc.Content, "# Version 2.0") {
+		t.Errorf("Expected content to start with the article heading, got:\n%s", doc.Content)
+	}
+	if !strings.Contains(doc.Content, "| start_job |") {
+		t.Errorf("Expected the article table to be kept, got:\n%s", doc.Content)
+	}
+	for _, unwanted := range []string{"Home", "Copyright", "Share on social media"} {
+		if strings.Contains(doc.Content, unwanted) {
+			t.Errorf("Expected readability output not to contain %q, got:\n%s", unwanted, doc.Content)
+		}
+	}
+}
+
+func TestConvert_ReadabilityScoring(t *testing.T) {
+	page := `<html><body>
+<div id="menu"><p>Home, About, Contact, Products, Services, Careers</p></div>
+<div id="story">
+  <p>The first paragraph of the story, long enough to count as prose, with commas.</p>
+  <p>A second paragraph continues the story, again with enough text to be scored.</p>
+</div>
+<div class="sidebar"><p>Related links and other things, which are not the story at all.</p></div>
+</body></html>`
+	doc := convert(t, page, "text/html", FormatReadability)
+
+	if !strings.Contains(doc.Content, "first paragraph") || !strings.Contains(doc.Content, "second paragraph") {
+		t.Errorf("Expected the story paragraphs, got:\n%s", doc.Content)
+	}
+	if strings.Contains(doc.Content, "Careers") || strings.Contains(doc.Content, "Related links") {
+		t.Errorf("Expected menu and sidebar to be left out, got:\n%s", doc.Content)
+	}
+}
+
+func TestConvert_Text(t *testing.T) {
+	doc := convert(t, articlePage, "text/html", FormatText)
+
+	for _, want := range []string{"Version 2.0", "This release adds streaming and background jobs.", "start_job\tnew | beta"} {
+		if !strings.Contains(doc.Content, want) {
+			t.Errorf("Expected text to contain %q, got:\n%s", want, doc.Content)
+		}
+	}
+	for _, unwanted := range []string{"**", "](", "#", "tracking"} {
+		if strings.Contains(doc.Content, unwanted) {
+			t.Errorf("Expected text not to contain %q, got:\n%s", unwanted, doc.Content)
+		}
+	}
+}
+
+func TestConvert_Charsets(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		contentType string
+		charset     string
+	}{
+		{"header", "<html><body><p>caf\xe9</p></body></html>", "text/html; charset=ISO-8859-1", "windows-1252"},
+		{"meta tag", "<html><head><meta charset=\"iso-8859-1\"></head><body><p>caf\xe9</p></body></html>", "text/html", "windows-1252"},
+		{"utf-8", "<html><body><p>café</p></body></html>", "text/html; charset=utf-8", "utf-8"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := convert(t, tt.body, tt.contentType, FormatText)
+			if doc.Content != "café" {
+				t.Errorf("Expected %q, got %q", "café", doc.Content)
+			}
+			if doc.Charset != tt.charset {
+				t.Errorf("Expected charset %q, got %q", tt.charset, doc.Charset)
+			}
+		})
+	}
+
+	doc := convert(t, "caf\xe9", "text/plain; charset=iso-8859-1", FormatRaw)
+	if doc.Content != "café" {
+		t.Errorf("Expected raw text to be decoded, got %q", doc.Content)
+	}
+}
+
+func TestConvert_NonHTML(t *testing.T) {
+	json := `{"name": "<b>not html</b>"}`
+	doc := convert(t, json, "application/json", FormatMarkdown)
+	if doc.Content != json {
+		t.Errorf("Expected JSON to be returned unchanged, got %q", doc.Content)
+	}
+	if doc.Title != "" || doc.CanonicalURL != "" {
+		t.Errorf("Expected no title or canonical URL for JSON, got %q and %q", doc.Title, doc.CanonicalURL)
+	}
+
+	binary := "\x89PNG\r\n\x1a\n\xff"
+	doc = convert(t, binary, "image/png", FormatText)
+	if doc.Content != binary || doc.Charset != "" {
+		t.Errorf("Expected binary body to be returned unchanged")
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	if f, err := ParseFormat(""); err != nil || f != FormatRaw {
+		t.Errorf("Expected empty format to mean raw, got %q, %v", f, err)
+	}
+	if f, err := ParseFormat("Markdown"); err != nil || f != FormatMarkdown {
+		t.Errorf("Expected Markdown to parse, got %q, %v", f, err)
+	}
+	if _, err := ParseFormat("pdf"); err == nil {
+		t.Error("Expected unknown format to be rejected")
+	}
+}
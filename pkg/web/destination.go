@@ -0,0 +1,328 @@
+// Package web implements the HTTP side of the web tools: the destination
+// policy that keeps requests away from internal services, and conversion of
+// fetched pages to text and Markdown.
+package web
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// DestinationPolicy restricts the URLs the web tools may request. Host and
+// scheme rules are checked for the requested URL and every redirect; address
+// rules are checked for each connection as it is dialled, after DNS
+// resolution, so that a hostname cannot be pointed at a blocked address.
+//
+// Host patterns are a host name such as "example.com", which matches only
+// that host, or "*.example.com", which matches its subdomains. IP literals
+// in URLs are matched as host names too.
+type DestinationPolicy struct {
+	// AllowHosts, if not empty, limits requests to matching hosts
+	AllowHosts []string `yaml:"allow_hosts" json:"allow_hosts,omitempty"`
+	// DenyHosts rejects matching hosts, even if AllowHosts matches them
+	DenyHosts []string `yaml:"deny_hosts" json:"deny_hosts,omitempty"`
+	// AllowPrivate permits connections to loopback, private, link-local and
+	// other internal addresses, which are blocked by default
+	AllowPrivate bool `yaml:"allow_private" json:"allow_private,omitempty"`
+	// AllowCIDRs exempts networks from the default internal address block,
+	// e.g. a single intranet subnet
+	AllowCIDRs []string `yaml:"allow_cidrs" json:"allow_cidrs,omitempty"`
+	// DenyCIDRs blocks further networks. It takes precedence over AllowCIDRs
+	// and AllowPrivate.
+	DenyCIDRs []string `yaml:"deny_cidrs" json:"deny_cidrs,omitempty"`
+	// Schemes lists the permitted URL schemes. Empty means http and https.
+	Schemes []string `yaml:"schemes" json:"schemes,omitempty"`
+	// Ports lists the permitted ports. Empty means any port.
+	Ports []int `yaml:"ports" json:"ports,omitempty"`
+
+	allowNets []*net.IPNet
+	denyNets  []*net.IPNet
+}
+
+// blockedNetwork is a range of addresses blocked unless AllowPrivate is set
+type blockedNetwork struct {
+	network *net.IPNet
+	name    string
+}
+
+// internalNetworks are the address ranges that reach the host itself, the
+// local network or cloud infrastructure rather than the public internet
+var internalNetworks = mustParseNetworks([][2]string{
+	{"0.0.0.0/8", "unspecified"},
+	{"10.0.0.0/8", "private"},
+	{"100.64.0.0/10", "carrier-grade NAT"},
+	{"127.0.0.0/8", "loopback"},
+	{"169.254.0.0/16", "link-local, cloud metadata"},
+	{"172.16.0.0/12", "private"},
+	{"192.0.0.0/24", "IETF protocol assignments"},
+	{"192.168.0.0/16", "private"},
+	{"198.18.0.0/15", "benchmarking"},
+	{"224.0.0.0/4", "multicast"},
+	{"240.0.0.0/4", "reserved"},
+	{"::/128", "unspecified"},
+	{"::1/128", "loopback"},
+	{"64:ff9b::/96", "NAT64"},
+	{"fc00::/7", "unique local, cloud metadata"},
+	{"fe80::/10", "link-local"},
+	{"ff00::/8", "multicast"},
+})
+
+func mustParseNetworks(specs [][2]string) []blockedNetwork {
+	networks := make([]blockedNetwork, 0, len(specs))
+	for _, spec := range specs {
+		_, network, err := net.ParseCIDR(spec[0])
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, blockedNetwork{network: network, name: spec[1]})
+	}
+	return networks
+}
+
+// DestinationError reports a request to a destination the policy does not
+// allow
+type DestinationError struct {
+	// Destination is the URL or address that was rejected
+	Destination string
+	// Reason explains which rule rejected it
+	Reason string
+}
+
+func (e *DestinationError) Error() string {
+	return fmt.Sprintf("destination not allowed: %s: %s", e.Destination, e.Reason)
+}
+
+// Compile validates the policy and prepares it for use. It must be called
+// before the policy is used, and again after it is modified.
+func (p *DestinationPolicy) Compile() error {
+	var errs []string
+
+	p.allowNets, p.denyNets = nil, nil
+	for i, cidr := range p.AllowCIDRs {
+		network, err := parseNetwork(cidr)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("allow_cidrs[%d]: %v", i, err))
+			continue
+		}
+		p.allowNets = append(p.allowNets, network)
+	}
+	for i, cidr := range p.DenyCIDRs {
+		network, err := parseNetwork(cidr)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("deny_cidrs[%d]: %v", i, err))
+			continue
+		}
+		p.denyNets = append(p.denyNets, network)
+	}
+
+	for i, host := range p.AllowHosts {
+		if !validHostPattern(host) {
+			errs = append(errs, fmt.Sprintf("allow_hosts[%d]: invalid host pattern %q", i, host))
+		}
+	}
+	for i, host := range p.DenyHosts {
+		if !validHostPattern(host) {
+			errs = append(errs, fmt.Sprintf("deny_hosts[%d]: invalid host pattern %q", i, host))
+		}
+	}
+	for i, scheme := range p.Schemes {
+		if scheme != "http" && scheme != "https" {
+			errs = append(errs, fmt.Sprintf("schemes[%d]: must be http or https, got %q", i, scheme))
+		}
+	}
+	for i, port := range p.Ports {
+		if port < 1 || port > 65535 {
+			errs = append(errs, fmt.Sprintf("ports[%d]: invalid port %d", i, port))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// parseNetwork parses a CIDR or a single address
+func parseNetwork(cidr string) (*net.IPNet, error) {
+	if !strings.Contains(cidr, "/") {
+		ip := net.ParseIP(cidr)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid address %q", cidr)
+		}
+		bits := 128
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid network %q", cidr)
+	}
+	return network, nil
+}
+
+func validHostPattern(pattern string) bool {
+	host := strings.TrimPrefix(pattern, "*.")
+	return host != "" && !strings.ContainsAny(host, "*/: ")
+}
+
+// CheckURL checks a URL's scheme, host and port. Addresses are checked when
+// connecting; see CheckAddress.
+func (p *DestinationPolicy) CheckURL(u *url.URL) error {
+	deny := func(reason string) error {
+		return &DestinationError{Destination: u.Redacted(), Reason: reason}
+	}
+
+	scheme := strings.ToLower(u.Scheme)
+	schemes := p.Schemes
+	if len(schemes) == 0 {
+		schemes = []string{"http", "https"}
+	}
+	if !contains(schemes, scheme) {
+		return deny(fmt.Sprintf("scheme %q is not allowed", u.Scheme))
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "" {
+		return deny("URL has no host")
+	}
+	if pattern, ok := matchHost(p.DenyHosts, host); ok {
+		return deny(fmt.Sprintf("host %s matches denied host %q", host, pattern))
+	}
+	if len(p.AllowHosts) > 0 {
+		if _, ok := matchHost(p.AllowHosts, host); !ok {
+			return deny(fmt.Sprintf("host %s is not in the allowed hosts", host))
+		}
+	}
+
+	if len(p.Ports) > 0 {
+		port := urlPort(u)
+		allowed := false
+		for _, permitted := range p.Ports {
+			if permitted == port {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return deny(fmt.Sprintf("port %d is not allowed", port))
+		}
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		return p.CheckAddress(ip)
+	}
+	return nil
+}
+
+// CheckAddress checks an IP address that a request is about to connect to
+func (p *DestinationPolicy) CheckAddress(ip net.IP) error {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	deny := func(reason string) error {
+		return &DestinationError{Destination: ip.String(), Reason: reason}
+	}
+
+	for _, network := range p.denyNets {
+		if network.Contains(ip) {
+			return deny(fmt.Sprintf("address is in denied network %s", network))
+		}
+	}
+	if p.AllowPrivate {
+		return nil
+	}
+	for _, network := range p.allowNets {
+		if network.Contains(ip) {
+			return nil
+		}
+	}
+	for _, blocked := range internalNetworks {
+		if blocked.network.Contains(ip) {
+			return deny(fmt.Sprintf("address is in blocked network %s (%s)", blocked.network, blocked.name))
+		}
+	}
+	return nil
+}
+
+// NewClient returns an HTTP client that enforces the policy on the request,
+// every redirect and every connection. Proxies are not used, as they would
+// connect on the client's behalf.
+func (p *DestinationPolicy) NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   p.controlDial,
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			return p.CheckURL(req.URL)
+		},
+	}
+}
+
+// controlDial checks the resolved address of each connection before it is
+// made
+func (p *DestinationPolicy) controlDial(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return &DestinationError{Destination: address, Reason: "not an IP address"}
+	}
+	return p.CheckAddress(ip)
+}
+
+// matchHost returns the first pattern matching host
+func matchHost(patterns []string, host string) (string, bool) {
+	for _, pattern := range patterns {
+		pattern = strings.TrimSuffix(strings.ToLower(pattern), ".")
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return pattern, true
+			}
+		} else if host == pattern {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+// urlPort returns the URL's port, or the default port for its scheme
+func urlPort(u *url.URL) int {
+	if port, err := strconv.Atoi(u.Port()); err == nil {
+		return port
+	}
+	if strings.EqualFold(u.Scheme, "https") {
+		return 443
+	}
+	return 80
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
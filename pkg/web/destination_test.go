@@ -0,0 +1,191 @@
+package web
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func compiled(t *testing.T, p *DestinationPolicy) *DestinationPolicy {
+	t.Helper()
+	if err := p.Compile(); err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	return p
+}
+
+func TestCheckURL_DefaultPolicy(t *testing.T) {
+	policy := compiled(t, &DestinationPolicy{})
+
+	tests := []struct {
+		url     string
+		allowed bool
+	}{
+		{"https://example.com/page", true},
+		{"http://93.184.216.34/", true},
+		{"http://169.254.169.254/latest/meta-data/", false},
+		{"http://169.254.170.2/v2/credentials", false},
+		{"http://127.0.0.1:8080/admin", false},
+		{"http://10.0.0.5/", false},
+		{"http://172.20.1.1/", false},
+		{"http://192.168.1.1/", false},
+		{"http://[::1]/", false},
+		{"http://[::ffff:127.0.0.1]/", false},
+		{"http://[fd00:ec2::254]/", false},
+		{"http://0.0.0.0/", false},
+		{"ftp://example.com/file", false},
+		{"file:///etc/passwd", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = policy.CheckURL(u)
+			if tt.allowed && err != nil {
+				t.Errorf("Expected %s to be allowed, got %v", tt.url, err)
+			}
+			if !tt.allowed {
+				var destErr *DestinationError
+				if !errors.As(err, &destErr) {
+					t.Errorf("Expected %s to be denied with a DestinationError, got %v", tt.url, err)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckURL_HostLists(t *testing.T) {
+	policy := compiled(t, &DestinationPolicy{
+		AllowHosts: []string{"example.com", "*.example.org"},
+		DenyHosts:  []string{"secret.example.org"},
+		Ports:      []int{443, 8443},
+	})
+
+	tests := []struct {
+		url     string
+		allowed bool
+	}{
+		{"https://example.com/", true},
+		{"https://EXAMPLE.com./", true},
+		{"https://www.example.com/", false},
+		{"https://docs.example.org/", true},
+		{"https://example.org/", false},
+		{"https://secret.example.org/", false},
+		{"https://example.com:8443/", true},
+		{"http://example.com/", false},
+		{"https://other.net/", false},
+	}
+
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.url)
+		err := policy.CheckURL(u)
+		if (err == nil) != tt.allowed {
+			t.Errorf("CheckURL(%s) = %v, expected allowed=%v", tt.url, err, tt.allowed)
+		}
+	}
+}
+
+func TestCheckAddress_CIDRs(t *testing.T) {
+	policy := compiled(t, &DestinationPolicy{
+		AllowCIDRs: []string{"10.1.0.0/16"},
+		DenyCIDRs:  []string{"203.0.113.0/24", "10.1.2.3"},
+	})
+
+	tests := []struct {
+		ip      string
+		allowed bool
+	}{
+		{"10.1.5.5", true},
+		{"10.1.2.3", false},
+		{"10.2.0.1", false},
+		{"203.0.113.9", false},
+		{"8.8.8.8", true},
+	}
+	for _, tt := range tests {
+		err := policy.CheckAddress(net.ParseIP(tt.ip))
+		if (err == nil) != tt.allowed {
+			t.Errorf("CheckAddress(%s) = %v, expected allowed=%v", tt.ip, err, tt.allowed)
+		}
+	}
+
+	private := compiled(t, &DestinationPolicy{AllowPrivate: true, DenyCIDRs: []string{"169.254.169.254/32"}})
+	if err := private.CheckAddress(net.ParseIP("127.0.0.1")); err != nil {
+		t.Errorf("Expected loopback to be allowed with AllowPrivate, got %v", err)
+	}
+	if err := private.CheckAddress(net.ParseIP("169.254.169.254")); err == nil {
+		t.Error("Expected denied network to take precedence over AllowPrivate")
+	}
+}
+
+func TestCompile_Errors(t *testing.T) {
+	policy := &DestinationPolicy{
+		AllowCIDRs: []string{"10.0.0.0/33"},
+		DenyCIDRs:  []string{"nonsense"},
+		AllowHosts: []string{"http://example.com"},
+		Schemes:    []string{"ftp"},
+		Ports:      []int{0},
+	}
+	err := policy.Compile()
+	if err == nil {
+		t.Fatal("Expected Compile to fail")
+	}
+	for _, field := range []string{"allow_cidrs[0]", "deny_cidrs[0]", "allow_hosts[0]", "schemes[0]", "ports[0]"} {
+		if !strings.Contains(err.Error(), field) {
+			t.Errorf("Expected error to mention %s, got %v", field, err)
+		}
+	}
+}
+
+func TestNewClient_BlocksAtDialTime(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "internal")
+	}))
+	defer server.Close()
+
+	// "localhost" passes the URL check but resolves to a blocked address
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	target := "http://localhost:" + port + "/"
+
+	client := compiled(t, &DestinationPolicy{}).NewClient(5 * time.Second)
+	_, err := client.Get(target)
+	var destErr *DestinationError
+	if !errors.As(err, &destErr) {
+		t.Fatalf("Expected a DestinationError, got %v", err)
+	}
+	if !strings.Contains(destErr.Reason, "loopback") {
+		t.Errorf("Expected the reason to name the loopback network, got %q", destErr.Reason)
+	}
+
+	client = compiled(t, &DestinationPolicy{AllowPrivate: true}).NewClient(5 * time.Second)
+	resp, err := client.Get(target)
+	if err != nil {
+		t.Fatalf("Expected request to succeed with AllowPrivate, got %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestNewClient_ChecksRedirects(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://metadata.internal.test/latest/", http.StatusFound)
+	}))
+	defer server.Close()
+
+	policy := compiled(t, &DestinationPolicy{AllowPrivate: true, DenyHosts: []string{"*.internal.test"}})
+	_, err := policy.NewClient(5 * time.Second).Get(server.URL)
+	var destErr *DestinationError
+	if !errors.As(err, &destErr) {
+		t.Fatalf("Expected the redirect to be denied, got %v", err)
+	}
+	if !strings.Contains(destErr.Reason, "denied host") {
+		t.Errorf("Unexpected reason: %q", destErr.Reason)
+	}
+}
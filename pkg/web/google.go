@@ -0,0 +1,181 @@
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// SearchResult is one organic result of a web search
+type SearchResult struct {
+	// Title is the result's headline
+	Title string `json:"title"`
+	// URL is the address of the result, with search engine redirects removed
+	URL string `json:"url"`
+	// Snippet is the summary shown under the title, if any
+	Snippet string `json:"snippet,omitempty"`
+	// Position is the 1-based rank of the result across all pages
+	Position int `json:"position"`
+}
+
+// googleSnippetClasses mark the snippet of a result in the layouts Google
+// serves to browsers and to basic HTML clients
+var googleSnippetClasses = []string{"VwiC3b", "IsZvec", "aCOpRe", "s3v9rd", "st"}
+
+// ParseGoogleResults extracts the organic results from a Google results
+// page. Each result is an anchor holding an <h3> headline; ads, image
+// carousels and links back to Google are left out. start is the offset of
+// the page, so the first result is at position start+1.
+func ParseGoogleResults(body []byte, start int) ([]SearchResult, error) {
+	root, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse results page: %w", err)
+	}
+
+	var anchors []*html.Node
+	walk(root, func(n *html.Node) {
+		if n.Type == html.ElementNode && n.DataAtom == atom.A && !insideAd(n) &&
+			find(n, func(n *html.Node) bool { return n.DataAtom == atom.H3 }) != nil {
+			anchors = append(anchors, n)
+		}
+	})
+
+	results := []SearchResult{}
+	seen := make(map[string]bool)
+	for _, a := range anchors {
+		target := UnwrapRedirect(attr(a, "href"))
+		if target == "" || seen[target] {
+			continue
+		}
+		headline := find(a, func(n *html.Node) bool { return n.DataAtom == atom.H3 })
+		title := strings.Join(strings.Fields(textContent(headline)), " ")
+		if title == "" {
+			continue
+		}
+		seen[target] = true
+		results = append(results, SearchResult{
+			Title:    title,
+			URL:      target,
+			Snippet:  googleSnippet(resultContainer(a, anchors), a),
+			Position: start + len(results) + 1,
+		})
+	}
+	return results, nil
+}
+
+// UnwrapRedirect returns the destination of a search result link, following
+// Google's /url?q= click-tracking redirect. It returns "" for links that stay
+// on Google, such as further searches, or that are not http or https.
+func UnwrapRedirect(href string) string {
+	u, err := url.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return ""
+	}
+	if (u.Host == "" || isGoogleHost(u.Hostname())) && u.Path == "/url" {
+		query := u.Query()
+		target := query.Get("q")
+		if target == "" {
+			target = query.Get("url")
+		}
+		if u, err = url.Parse(target); err != nil {
+			return ""
+		}
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || isGoogleHost(u.Hostname()) {
+		return ""
+	}
+	return u.String()
+}
+
+// googleSites are the subdomains of Google's own pages, which are never
+// organic results. Other subdomains, such as developers.google.com, can be.
+var googleSites = map[string]bool{
+	"": true, "www": true, "webcache": true, "translate": true, "maps": true,
+	"accounts": true, "support": true, "policies": true, "images": true,
+}
+
+// isGoogleHost reports whether host is one of Google's search pages, such as
+// www.google.com or google.co.uk
+func isGoogleHost(host string) bool {
+	labels := strings.Split(strings.ToLower(host), ".")
+	for i, label := range labels {
+		if label != "google" {
+			continue
+		}
+		tld := labels[i+1:]
+		validTLD := len(tld) == 1 || len(tld) == 2 && (tld[0] == "co" || tld[0] == "com")
+		return validTLD && googleSites[strings.Join(labels[:i], ".")]
+	}
+	return false
+}
+
+// insideAd reports whether a result is a sponsored listing
+func insideAd(n *html.Node) bool {
+	for p := n; p != nil; p = p.Parent {
+		if p.Type != html.ElementNode {
+			continue
+		}
+		id := attr(p, "id")
+		if id == "tads" || id == "bottomads" || id == "tadsb" || attr(p, "data-text-ad") != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// resultContainer returns the outermost ancestor of a result anchor that
+// holds no other result
+func resultContainer(a *html.Node, anchors []*html.Node) *html.Node {
+	container := a
+	for p := a.Parent; p != nil && p.Type == html.ElementNode && p.DataAtom != atom.Body; p = p.Parent {
+		for _, other := range anchors {
+			if other != a && isAncestor(p, other) {
+				return container
+			}
+		}
+		container = p
+	}
+	return container
+}
+
+// googleSnippet returns the snippet of the result in container: the text of
+// a known snippet element or, failing that, the container's text outside the
+// result link and its displayed URL
+func googleSnippet(container, a *html.Node) string {
+	snippet := find(container, func(n *html.Node) bool {
+		if isAncestor(a, n) {
+			return false
+		}
+		classes := strings.Fields(attr(n, "class"))
+		for _, class := range googleSnippetClasses {
+			if contains(classes, class) {
+				return true
+			}
+		}
+		return attr(n, "data-sncf") != ""
+	})
+	if snippet != nil {
+		return strings.Join(strings.Fields(textContent(snippet)), " ")
+	}
+
+	var b strings.Builder
+	var collect func(*html.Node)
+	collect = func(n *html.Node) {
+		switch {
+		case n == a, n.DataAtom == atom.Cite, n.Type == html.ElementNode && skippedElements[n.DataAtom]:
+			return
+		case n.Type == html.TextNode:
+			b.WriteString(n.Data)
+			b.WriteString(" ")
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			collect(child)
+		}
+	}
+	collect(container)
+	return strings.Join(strings.Fields(b.String()), " ")
+}
@@ -0,0 +1,110 @@
+package web
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func parseFixture(t *testing.T, name string, start int) []SearchResult {
+	t.Helper()
+	body, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := ParseGoogleResults(body, start)
+	if err != nil {
+		t.Fatalf("ParseGoogleResults failed: %v", err)
+	}
+	return results
+}
+
+func TestParseGoogleResults_Basic(t *testing.T) {
+	results := parseFixture(t, "google_basic.html", 0)
+
+	expected := []SearchResult{
+		{
+			Title:    "mark3labs/mcp-go: A Go implementation of the Model Context ...",
+			URL:      "https://github.com/mark3labs/mcp-go",
+			Snippet:  "A Go implementation of the Model Context Protocol (MCP), enabling seamless integration between LLM applications and external data sources and tools.",
+			Position: 1,
+		},
+		{
+			Title:    "mcp package - github.com/modelcontextprotocol/go-sdk/mcp",
+			URL:      "https://pkg.go.dev/github.com/modelcontextprotocol/go-sdk/mcp?tab=doc",
+			Snippet:  "Mar 4, 2026 · Package mcp provides an SDK for writing model context protocol clients and servers.",
+			Position: 2,
+		},
+		{
+			Title:    "Building MCP servers | Google for Developers",
+			URL:      "https://developers.google.com/community/mcp",
+			Snippet:  "Learn how to build MCP servers that connect agents to your APIs.",
+			Position: 3,
+		},
+	}
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("Unexpected results:\n got: %+v\nwant: %+v", results, expected)
+	}
+}
+
+func TestParseGoogleResults_Browser(t *testing.T) {
+	results := parseFixture(t, "google_browser.html", 10)
+
+	expected := []SearchResult{
+		{
+			Title:    "Kubernetes Overview | Example Docs",
+			URL:      "https://docs.example.org/kubernetes/overview",
+			Snippet:  "Kubernetes is a portable, extensible, open source platform for managing containerized workloads and services.",
+			Position: 11,
+		},
+		{
+			Title:    "Kubernetes v1.36: What's New & Changed",
+			URL:      "https://blog.example.org/2026/03/kubernetes-1-36?utm_source=feed",
+			Snippet:  "Mar 12, 2026 — The release brings in-place pod resize to GA and 40 other enhancements.",
+			Position: 12,
+		},
+		{
+			Title:    "Kubernetes FAQ",
+			URL:      "https://example.org/kubernetes/faq",
+			Snippet:  "Answers to common questions about running clusters.",
+			Position: 13,
+		},
+	}
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("Unexpected results:\n got: %+v\nwant: %+v", results, expected)
+	}
+}
+
+func TestParseGoogleResults_NoResults(t *testing.T) {
+	results, err := ParseGoogleResults([]byte(`<html><body><p>Our systems have detected unusual traffic.</p></body></html>`), 0)
+	if err != nil {
+		t.Fatalf("ParseGoogleResults failed: %v", err)
+	}
+	if results == nil || len(results) != 0 {
+		t.Errorf("Expected an empty, non-nil result list, got %#v", results)
+	}
+}
+
+func TestUnwrapRedirect(t *testing.T) {
+	tests := []struct {
+		href     string
+		expected string
+	}{
+		{"/url?q=https://example.com/page%3Fa%3D1&sa=U&ved=x", "https://example.com/page?a=1"},
+		{"https://www.google.com/url?url=https://example.com/&rct=j", "https://example.com/"},
+		{"https://www.google.co.uk/url?q=http://example.org/", "http://example.org/"},
+		{"https://example.com/direct", "https://example.com/direct"},
+		{"https://developers.google.com/search", "https://developers.google.com/search"},
+		{"/search?q=more", ""},
+		{"https://www.google.com/search?q=images", ""},
+		{"/url?q=javascript:alert(1)", ""},
+		{"/aclk?sa=l&adurl=https://ads.example.net/", ""},
+	}
+
+	for _, tt := range tests {
+		if got := UnwrapRedirect(tt.href); got != tt.expected {
+			t.Errorf("UnwrapRedirect(%q) = %q, expected %q", tt.href, got, tt.expected)
+		}
+	}
+}
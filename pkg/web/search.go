@@ -0,0 +1,459 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// SearchRequest is a query to a SearchProvider
+type SearchRequest struct {
+	// Query is the search terms
+	Query string
+	// Count is the number of results wanted. Providers return at most their
+	// own page size.
+	Count int
+	// Start is the 0-based offset of the first result
+	Start int
+	// Language is a language code such as "en"
+	Language string
+	// SafeSearch is "off", "moderate" or "strict"
+	SafeSearch string
+}
+
+// SearchResponse is the answer of a SearchProvider
+type SearchResponse struct {
+	// Results are the results in rank order, positioned from Start+1. They
+	// are empty if the provider returned an error status.
+	Results []SearchResult
+	// URL is the URL that was requested, with credentials redacted
+	URL string
+	// StatusCode is the HTTP status of the provider's response
+	StatusCode int
+	// Body is the provider's raw response
+	Body []byte
+	// CacheStatus is the response's CacheStatusHeader, empty if the client
+	// does not use a Cache
+	CacheStatus string
+}
+
+// SearchProvider runs web searches against a search engine or API and
+// normalises its results
+type SearchProvider interface {
+	// Name identifies the provider, e.g. "searxng"
+	Name() string
+	// Search runs a query using client. A response with an error status is
+	// returned without error so that callers can report it.
+	Search(ctx context.Context, client *http.Client, req SearchRequest) (*SearchResponse, error)
+}
+
+// SearchConfig selects and configures a SearchProvider
+type SearchConfig struct {
+	// Provider is "google" (scraping the results page), "google-cse",
+	// "searxng", "brave" or "bing". Empty means "google".
+	Provider string
+	// Endpoint overrides the provider's API URL. It is required for
+	// "searxng", as the base URL of the instance.
+	Endpoint string
+	// APIKey authenticates with "google-cse", "brave" and "bing"
+	APIKey string
+	// EngineID is the Programmable Search Engine ID ("cx") for "google-cse"
+	EngineID string
+}
+
+// SearchProviders lists the supported provider names
+var SearchProviders = []string{"google", "google-cse", "searxng", "brave", "bing"}
+
+// maxSearchResponseSize bounds the provider response read into memory
+const maxSearchResponseSize = 2 * 1024 * 1024
+
+// NewSearchProvider creates the provider selected by config
+func NewSearchProvider(config SearchConfig) (SearchProvider, error) {
+	endpoint := func(defaultURL string) (*url.URL, error) {
+		raw := config.Endpoint
+		if raw == "" {
+			raw = defaultURL
+		}
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid search endpoint %q", raw)
+		}
+		return u, nil
+	}
+	requireKey := func() error {
+		if config.APIKey == "" {
+			return fmt.Errorf("search provider %s requires an API key", config.Provider)
+		}
+		return nil
+	}
+
+	switch config.Provider {
+	case "", "google":
+		u, err := endpoint("https://www.google.com/search")
+		if err != nil {
+			return nil, err
+		}
+		return &GoogleScraper{Endpoint: u}, nil
+	case "google-cse":
+		if err := requireKey(); err != nil {
+			return nil, err
+		}
+		if config.EngineID == "" {
+			return nil, fmt.Errorf("search provider google-cse requires a search engine ID")
+		}
+		u, err := endpoint("https://www.googleapis.com/customsearch/v1")
+		if err != nil {
+			return nil, err
+		}
+		return &GoogleCustomSearch{Endpoint: u, APIKey: config.APIKey, EngineID: config.EngineID}, nil
+	case "searxng":
+		if config.Endpoint == "" {
+			return nil, fmt.Errorf("search provider searxng requires the URL of the instance")
+		}
+		u, err := endpoint("")
+		if err != nil {
+			return nil, err
+		}
+		return &SearXNG{Endpoint: u, APIKey: config.APIKey}, nil
+	case "brave":
+		if err := requireKey(); err != nil {
+			return nil, err
+		}
+		u, err := endpoint("https://api.search.brave.com/res/v1/web/search")
+		if err != nil {
+			return nil, err
+		}
+		return &BraveSearch{Endpoint: u, APIKey: config.APIKey}, nil
+	case "bing":
+		if err := requireKey(); err != nil {
+			return nil, err
+		}
+		u, err := endpoint("https://api.bing.microsoft.com/v7.0/search")
+		if err != nil {
+			return nil, err
+		}
+		return &BingSearch{Endpoint: u, APIKey: config.APIKey}, nil
+	}
+	return nil, fmt.Errorf("unknown search provider %q (expected one of: %s)", config.Provider, strings.Join(SearchProviders, ", "))
+}
+
+// GoogleScraper searches by fetching and parsing Google's results page
+type GoogleScraper struct {
+	Endpoint *url.URL
+}
+
+func (g *GoogleScraper) Name() string { return "google" }
+
+func (g *GoogleScraper) Search(ctx context.Context, client *http.Client, req SearchRequest) (*SearchResponse, error) {
+	query := url.Values{}
+	query.Set("q", req.Query)
+	query.Set("num", strconv.Itoa(req.Count))
+	query.Set("hl", req.Language)
+	query.Set("safe", req.SafeSearch)
+	if req.Start > 0 {
+		query.Set("start", strconv.Itoa(req.Start))
+	}
+
+	// Google serves an error page to obvious bots, so appear as a browser
+	headers := http.Header{}
+	headers.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36")
+	headers.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
+	headers.Set("Accept-Language", req.Language+",en;q=0.5")
+
+	resp, err := fetchSearch(ctx, client, g.Endpoint, query, headers)
+	if err != nil || !resp.ok() {
+		return resp, err
+	}
+	resp.Results, err = ParseGoogleResults(resp.Body, req.Start)
+	return resp, err
+}
+
+// GoogleCustomSearch uses the Google Custom Search JSON API, which returns at
+// most 10 results per request
+type GoogleCustomSearch struct {
+	Endpoint *url.URL
+	APIKey   string
+	EngineID string
+}
+
+func (g *GoogleCustomSearch) Name() string { return "google-cse" }
+
+func (g *GoogleCustomSearch) Search(ctx context.Context, client *http.Client, req SearchRequest) (*SearchResponse, error) {
+	query := url.Values{}
+	query.Set("key", g.APIKey)
+	query.Set("cx", g.EngineID)
+	query.Set("q", req.Query)
+	query.Set("num", strconv.Itoa(min(req.Count, 10)))
+	query.Set("start", strconv.Itoa(req.Start+1))
+	if req.Language != "" {
+		query.Set("hl", req.Language)
+	}
+	if req.SafeSearch == "off" {
+		query.Set("safe", "off")
+	} else {
+		query.Set("safe", "active")
+	}
+
+	resp, err := fetchSearch(ctx, client, g.Endpoint, query, apiHeaders())
+	if err != nil || !resp.ok() {
+		return resp, err
+	}
+	var data struct {
+		Items []struct {
+			Title   string `json:"title"`
+			Link    string `json:"link"`
+			Snippet string `json:"snippet"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(resp.Body, &data); err != nil {
+		return resp, fmt.Errorf("invalid google-cse response: %w", err)
+	}
+	for _, item := range data.Items {
+		resp.add(req, item.Title, item.Link, item.Snippet)
+	}
+	return resp, nil
+}
+
+// SearXNG queries a SearXNG instance through its JSON API, which must be
+// enabled in the instance's settings ("formats: [html, json]"). SearXNG pages
+// are a fixed size, so the page holding Start is requested and the results
+// before Start are dropped.
+type SearXNG struct {
+	// Endpoint is the base URL of the instance
+	Endpoint *url.URL
+	// APIKey, if set, is sent as a bearer token for instances behind an
+	// authenticating proxy
+	APIKey string
+}
+
+// searxngPageSize is SearXNG's default number of results per page
+const searxngPageSize = 10
+
+func (s *SearXNG) Name() string { return "searxng" }
+
+func (s *SearXNG) Search(ctx context.Context, client *http.Client, req SearchRequest) (*SearchResponse, error) {
+	endpoint := s.Endpoint.JoinPath("search")
+	query := url.Values{}
+	query.Set("q", req.Query)
+	query.Set("format", "json")
+	query.Set("pageno", strconv.Itoa(req.Start/searxngPageSize+1))
+	if req.Language != "" {
+		query.Set("language", req.Language)
+	}
+	switch req.SafeSearch {
+	case "off":
+		query.Set("safesearch", "0")
+	case "strict":
+		query.Set("safesearch", "2")
+	default:
+		query.Set("safesearch", "1")
+	}
+
+	headers := apiHeaders()
+	if s.APIKey != "" {
+		headers.Set("Authorization", "Bearer "+s.APIKey)
+	}
+	resp, err := fetchSearch(ctx, client, endpoint, query, headers)
+	if err != nil || !resp.ok() {
+		return resp, err
+	}
+	var data struct {
+		Results []struct {
+			Title   string `json:"title"`
+			URL     string `json:"url"`
+			Content string `json:"content"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(resp.Body, &data); err != nil {
+		return resp, fmt.Errorf("invalid searxng response: %w", err)
+	}
+
+	// Skip the results on the page before Start
+	for i, item := range data.Results {
+		if i < req.Start%searxngPageSize {
+			continue
+		}
+		if len(resp.Results) == req.Count {
+			break
+		}
+		resp.add(req, item.Title, item.URL, item.Content)
+	}
+	return resp, nil
+}
+
+// BraveSearch uses the Brave Search web search API, which returns at most 20
+// results per request and pages in multiples of the count
+type BraveSearch struct {
+	Endpoint *url.URL
+	APIKey   string
+}
+
+func (b *BraveSearch) Name() string { return "brave" }
+
+func (b *BraveSearch) Search(ctx context.Context, client *http.Client, req SearchRequest) (*SearchResponse, error) {
+	count := min(req.Count, 20)
+	query := url.Values{}
+	query.Set("q", req.Query)
+	query.Set("count", strconv.Itoa(count))
+	query.Set("offset", strconv.Itoa(req.Start/count))
+	if req.Language != "" {
+		query.Set("search_lang", req.Language)
+	}
+	if req.SafeSearch != "" {
+		query.Set("safesearch", req.SafeSearch)
+	}
+
+	headers := apiHeaders()
+	headers.Set("X-Subscription-Token", b.APIKey)
+	resp, err := fetchSearch(ctx, client, b.Endpoint, query, headers)
+	if err != nil || !resp.ok() {
+		return resp, err
+	}
+	var data struct {
+		Web struct {
+			Results []struct {
+				Title       string `json:"title"`
+				URL         string `json:"url"`
+				Description string `json:"description"`
+			} `json:"results"`
+		} `json:"web"`
+	}
+	if err := json.Unmarshal(resp.Body, &data); err != nil {
+		return resp, fmt.Errorf("invalid brave response: %w", err)
+	}
+
+	for i, item := range data.Web.Results {
+		if i < req.Start%count {
+			continue
+		}
+		resp.add(req, item.Title, item.URL, item.Description)
+	}
+	return resp, nil
+}
+
+// BingSearch uses the Bing Web Search API, which returns at most 50 results
+// per request
+type BingSearch struct {
+	Endpoint *url.URL
+	APIKey   string
+}
+
+func (b *BingSearch) Name() string { return "bing" }
+
+func (b *BingSearch) Search(ctx context.Context, client *http.Client, req SearchRequest) (*SearchResponse, error) {
+	query := url.Values{}
+	query.Set("q", req.Query)
+	query.Set("count", strconv.Itoa(min(req.Count, 50)))
+	query.Set("offset", strconv.Itoa(req.Start))
+	if req.Language != "" {
+		query.Set("setLang", req.Language)
+	}
+	switch req.SafeSearch {
+	case "off":
+		query.Set("safeSearch", "Off")
+	case "strict":
+		query.Set("safeSearch", "Strict")
+	default:
+		query.Set("safeSearch", "Moderate")
+	}
+
+	headers := apiHeaders()
+	headers.Set("Ocp-Apim-Subscription-Key", b.APIKey)
+	resp, err := fetchSearch(ctx, client, b.Endpoint, query, headers)
+	if err != nil || !resp.ok() {
+		return resp, err
+	}
+	var data struct {
+		WebPages struct {
+			Value []struct {
+				Name    string `json:"name"`
+				URL     string `json:"url"`
+				Snippet string `json:"snippet"`
+			} `json:"value"`
+		} `json:"webPages"`
+	}
+	if err := json.Unmarshal(resp.Body, &data); err != nil {
+		return resp, fmt.Errorf("invalid bing response: %w", err)
+	}
+	for _, item := range data.WebPages.Value {
+		resp.add(req, item.Name, item.URL, item.Snippet)
+	}
+	return resp, nil
+}
+
+// apiHeaders returns the headers sent to JSON search APIs
+func apiHeaders() http.Header {
+	headers := http.Header{}
+	headers.Set("User-Agent", "go-mcp-commander/1.0")
+	headers.Set("Accept", "application/json")
+	return headers
+}
+
+// fetchSearch requests endpoint with query and reads the response
+func fetchSearch(ctx context.Context, client *http.Client, endpoint *url.URL, query url.Values, headers http.Header) (*SearchResponse, error) {
+	u := *endpoint
+	merged := u.Query()
+	for key, values := range query {
+		merged[key] = values
+	}
+	u.RawQuery = merged.Encode()
+
+	resp := &SearchResponse{URL: redactQuery(u, "key")}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return resp, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header = headers
+
+	httpResp, err := client.Do(req)
+	if err != nil {
+		return resp, err
+	}
+	defer httpResp.Body.Close()
+
+	resp.StatusCode = httpResp.StatusCode
+	resp.CacheStatus = httpResp.Header.Get(CacheStatusHeader)
+	resp.Body, err = io.ReadAll(io.LimitReader(httpResp.Body, maxSearchResponseSize))
+	if err != nil {
+		return resp, fmt.Errorf("failed to read response: %w", err)
+	}
+	resp.Results = []SearchResult{}
+	return resp, nil
+}
+
+// ok reports whether the provider answered with a success status
+func (r *SearchResponse) ok() bool {
+	return r.StatusCode >= 200 && r.StatusCode < 300
+}
+
+// add appends a result from a JSON API, skipping results without a URL
+func (r *SearchResponse) add(req SearchRequest, title, link, snippet string) {
+	link = strings.TrimSpace(link)
+	if link == "" {
+		return
+	}
+	r.Results = append(r.Results, SearchResult{
+		Title:    strings.Join(strings.Fields(title), " "),
+		URL:      link,
+		Snippet:  strings.Join(strings.Fields(snippet), " "),
+		Position: req.Start + len(r.Results) + 1,
+	})
+}
+
+// redactQuery returns u as a string with the values of the named query
+// parameters hidden
+func redactQuery(u url.URL, names ...string) string {
+	query := u.Query()
+	for _, name := range names {
+		if query.Has(name) {
+			query.Set(name, "REDACTED")
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
@@ -0,0 +1,201 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// searchServer serves body and records the last request it received
+func searchServer(t *testing.T, status int, body string) (*httptest.Server, **http.Request) {
+	t.Helper()
+	var last *http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		last = r
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server, &last
+}
+
+func newProvider(t *testing.T, config SearchConfig) SearchProvider {
+	t.Helper()
+	provider, err := NewSearchProvider(config)
+	if err != nil {
+		t.Fatalf("NewSearchProvider failed: %v", err)
+	}
+	return provider
+}
+
+func search(t *testing.T, provider SearchProvider, req SearchRequest) *SearchResponse {
+	t.Helper()
+	resp, err := provider.Search(context.Background(), &http.Client{Timeout: 5 * time.Second}, req)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	return resp
+}
+
+func expectQuery(t *testing.T, r *http.Request, expected map[string]string) {
+	t.Helper()
+	for key, value := range expected {
+		if got := r.URL.Query().Get(key); got != value {
+			t.Errorf("Expected query parameter %s=%q, got %q", key, value, got)
+		}
+	}
+}
+
+func TestSearch_Google(t *testing.T) {
+	page, err := os.ReadFile(filepath.Join("testdata", "google_basic.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, last := searchServer(t, http.StatusOK, string(page))
+	provider := newProvider(t, SearchConfig{Provider: "google", Endpoint: server.URL + "/search"})
+
+	resp := search(t, provider, SearchRequest{Query: "golang mcp", Count: 10, Start: 20, Language: "de", SafeSearch: "strict"})
+	expectQuery(t, *last, map[string]string{"q": "golang mcp", "num": "10", "start": "20", "hl": "de", "safe": "strict"})
+	if len(resp.Results) != 3 || resp.Results[0].Position != 21 {
+		t.Errorf("Expected 3 results from position 21, got %+v", resp.Results)
+	}
+}
+
+func TestSearch_GoogleCustomSearch(t *testing.T) {
+	server, last := searchServer(t, http.StatusOK, `{"items": [
+		{"title": "Go", "link": "https://go.dev/", "snippet": "Build simple,\n secure software."},
+		{"title": "No link"}
+	]}`)
+	provider := newProvider(t, SearchConfig{Provider: "google-cse", Endpoint: server.URL, APIKey: "secret", EngineID: "engine"})
+
+	resp := search(t, provider, SearchRequest{Query: "go", Count: 30, Start: 10, SafeSearch: "moderate"})
+	expectQuery(t, *last, map[string]string{"key": "secret", "cx": "engine", "q": "go", "num": "10", "start": "11", "safe": "active"})
+
+	expected := []SearchResult{{Title: "Go", URL: "https://go.dev/", Snippet: "Build simple, secure software.", Position: 11}}
+	if !reflect.DeepEqual(resp.Results, expected) {
+		t.Errorf("Unexpected results: %+v", resp.Results)
+	}
+	if strings.Contains(resp.URL, "secret") {
+		t.Errorf("Expected the API key to be redacted from %s", resp.URL)
+	}
+}
+
+func TestSearch_SearXNG(t *testing.T) {
+	server, last := searchServer(t, http.StatusOK, `{"results": [
+		{"title": "One", "url": "https://one.example/", "content": "first"},
+		{"title": "Two", "url": "https://two.example/", "content": "second"},
+		{"title": "Three", "url": "https://three.example/", "content": "third"},
+		{"title": "Four", "url": "https://four.example/", "content": "fourth"}
+	]}`)
+	provider := newProvider(t, SearchConfig{Provider: "searxng", Endpoint: server.URL + "/searx/"})
+
+	resp := search(t, provider, SearchRequest{Query: "mcp", Count: 2, Start: 11, Language: "en", SafeSearch: "off"})
+	if (*last).URL.Path != "/searx/search" {
+		t.Errorf("Expected request to /searx/search, got %s", (*last).URL.Path)
+	}
+	expectQuery(t, *last, map[string]string{"q": "mcp", "format": "json", "pageno": "2", "language": "en", "safesearch": "0"})
+
+	expected := []SearchResult{
+		{Title: "Two", URL: "https://two.example/", Snippet: "second", Position: 12},
+		{Title: "Three", URL: "https://three.example/", Snippet: "third", Position: 13},
+	}
+	if !reflect.DeepEqual(resp.Results, expected) {
+		t.Errorf("Unexpected results: %+v", resp.Results)
+	}
+}
+
+func TestSearch_Brave(t *testing.T) {
+	server, last := searchServer(t, http.StatusOK, `{"web": {"results": [
+		{"title": "Brave", "url": "https://brave.example/", "description": "A <strong>private</strong> browser"}
+	]}}`)
+	provider := newProvider(t, SearchConfig{Provider: "brave", Endpoint: server.URL, APIKey: "token"})
+
+	resp := search(t, provider, SearchRequest{Query: "browser", Count: 10, Start: 20, SafeSearch: "moderate"})
+	if got := (*last).Header.Get("X-Subscription-Token"); got != "token" {
+		t.Errorf("Expected subscription token header, got %q", got)
+	}
+	expectQuery(t, *last, map[string]string{"q": "browser", "count": "10", "offset": "2", "safesearch": "moderate"})
+	if len(resp.Results) != 1 || resp.Results[0].Position != 21 || resp.Results[0].URL != "https://brave.example/" {
+		t.Errorf("Unexpected results: %+v", resp.Results)
+	}
+}
+
+func TestSearch_Bing(t *testing.T) {
+	server, last := searchServer(t, http.StatusOK, `{"webPages": {"value": [
+		{"name": "Bing", "url": "https://bing.example/", "snippet": "Search"}
+	]}}`)
+	provider := newProvider(t, SearchConfig{Provider: "bing", Endpoint: server.URL, APIKey: "key"})
+
+	resp := search(t, provider, SearchRequest{Query: "q", Count: 100, Start: 5, SafeSearch: "strict"})
+	if got := (*last).Header.Get("Ocp-Apim-Subscription-Key"); got != "key" {
+		t.Errorf("Expected subscription key header, got %q", got)
+	}
+	expectQuery(t, *last, map[string]string{"count": "50", "offset": "5", "safeSearch": "Strict"})
+
+	expected := []SearchResult{{Title: "Bing", URL: "https://bing.example/", Snippet: "Search", Position: 6}}
+	if !reflect.DeepEqual(resp.Results, expected) {
+		t.Errorf("Unexpected results: %+v", resp.Results)
+	}
+}
+
+func TestSearch_ErrorStatus(t *testing.T) {
+	server, _ := searchServer(t, http.StatusTooManyRequests, `{"error": "rate limited"}`)
+	provider := newProvider(t, SearchConfig{Provider: "brave", Endpoint: server.URL, APIKey: "token"})
+
+	resp := search(t, provider, SearchRequest{Query: "q", Count: 10})
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("Expected status 429, got %d", resp.StatusCode)
+	}
+	if len(resp.Results) != 0 || !strings.Contains(string(resp.Body), "rate limited") {
+		t.Errorf("Expected no results and the raw body, got %+v and %q", resp.Results, resp.Body)
+	}
+}
+
+func TestSearch_InvalidJSON(t *testing.T) {
+	server, _ := searchServer(t, http.StatusOK, `<html>not json</html>`)
+	provider := newProvider(t, SearchConfig{Provider: "searxng", Endpoint: server.URL})
+
+	_, err := provider.Search(context.Background(), http.DefaultClient, SearchRequest{Query: "q", Count: 10})
+	if err == nil || !strings.Contains(err.Error(), "invalid searxng response") {
+		t.Errorf("Expected an invalid response error, got %v", err)
+	}
+}
+
+func TestNewSearchProvider_Errors(t *testing.T) {
+	tests := []struct {
+		config  SearchConfig
+		message string
+	}{
+		{SearchConfig{Provider: "altavista"}, "unknown search provider"},
+		{SearchConfig{Provider: "searxng"}, "requires the URL"},
+		{SearchConfig{Provider: "brave"}, "requires an API key"},
+		{SearchConfig{Provider: "google-cse", APIKey: "k"}, "search engine ID"},
+		{SearchConfig{Provider: "bing", APIKey: "k", Endpoint: "ftp://bing.example/"}, "invalid search endpoint"},
+	}
+
+	for _, tt := range tests {
+		_, err := NewSearchProvider(tt.config)
+		if err == nil || !strings.Contains(err.Error(), tt.message) {
+			t.Errorf("NewSearchProvider(%+v) = %v, expected error containing %q", tt.config, err, tt.message)
+		}
+	}
+
+	provider := newProvider(t, SearchConfig{})
+	if provider.Name() != "google" {
+		t.Errorf("Expected the default provider to be google, got %s", provider.Name())
+	}
+}
+
+func TestRedactQuery(t *testing.T) {
+	u, _ := url.Parse("https://api.example/search?key=secret&q=go")
+	if got := redactQuery(*u, "key"); strings.Contains(got, "secret") || !strings.Contains(got, "q=go") {
+		t.Errorf("Unexpected redacted URL %s", got)
+	}
+}
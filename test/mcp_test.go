@@ -5,8 +5,8 @@ package test
 import (
 	"bytes"
 	"encoding/json"
-	"os"
 	"os/exec"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"testing"
@@ -36,33 +36,29 @@ type MCPError struct {
 	Data    interface{} `json:"data,omitempty"`
 }
 
-func getBinaryPath() string {
+// buildBinary builds the server into a temporary directory and returns its
+// path, so test runs leave the source tree untouched
+func buildBinary(t *testing.T) string {
+	t.Helper()
+
+	name := "go-mcp-commander"
 	if runtime.GOOS == "windows" {
-		return "./go-mcp-commander.exe"
+		name += ".exe"
 	}
-	return "./go-mcp-commander"
-}
-
-func buildBinary(t *testing.T) {
-	t.Helper()
+	binaryPath := filepath.Join(t.TempDir(), name)
 
-	cmd := exec.Command("go", "build", "-o", getBinaryPath(), ".")
+	cmd := exec.Command("go", "build", "-o", binaryPath, ".")
 	cmd.Dir = ".."
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		t.Fatalf("Failed to build binary: %v\nOutput: %s", err, output)
 	}
+	return binaryPath
 }
 
-func runMCPServer(t *testing.T, input string, timeout time.Duration) (string, error) {
+func runMCPServer(t *testing.T, binaryPath, input string, timeout time.Duration) (string, error) {
 	t.Helper()
 
-	binaryPath := getBinaryPath()
-	if _, err := os.Stat(binaryPath); os.IsNotExist(err) {
-		// Try from parent directory
-		binaryPath = "../" + getBinaryPath()
-	}
-
 	cmd := exec.Command(binaryPath, "-log-level", "off")
 	cmd.Stdin = strings.NewReader(input)
 
@@ -93,7 +89,7 @@ func runMCPServer(t *testing.T, input string, timeout time.Duration) (string, er
 }
 
 func TestMCP_Initialize(t *testing.T) {
-	buildBinary(t)
+	binary := buildBinary(t)
 
 	request := MCPRequest{
 		JSONRPC: "2.0",
@@ -112,7 +108,7 @@ func TestMCP_Initialize(t *testing.T) {
 	requestData, _ := json.Marshal(request)
 	input := string(requestData) + "\n"
 
-	output, err := runMCPServer(t, input, 5*time.Second)
+	output, err := runMCPServer(t, binary, input, 5*time.Second)
 	if err != nil {
 		t.Fatalf("Server error: %v", err)
 	}
@@ -143,7 +139,7 @@ func TestMCP_Initialize(t *testing.T) {
 }
 
 func TestMCP_ListTools(t *testing.T) {
-	buildBinary(t)
+	binary := buildBinary(t)
 
 	request := MCPRequest{
 		JSONRPC: "2.0",
@@ -154,7 +150,7 @@ func TestMCP_ListTools(t *testing.T) {
 	requestData, _ := json.Marshal(request)
 	input := string(requestData) + "\n"
 
-	output, err := runMCPServer(t, input, 5*time.Second)
+	output, err := runMCPServer(t, binary, input, 5*time.Second)
 	if err != nil {
 		t.Fatalf("Server error: %v", err)
 	}
@@ -199,7 +195,7 @@ func TestMCP_ListTools(t *testing.T) {
 }
 
 func TestMCP_ExecuteCommand(t *testing.T) {
-	buildBinary(t)
+	binary := buildBinary(t)
 
 	var command string
 	if runtime.GOOS == "windows" {
@@ -223,7 +219,7 @@ func TestMCP_ExecuteCommand(t *testing.T) {
 	requestData, _ := json.Marshal(request)
 	input := string(requestData) + "\n"
 
-	output, err := runMCPServer(t, input, 10*time.Second)
+	output, err := runMCPServer(t, binary, input, 10*time.Second)
 	if err != nil {
 		t.Fatalf("Server error: %v", err)
 	}
@@ -263,7 +259,7 @@ func TestMCP_ExecuteCommand(t *testing.T) {
 }
 
 func TestMCP_ExecuteCommand_Blocked(t *testing.T) {
-	buildBinary(t)
+	binary := buildBinary(t)
 
 	var command string
 	if runtime.GOOS == "windows" {
@@ -287,7 +283,7 @@ func TestMCP_ExecuteCommand_Blocked(t *testing.T) {
 	requestData, _ := json.Marshal(request)
 	input := string(requestData) + "\n"
 
-	output, err := runMCPServer(t, input, 5*time.Second)
+	output, err := runMCPServer(t, binary, input, 5*time.Second)
 	if err != nil {
 		t.Fatalf("Server error: %v", err)
 	}
@@ -310,7 +306,7 @@ func TestMCP_ExecuteCommand_Blocked(t *testing.T) {
 }
 
 func TestMCP_Ping(t *testing.T) {
-	buildBinary(t)
+	binary := buildBinary(t)
 
 	request := MCPRequest{
 		JSONRPC: "2.0",
@@ -321,7 +317,7 @@ func TestMCP_Ping(t *testing.T) {
 	requestData, _ := json.Marshal(request)
 	input := string(requestData) + "\n"
 
-	output, err := runMCPServer(t, input, 5*time.Second)
+	output, err := runMCPServer(t, binary, input, 5*time.Second)
 	if err != nil {
 		t.Fatalf("Server error: %v", err)
 	}
@@ -337,7 +333,7 @@ func TestMCP_Ping(t *testing.T) {
 }
 
 func TestMCP_GetShellInfo(t *testing.T) {
-	buildBinary(t)
+	binary := buildBinary(t)
 
 	request := MCPRequest{
 		JSONRPC: "2.0",
@@ -352,7 +348,7 @@ func TestMCP_GetShellInfo(t *testing.T) {
 	requestData, _ := json.Marshal(request)
 	input := string(requestData) + "\n"
 
-	output, err := runMCPServer(t, input, 5*time.Second)
+	output, err := runMCPServer(t, binary, input, 5*time.Second)
 	if err != nil {
 		t.Fatalf("Server error: %v", err)
 	}
@@ -396,3 +392,85 @@ func TestMCP_GetShellInfo(t *testing.T) {
 		}
 	}
 }
+
+func TestMCP_ProtocolVersionGating(t *testing.T) {
+	binary := buildBinary(t)
+
+	tests := []struct {
+		version    string
+		structured bool
+	}{
+		{"2024-11-05", false},
+		{"2025-03-26", false},
+		{"2025-06-18", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			var input strings.Builder
+			for _, request := range []MCPRequest{
+				{JSONRPC: "2.0", ID: 1, Method: "initialize", Params: map[string]interface{}{
+					"protocolVersion": tt.version,
+					"capabilities":    map[string]interface{}{},
+					"clientInfo":      map[string]interface{}{"name": "test-client", "version": "1.0.0"},
+				}},
+				{JSONRPC: "2.0", Method: "notifications/initialized"},
+				{JSONRPC: "2.0", ID: 2, Method: "tools/list"},
+				{JSONRPC: "2.0", ID: 3, Method: "tools/call", Params: map[string]interface{}{"name": "get_shell_info"}},
+			} {
+				data, _ := json.Marshal(request)
+				input.Write(append(data, '\n'))
+			}
+
+			output, err := runMCPServer(t, binary, input.String(), 5*time.Second)
+			if err != nil {
+				t.Fatalf("Server error: %v", err)
+			}
+
+			responses := make(map[float64]map[string]interface{})
+			for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+				var response MCPResponse
+				if err := json.Unmarshal([]byte(line), &response); err != nil {
+					t.Fatalf("Failed to parse response: %v\nOutput: %s", err, output)
+				}
+				if response.Error != nil {
+					t.Fatalf("Unexpected error: %v", response.Error)
+				}
+				id, _ := response.ID.(float64)
+				responses[id], _ = response.Result.(map[string]interface{})
+			}
+
+			if got := responses[1]["protocolVersion"]; got != tt.version {
+				t.Errorf("Expected protocol version %s, got %v", tt.version, got)
+			}
+
+			tools, _ := responses[2]["tools"].([]interface{})
+			var shellInfo map[string]interface{}
+			for _, tool := range tools {
+				if toolMap, ok := tool.(map[string]interface{}); ok && toolMap["name"] == "get_shell_info" {
+					shellInfo = toolMap
+				}
+			}
+			if shellInfo == nil {
+				t.Fatal("Expected get_shell_info to be listed")
+			}
+			if _, ok := shellInfo["outputSchema"]; ok != tt.structured {
+				t.Errorf("Expected outputSchema present=%v, got %v", tt.structured, shellInfo)
+			}
+			if _, ok := shellInfo["annotations"]; ok != (tt.version != "2024-11-05") {
+				t.Errorf("Expected annotations only from 2025-03-26, got %v", shellInfo)
+			}
+
+			structured, ok := responses[3]["structuredContent"].(map[string]interface{})
+			if ok != tt.structured {
+				t.Fatalf("Expected structuredContent present=%v, got %v", tt.structured, responses[3])
+			}
+			if ok && structured["shell"] == "" {
+				t.Errorf("Expected the shell in structuredContent, got %v", structured)
+			}
+			if content, _ := responses[3]["content"].([]interface{}); len(content) != 1 {
+				t.Errorf("Expected the text content for every version, got %v", responses[3])
+			}
+		})
+	}
+}